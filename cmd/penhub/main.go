@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/pkg/errors"
 	"github.com/rustamfozilov/penhub/internal/db"
 	"github.com/rustamfozilov/penhub/internal/handlers"
+	"github.com/rustamfozilov/penhub/internal/mail"
 	"github.com/rustamfozilov/penhub/internal/services"
 	"github.com/rustamfozilov/penhub/internal/types"
 	"log"
@@ -34,7 +36,10 @@ func execute(host string, port string) error {
 		return err
 	}
 	defer newDB.Pool.Close()
-	service := services.NewService(newDB, config.ImagesPath)
+	service := services.NewService(newDB, config, mail.NewMailer(&config.Mail))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go service.RunJobs(ctx)
 	handler := handlers.NewHandler(service)
 	mux := NewRouter(handler)
 	server := http.Server{
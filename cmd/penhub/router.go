@@ -3,6 +3,8 @@ package main
 import (
 	"github.com/go-chi/chi/v5"
 	"github.com/rustamfozilov/penhub/internal/handlers"
+	"github.com/rustamfozilov/penhub/internal/policy"
+	"github.com/rustamfozilov/penhub/internal/types"
 )
 
 func NewRouter(h *handlers.Handler) *chi.Mux {
@@ -12,28 +14,92 @@ func NewRouter(h *handlers.Handler) *chi.Mux {
 	unAuthMux := chi.NewMux()
 	unAuthMux.Route("/user", func(r chi.Router) {
 		r.Post("/registration", h.RegistrationUser)
+		r.Post("/email/verify", h.VerifyEmail)
 		r.Get("/token", h.GetTokenForUser)
+		r.Post("/token/refresh", h.RefreshToken)
+		r.Post("/token/mfa", h.CompleteMFA)
+		r.Post("/password/reset", h.RequestPasswordReset)
+		r.Post("/password/reset/confirm", h.ResetPassword)
+		r.Get("/oidc/login", h.StartOIDCLogin)
+		r.Post("/oidc/callback", h.CompleteOIDCLogin)
+	})
+	unAuthMux.Route("/authors", func(r chi.Router) {
+		r.Get("/page", h.GetAuthorPage)
 	})
 	authMux := chi.NewMux()
-	authMux.Use(handlers.Authentication(h.Service.IdByToken))
+	authMux.Use(handlers.Authentication(h.Service.Authenticate))
+	authMux.Route("/user", func(r chi.Router) {
+		r.Use(handlers.SessionOnly)
+		r.Post("/logout", h.Logout)
+		r.Post("/logout/all", h.LogoutAll) // revoke all my sessions
+		r.Get("/sessions", h.GetSessions)
+		r.Get("/profile", h.GetProfile)
+		r.Put("/profile", h.EditProfile)
+		r.Put("/avatar", h.EditAvatar)
+		r.Put("/password", h.ChangePassword) // signs out other sessions
+		r.Post("/export", h.RequestDataExport)
+		r.Get("/export", h.GetDataExport)
+		r.Get("/export/download", h.DownloadDataExport)
+		r.Post("/delete", h.RequestAccountDeletion)
+		r.Get("/delete", h.GetAccountDeletion)
+		r.Delete("/delete", h.CancelAccountDeletion)
+		r.Post("/2fa/enroll", h.EnrollTOTP)
+		r.Post("/2fa/confirm", h.ConfirmTOTP)
+		r.Post("/2fa/disable", h.DisableTOTP)
+		r.Post("/keys", h.CreateAPIKey)
+		r.Get("/keys", h.GetAPIKeys)
+		r.Delete("/keys", h.RevokeAPIKey)
+		r.Post("/oidc/link", h.LinkIdentity)
+		r.Get("/identities", h.GetIdentities)
+		r.Delete("/identities", h.UnlinkIdentity)
+	})
+	authMux.Route("/pennames", func(r chi.Router) {
+		r.Use(handlers.SessionOnly)
+		r.Post("/create", h.CreatePenName)
+		r.Get("/", h.GetPenNames) // my pen names
+		r.Put("/edit", h.EditPenName)
+	})
 	authMux.Route("/books", func(r chi.Router) {
+		r.Use(handlers.RequireScope(policy.ScopeBooksRead, policy.ScopeBooksWrite))
 		r.Post("/create", h.CreateBook)
+		r.Post("/import", h.ImportBook)
 		r.Get("/genres", h.GetAllGenres)
 		r.Get("/genres/id", h.GetGenreById)
+		r.Get("/book", h.GetBook)
 		r.Get("/", h.GetBooksByUserId) // my books
-		r.Put("/edit", h.EditBook)     // нужно всегда отправлять access true чтобы депжать активной
+		r.Put("/edit", h.EditBook)
 		r.Put("/image/edit", h.EditImage)
 		r.Get("/image", h.GetImageByName)
+		r.Get("/export/epub", h.ExportEPUB)
+		r.Get("/export/fb2", h.ExportFB2)
+		r.Get("/export/txt", h.ExportText)
+		r.Post("/export/pdf", h.RequestPDFExport)
+		r.Get("/export/pdf", h.GetPDFExport)
+		r.Get("/export/pdf/download", h.DownloadPDFExport)
 		r.Delete("/delete", h.DeleteBook) // also, for recover
 	})
 	authMux.Route("/chapters", func(r chi.Router) {
+		r.Use(handlers.RequireScope(policy.ScopeChaptersRead, policy.ScopeChaptersWrite))
 		r.Post("/write", h.WriteChapter)
 		r.Get("/list", h.GetChaptersByBookId)
 		r.Get("/read", h.ReadChapter)
 		r.Put("/edit", h.EditChapter)
+		r.Put("/reorder", h.ReorderChapters)
 		r.Delete("/delete", h.DeleteChapter) //also, for recover
+		r.Get("/revisions", h.GetRevisions)
+		r.Get("/revision", h.GetRevision)
+		r.Get("/revisions/diff", h.DiffRevisions)
+		r.Post("/revisions/restore", h.RestoreRevision) // saved as a new revision
+	})
+	authMux.Route("/volumes", func(r chi.Router) {
+		r.Use(handlers.RequireScope(policy.ScopeChaptersRead, policy.ScopeChaptersWrite))
+		r.Post("/create", h.CreateVolume)
+		r.Put("/edit", h.EditVolume)
+		r.Put("/reorder", h.ReorderVolumes)
+		r.Delete("/delete", h.DeleteVolume) // chapters stay in the book
 	})
 	authMux.Route("/search", func(r chi.Router) {
+		r.Use(handlers.RequireScope(policy.ScopeBooksRead, policy.ScopeBooksRead))
 		r.Get("/title", h.SearchByTitle)
 		r.Get("/author", h.SearchAuthor)
 		r.Get("/author/books", h.GetBooksByAuthorId)
@@ -41,11 +107,28 @@ func NewRouter(h *handlers.Handler) *chi.Mux {
 		r.Get("/genre/books", h.GetBooksByGenreId)
 	})
 	authMux.Route("/rating", func(r chi.Router) {
+		r.Use(handlers.RequireScope(policy.ScopeRatingRead, policy.ScopeRatingWrite))
 		r.Post("/like", h.AddLike)
 		r.Get("/like", h.GetLikeId)
 		r.Delete("/like", h.DeleteLike)
 		r.Get("/book", h.BookLikes)
 	})
+	authMux.Route("/moderation", func(r chi.Router) {
+		r.Use(handlers.SessionOnly)
+		r.Put("/books/hide", h.HideBook) // also, for unhide
+		r.Put("/chapters/hide", h.HideChapter)
+	})
+	authMux.Route("/admin", func(r chi.Router) {
+		r.Use(handlers.SessionOnly)
+		r.Use(handlers.RequireRole(h.Service.RoleById, types.RoleAdmin))
+		r.Put("/users/active", h.SetUserActive)
+		r.Put("/users/role", h.SetUserRole)
+		r.Post("/genres", h.CreateGenre)
+		r.Put("/genres", h.UpdateGenre)
+		r.Post("/invites", h.CreateInviteCode)
+		r.Get("/invites", h.GetInviteCodes)
+		r.Get("/lockouts", h.GetLockoutEvents)
+	})
 	mux.Mount(`/api/unauth`, unAuthMux)
 	mux.Mount(`/api`, authMux)
 
@@ -0,0 +1,256 @@
+// Package bookimport reads books from EPUB, FictionBook 2 and DOCX files and
+// from ZIP archives of Markdown files. Chapters come out as HTML in the
+// subset the markup package keeps, or as the Markdown they were written in.
+package bookimport
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/htmlindex"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported file format")
+	ErrTooLarge    = errors.New("file unpacks to too much data")
+	ErrInvalid     = errors.New("file is damaged")
+	ErrNoChapters  = errors.New("no chapters found")
+)
+
+// Formats a book can be read from.
+const (
+	EPUB     = "epub"
+	FB2      = "fb2"
+	DOCX     = "docx"
+	Markdown = "markdown"
+)
+
+type Book struct {
+	Format      string
+	Title       string
+	Description string
+	Cover       *Cover
+	Chapters    []*Chapter
+	// Warnings tell what was left out on the way.
+	Warnings []string
+}
+
+type Cover struct {
+	// Extension of the image file, such as ".jpg".
+	Extension string
+	Data      []byte
+}
+
+type Chapter struct {
+	Title string
+	// Format is the markup format of Content, html or markdown.
+	Format  string
+	Content string
+}
+
+// Read detects the format of file and reads the book in it. Archives may
+// unpack to no more than maxUnpacked bytes.
+func Read(file io.ReaderAt, size int64, maxUnpacked int64) (*Book, error) {
+	head := make([]byte, 1024)
+	n, _ := file.ReadAt(head, 0)
+	head = head[:n]
+	var book *Book
+	var err error
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		book, err = readArchive(file, size, maxUnpacked)
+	case bytes.Contains(head, []byte("<FictionBook")):
+		if size > maxUnpacked {
+			return nil, ErrTooLarge
+		}
+		data := make([]byte, size)
+		_, err = file.ReadAt(data, 0)
+		if err != nil && err != io.EOF {
+			return nil, errors.WithStack(err)
+		}
+		book, err = readFB2(data)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(book.Chapters) == 0 {
+		return nil, ErrNoChapters
+	}
+	return book, nil
+}
+
+func readArchive(file io.ReaderAt, size int64, maxUnpacked int64) (*Book, error) {
+	reader, err := zip.NewReader(file, size)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	a := newArchive(reader, maxUnpacked)
+	switch {
+	case a.has("META-INF/container.xml"):
+		return readEPUB(a)
+	case a.has("word/document.xml"):
+		return readDOCX(a)
+	}
+	for _, name := range a.names {
+		if strings.EqualFold(path.Ext(name), ".fb2") {
+			data, err := a.read(name)
+			if err != nil {
+				return nil, err
+			}
+			return readFB2(data)
+		}
+	}
+	if len(markdownFiles(a)) != 0 {
+		return readMarkdown(a)
+	}
+	return nil, ErrUnsupported
+}
+
+func invalid(err error) error {
+	return errors.Wrap(ErrInvalid, err.Error())
+}
+
+// archive reads the files of a ZIP archive while keeping count of the bytes
+// unpacked, so that a small archive cannot unpack to fill the memory.
+type archive struct {
+	files map[string]*zip.File
+	// names lists the files in archive order.
+	names  []string
+	budget int64
+}
+
+func newArchive(reader *zip.Reader, budget int64) *archive {
+	a := &archive{files: make(map[string]*zip.File), budget: budget}
+	for _, file := range reader.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		if _, seen := a.files[file.Name]; !seen {
+			a.files[file.Name] = file
+			a.names = append(a.names, file.Name)
+		}
+	}
+	return a
+}
+
+func (a *archive) has(name string) bool {
+	_, ok := a.files[name]
+	return ok
+}
+
+func (a *archive) read(name string) ([]byte, error) {
+	file, ok := a.files[name]
+	if !ok {
+		return nil, errors.Wrap(ErrInvalid, name+" is missing")
+	}
+	r, err := file.Open()
+	if err != nil {
+		return nil, invalid(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, a.budget+1))
+	if err != nil {
+		return nil, invalid(err)
+	}
+	if int64(len(data)) > a.budget {
+		return nil, ErrTooLarge
+	}
+	a.budget -= int64(len(data))
+	return data, nil
+}
+
+// newDecoder returns a lenient XML decoder that understands HTML entities
+// and the legacy encodings FictionBook files are often saved in.
+func newDecoder(data []byte) *xml.Decoder {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		encoding, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, err
+		}
+		return encoding.NewDecoder().Reader(input), nil
+	}
+	return d
+}
+
+func attribute(element xml.StartElement, name string) string {
+	for _, item := range element.Attr {
+		if item.Name.Local == name {
+			return item.Value
+		}
+	}
+	return ""
+}
+
+// imageExtension returns the extension of an image name in lower case, or
+// "" when it is not a cover image format.
+func imageExtension(name string) string {
+	extension := strings.ToLower(path.Ext(name))
+	switch extension {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		return extension
+	}
+	return ""
+}
+
+func untitled(number int) string {
+	return "Chapter " + strconv.Itoa(number)
+}
+
+// sameTitle tells whether a heading repeats a title, as exported books
+// repeat the chapter name at the top of each chapter.
+func sameTitle(heading, title string) bool {
+	return strings.EqualFold(strings.Join(strings.Fields(heading), " "), strings.Join(strings.Fields(title), " "))
+}
+
+// naturalLess orders names with their numbers compared by value, so that
+// "2.md" comes before "10.md".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, restA := leadingNumber(a)
+			nb, restB := leadingNumber(b)
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = restA, restB
+			continue
+		}
+		ra, sizeA := utf8.DecodeRuneInString(a)
+		rb, sizeB := utf8.DecodeRuneInString(b)
+		if ca, cb := unicode.ToLower(ra), unicode.ToLower(rb); ca != cb {
+			return ca < cb
+		}
+		a, b = a[sizeA:], b[sizeB:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// leadingNumber splits the digits off the start of text, without their
+// leading zeros.
+func leadingNumber(text string) (string, string) {
+	end := 0
+	for end < len(text) && isDigit(text[end]) {
+		end++
+	}
+	number := strings.TrimLeft(text[:end], "0")
+	return number, text[end:]
+}
@@ -0,0 +1,251 @@
+package bookimport
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// maxUnpacked is generous for the fixtures and small enough for the tests
+// of archives that unpack to more.
+const maxUnpacked = 1 << 20
+
+// zipFixture packs a directory of testdata into a ZIP archive, with the
+// mimetype of an EPUB first and the other files in name order.
+func zipFixture(t *testing.T, dir string) []byte {
+	t.Helper()
+	root := filepath.Join("testdata", dir)
+	names := make([]string, 0)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		names = append(names, filepath.ToSlash(name))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return names[i] == "mimetype" && names[j] != "mimetype"
+	})
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = archive.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func read(t *testing.T, data []byte) *Book {
+	t.Helper()
+	book, err := Read(bytes.NewReader(data), int64(len(data)), maxUnpacked)
+	if err != nil {
+		t.Fatalf("Read: %+v", err)
+	}
+	return book
+}
+
+type chapter struct {
+	title, content string
+}
+
+func TestRead(t *testing.T) {
+	fb2, err := os.ReadFile(filepath.Join("testdata", "book.fb2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fixtures tell the same story, split into chapters the way each
+	// format does it.
+	tests := []struct {
+		format   string
+		data     []byte
+		title    string
+		cover    string
+		chapters []chapter
+	}{
+		{EPUB, zipFixture(t, "epub"), "Сказки на новый лад", ".png", []chapter{
+			// Titled after the table of contents, with the heading that
+			// repeats the title dropped.
+			{"Пролог", "<p>Жили-были <em>старик</em> со старухой.</p>\n"},
+			// The deepest entry wins over the part that opens with it.
+			{"Глава 1", "<p>Пошёл старик к <strong>синему</strong> морю.</p>\n"},
+			// Missing from the table of contents, titled after its heading.
+			{"Глава 2", `<p>Вот пришёл он к <a href="https://example.com/sea">морю</a>.</p>` + "\n"},
+		}},
+		{FB2, fb2, "Сказки на новый лад", ".png", []chapter{
+			{"Пролог", "<p>Жили-были <em>старик</em> со старухой.</p>\n"},
+			{"Глава 1", "<p>Пошёл старик к <strong>синему</strong> морю.</p>\n<hr />\n<p>Стал он кликать золотую рыбку.</p>\n"},
+			{"Глава 2", `<p>Вот пришёл он к <a href="https://example.com/sea">морю</a>.</p>` + "\n"},
+		}},
+		{DOCX, zipFixture(t, "docx"), "Сказки на новый лад", "", []chapter{
+			{"Пролог", "<p>Жили-были <em>старик</em> со старухой.</p>\n"},
+			{"Глава 1", "<p>Пошёл старик к <strong>синему</strong> морю.</p>\n<h3>У моря</h3>\n<p>Стал он кликать золотую рыбку.</p>\n"},
+			{"Глава 2", "<p>Вот пришёл он к морю.</p>\n"},
+		}},
+		{Markdown, zipFixture(t, "markdown"), "", ".png", []chapter{
+			// Named after the file, which has no heading.
+			{"prologue", "Жили-были *старик* со старухой."},
+			{"Глава 1", "Пошёл старик к **синему** морю.\n\n```\n# не заголовок\n```"},
+			{"Глава 2", "Вот пришёл он к [морю](https://example.com/sea)."},
+			// 10_epilogue.md comes after 02_part-one.md.
+			{"Эпилог", "Глядь: опять перед ним землянка."},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			book := read(t, tt.data)
+			if book.Format != tt.format || book.Title != tt.title {
+				t.Errorf("format %q, title %q", book.Format, book.Title)
+			}
+			if tt.format != Markdown && book.Description != "Старые сказки, рассказанные заново." {
+				t.Errorf("description %q", book.Description)
+			}
+			switch {
+			case tt.cover == "" && book.Cover != nil:
+				t.Errorf("cover %s found", book.Cover.Extension)
+			case tt.cover != "" && (book.Cover == nil || book.Cover.Extension != tt.cover || !bytes.HasPrefix(book.Cover.Data, []byte("\x89PNG"))):
+				t.Errorf("cover %+v, want a %s image", book.Cover, tt.cover)
+			}
+			got := make([]chapter, 0, len(book.Chapters))
+			for _, c := range book.Chapters {
+				got = append(got, chapter{c.Title, c.Content})
+			}
+			if len(got) != len(tt.chapters) {
+				t.Fatalf("chapters %q, want %q", got, tt.chapters)
+			}
+			for i := range got {
+				if got[i] != tt.chapters[i] {
+					t.Errorf("chapter %d = %q, want %q", i+1, got[i], tt.chapters[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadFB2Warnings(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "book.fb2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := read(t, data)
+	if len(book.Warnings) != 1 || !strings.Contains(book.Warnings[0], "notes") {
+		t.Errorf("warnings %q", book.Warnings)
+	}
+}
+
+// archiveOf packs files of the given sizes, each a run of one letter that
+// compresses to almost nothing.
+func archiveOf(t *testing.T, sizes map[string]int) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for name, size := range sizes {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte("# Глава\n\n" + strings.Repeat("a", size)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := archive.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestReadTooLarge(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"zip bomb", archiveOf(t, map[string]int{"book.md": 64 * maxUnpacked})},
+		{"files together", archiveOf(t, map[string]int{"1.md": maxUnpacked / 2, "2.md": maxUnpacked / 2, "3.md": maxUnpacked / 2})},
+		{"fb2", []byte(`<?xml version="1.0"?><FictionBook>` + strings.Repeat(" ", maxUnpacked) + "</FictionBook>")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "zip bomb" && len(tt.data) > maxUnpacked/8 {
+				t.Fatalf("archive of %d bytes is no bomb", len(tt.data))
+			}
+			_, err := Read(bytes.NewReader(tt.data), int64(len(tt.data)), maxUnpacked)
+			if !errors.Is(err, ErrTooLarge) {
+				t.Errorf("error = %v, want ErrTooLarge", err)
+			}
+		})
+	}
+}
+
+// Archives within the limit are read, however much they compress.
+func TestReadWithinLimit(t *testing.T) {
+	data := archiveOf(t, map[string]int{"1.md": maxUnpacked / 4, "2.md": maxUnpacked / 4})
+	book := read(t, data)
+	if len(book.Chapters) != 2 {
+		t.Errorf("%d chapters, want 2", len(book.Chapters))
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"plain text", []byte("Жили-были старик со старухой."), ErrUnsupported},
+		{"archive of images", archiveWith(t, "cover.png", "\x89PNG"), ErrUnsupported},
+		{"damaged archive", append([]byte("PK\x03\x04"), make([]byte, 100)...), ErrInvalid},
+		{"epub without package", archiveWith(t, "META-INF/container.xml", "<container><rootfiles/></container>"), ErrInvalid},
+		{"fb2 without sections", []byte("<FictionBook><body><title><p>Пусто</p></title></body></FictionBook>"), ErrNoChapters},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(bytes.NewReader(tt.data), int64(len(tt.data)), maxUnpacked)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func archiveWith(t *testing.T, name, content string) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	w, err := archive.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = archive.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
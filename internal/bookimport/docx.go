@@ -0,0 +1,333 @@
+package bookimport
+
+import (
+	"encoding/xml"
+	"github.com/rustamfozilov/penhub/internal/markup"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type docxStyle struct {
+	// level is the heading level from one, zero for the title and -1 for
+	// body text.
+	level int
+}
+
+type docxRun struct {
+	text                 string
+	bold, italic, strike bool
+	link                 string
+	lineBreak            bool
+}
+
+type docxParagraph struct {
+	style string
+	// outline is the outline level set on the paragraph itself, if any.
+	outline int
+	runs    []docxRun
+}
+
+// chapterLine matches paragraphs that start a chapter in manuscripts
+// written without heading styles.
+var chapterLine = regexp.MustCompile(`(?i)^(глава|часть|пролог|эпилог|chapter|part|prologue|epilogue)([\s.:]|$)`)
+
+// readDOCX splits the document at the top heading level it uses. A
+// document without headings is split at paragraphs such as "Chapter 1".
+func readDOCX(a *archive) (*Book, error) {
+	styles, err := docxStyles(a)
+	if err != nil {
+		return nil, err
+	}
+	links, err := docxLinks(a)
+	if err != nil {
+		return nil, err
+	}
+	data, err := a.read("word/document.xml")
+	if err != nil {
+		return nil, err
+	}
+	paragraphs, err := docxParagraphs(data, links)
+	if err != nil {
+		return nil, err
+	}
+	book := &Book{Format: DOCX}
+	docxProperties(a, book)
+	levels := make([]int, len(paragraphs))
+	top := 0
+	for i, paragraph := range paragraphs {
+		levels[i] = -1
+		if style, ok := styles[paragraph.style]; ok {
+			levels[i] = style.level
+		}
+		if paragraph.outline > 0 {
+			levels[i] = paragraph.outline
+		}
+		if levels[i] > 0 && (top == 0 || levels[i] < top) {
+			top = levels[i]
+		}
+	}
+	var chapter *Chapter
+	var content *htmlBuilder
+	finish := func() {
+		if chapter != nil && content.hasText {
+			chapter.Content = content.String()
+			book.Chapters = append(book.Chapters, chapter)
+		}
+	}
+	for i, paragraph := range paragraphs {
+		text := strings.TrimSpace(paragraph.text())
+		switch {
+		case levels[i] == 0:
+			if book.Title == "" {
+				book.Title = text
+			}
+			continue
+		case top > 0 && levels[i] == top, top == 0 && len(text) < 100 && chapterLine.MatchString(text):
+			finish()
+			chapter, content = &Chapter{Title: text, Format: markup.HTML}, &htmlBuilder{}
+			if chapter.Title == "" {
+				chapter.Title = untitled(len(book.Chapters) + 1)
+			}
+			continue
+		}
+		if chapter == nil {
+			chapter, content = &Chapter{Title: untitled(1), Format: markup.HTML}, &htmlBuilder{}
+		}
+		element := "p"
+		if levels[i] > 0 {
+			element = "h" + strconv.Itoa(minInt(levels[i]-top+2, 6))
+		}
+		paragraph.write(content, element)
+	}
+	finish()
+	return book, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (p *docxParagraph) text() string {
+	var text strings.Builder
+	for _, run := range p.runs {
+		text.WriteString(run.text)
+	}
+	return text.String()
+}
+
+func (p *docxParagraph) write(b *htmlBuilder, element string) {
+	b.start(element)
+	for _, run := range p.runs {
+		if run.lineBreak {
+			b.start("br")
+			continue
+		}
+		if run.link != "" {
+			b.link(run.link)
+		}
+		if run.bold {
+			b.start("strong")
+		}
+		if run.italic {
+			b.start("em")
+		}
+		if run.strike {
+			b.start("s")
+		}
+		b.text(run.text)
+		if run.strike {
+			b.end("s")
+		}
+		if run.italic {
+			b.end("em")
+		}
+		if run.bold {
+			b.end("strong")
+		}
+		if run.link != "" {
+			b.end("a")
+		}
+	}
+	b.end(element)
+}
+
+// docxStyles returns the paragraph styles that make headings, by style id.
+// Ids are localised, "1" in a Russian document is "Heading 1", so headings
+// are told by the style name or its outline level.
+func docxStyles(a *archive) (map[string]docxStyle, error) {
+	styles := make(map[string]docxStyle)
+	if !a.has("word/styles.xml") {
+		return styles, nil
+	}
+	data, err := a.read("word/styles.xml")
+	if err != nil {
+		return nil, err
+	}
+	var document struct {
+		Styles []struct {
+			Id   string `xml:"styleId,attr"`
+			Name struct {
+				Value string `xml:"val,attr"`
+			} `xml:"name"`
+			Outline *struct {
+				Value string `xml:"val,attr"`
+			} `xml:"pPr>outlineLvl"`
+		} `xml:"style"`
+	}
+	err = newDecoder(data).Decode(&document)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	for _, style := range document.Styles {
+		name := strings.ToLower(style.Name.Value)
+		switch {
+		case name == "title":
+			styles[style.Id] = docxStyle{level: 0}
+		case strings.HasPrefix(name, "heading "):
+			level, err := strconv.Atoi(strings.TrimPrefix(name, "heading "))
+			if err == nil && level > 0 {
+				styles[style.Id] = docxStyle{level: level}
+			}
+		case style.Outline != nil:
+			level, err := strconv.Atoi(style.Outline.Value)
+			if err == nil && level < 9 {
+				styles[style.Id] = docxStyle{level: level + 1}
+			}
+		}
+	}
+	return styles, nil
+}
+
+// docxLinks returns the targets of external links by relationship id.
+func docxLinks(a *archive) (map[string]string, error) {
+	links := make(map[string]string)
+	if !a.has("word/_rels/document.xml.rels") {
+		return links, nil
+	}
+	var document struct {
+		Relationships []struct {
+			Id     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+			Mode   string `xml:"TargetMode,attr"`
+		} `xml:"Relationship"`
+	}
+	err := a.decode("word/_rels/document.xml.rels", &document)
+	if err != nil {
+		return nil, err
+	}
+	for _, relationship := range document.Relationships {
+		if relationship.Mode == "External" {
+			links[relationship.Id] = relationship.Target
+		}
+	}
+	return links, nil
+}
+
+// docxProperties reads the title and description of the document.
+func docxProperties(a *archive, book *Book) {
+	var properties struct {
+		Title       string `xml:"title"`
+		Description string `xml:"description"`
+	}
+	if !a.has("docProps/core.xml") || a.decode("docProps/core.xml", &properties) != nil {
+		return
+	}
+	book.Title = strings.TrimSpace(properties.Title)
+	book.Description = strings.TrimSpace(properties.Description)
+}
+
+// docxParagraphs reads the paragraphs of the document body with their runs.
+// Deleted revisions, field codes and drawings are left out.
+func docxParagraphs(data []byte, links map[string]string) ([]*docxParagraph, error) {
+	d := newDecoder(data)
+	paragraphs := make([]*docxParagraph, 0)
+	var paragraph *docxParagraph
+	var run docxRun
+	link, skipping, inRun, inText := "", 0, false, false
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			return paragraphs, nil
+		}
+		if err != nil {
+			return nil, invalid(err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if skipping > 0 {
+				skipping++
+				continue
+			}
+			switch t.Name.Local {
+			case "del", "instrText", "drawing", "pict", "footnoteReference", "endnoteReference":
+				skipping = 1
+			case "p":
+				paragraph = &docxParagraph{}
+				paragraphs = append(paragraphs, paragraph)
+			case "pStyle":
+				if paragraph != nil {
+					paragraph.style = attribute(t, "val")
+				}
+			case "outlineLvl":
+				level, err := strconv.Atoi(attribute(t, "val"))
+				if paragraph != nil && err == nil && level < 9 {
+					paragraph.outline = level + 1
+				}
+			case "hyperlink":
+				link = links[attribute(t, "id")]
+			case "r":
+				run, inRun = docxRun{link: link}, true
+			case "b":
+				run.bold = enabled(t)
+			case "i":
+				run.italic = enabled(t)
+			case "strike", "dstrike":
+				run.strike = enabled(t)
+			case "t":
+				inText = true
+			case "tab":
+				// Tabs outside runs are tab stops.
+				if paragraph != nil && inRun {
+					paragraph.runs = append(paragraph.runs, docxRun{text: " "})
+				}
+			case "br", "cr":
+				if paragraph != nil && inRun && attribute(t, "type") != "page" && attribute(t, "type") != "column" {
+					paragraph.runs = append(paragraph.runs, docxRun{lineBreak: true})
+				}
+			}
+		case xml.EndElement:
+			if skipping > 0 {
+				skipping--
+				continue
+			}
+			switch t.Name.Local {
+			case "hyperlink":
+				link = ""
+			case "r":
+				inRun = false
+			case "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText && skipping == 0 && paragraph != nil {
+				current := run
+				current.text = string(t)
+				paragraph.runs = append(paragraph.runs, current)
+			}
+		}
+	}
+}
+
+// enabled tells whether a toggle property such as bold is on.
+func enabled(t xml.StartElement) bool {
+	switch attribute(t, "val") {
+	case "0", "false", "off":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,329 @@
+package bookimport
+
+import (
+	"encoding/xml"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/markup"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Metadata struct {
+		Titles       []string `xml:"title"`
+		Descriptions []string `xml:"description"`
+		Meta         []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []epubItem `xml:"manifest>item"`
+	Spine    struct {
+		Toc   string `xml:"toc,attr"`
+		Items []struct {
+			IdRef  string `xml:"idref,attr"`
+			Linear string `xml:"linear,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+type epubItem struct {
+	Id         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+type ncxPoint struct {
+	Label   string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	Points []ncxPoint `xml:"navPoint"`
+}
+
+// skipped elements of content documents are left out with their content.
+var skipped = map[string]bool{
+	"head": true, "math": true, "nav": true, "object": true, "script": true, "style": true, "svg": true,
+	"template": true, "title": true,
+}
+
+// htmlNames maps presentational elements to the ones chapters are written
+// with.
+var htmlNames = map[string]string{"b": "strong", "del": "s", "i": "em", "strike": "s"}
+
+// readEPUB takes a chapter from each content document in the spine, titled
+// after the table of contents or else after its first heading.
+func readEPUB(a *archive) (*Book, error) {
+	var container epubContainer
+	err := a.decode("META-INF/container.xml", &container)
+	if err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, errors.Wrap(ErrInvalid, "no package document")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+	var opf epubPackage
+	err = a.decode(opfPath, &opf)
+	if err != nil {
+		return nil, err
+	}
+	book := &Book{Format: EPUB}
+	if len(opf.Metadata.Titles) != 0 {
+		book.Title = strings.TrimSpace(opf.Metadata.Titles[0])
+	}
+	if len(opf.Metadata.Descriptions) != 0 {
+		// Descriptions are often HTML.
+		book.Description = markup.Text(markup.HTML, opf.Metadata.Descriptions[0])
+	}
+	items := make(map[string]*epubItem, len(opf.Manifest))
+	for i := range opf.Manifest {
+		items[opf.Manifest[i].Id] = &opf.Manifest[i]
+	}
+	book.Cover = epubCover(a, &opf, items, opfPath)
+	titles := epubTitles(a, &opf, items, opfPath)
+	for _, itemRef := range opf.Spine.Items {
+		item, ok := items[itemRef.IdRef]
+		if !ok || itemRef.Linear == "no" || hasProperty(item.Properties, "nav") {
+			continue
+		}
+		if item.MediaType != "application/xhtml+xml" && item.MediaType != "text/html" {
+			continue
+		}
+		name := resolve(opfPath, item.Href)
+		data, err := a.read(name)
+		if err != nil {
+			return nil, err
+		}
+		var b htmlBuilder
+		err = readXHTML(data, &b)
+		if err != nil {
+			return nil, err
+		}
+		if !b.hasText {
+			continue
+		}
+		chapter := &Chapter{Title: titles[name], Format: markup.HTML}
+		content, heading := b.withoutHeading()
+		switch {
+		case chapter.Title == "" && strings.TrimSpace(heading) != "":
+			chapter.Title = strings.TrimSpace(heading)
+			chapter.Content = content
+		case heading != "" && sameTitle(heading, chapter.Title):
+			chapter.Content = content
+		default:
+			chapter.Content = b.String()
+		}
+		if chapter.Title == "" {
+			chapter.Title = untitled(len(book.Chapters) + 1)
+		}
+		book.Chapters = append(book.Chapters, chapter)
+	}
+	return book, nil
+}
+
+func (a *archive) decode(name string, v interface{}) error {
+	data, err := a.read(name)
+	if err != nil {
+		return err
+	}
+	err = newDecoder(data).Decode(v)
+	if err != nil {
+		return invalid(err)
+	}
+	return nil
+}
+
+// resolve returns the archive path of href, relative to the file base.
+func resolve(base, href string) string {
+	if i := strings.IndexByte(href, '#'); i >= 0 {
+		href = href[:i]
+	}
+	unescaped, err := url.PathUnescape(href)
+	if err == nil {
+		href = unescaped
+	}
+	return strings.TrimPrefix(path.Join(path.Dir(base), href), "/")
+}
+
+func hasProperty(properties, name string) bool {
+	for _, item := range strings.Fields(properties) {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// epubCover reads the cover image named by the package document, the EPUB 3
+// way or the older one.
+func epubCover(a *archive, opf *epubPackage, items map[string]*epubItem, opfPath string) *Cover {
+	var cover *epubItem
+	for i := range opf.Manifest {
+		if hasProperty(opf.Manifest[i].Properties, "cover-image") {
+			cover = &opf.Manifest[i]
+		}
+	}
+	for _, meta := range opf.Metadata.Meta {
+		if cover == nil && meta.Name == "cover" {
+			cover = items[meta.Content]
+		}
+	}
+	if cover == nil || imageExtension(cover.Href) == "" {
+		return nil
+	}
+	data, err := a.read(resolve(opfPath, cover.Href))
+	if err != nil {
+		return nil
+	}
+	return &Cover{Extension: imageExtension(cover.Href), Data: data}
+}
+
+// epubTitles returns the titles of the content documents in the table of
+// contents, from the navigation document or else the EPUB 2 NCX. Where a
+// document has several entries the deepest wins, so that a chapter opening
+// a part keeps its own title.
+func epubTitles(a *archive, opf *epubPackage, items map[string]*epubItem, opfPath string) map[string]string {
+	titles := make(map[string]string)
+	depths := make(map[string]int)
+	add := func(base, href, title string, depth int) {
+		title = strings.Join(strings.Fields(title), " ")
+		name := resolve(base, href)
+		if title == "" || href == "" || depth <= depths[name] {
+			return
+		}
+		titles[name], depths[name] = title, depth
+	}
+	for _, item := range opf.Manifest {
+		if !hasProperty(item.Properties, "nav") {
+			continue
+		}
+		navPath := resolve(opfPath, item.Href)
+		data, err := a.read(navPath)
+		if err == nil {
+			readNavigation(data, func(href, title string, depth int) {
+				add(navPath, href, title, depth)
+			})
+		}
+		if len(titles) != 0 {
+			return titles
+		}
+	}
+	ncx, ok := items[opf.Spine.Toc]
+	if !ok {
+		return titles
+	}
+	ncxPath := resolve(opfPath, ncx.Href)
+	var document struct {
+		Points []ncxPoint `xml:"navMap>navPoint"`
+	}
+	if a.decode(ncxPath, &document) != nil {
+		return titles
+	}
+	var walk func(points []ncxPoint, depth int)
+	walk = func(points []ncxPoint, depth int) {
+		for _, point := range points {
+			add(ncxPath, point.Content.Src, point.Label, depth)
+			walk(point.Points, depth+1)
+		}
+	}
+	walk(document.Points, 1)
+	return titles
+}
+
+// readNavigation calls entry for each link of the toc nav of a navigation
+// document with the depth of its list.
+func readNavigation(data []byte, entry func(href, title string, depth int)) {
+	d := newDecoder(data)
+	inToc, depth := false, 0
+	href, inLink := "", false
+	var title strings.Builder
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch strings.ToLower(t.Name.Local) {
+			case "nav":
+				inToc = hasProperty(attribute(t, "type"), "toc")
+			case "ol":
+				depth++
+			case "a":
+				href, inLink = attribute(t, "href"), true
+				title.Reset()
+			}
+		case xml.EndElement:
+			switch strings.ToLower(t.Name.Local) {
+			case "nav":
+				inToc = false
+			case "ol":
+				depth--
+			case "a":
+				if inToc && inLink {
+					entry(href, title.String(), depth)
+				}
+				inLink = false
+			}
+		case xml.CharData:
+			if inLink {
+				title.Write(t)
+			}
+		}
+	}
+}
+
+// readXHTML writes the body of a content document to b.
+func readXHTML(data []byte, b *htmlBuilder) error {
+	d := newDecoder(data)
+	skipping := 0
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return invalid(err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if skipping > 0 || skipped[name] {
+				skipping++
+				continue
+			}
+			if name == "a" {
+				b.link(attribute(t, "href"))
+				continue
+			}
+			if mapped, ok := htmlNames[name]; ok {
+				name = mapped
+			}
+			b.start(name)
+		case xml.EndElement:
+			if skipping > 0 {
+				skipping--
+				continue
+			}
+			name := strings.ToLower(t.Name.Local)
+			if mapped, ok := htmlNames[name]; ok {
+				name = mapped
+			}
+			b.end(name)
+		case xml.CharData:
+			if skipping == 0 {
+				b.text(string(t))
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package bookimport
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"github.com/rustamfozilov/penhub/internal/markup"
+	"io"
+	"strings"
+)
+
+// fb2Names maps the FictionBook elements of section content to HTML.
+var fb2Names = map[string]string{
+	"p": "p", "text-author": "p", "date": "p", "stanza": "p",
+	"cite": "blockquote", "epigraph": "blockquote", "poem": "blockquote",
+	"emphasis": "em", "strong": "strong", "strikethrough": "s", "sub": "sub", "sup": "sup", "code": "code",
+	"table": "table", "tr": "tr", "td": "td", "th": "td",
+}
+
+type fb2Section struct {
+	title   strings.Builder
+	content *htmlBuilder
+}
+
+// fb2Reader takes a chapter from each section with text of its own. A
+// section that only holds other sections, such as a part, adds none.
+type fb2Reader struct {
+	book  *Book
+	stack []string
+	// skipping counts the open elements left out with their content.
+	skipping   int
+	bodies     int
+	sections   []*fb2Section
+	inTitle    bool
+	annotation *htmlBuilder
+	capture    *strings.Builder
+	subtitle   *strings.Builder
+	coverId    string
+	coverType  string
+	cover      *strings.Builder
+}
+
+func readFB2(data []byte) (*Book, error) {
+	r := fb2Reader{book: &Book{Format: FB2}}
+	d := newDecoder(data)
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, invalid(err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			r.start(t)
+			r.stack = append(r.stack, t.Name.Local)
+		case xml.EndElement:
+			if len(r.stack) == 0 {
+				continue
+			}
+			r.stack = r.stack[:len(r.stack)-1]
+			r.end(t.Name.Local)
+		case xml.CharData:
+			r.text(string(t))
+		}
+	}
+	if r.annotation != nil {
+		r.book.Description = markup.Text(markup.HTML, r.annotation.String())
+	}
+	if r.cover != nil {
+		r.readCover()
+	}
+	return r.book, nil
+}
+
+func (r *fb2Reader) parent() string {
+	if len(r.stack) == 0 {
+		return ""
+	}
+	return r.stack[len(r.stack)-1]
+}
+
+func (r *fb2Reader) inside(name string) bool {
+	for _, item := range r.stack {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// builder returns where section content goes now, if anywhere.
+func (r *fb2Reader) builder() *htmlBuilder {
+	if len(r.sections) != 0 {
+		return r.sections[len(r.sections)-1].content
+	}
+	if r.annotation != nil && r.inside("annotation") {
+		return r.annotation
+	}
+	return nil
+}
+
+func (r *fb2Reader) start(t xml.StartElement) {
+	name := t.Name.Local
+	if r.skipping > 0 {
+		r.skipping++
+		return
+	}
+	switch {
+	case name == "body":
+		r.bodies++
+		if r.bodies > 1 {
+			r.book.Warnings = append(r.book.Warnings, "notes and other bodies after the main one are left out")
+			r.skipping = 1
+		}
+		return
+	case name == "book-title" && r.parent() == "title-info":
+		r.capture = &strings.Builder{}
+		return
+	case name == "annotation" && r.parent() == "title-info":
+		r.annotation = &htmlBuilder{}
+		return
+	case name == "image" && r.parent() == "coverpage":
+		r.coverId = strings.TrimPrefix(attribute(t, "href"), "#")
+		return
+	case name == "binary":
+		if r.coverId != "" && attribute(t, "id") == r.coverId {
+			r.cover, r.coverType = &strings.Builder{}, attribute(t, "content-type")
+		}
+		return
+	case name == "section" && r.inside("body"):
+		if len(r.sections) != 0 {
+			// Text ahead of the subsections, such as the preface of a part.
+			r.finishSection()
+		}
+		r.sections = append(r.sections, &fb2Section{content: &htmlBuilder{}})
+		return
+	case name == "title" && r.parent() == "section":
+		r.inTitle = true
+		return
+	case (name == "title" || name == "epigraph") && r.parent() == "body":
+		r.skipping = 1
+		return
+	}
+	b := r.builder()
+	if b == nil || r.inTitle {
+		return
+	}
+	switch name {
+	case "subtitle":
+		r.subtitle = &strings.Builder{}
+	case "a":
+		b.link(attribute(t, "href"))
+	case "title":
+		b.start("h3")
+	default:
+		if mapped, ok := fb2Names[name]; ok {
+			b.start(mapped)
+		}
+	}
+}
+
+func (r *fb2Reader) end(name string) {
+	if r.skipping > 0 {
+		r.skipping--
+		return
+	}
+	switch {
+	case name == "book-title" && r.capture != nil:
+		r.book.Title = strings.Join(strings.Fields(r.capture.String()), " ")
+		r.capture = nil
+		return
+	case name == "binary":
+		return
+	case name == "section" && len(r.sections) != 0:
+		r.finishSection()
+		r.sections = r.sections[:len(r.sections)-1]
+		return
+	case name == "title" && r.inTitle && r.parent() == "section":
+		r.inTitle = false
+		return
+	}
+	b := r.builder()
+	if b == nil {
+		return
+	}
+	if r.inTitle {
+		if name == "p" {
+			r.sections[len(r.sections)-1].title.WriteString(" ")
+		}
+		return
+	}
+	switch name {
+	case "subtitle":
+		text := strings.Join(strings.Fields(r.subtitle.String()), " ")
+		r.subtitle = nil
+		if sceneBreak(text) {
+			b.start("hr")
+			return
+		}
+		b.start("h3")
+		b.text(text)
+		b.end("h3")
+	case "a":
+		b.end("a")
+	case "v":
+		b.start("br")
+	case "title":
+		b.end("h3")
+	default:
+		if mapped, ok := fb2Names[name]; ok {
+			b.end(mapped)
+		}
+	}
+}
+
+func (r *fb2Reader) text(data string) {
+	switch {
+	case r.skipping > 0:
+	case r.capture != nil:
+		r.capture.WriteString(data)
+	case r.cover != nil && r.parent() == "binary":
+		r.cover.WriteString(data)
+	case r.inTitle:
+		r.sections[len(r.sections)-1].title.WriteString(data)
+	case r.subtitle != nil:
+		r.subtitle.WriteString(data)
+	default:
+		if b := r.builder(); b != nil {
+			b.text(data)
+		}
+	}
+}
+
+// finishSection adds the innermost section as a chapter when it has text,
+// and leaves it empty for the text after its subsections.
+func (r *fb2Reader) finishSection() {
+	section := r.sections[len(r.sections)-1]
+	if !section.content.hasText {
+		return
+	}
+	title := strings.Join(strings.Fields(section.title.String()), " ")
+	if title == "" {
+		title = untitled(len(r.book.Chapters) + 1)
+	}
+	r.book.Chapters = append(r.book.Chapters, &Chapter{Title: title, Format: markup.HTML, Content: section.content.String()})
+	section.content = &htmlBuilder{}
+}
+
+func (r *fb2Reader) readCover() {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(r.cover.String()), ""))
+	if err != nil {
+		r.book.Warnings = append(r.book.Warnings, "the cover image is damaged and left out")
+		return
+	}
+	extension := imageExtension(r.coverId)
+	switch r.coverType {
+	case "image/jpeg":
+		extension = ".jpg"
+	case "image/png":
+		extension = ".png"
+	case "image/gif":
+		extension = ".gif"
+	}
+	if extension == "" {
+		return
+	}
+	r.book.Cover = &Cover{Extension: extension, Data: data}
+}
+
+// sceneBreak tells whether a subtitle only marks a scene break, such as
+// "* * *".
+func sceneBreak(text string) bool {
+	if text == "" {
+		return false
+	}
+	for _, c := range text {
+		if !strings.ContainsRune("*-—–~#• ", c) {
+			return false
+		}
+	}
+	return true
+}
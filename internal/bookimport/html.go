@@ -0,0 +1,251 @@
+package bookimport
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+// containers are the blocks that hold text, structures the ones that hold
+// other blocks.
+var (
+	containers = map[string]bool{
+		"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "li": true, "pre": true,
+	}
+	structures = map[string]bool{"blockquote": true, "ol": true, "ul": true}
+	inlines    = map[string]bool{
+		"code": true, "em": true, "s": true, "small": true, "strong": true, "sub": true, "sup": true, "u": true,
+	}
+	// boundaries are left out but end the paragraph they interrupt.
+	boundaries = map[string]bool{
+		"article": true, "aside": true, "body": true, "dd": true, "div": true, "dl": true, "dt": true,
+		"figcaption": true, "figure": true, "footer": true, "header": true, "main": true, "section": true,
+		"table": true, "td": true, "th": true, "tr": true,
+	}
+)
+
+type inlineElement struct {
+	name       string
+	start, end string
+}
+
+type openBlock struct {
+	name string
+	// start is where the block was written and texts the count of texts
+	// written before it, so that a block left without text can be dropped.
+	start, texts int
+}
+
+// htmlBuilder writes chapter HTML from a stream of elements and text. Text
+// outside a paragraph opens one, whitespace is collapsed as browsers do, and
+// inline elements cut by a block are reopened after it, so that the result
+// is well-formed whatever the source. Blocks without text are left out.
+type htmlBuilder struct {
+	out    bytes.Buffer
+	blocks []openBlock
+	// implicit tells that the innermost block is a paragraph opened by text.
+	implicit bool
+	inline   []inlineElement
+	// empty tells that the innermost container has no text yet.
+	empty, space bool
+	texts        int
+	hasText      bool
+	// written counts the blocks written; the first is remembered when it is
+	// a heading, as it may repeat the chapter title.
+	written                  int
+	headingStart, headingEnd int
+	heading                  strings.Builder
+	inHeading                bool
+}
+
+func (b *htmlBuilder) start(name string) {
+	switch {
+	case inlines[name]:
+		b.openInline(inlineElement{name: name, start: "<" + name + ">", end: "</" + name + ">"})
+	case name == "br":
+		if b.inContainer() && !b.empty {
+			b.out.WriteString("<br />")
+			b.space = false
+		}
+	case name == "hr":
+		b.closeParagraph()
+		b.out.WriteString("<hr />\n")
+		b.written++
+	case containers[name], structures[name]:
+		b.closeParagraph()
+		b.openBlock(name)
+	case boundaries[name]:
+		b.closeImplicit()
+	}
+}
+
+func (b *htmlBuilder) end(name string) {
+	switch {
+	case inlines[name] || name == "a":
+		last := len(b.inline) - 1
+		if last < 0 || b.inline[last].name != name {
+			return
+		}
+		if b.inContainer() {
+			b.out.WriteString(b.inline[last].end)
+		}
+		b.inline = b.inline[:last]
+	case containers[name], structures[name]:
+		b.closeImplicit()
+		for i := len(b.blocks) - 1; i >= 0; i-- {
+			if b.blocks[i].name == name {
+				for len(b.blocks) > i {
+					b.closeBlock()
+				}
+				return
+			}
+		}
+	case boundaries[name]:
+		b.closeImplicit()
+	}
+}
+
+// link opens a link. Links within the book lead nowhere once it is split
+// into chapters, so only links to the web are kept.
+func (b *htmlBuilder) link(href string) {
+	href = strings.TrimSpace(href)
+	lower := strings.ToLower(href)
+	if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "mailto:") {
+		b.openInline(inlineElement{name: "a", start: `<a href="` + html.EscapeString(href) + `">`, end: "</a>"})
+		return
+	}
+	b.openInline(inlineElement{name: "a"})
+}
+
+func (b *htmlBuilder) text(data string) {
+	if b.top() == "pre" {
+		if data != "" {
+			b.out.WriteString(html.EscapeString(data))
+			b.empty, b.hasText = false, true
+			b.texts++
+		}
+		return
+	}
+	words := strings.Fields(data)
+	if len(words) == 0 {
+		if data != "" && !b.empty {
+			b.space = true
+		}
+		return
+	}
+	if !b.inContainer() {
+		b.openBlock("p")
+		b.implicit = true
+	}
+	if (b.space || isSpace(data[0])) && !b.empty {
+		b.out.WriteString(" ")
+		if b.inHeading {
+			b.heading.WriteString(" ")
+		}
+	}
+	text := strings.Join(words, " ")
+	b.out.WriteString(html.EscapeString(text))
+	if b.inHeading {
+		b.heading.WriteString(text)
+	}
+	b.space = isSpace(data[len(data)-1])
+	b.empty, b.hasText = false, true
+	b.texts++
+}
+
+// String returns the HTML written so far, with all elements closed.
+func (b *htmlBuilder) String() string {
+	for len(b.blocks) != 0 {
+		b.closeBlock()
+	}
+	return b.out.String()
+}
+
+// withoutHeading returns the HTML without its first block when that is a
+// heading, together with the text of the heading.
+func (b *htmlBuilder) withoutHeading() (string, string) {
+	content := b.String()
+	if b.headingEnd == 0 {
+		return content, ""
+	}
+	return content[:b.headingStart] + content[b.headingEnd:], b.heading.String()
+}
+
+func (b *htmlBuilder) top() string {
+	if len(b.blocks) == 0 {
+		return ""
+	}
+	return b.blocks[len(b.blocks)-1].name
+}
+
+func (b *htmlBuilder) inContainer() bool {
+	return containers[b.top()]
+}
+
+func (b *htmlBuilder) openInline(element inlineElement) {
+	b.inline = append(b.inline, element)
+	if b.inContainer() {
+		if b.space && !b.empty {
+			b.out.WriteString(" ")
+			b.space = false
+		}
+		b.out.WriteString(element.start)
+	}
+}
+
+func (b *htmlBuilder) openBlock(name string) {
+	if b.written == 0 && name[0] == 'h' {
+		b.headingStart, b.inHeading = b.out.Len(), true
+		b.heading.Reset()
+	}
+	b.blocks = append(b.blocks, openBlock{name: name, start: b.out.Len(), texts: b.texts})
+	b.out.WriteString("<" + name + ">")
+	b.written++
+	if containers[name] {
+		for _, element := range b.inline {
+			b.out.WriteString(element.start)
+		}
+		b.empty, b.space = true, false
+	}
+}
+
+func (b *htmlBuilder) closeBlock() {
+	block := b.blocks[len(b.blocks)-1]
+	b.blocks = b.blocks[:len(b.blocks)-1]
+	b.implicit = false
+	if block.texts == b.texts {
+		b.out.Truncate(block.start)
+		b.written--
+		b.inHeading = false
+		return
+	}
+	if containers[block.name] {
+		for i := len(b.inline) - 1; i >= 0; i-- {
+			b.out.WriteString(b.inline[i].end)
+		}
+	}
+	b.out.WriteString("</" + block.name + ">\n")
+	if b.inHeading {
+		b.headingEnd, b.inHeading = b.out.Len(), false
+	}
+}
+
+func (b *htmlBuilder) closeImplicit() {
+	if b.implicit {
+		b.closeBlock()
+	}
+}
+
+// closeParagraph closes the block a new block cannot be nested in. List
+// items and quotes hold blocks.
+func (b *htmlBuilder) closeParagraph() {
+	b.closeImplicit()
+	switch b.top() {
+	case "p", "h1", "h2", "h3", "h4", "h5", "h6", "pre":
+		b.closeBlock()
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
@@ -0,0 +1,95 @@
+package bookimport
+
+import (
+	"github.com/rustamfozilov/penhub/internal/markup"
+	"path"
+	"sort"
+	"strings"
+)
+
+// markdownFiles lists the Markdown files of an archive in reading order:
+// by path, with numbers compared by value. Hidden files and the metadata
+// macOS adds to archives are left out.
+func markdownFiles(a *archive) []string {
+	names := make([]string, 0)
+	for _, name := range a.names {
+		if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".") {
+			continue
+		}
+		switch strings.ToLower(path.Ext(name)) {
+		case ".md", ".markdown":
+			names = append(names, name)
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return naturalLess(names[i], names[j])
+	})
+	return names
+}
+
+// readMarkdown takes the chapters from the files in order. A file starts a
+// chapter, and so does each top-level heading in it; a chapter without a
+// heading is named after its file. An image named cover is the cover.
+func readMarkdown(a *archive) (*Book, error) {
+	book := &Book{Format: Markdown}
+	for _, name := range markdownFiles(a) {
+		data, err := a.read(name)
+		if err != nil {
+			return nil, err
+		}
+		title := fileTitle(name)
+		var content []string
+		finish := func() {
+			text := strings.Trim(strings.Join(content, "\n"), "\n")
+			if strings.TrimSpace(text) != "" {
+				if title == "" {
+					title = untitled(len(book.Chapters) + 1)
+				}
+				book.Chapters = append(book.Chapters, &Chapter{Title: title, Format: markup.Markdown, Content: text})
+			}
+			content = nil
+		}
+		source := strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\r", "\n")
+		source = strings.TrimPrefix(source, "\ufeff")
+		fence := ""
+		for _, line := range strings.Split(source, "\n") {
+			trimmed := strings.TrimSpace(line)
+			switch {
+			case fence != "":
+				if strings.HasPrefix(trimmed, fence) {
+					fence = ""
+				}
+			case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+				fence = trimmed[:3]
+			case strings.HasPrefix(line, "# ") || line == "#":
+				finish()
+				title = markup.Text(markup.Markdown, strings.TrimRight(strings.TrimSpace(line[1:]), "# "))
+				continue
+			}
+			content = append(content, line)
+		}
+		finish()
+	}
+	for _, name := range a.names {
+		base := path.Base(name)
+		extension := imageExtension(base)
+		if extension != "" && strings.EqualFold(strings.TrimSuffix(base, path.Ext(base)), "cover") {
+			data, err := a.read(name)
+			if err != nil {
+				return nil, err
+			}
+			book.Cover = &Cover{Extension: extension, Data: data}
+			break
+		}
+	}
+	return book, nil
+}
+
+// fileTitle names a chapter after its file, without the extension and the
+// number that orders the files: "02_the-road.md" is "the road".
+func fileTitle(name string) string {
+	title := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	title = strings.TrimLeft(title, "0123456789 ._-")
+	title = strings.NewReplacer("_", " ", "-", " ").Replace(title)
+	return strings.TrimSpace(title)
+}
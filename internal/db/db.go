@@ -5,8 +5,8 @@ import (
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
 	"github.com/rustamfozilov/penhub/internal/types"
-	"golang.org/x/crypto/bcrypt"
 	"log"
 	"time"
 )
@@ -16,6 +16,11 @@ type DB struct {
 }
 
 var ErrNotFound = errors.New("not found")
+var ErrInvalidInvite = errors.New("invalid invite code")
+var ErrInvalidPosition = errors.New("invalid chapter position")
+var ErrNumberTaken = errors.New("chapter number already taken")
+var ErrInvalidOrder = errors.New("order must list every chapter of the book once")
+var ErrInvalidVolume = errors.New("volume belongs to another book")
 
 func NewDB(config *types.Config) (*DB, error) {
 	dsn := "postgres://" + config.UserName + ":" + config.Password + "@" + config.Host + ":" + config.Port + "/" + config.Database
@@ -31,18 +36,65 @@ func NewDB(config *types.Config) (*DB, error) {
 
 func (d *DB) CreateBook(ctx context.Context, book *types.Book) error {
 	_, err := d.Pool.Exec(ctx, `
-	insert into books (title, author_id, description, cover_image_name, access_read, genre_id, active, created)
-	 values ($1, $2, $3, $4, $5,$6, default, default)   
-`, book.Title, book.AuthorId, book.Description, book.Image, book.AccessRead, book.Genre)
+	insert into books (title, author_id, pen_name_id, description, cover_image_name, access_read, downloadable, genre_id, status, publish_at, active, created)
+	 values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, default, default)
+`, book.Title, book.AuthorId, book.PenNameId, book.Description, book.Image, book.AccessRead, book.Downloadable, book.Genre, book.Status, book.PublishAt)
 	return errors.WithStack(err)
 }
 
-func (d *DB) RegistrationUser(ctx context.Context, user *types.User, hash []byte) error {
-	_, err := d.Pool.Exec(ctx, `
-		insert into users (name, login, password, active, created)
-		values ($1, $2, $3, default, default)
-`, user.Name, user.Login, hash)
-	return errors.WithStack(err)
+// RegistrationUser creates the account together with its invite redemption and
+// email verification token, whichever of them apply.
+func (d *DB) RegistrationUser(ctx context.Context, user *types.User, hash string, verification *types.VerificationToken) error {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var inviteId int64
+	if user.InviteCode != "" {
+		err = tx.QueryRow(ctx, `
+		update invite_codes set uses = uses + 1 where code = $1 and uses < max_uses
+		returning id
+`, user.InviteCode).Scan(&inviteId)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInvalidInvite
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	err = tx.QueryRow(ctx, `
+		insert into users (name, login, email, password, active, created)
+		values ($1, $2, nullif($3, ''), $4, $5, default)
+		returning id
+`, user.Name, user.Login, user.Email, hash, verification == nil).Scan(&user.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = tx.Exec(ctx, `
+		insert into pen_names (user_id, name) values ($1, $2)
+`, user.ID, user.Name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if inviteId != 0 {
+		_, err = tx.Exec(ctx, `
+		insert into invite_redemptions (invite_id, user_id) values ($1, $2)
+`, inviteId, user.ID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if verification != nil {
+		_, err = tx.Exec(ctx, `
+		insert into email_verifications (user_id, token_hash, expire) values ($1, $2, $3)
+`, user.ID, verification.TokenHash, verification.Expire)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(tx.Commit(ctx))
 }
 
 func (d *DB) IsLoginUsed(ctx context.Context, login string) bool {
@@ -77,438 +129,2350 @@ func (d *DB) IsLoginUsed(ctx context.Context, login string) bool {
 	return false
 }
 
-func (d *DB) ValidateLoginAndPassword(ctx context.Context, login, password string) (bool, int64, error) {
-	var id int64
-	var hash string
-	err := d.Pool.QueryRow(ctx, `
-		select password, id from users where login = $1
-`, login).Scan(&hash, &id)
+func (d *DB) GetPasswordHash(ctx context.Context, login string) (id int64, hash string, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		select id, password from users where login = $1 and active = true
+`, login).Scan(&id, &hash)
 	if err != nil {
-		return true, 0, errors.WithStack(err)
+		return 0, "", errors.WithStack(err)
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	if err != nil {
-		log.Println("invalid password")
+	return id, hash, nil
+}
 
-		return false, 0, errors.WithStack(err)
+func (d *DB) UpdatePasswordHash(ctx context.Context, id int64, hash string) error {
+	_, err := d.Pool.Exec(ctx, `
+		update users set password = $1 where id = $2
+`, hash, id)
+	if err != nil {
+		return errors.WithStack(err)
 	}
-	return true, id, nil
+	return nil
 }
 
-func (d *DB) PutNewToken(ctx context.Context, token string, id int64) error {
+func (d *DB) PutNewToken(ctx context.Context, session *types.Session) error {
 	_, err := d.Pool.Exec(ctx, `
-			insert into users_tokens (user_id, token, expire, created) 
-			values ($1, $2, default, default)
-`, id, token)
+			insert into users_tokens (user_id, token_hash, token_prefix, expire, refresh_token_hash, refresh_expire, user_agent, ip)
+			values ($1, $2, $3, $4, $5, $6, $7, $8)
+`, session.UserId, session.Token, session.TokenPrefix, session.Expire, session.RefreshToken, session.RefreshExpire, session.UserAgent, session.IP)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	return nil
 }
 
-func (d *DB) IdByToken(cxt context.Context, token string) (id int64, expire time.Time, err error) {
+func (d *DB) IdByToken(cxt context.Context, tokenHash string) (id int64, expire time.Time, err error) {
 	err = d.Pool.QueryRow(cxt, `
-select user_id, expire from users_tokens where token = $1
-`, token).Scan(&id, &expire)
+update users_tokens set last_seen = current_timestamp
+where token_hash = $1 and revoked = false and user_id in (select id from users where active = true)
+returning user_id, expire
+`, tokenHash).Scan(&id, &expire)
 	if err != nil {
 		return 0, expire, errors.WithStack(err)
 	}
 	return id, expire, nil
 }
 
-func (d *DB) GetBookId(ctx context.Context, title string) (id int64, err error) {
-
-	err = d.Pool.QueryRow(ctx, `
-		select id from books where title = $1
-`, title).Scan(&id)
-	if errors.Is(err, pgx.ErrNoRows) {
-		log.Println("book not exist")
-		return 0, errors.WithStack(err)
-	}
+func (d *DB) RotateToken(ctx context.Context, refreshTokenHash string, session *types.Session) error {
+	err := d.Pool.QueryRow(ctx, `
+		update users_tokens set token_hash = $1, token_prefix = $2, expire = $3, refresh_token_hash = $4, refresh_expire = $5,
+		last_seen = current_timestamp
+		where refresh_token_hash = $6 and revoked = false and refresh_expire > current_timestamp
+		returning id, user_id
+`, session.Token, session.TokenPrefix, session.Expire, session.RefreshToken, session.RefreshExpire, refreshTokenHash).Scan(&session.ID, &session.UserId)
 	if err != nil {
-		return 0, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
-	return id, nil
+	return nil
 }
 
-func (d *DB) BookAccess(ctx context.Context, userId, bookId int64) (bool, error) {
-	var authorId int64
-	err := d.Pool.QueryRow(ctx, `
-		select author_id from books where id = $1
-`, bookId).Scan(&authorId)
-	if errors.Is(err, pgx.ErrNoRows) {
-		log.Println("book not exist")
-	}
+func (d *DB) RevokeToken(ctx context.Context, tokenHash string) error {
+	_, err := d.Pool.Exec(ctx, `
+		update users_tokens set revoked = true where token_hash = $1
+`, tokenHash)
 	if err != nil {
-		err := errors.WithStack(err)
-		return false, err
-	}
-	if authorId == userId {
-		return true, nil
+		return errors.WithStack(err)
 	}
-	return false, nil
+	return nil
 }
 
-func (d *DB) WriteChapter(ctx context.Context, chapter *types.Chapter) error {
+func (d *DB) RevokeAllTokens(ctx context.Context, userId int64) error {
 	_, err := d.Pool.Exec(ctx, `
-		insert into chapters (book_id, number, name, content,active, created) 
-		values ($1, $2, $3, $4, default, default)
-`, chapter.BookId, chapter.Number, chapter.Name, chapter.Content)
-
+		update users_tokens set revoked = true where user_id = $1 and revoked = false
+`, userId)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	return nil
 }
 
-func (d *DB) GetBooksById(ctx context.Context, authorId *types.AuthorId) ([]*types.Book, error) {
-	books := make([]*types.Book, 0)
+func (d *DB) GetSessions(ctx context.Context, userId int64, tokenHash string) ([]*types.Session, error) {
+	sessions := make([]*types.Session, 0)
 	rows, err := d.Pool.Query(ctx, `
-		select id, title, genre_id, author_id, description, cover_image_name, active, created from books
-		where author_id = $1 and id > $2 and active = true 
-		order by id limit 10
-`, authorId.Id, authorId.LastBookId)
+		select id, token_prefix, user_agent, ip, token_hash = $2, last_seen, created from users_tokens
+		where user_id = $1 and revoked = false and refresh_expire > current_timestamp
+		order by last_seen desc
+`, userId, tokenHash)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var book types.Book
-		err := rows.Scan(&book.ID, &book.Title, &book.Genre, &book.AuthorId, &book.Description, &book.Image, &book.Active, &book.Created)
+		var session types.Session
+		err := rows.Scan(&session.ID, &session.TokenPrefix, &session.UserAgent, &session.IP, &session.Current, &session.LastSeen, &session.Created)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
-		if book.Active {
-			books = append(books, &book)
-		}
+		sessions = append(sessions, &session)
 	}
 	err = rows.Err()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return books, nil
+	return sessions, nil
+}
+
+func (d *DB) CreateAPIKey(ctx context.Context, key *types.APIKey) error {
+	err := d.Pool.QueryRow(ctx, `
+		insert into api_keys (user_id, name, key_hash, key_prefix, scopes) values ($1, $2, $3, $4, $5)
+		returning id, created
+`, key.UserId, key.Name, key.KeyHash, key.Prefix, key.Scopes).Scan(&key.ID, &key.Created)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
 }
 
-func (d *DB) GetChaptersByBookId(ctx context.Context, id int64) ([]*types.Chapter, error) {
+func (d *DB) GetAPIKeys(ctx context.Context, userId int64) ([]*types.APIKey, error) {
+	keys := make([]*types.APIKey, 0)
 	rows, err := d.Pool.Query(ctx, `
-	select id, book_id, number, name, active, created from chapters where book_id = $1 and active = true
-		order by number 
-`, id)
+		select id, name, key_prefix, scopes, last_used, created from api_keys
+		where user_id = $1 and revoked = false
+		order by created desc
+`, userId)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	defer rows.Close()
-	chapters := make([]*types.Chapter, 0)
 	for rows.Next() {
-		var chapter types.Chapter
-		err := rows.Scan(&chapter.ID, &chapter.BookId, &chapter.Number, &chapter.Name, &chapter.Active, &chapter.Created)
+		var key types.APIKey
+		err := rows.Scan(&key.ID, &key.Name, &key.Prefix, &key.Scopes, &key.LastUsed, &key.Created)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
-		if chapter.Active {
-			chapters = append(chapters, &chapter)
-		}
+		keys = append(keys, &key)
 	}
 	err = rows.Err()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return chapters, nil
+	return keys, nil
 }
 
-func (d *DB) ReadChapter(ctx context.Context, id int64) (*types.Chapter, error) {
-	var chapter types.Chapter
+func (d *DB) RevokeAPIKey(ctx context.Context, id, userId int64) error {
 	err := d.Pool.QueryRow(ctx, `
-	select id, book_id, number, name, content, active, created from chapters where id = $1 and active = true
-`, id).Scan(&chapter.ID, &chapter.BookId, &chapter.Number, &chapter.Name, &chapter.Content, &chapter.Active, &chapter.Created)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	return &chapter, nil
-}
-
-func (d *DB) EditTitle(ctx context.Context, id int64, title string) error {
-	_, err := d.Pool.Exec(ctx, `
-		update books set title = $1 where id = $2
-`, title, id)
+		update api_keys set revoked = true where id = $1 and user_id = $2 and revoked = false
+		returning id
+`, id, userId).Scan(&id)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	return nil
 }
 
-func (d *DB) EditContent(ctx context.Context, edit *types.Chapter) error {
-
-	_, err := d.Pool.Exec(ctx, `
-			update chapters set content = $1 where id = $2
-`, edit.Content, edit.ID)
+// APIKeyUser resolves a key digest to its owner and scopes and marks the key as used.
+func (d *DB) APIKeyUser(ctx context.Context, keyHash string) (id int64, scopes []string, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		update api_keys set last_used = current_timestamp
+		where key_hash = $1 and revoked = false and user_id in (select id from users where active = true)
+		returning user_id, scopes
+`, keyHash).Scan(&id, &scopes)
 	if err != nil {
-		return errors.WithStack(err)
+		return 0, nil, errors.WithStack(err)
 	}
-	return nil
+	return id, scopes, nil
 }
 
-func (d *DB) EditAccess(ctx context.Context, edit *types.Book) error {
-	_, err := d.Pool.Exec(ctx, `
-				update books set  access_read = $1 where id = $2
-`, edit.AccessRead, edit.ID)
+func (d *DB) GetBookId(ctx context.Context, title string) (id int64, err error) {
+
+	err = d.Pool.QueryRow(ctx, `
+		select id from books where title = $1
+`, title).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		log.Println("book not exist")
+		return 0, errors.WithStack(err)
+	}
 	if err != nil {
-		return errors.WithStack(err)
+		return 0, errors.WithStack(err)
 	}
-	return nil
+	return id, nil
 }
 
-func (d *DB) EditChapterName(ctx context.Context, edit *types.Chapter) error {
-	_, err := d.Pool.Exec(ctx, `
-			update chapters set name = $1 where id = $2
-`, edit.Name, edit.ID)
+func (d *DB) BookResource(ctx context.Context, bookId int64) (*policy.Resource, error) {
+	var resource policy.Resource
+	err := d.Pool.QueryRow(ctx, `
+		select author_id, access_read, active, hidden, status = 'published', downloadable from books where id = $1
+`, bookId).Scan(&resource.OwnerId, &resource.AccessRead, &resource.Active, &resource.Hidden, &resource.Published, &resource.Downloadable)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
-	return nil
+	return &resource, nil
 }
 
-func (d *DB) EditChapterNumber(ctx context.Context, edit *types.Chapter) error {
-
-	_, err := d.Pool.Exec(ctx, `
-			update chapters set number = $1 where id = $2
-`, edit.Number, edit.ID)
+// ImageOwner tells what an image belongs to: the book it is the cover of,
+// or else whether it is the avatar of an active user.
+func (d *DB) ImageOwner(ctx context.Context, imageName string) (bookId int64, avatar bool, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		select coalesce((select id from books where cover_image_name = $1 order by id limit 1), 0),
+		exists (select 1 from users where avatar_image_name = $1 and active = true)
+`, imageName).Scan(&bookId, &avatar)
 	if err != nil {
-		return errors.WithStack(err)
+		return 0, false, errors.WithStack(err)
 	}
-	return nil
+	return bookId, avatar, nil
 }
 
-func (d *DB) SearchByTitle(ctx context.Context, title *types.BookTitle) ([]*types.Book, error) {
-	books := make([]*types.Book, 0)
-	rows, err := d.Pool.Query(ctx, `
-			select *from books where "like"(title, $1) and active = true
-`, title.Title+"%")
+func (d *DB) ChapterResource(ctx context.Context, chapterId int64) (*policy.Resource, error) {
+	var resource policy.Resource
+	err := d.Pool.QueryRow(ctx, `
+		select b.author_id, b.access_read, b.active and c.active, b.hidden or c.hidden,
+		b.status = 'published' and c.status = 'published'
+		from chapters c join books b on b.id = c.book_id where c.id = $1
+`, chapterId).Scan(&resource.OwnerId, &resource.AccessRead, &resource.Active, &resource.Hidden, &resource.Published)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var book types.Book
-		err := rows.Scan(&book.ID, &book.Title, &book.AuthorId, &book.Genre, &book.Description, &book.Image, &book.AccessRead, &book.Active, &book.Created)
-		if err != nil {
+	return &resource, nil
+}
 
-			return nil, errors.WithStack(err)
-		}
-		books = append(books, &book)
-	}
-	err = rows.Err()
+func (d *DB) RatingResource(ctx context.Context, ratingId int64) (*policy.Resource, error) {
+	var resource policy.Resource
+	err := d.Pool.QueryRow(ctx, `
+		select user_id from ratings where id = $1
+`, ratingId).Scan(&resource.OwnerId)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return books, nil
+	return &resource, nil
 }
 
-func (d *DB) SearchByAuthor(ctx context.Context, author *types.AuthorName) ([]*types.User, error) {
-	authors := make([]*types.User, 0)
-	rows, err := d.Pool.Query(ctx, `
-			select id, name, active, created from users where "like"(name, $1) and active = true 
-`, author.Name+"%")
+// WriteChapter stores the chapter together with its first revision. The
+// book row is locked while numbers are chosen, as in every statement that
+// renumbers chapters.
+func (d *DB) WriteChapter(ctx context.Context, chapter *types.Chapter) error {
+	tx, err := d.Pool.Begin(ctx)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var user types.User
+	defer tx.Rollback(ctx)
 
-		err := rows.Scan(&user.ID, &user.Name, &user.Active, &user.Created)
+	err = lockBook(ctx, tx, chapter.BookId)
+	if err != nil {
+		return err
+	}
+	if chapter.VolumeId != nil {
+		err = checkVolume(ctx, tx, *chapter.VolumeId, chapter.BookId)
 		if err != nil {
-			err := errors.WithStack(err)
-			return nil, err
+			return err
+		}
+	}
+	switch {
+	case chapter.After != nil:
+		var after int64
+		if *chapter.After != 0 {
+			err = tx.QueryRow(ctx, `
+				select number from chapters where id = $1 and book_id = $2
+`, *chapter.After, chapter.BookId).Scan(&after)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrInvalidPosition
+			}
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		_, err = tx.Exec(ctx, `
+			update chapters set number = number + 1 where book_id = $1 and number > $2
+`, chapter.BookId, after)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		chapter.Number = after + 1
+	case chapter.Number == 0:
+		err = tx.QueryRow(ctx, `
+			select coalesce(max(number), 0) + 1 from chapters where book_id = $1
+`, chapter.BookId).Scan(&chapter.Number)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	default:
+		var taken bool
+		err = tx.QueryRow(ctx, `
+			select exists(select 1 from chapters where book_id = $1 and number = $2)
+`, chapter.BookId, chapter.Number).Scan(&taken)
+		if err != nil {
+			return errors.WithStack(err)
 		}
-		if user.Active {
-			authors = append(authors, &user)
+		if taken {
+			return ErrNumberTaken
 		}
 	}
-	err = rows.Err()
+
+	err = insertChapter(ctx, tx, chapter)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return err
 	}
-	return authors, nil
+	return errors.WithStack(tx.Commit(ctx))
 }
 
-func (d *DB) GetAllGenres(ctx context.Context) ([]*types.Genre, error) {
-	genres := make([]*types.Genre, 0)
+// insertChapter inserts a chapter with its first revision.
+func insertChapter(ctx context.Context, tx pgx.Tx, chapter *types.Chapter) error {
+	err := tx.QueryRow(ctx, `
+		with chapter as (
+			insert into chapters (book_id, volume_id, number, name, content, format, status, publish_at, active, created)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, default, default)
+			returning id, book_id, name, content, format
+		)
+		insert into chapter_revisions (chapter_id, name, content, format, author_id)
+		select c.id, c.name, c.content, c.format, b.author_id from chapter c join books b on b.id = c.book_id
+		returning chapter_id
+`, chapter.BookId, chapter.VolumeId, chapter.Number, chapter.Name, chapter.Content, chapter.Format, chapter.Status, chapter.PublishAt).Scan(&chapter.ID)
+	return errors.WithStack(err)
+}
 
-	rows, err := d.Pool.Query(ctx, `
-	select * from genres where active = true
-`)
+// ImportBook creates a book together with its chapters, numbered from one
+// in the given order.
+func (d *DB) ImportBook(ctx context.Context, book *types.Book, chapters []*types.Chapter) error {
+	tx, err := d.Pool.Begin(ctx)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
-	defer rows.Close()
+	defer tx.Rollback(ctx)
 
-	for rows.Next() {
-		var genre types.Genre
-		err := rows.Scan(&genre.Id, &genre.Name, &genre.Active)
+	err = tx.QueryRow(ctx, `
+	insert into books (title, author_id, pen_name_id, description, cover_image_name, access_read, downloadable, genre_id, status, publish_at, active, created)
+	 values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, default, default)
+	 returning id
+`, book.Title, book.AuthorId, book.PenNameId, book.Description, book.Image, book.AccessRead, book.Downloadable, book.Genre, book.Status, book.PublishAt).Scan(&book.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for i, chapter := range chapters {
+		chapter.BookId = book.ID
+		chapter.Number = int64(i + 1)
+		err = insertChapter(ctx, tx, chapter)
 		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-
-		if genre.Active {
-			genres = append(genres, &genre)
+			return err
 		}
 	}
-	return genres, nil
+	return errors.WithStack(tx.Commit(ctx))
 }
 
-func (d *DB) SearchGenre(ctx context.Context, genreName types.GenreName) ([]*types.Genre, error) {
-	genres := make([]*types.Genre, 0)
-	rows, err := d.Pool.Query(ctx, `
-	select *from genres where "like"(name, $1) and active = true
-`, genreName.Name+"%")
+// checkVolume returns ErrInvalidVolume unless the volume belongs to the book.
+func checkVolume(ctx context.Context, tx pgx.Tx, volumeId, bookId int64) error {
+	var volumeBook int64
+	err := tx.QueryRow(ctx, `
+		select book_id from volumes where id = $1
+`, volumeId).Scan(&volumeBook)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidVolume
+	}
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
-	defer rows.Close()
+	if volumeBook != bookId {
+		return ErrInvalidVolume
+	}
+	return nil
+}
 
-	for rows.Next() {
-		var genre types.Genre
-		err := rows.Scan(&genre.Id, &genre.Name, &genre.Active)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
+func lockBook(ctx context.Context, tx pgx.Tx, bookId int64) error {
+	_, err := tx.Exec(ctx, `
+		select id from books where id = $1 for update
+`, bookId)
+	return errors.WithStack(err)
+}
 
-		if genre.Active {
-			genres = append(genres, &genre)
-		}
+// ReorderChapters numbers the listed chapters from one in the given order.
+// The list must hold every chapter the author sees; deleted and hidden
+// chapters keep their relative order behind them.
+func (d *DB) ReorderChapters(ctx context.Context, order *types.ChapterOrder) error {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
 	}
-	return genres, nil
-}
+	defer tx.Rollback(ctx)
 
-func (d *DB) GetBooksByGenreId(ctx context.Context, genreId *types.GenreID) ([]*types.Book, error) {
-	books := make([]*types.Book, 0)
-	rows, err := d.Pool.Query(ctx, `
-		select id, title, genre_id, author_id, description, cover_image_name, active, created from books
-		where genre_id = $1 and id > $2 and active = true
-		order by id limit 5 
-`, genreId.Id, genreId.LastBookId)
+	err = lockBook(ctx, tx, order.BookId)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var book types.Book
-		err := rows.Scan(&book.ID, &book.Title, &book.Genre, &book.AuthorId, &book.Description, &book.Image, &book.Active, &book.Created)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		if book.Active {
-			books = append(books, &book)
-		}
+	var listed, missing int64
+	err = tx.QueryRow(ctx, `
+		select count(*) filter (where id = any($2)),
+		       count(*) filter (where active = true and hidden = false and id <> all($2))
+		from chapters where book_id = $1
+`, order.BookId, order.ChapterIds).Scan(&listed, &missing)
+	if err != nil {
+		return errors.WithStack(err)
 	}
-	err = rows.Err()
+	if listed != int64(len(order.ChapterIds)) || missing != 0 {
+		return ErrInvalidOrder
+	}
+	_, err = tx.Exec(ctx, `
+		update chapters c set number = p.position from (
+			select id, position from unnest($2::bigint[]) with ordinality as listed(id, position)
+			union all
+			select id, $3 + row_number() over (order by number) from chapters
+			where book_id = $1 and id <> all($2)
+		) p where c.id = p.id and c.book_id = $1
+`, order.BookId, order.ChapterIds, len(order.ChapterIds))
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
-	return books, nil
+	return errors.WithStack(tx.Commit(ctx))
 }
 
-func (d *DB) GetGenreById(ctx context.Context, genreId types.GenreID) (*types.Genre, error) {
-	var genre types.Genre
+func (d *DB) VolumeResource(ctx context.Context, volumeId int64) (*policy.Resource, error) {
+	var resource policy.Resource
 	err := d.Pool.QueryRow(ctx, `
-		select * from genres where id = $1 and active = true
-`, genreId.Id).Scan(&genre.Id, &genre.Name, &genre.Active)
+		select b.author_id, b.access_read, b.active, b.hidden, b.status = 'published'
+		from volumes v join books b on b.id = v.book_id where v.id = $1
+`, volumeId).Scan(&resource.OwnerId, &resource.AccessRead, &resource.Active, &resource.Hidden, &resource.Published)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &genre, nil
+	return &resource, nil
 }
 
-func (d *DB) EditImageName(ctx context.Context, book *types.Book) error {
-	_, err := d.Pool.Exec(ctx, `
-				update books set cover_image_name = $1 where id = $2 and active = true
-`, book.Image, book.ID)
+// CreateVolume appends a volume to the book.
+func (d *DB) CreateVolume(ctx context.Context, volume *types.Volume) error {
+	tx, err := d.Pool.Begin(ctx)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	return nil
-}
+	defer tx.Rollback(ctx)
 
-func (d *DB) EditGenre(ctx context.Context, book *types.Book) error {
-	_, err := d.Pool.Exec(ctx, `
-				update books set genre_id = $1 where id = $2 and active = true
-`, book.Genre, book.ID)
+	err = lockBook(ctx, tx, volume.BookId)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(ctx, `
+		insert into volumes (book_id, title, description, number)
+		values ($1, $2, $3, (select coalesce(max(number), 0) + 1 from volumes where book_id = $1))
+		returning id, number, created
+`, volume.BookId, volume.Title, volume.Description).Scan(&volume.ID, &volume.Number, &volume.Created)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	return nil
+	return errors.WithStack(tx.Commit(ctx))
 }
 
-func (d *DB) EditDescription(ctx context.Context, book *types.Book) error {
-
+func (d *DB) EditVolume(ctx context.Context, volume *types.Volume) error {
 	_, err := d.Pool.Exec(ctx, `
-				update books set description = $1 where id = $2 and active = true
-`, book.Description, book.ID)
+		update volumes set title = $1, description = $2 where id = $3
+`, volume.Title, volume.Description, volume.ID)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	return nil
 }
 
-func (d *DB) DeleteBook(ctx context.Context, book *types.Book) error {
-	_, err := d.Pool.Exec(ctx, `
-		update books set active = $1 where id = $2
-`, book.Active, book.ID)
+// DeleteVolume removes a volume and closes the gap in the numbering. Its
+// chapters stay in the book outside any volume.
+func (d *DB) DeleteVolume(ctx context.Context, volumeId int64) error {
+	tx, err := d.Pool.Begin(ctx)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	return nil
-}
+	defer tx.Rollback(ctx)
 
-func (d *DB) DeleteChapter(ctx context.Context, chapter *types.Chapter) error {
-	_, err := d.Pool.Exec(ctx, `
-		update chapters set active = $1 where id = $2
-`, chapter.Active, chapter.ID)
+	var bookId int64
+	err = tx.QueryRow(ctx, `
+		select book_id from volumes where id = $1
+`, volumeId).Scan(&bookId)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	return nil
-}
-
-func (d *DB) AddLike(ctx context.Context, userId *int64, bookId *types.BookId) error {
-	_, err := d.Pool.Exec(ctx, `
-		 insert into ratings (book_id, user_id, created) values ($1, $2, default)
- `, bookId.Id, userId)
+	err = lockBook(ctx, tx, bookId)
+	if err != nil {
+		return err
+	}
+	var number int64
+	err = tx.QueryRow(ctx, `
+		delete from volumes where id = $1 returning number
+`, volumeId).Scan(&number)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = tx.Exec(ctx, `
+		update volumes set number = number - 1 where book_id = $1 and number > $2
+`, bookId, number)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(tx.Commit(ctx))
+}
+
+// ReorderVolumes numbers the volumes of a book in the given order, which
+// must list each of them once.
+func (d *DB) ReorderVolumes(ctx context.Context, order *types.VolumeOrder) error {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = lockBook(ctx, tx, order.BookId)
+	if err != nil {
+		return err
+	}
+	var listed, total int64
+	err = tx.QueryRow(ctx, `
+		select count(*) filter (where id = any($2)), count(*) from volumes where book_id = $1
+`, order.BookId, order.VolumeIds).Scan(&listed, &total)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if listed != int64(len(order.VolumeIds)) || listed != total {
+		return ErrInvalidOrder
+	}
+	_, err = tx.Exec(ctx, `
+		update volumes v set number = listed.position
+		from unnest($2::bigint[]) with ordinality as listed(id, position)
+		where v.id = listed.id and v.book_id = $1
+`, order.BookId, order.VolumeIds)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(tx.Commit(ctx))
+}
+
+func (d *DB) GetVolumes(ctx context.Context, bookId int64) ([]*types.Volume, error) {
+	volumes := make([]*types.Volume, 0)
+	rows, err := d.Pool.Query(ctx, `
+		select id, book_id, title, description, number, created from volumes
+		where book_id = $1 order by number
+`, bookId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var volume types.Volume
+		err := rows.Scan(&volume.ID, &volume.BookId, &volume.Title, &volume.Description, &volume.Number, &volume.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		volumes = append(volumes, &volume)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return volumes, nil
+}
+
+func (d *DB) GetBooksById(ctx context.Context, authorId *types.AuthorId) ([]*types.Book, error) {
+	books := make([]*types.Book, 0)
+	rows, err := d.Pool.Query(ctx, `
+		select id, title, genre_id, author_id, pen_name_id, (select name from pen_names where id = pen_name_id),
+		description, cover_image_name, active, status, publish_at, version, created from books
+		where author_id = $1 and id > $2 and active = true and hidden = false
+		order by id limit 10
+`, authorId.Id, authorId.LastBookId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var book types.Book
+		err := rows.Scan(&book.ID, &book.Title, &book.Genre, &book.AuthorId, &book.PenNameId, &book.PenName, &book.Description, &book.Image, &book.Active, &book.Status, &book.PublishAt, &book.Version, &book.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if book.Active {
+			books = append(books, &book)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return books, nil
+}
+
+func (d *DB) GetBooksByPenName(ctx context.Context, penNameId *types.PenNameId, viewerId int64) ([]*types.Book, error) {
+	books := make([]*types.Book, 0)
+	rows, err := d.Pool.Query(ctx, `
+		select id, title, genre_id, author_id, pen_name_id, (select name from pen_names where id = pen_name_id),
+		description, cover_image_name, active, created from books
+		where pen_name_id = $1 and id > $2 and active = true and hidden = false
+		and ((access_read = true and status = 'published') or author_id = $3)
+		order by id limit 10
+`, penNameId.Id, penNameId.LastBookId, viewerId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var book types.Book
+		err := rows.Scan(&book.ID, &book.Title, &book.Genre, &book.AuthorId, &book.PenNameId, &book.PenName, &book.Description, &book.Image, &book.Active, &book.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		books = append(books, &book)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return books, nil
+}
+
+// GetChaptersByBookId lists unpublished chapters only to the author of the book.
+func (d *DB) GetChaptersByBookId(ctx context.Context, id int64, viewerId int64) ([]*types.Chapter, error) {
+	rows, err := d.Pool.Query(ctx, `
+	select c.id, c.book_id, c.volume_id, c.number, c.name, c.active, c.status, c.publish_at, c.version, c.created from chapters c
+		join books b on b.id = c.book_id
+		where c.book_id = $1 and c.active = true and c.hidden = false and (c.status = 'published' or b.author_id = $2)
+		order by c.number
+`, id, viewerId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	chapters := make([]*types.Chapter, 0)
+	for rows.Next() {
+		var chapter types.Chapter
+		err := rows.Scan(&chapter.ID, &chapter.BookId, &chapter.VolumeId, &chapter.Number, &chapter.Name, &chapter.Active, &chapter.Status, &chapter.PublishAt, &chapter.Version, &chapter.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if chapter.Active {
+			chapters = append(chapters, &chapter)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return chapters, nil
+}
+
+// ChapterChecksums returns a checksum of the name, format and content of
+// each chapter of a book the viewer may read, by chapter id.
+func (d *DB) ChapterChecksums(ctx context.Context, bookId int64, viewerId int64) (map[int64]string, error) {
+	rows, err := d.Pool.Query(ctx, `
+	select c.id, md5(c.name || '/' || c.format || '/' || c.content) from chapters c
+		join books b on b.id = c.book_id
+		where c.book_id = $1 and c.active = true and c.hidden = false and (c.status = 'published' or b.author_id = $2)
+`, bookId, viewerId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	checksums := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var checksum string
+		err := rows.Scan(&id, &checksum)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		checksums[id] = checksum
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return checksums, nil
+}
+
+func (d *DB) ReadChapter(ctx context.Context, id int64, viewerId int64) (*types.Chapter, error) {
+	var chapter types.Chapter
+	err := d.Pool.QueryRow(ctx, `
+	select c.id, c.book_id, c.volume_id, c.number, c.name, c.content, c.format, c.active, c.status, c.publish_at, c.version, c.created from chapters c
+		join books b on b.id = c.book_id
+		where c.id = $1 and c.active = true and c.hidden = false and (c.status = 'published' or b.author_id = $2)
+`, id, viewerId).Scan(&chapter.ID, &chapter.BookId, &chapter.VolumeId, &chapter.Number, &chapter.Name, &chapter.Content, &chapter.Format, &chapter.Active, &chapter.Status, &chapter.PublishAt, &chapter.Version, &chapter.Created)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &chapter, nil
+}
+
+func (d *DB) GetRevisions(ctx context.Context, chapterId int64) ([]*types.Revision, error) {
+	revisions := make([]*types.Revision, 0)
+	rows, err := d.Pool.Query(ctx, `
+		select id, chapter_id, name, format, author_id, created from chapter_revisions
+		where chapter_id = $1 order by id desc
+`, chapterId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var revision types.Revision
+		err := rows.Scan(&revision.ID, &revision.ChapterId, &revision.Name, &revision.Format, &revision.AuthorId, &revision.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		revisions = append(revisions, &revision)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return revisions, nil
+}
+
+func (d *DB) GetRevision(ctx context.Context, chapterId, id int64) (*types.Revision, error) {
+	var revision types.Revision
+	err := d.Pool.QueryRow(ctx, `
+		select id, chapter_id, name, content, format, author_id, created from chapter_revisions
+		where id = $1 and chapter_id = $2
+`, id, chapterId).Scan(&revision.ID, &revision.ChapterId, &revision.Name, &revision.Content, &revision.Format, &revision.AuthorId, &revision.Created)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &revision, nil
+}
+
+// RestoreRevision copies an old revision back into the chapter and records
+// the result as the newest revision, so the restore itself can be undone.
+func (d *DB) RestoreRevision(ctx context.Context, chapterId, id, authorId int64) (*types.Revision, error) {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		update chapters c set name = r.name, content = r.content, format = r.format, version = c.version + 1
+		from chapter_revisions r where r.id = $1 and r.chapter_id = $2 and c.id = r.chapter_id
+`, id, chapterId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, errors.WithStack(pgx.ErrNoRows)
+	}
+	var revision types.Revision
+	err = tx.QueryRow(ctx, `
+		insert into chapter_revisions (chapter_id, name, content, format, author_id)
+		select id, name, content, format, $2 from chapters where id = $1
+		returning id, chapter_id, name, content, format, author_id, created
+`, chapterId, authorId).Scan(&revision.ID, &revision.ChapterId, &revision.Name, &revision.Content, &revision.Format, &revision.AuthorId, &revision.Created)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &revision, nil
+}
+
+// PruneRevisions deletes revisions older than keepDays that are not among
+// the keepLast newest of their chapter. The newest revision always stays.
+func (d *DB) PruneRevisions(ctx context.Context, keepLast, keepDays int64) (int64, error) {
+	tag, err := d.Pool.Exec(ctx, `
+		delete from chapter_revisions r using (
+			select id, row_number() over (partition by chapter_id order by id desc) as rank from chapter_revisions
+		) ranked
+		where r.id = ranked.id and ranked.rank > greatest($1, 1)
+		and r.created < current_timestamp - $2 * interval '1 day'
+`, keepLast, keepDays)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (d *DB) GetBook(ctx context.Context, id int64) (*types.Book, error) {
+	var book types.Book
+	err := d.Pool.QueryRow(ctx, `
+		select id, title, genre_id, author_id, pen_name_id, (select name from pen_names where id = pen_name_id),
+		description, cover_image_name, access_read, downloadable, active, hidden, status, publish_at, version, created from books
+		where id = $1
+`, id).Scan(&book.ID, &book.Title, &book.Genre, &book.AuthorId, &book.PenNameId, &book.PenName, &book.Description,
+		&book.Image, &book.AccessRead, &book.Downloadable, &book.Active, &book.Hidden, &book.Status, &book.PublishAt, &book.Version, &book.Created)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &book, nil
+}
+
+// EditBook bumps the version of a book if it still equals version and
+// applies the fields set in edit in the same transaction, so that of two
+// edits based on the same version only one goes through. A zero version
+// bumps unconditionally. The error is pgx.ErrNoRows on a mismatch, and
+// current is then the version the book has.
+func (d *DB) EditBook(ctx context.Context, edit *types.BookEdit, version int64) (current int64, err error) {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		update books set version = version + 1 where id = $1 and ($2 = 0 or version = $2) returning version
+`, edit.ID, version).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = tx.QueryRow(ctx, `
+		select version from books where id = $1
+`, edit.ID).Scan(&current)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return current, errors.WithStack(pgx.ErrNoRows)
+	}
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	_, err = tx.Exec(ctx, `
+		update books set title = coalesce(nullif($2, ''), title), pen_name_id = coalesce(nullif($3::bigint, 0), pen_name_id),
+		genre_id = coalesce(nullif($4::bigint, 0), genre_id), description = coalesce(nullif($5, ''), description),
+		cover_image_name = coalesce(nullif($6, ''), cover_image_name),
+		access_read = coalesce($7, access_read), downloadable = coalesce($8, downloadable),
+		status = coalesce(nullif($9, ''), status), publish_at = case when $9 = '' then publish_at else $10 end
+		where id = $1
+`, edit.ID, edit.Title, edit.PenNameId, edit.Genre, edit.Description, edit.Image, edit.AccessRead, edit.Downloadable,
+		edit.Status, edit.PublishAt)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return current, errors.WithStack(tx.Commit(ctx))
+}
+
+// EditChapter claims the next version of a chapter like EditBook
+// and applies the fields set in edit within the same transaction, so that
+// an edit that fails leaves the chapter and its version as they were. The
+// volume is checked before the claim. A changed name, content or format is
+// recorded as a revision by authorId.
+func (d *DB) EditChapter(ctx context.Context, edit *types.Chapter, version, authorId int64) (current int64, err error) {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var bookId int64
+	err = tx.QueryRow(ctx, `
+		select book_id from chapters where id = $1
+`, edit.ID).Scan(&bookId)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	err = lockBook(ctx, tx, bookId)
+	if err != nil {
+		return 0, err
+	}
+	if edit.VolumeId != nil && *edit.VolumeId != 0 {
+		err = checkVolume(ctx, tx, *edit.VolumeId, bookId)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = tx.QueryRow(ctx, `
+		update chapters set version = version + 1 where id = $1 and ($2 = 0 or version = $2) returning version
+`, edit.ID, version).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = tx.QueryRow(ctx, `
+		select version from chapters where id = $1
+`, edit.ID).Scan(&current)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return current, errors.WithStack(pgx.ErrNoRows)
+	}
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	// A zero volume id moves the chapter out of any volume.
+	var volumeId *int64
+	if edit.VolumeId != nil && *edit.VolumeId != 0 {
+		volumeId = edit.VolumeId
+	}
+	_, err = tx.Exec(ctx, `
+		update chapters set content = coalesce(nullif($2, ''), content), name = coalesce(nullif($3, ''), name),
+		format = coalesce(nullif($4, ''), format), status = coalesce(nullif($5, ''), status),
+		publish_at = case when $5 = '' then publish_at else $6 end,
+		volume_id = case when $7 then $8 else volume_id end
+		where id = $1
+`, edit.ID, edit.Content, edit.Name, edit.Format, edit.Status, edit.PublishAt, edit.VolumeId != nil, volumeId)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if edit.Content != "" || edit.Name != "" || edit.Format != "" {
+		err = recordRevision(ctx, tx, edit.ID, authorId)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if edit.Number != 0 {
+		err = moveChapter(ctx, tx, edit.ID, bookId, edit.Number)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return current, errors.WithStack(tx.Commit(ctx))
+}
+
+// recordRevision saves the current name, content and format of a chapter as
+// a new revision, unless they are those of the latest revision already.
+func recordRevision(ctx context.Context, tx pgx.Tx, chapterId, authorId int64) error {
+	_, err := tx.Exec(ctx, `
+		insert into chapter_revisions (chapter_id, name, content, format, author_id)
+		select c.id, c.name, c.content, c.format, $2 from chapters c
+		where c.id = $1 and not exists (
+			select from (
+				select name, content, format from chapter_revisions where chapter_id = $1 order by id desc limit 1
+			) r
+			where r.name = c.name and r.content = c.content and r.format = c.format
+		)
+`, chapterId, authorId)
+	return errors.WithStack(err)
+}
+
+// moveChapter moves a chapter to another position and shifts the chapters
+// in between, keeping numbers unique. Positions past the last chapter move
+// it to the end. The book must be locked.
+func moveChapter(ctx context.Context, tx pgx.Tx, chapterId, bookId, number int64) error {
+	var from int64
+	err := tx.QueryRow(ctx, `
+		select number from chapters where id = $1
+`, chapterId).Scan(&from)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = tx.Exec(ctx, `
+		with target as (
+			select greatest(1, least($3, max(number))) as number from chapters where book_id = $2
+		)
+		update chapters set number = case
+			when id = $1 then target.number
+			when target.number < $4 then chapters.number + 1
+			else chapters.number - 1 end
+		from target
+		where book_id = $2 and (id = $1 or chapters.number between least($4, target.number) and greatest($4, target.number))
+`, chapterId, bookId, number, from)
+	return errors.WithStack(err)
+}
+
+func (d *DB) SearchByTitle(ctx context.Context, title *types.BookTitle) ([]*types.Book, error) {
+	books := make([]*types.Book, 0)
+	rows, err := d.Pool.Query(ctx, `
+			select id, title, author_id, pen_name_id, (select name from pen_names where id = pen_name_id),
+			genre_id, description, cover_image_name, access_read, active, created from books
+			where "like"(title, $1) and active = true and hidden = false and access_read = true and status = 'published'
+`, title.Title+"%")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var book types.Book
+		err := rows.Scan(&book.ID, &book.Title, &book.AuthorId, &book.PenNameId, &book.PenName, &book.Genre, &book.Description, &book.Image, &book.AccessRead, &book.Active, &book.Created)
+		if err != nil {
+
+			return nil, errors.WithStack(err)
+		}
+		books = append(books, &book)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return books, nil
+}
+
+func (d *DB) SearchByAuthor(ctx context.Context, author *types.AuthorName) ([]*types.PenName, error) {
+	penNames := make([]*types.PenName, 0)
+	rows, err := d.Pool.Query(ctx, `
+			select p.id, p.user_id, p.name, p.bio, p.linked, p.created from pen_names p
+			join users u on u.id = p.user_id
+			where "like"(p.name, $1) and p.active = true and u.active = true
+`, author.Name+"%")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var penName types.PenName
+		err := rows.Scan(&penName.ID, &penName.UserId, &penName.Name, &penName.Bio, &penName.Linked, &penName.Created)
+		if err != nil {
+			err := errors.WithStack(err)
+			return nil, err
+		}
+		penNames = append(penNames, &penName)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return penNames, nil
+}
+
+func (d *DB) GetAllGenres(ctx context.Context) ([]*types.Genre, error) {
+	genres := make([]*types.Genre, 0)
+
+	rows, err := d.Pool.Query(ctx, `
+	select * from genres where active = true
+`)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var genre types.Genre
+		err := rows.Scan(&genre.Id, &genre.Name, &genre.Active)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if genre.Active {
+			genres = append(genres, &genre)
+		}
+	}
+	return genres, nil
+}
+
+func (d *DB) SearchGenre(ctx context.Context, genreName types.GenreName) ([]*types.Genre, error) {
+	genres := make([]*types.Genre, 0)
+	rows, err := d.Pool.Query(ctx, `
+	select *from genres where "like"(name, $1) and active = true
+`, genreName.Name+"%")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var genre types.Genre
+		err := rows.Scan(&genre.Id, &genre.Name, &genre.Active)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if genre.Active {
+			genres = append(genres, &genre)
+		}
+	}
+	return genres, nil
+}
+
+func (d *DB) GetBooksByGenreId(ctx context.Context, genreId *types.GenreID) ([]*types.Book, error) {
+	books := make([]*types.Book, 0)
+	rows, err := d.Pool.Query(ctx, `
+		select id, title, genre_id, author_id, pen_name_id, (select name from pen_names where id = pen_name_id),
+		description, cover_image_name, active, created from books
+		where genre_id = $1 and id > $2 and active = true and hidden = false and access_read = true and status = 'published'
+		order by id limit 5 
+`, genreId.Id, genreId.LastBookId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var book types.Book
+		err := rows.Scan(&book.ID, &book.Title, &book.Genre, &book.AuthorId, &book.PenNameId, &book.PenName, &book.Description, &book.Image, &book.Active, &book.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if book.Active {
+			books = append(books, &book)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return books, nil
+}
+
+func (d *DB) GetGenreById(ctx context.Context, genreId types.GenreID) (*types.Genre, error) {
+	var genre types.Genre
+	err := d.Pool.QueryRow(ctx, `
+		select * from genres where id = $1 and active = true
+`, genreId.Id).Scan(&genre.Id, &genre.Name, &genre.Active)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &genre, nil
+}
+
+// PublishScheduled publishes every book and chapter whose time has come.
+// Each row is flipped by a single update, so concurrent runs are harmless.
+func (d *DB) PublishScheduled(ctx context.Context) (books int64, chapters int64, err error) {
+	tag, err := d.Pool.Exec(ctx, `
+		update books set status = 'published' where status = 'scheduled' and publish_at <= current_timestamp
+`)
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	books = tag.RowsAffected()
+	tag, err = d.Pool.Exec(ctx, `
+		update chapters set status = 'published' where status = 'scheduled' and publish_at <= current_timestamp
+`)
+	if err != nil {
+		return books, 0, errors.WithStack(err)
+	}
+	return books, tag.RowsAffected(), nil
+}
+
+func (d *DB) DeleteBook(ctx context.Context, book *types.Book) error {
+	_, err := d.Pool.Exec(ctx, `
+		update books set active = $1 where id = $2
+`, book.Active, book.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) DeleteChapter(ctx context.Context, chapter *types.Chapter) error {
+	_, err := d.Pool.Exec(ctx, `
+		update chapters set active = $1 where id = $2
+`, chapter.Active, chapter.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) AddLike(ctx context.Context, userId *int64, bookId *types.BookId) error {
+	_, err := d.Pool.Exec(ctx, `
+		 insert into ratings (book_id, user_id, created) values ($1, $2, default)
+ `, bookId.Id, userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) DeleteLike(ctx context.Context, ratingId *types.RatingID) error {
+	_, err := d.Pool.Exec(ctx, `
+		 delete from ratings where id = $1
+ `, ratingId.Id)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) GetLikeId(ctx context.Context, userId *int64, bookId *types.BookId) (*types.RatingID, error) {
+	var likeId types.RatingID
+	err := d.Pool.QueryRow(ctx, `
+		select id from ratings where user_id = $1 and book_id = $2
+`, userId, bookId.Id).Scan(&likeId.Id)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &likeId, nil
+}
+
+func (d *DB) BookLikes(ctx context.Context, bookId *types.BookId) (int64, error) {
+	var amount int64
+	err := d.Pool.QueryRow(ctx, `
+				select count(id) from ratings where book_id = $1
+`, bookId.Id).Scan(&amount)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return amount, nil
+}
+
+func (d *DB) GetUserRole(ctx context.Context, userId int64) (string, error) {
+	var role string
+	err := d.Pool.QueryRow(ctx, `
+		select role from users where id = $1 and active = true
+`, userId).Scan(&role)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return role, nil
+}
+
+func (d *DB) SetUserRole(ctx context.Context, userRole *types.UserRole) error {
+	_, err := d.Pool.Exec(ctx, `
+		update users set role = $1 where id = $2
+`, userRole.Role, userRole.Id)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) SetUserActive(ctx context.Context, userActive *types.UserActive) error {
+	_, err := d.Pool.Exec(ctx, `
+		update users set active = $1 where id = $2
+`, userActive.Active, userActive.Id)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) HideBook(ctx context.Context, book *types.Book) error {
+	_, err := d.Pool.Exec(ctx, `
+		update books set hidden = $1 where id = $2
+`, book.Hidden, book.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) HideChapter(ctx context.Context, chapter *types.Chapter) error {
+	_, err := d.Pool.Exec(ctx, `
+		update chapters set hidden = $1 where id = $2
+`, chapter.Hidden, chapter.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) CreateGenre(ctx context.Context, genre *types.Genre) error {
+	_, err := d.Pool.Exec(ctx, `
+		insert into genres (name, active) values ($1, default)
+`, genre.Name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) UpdateGenre(ctx context.Context, genre *types.Genre) error {
+	_, err := d.Pool.Exec(ctx, `
+		update genres set name = $1, active = $2 where id = $3
+`, genre.Name, genre.Active, genre.Id)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) UserIdByEmail(ctx context.Context, email string) (id int64, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		select id from users where email = $1 and active = true
+`, email).Scan(&id)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return id, nil
+}
+
+func (d *DB) UserIdByVerifiedEmail(ctx context.Context, email string) (id int64, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		select id from users where email = $1 and email_verified = true and active = true
+`, email).Scan(&id)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return id, nil
+}
+
+func (d *DB) PutOIDCLogin(ctx context.Context, login *types.OIDCLogin) error {
+	_, err := d.Pool.Exec(ctx, `
+		insert into oidc_logins (state_hash, nonce, code_verifier, user_id, expire) values ($1, $2, $3, nullif($4, 0), $5)
+`, login.State, login.Nonce, login.CodeVerifier, login.UserId, login.Expire)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// UseOIDCLogin removes the pending login so that its state works only once.
+func (d *DB) UseOIDCLogin(ctx context.Context, stateHash string) (*types.OIDCLogin, error) {
+	var login types.OIDCLogin
+	err := d.Pool.QueryRow(ctx, `
+		delete from oidc_logins where state_hash = $1
+		returning state_hash, nonce, code_verifier, coalesce(user_id, 0), expire
+`, stateHash).Scan(&login.State, &login.Nonce, &login.CodeVerifier, &login.UserId, &login.Expire)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &login, nil
+}
+
+func (d *DB) DeleteExpiredOIDCLogins(ctx context.Context) error {
+	_, err := d.Pool.Exec(ctx, `
+		delete from oidc_logins where expire < current_timestamp
+`)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// UserIdByIdentity returns the owner of an external identity, or pgx.ErrNoRows
+// when it is not linked. The owner may be inactive.
+func (d *DB) UserIdByIdentity(ctx context.Context, issuer, subject string) (id int64, active bool, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		select u.id, u.active from user_identities i join users u on u.id = i.user_id
+		where i.issuer = $1 and i.subject = $2
+`, issuer, subject).Scan(&id, &active)
+	if err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	return id, active, nil
+}
+
+func (d *DB) CreateIdentity(ctx context.Context, identity *types.Identity) error {
+	err := d.Pool.QueryRow(ctx, `
+		insert into user_identities (user_id, issuer, subject, email) values ($1, $2, $3, $4)
+		returning id, created
+`, identity.UserId, identity.Issuer, identity.Subject, identity.Email).Scan(&identity.ID, &identity.Created)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) GetIdentities(ctx context.Context, userId int64) ([]*types.Identity, error) {
+	identities := make([]*types.Identity, 0)
+	rows, err := d.Pool.Query(ctx, `
+		select id, issuer, subject, email, created from user_identities where user_id = $1 order by created
+`, userId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var identity types.Identity
+		err := rows.Scan(&identity.ID, &identity.Issuer, &identity.Subject, &identity.Email, &identity.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		identities = append(identities, &identity)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return identities, nil
+}
+
+func (d *DB) DeleteIdentity(ctx context.Context, id, userId int64) error {
+	err := d.Pool.QueryRow(ctx, `
+		delete from user_identities where id = $1 and user_id = $2 returning id
+`, id, userId).Scan(&id)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) PutPasswordReset(ctx context.Context, userId int64, tokenHash string, expire time.Time) error {
+	_, err := d.Pool.Exec(ctx, `
+		insert into password_resets (user_id, token_hash, expire) values ($1, $2, $3)
+`, userId, tokenHash, expire)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// ResetPassword redeems the reset token, stores the new hash and revokes every
+// session of the user in one transaction.
+func (d *DB) ResetPassword(ctx context.Context, tokenHash string, hash string) error {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userId int64
+	err = tx.QueryRow(ctx, `
+		update password_resets set used = true
+		where token_hash = $1 and used = false and expire > current_timestamp
+		returning user_id
+`, tokenHash).Scan(&userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = tx.Exec(ctx, `
+		update users set password = $1 where id = $2
+`, hash, userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = tx.Exec(ctx, `
+		update users_tokens set revoked = true where user_id = $1 and revoked = false
+`, userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(tx.Commit(ctx))
+}
+
+func (d *DB) VerifyEmail(ctx context.Context, tokenHash string) error {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userId int64
+	err = tx.QueryRow(ctx, `
+		update email_verifications set used = true
+		where token_hash = $1 and used = false and expire > current_timestamp
+		returning user_id
+`, tokenHash).Scan(&userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = tx.Exec(ctx, `
+		update users set email_verified = true, active = true where id = $1 and email_verified = false
+`, userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(tx.Commit(ctx))
+}
+
+func (d *DB) CreateInviteCode(ctx context.Context, invite *types.InviteCode) error {
+	err := d.Pool.QueryRow(ctx, `
+		insert into invite_codes (code, max_uses, created_by) values ($1, $2, $3)
+		returning id, created
+`, invite.Code, invite.MaxUses, invite.CreatedBy).Scan(&invite.ID, &invite.Created)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) GetInviteCodes(ctx context.Context) ([]*types.InviteCode, error) {
+	invites := make([]*types.InviteCode, 0)
+	byId := make(map[int64]*types.InviteCode)
+	rows, err := d.Pool.Query(ctx, `
+		select id, code, max_uses, uses, created_by, created from invite_codes order by id
+`)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		invite := types.InviteCode{Redemptions: make([]*types.InviteRedemption, 0)}
+		err := rows.Scan(&invite.ID, &invite.Code, &invite.MaxUses, &invite.Uses, &invite.CreatedBy, &invite.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		invites = append(invites, &invite)
+		byId[invite.ID] = &invite
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	rows, err = d.Pool.Query(ctx, `
+		select r.invite_id, r.user_id, u.login, r.created from invite_redemptions r
+		join users u on u.id = r.user_id order by r.created
+`)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var inviteId int64
+		var redemption types.InviteRedemption
+		err := rows.Scan(&inviteId, &redemption.UserId, &redemption.Login, &redemption.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if invite, ok := byId[inviteId]; ok {
+			invite.Redemptions = append(invite.Redemptions, &redemption)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return invites, nil
+}
+
+func (d *DB) GetUserLogin(ctx context.Context, userId int64) (login string, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		select login from users where id = $1
+`, userId).Scan(&login)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return login, nil
+}
+
+// GetUserNames returns the identifiers a password of the user must not
+// contain.
+func (d *DB) GetUserNames(ctx context.Context, userId int64) (login, name, email string, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		select login, name, coalesce(email, '') from users where id = $1
+`, userId).Scan(&login, &name, &email)
+	if err != nil {
+		return "", "", "", errors.WithStack(err)
+	}
+	return login, name, email, nil
+}
+
+// PasswordResetUser returns the user of a reset token that can still be used.
+func (d *DB) PasswordResetUser(ctx context.Context, tokenHash string) (userId int64, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		select user_id from password_resets
+		where token_hash = $1 and used = false and expire > current_timestamp
+`, tokenHash).Scan(&userId)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return userId, nil
+}
+
+func (d *DB) GetTOTP(ctx context.Context, userId int64) (*types.TOTP, error) {
+	var totp types.TOTP
+	err := d.Pool.QueryRow(ctx, `
+		select totp_secret, totp_pending_secret, totp_enabled, totp_last_step from users where id = $1
+`, userId).Scan(&totp.Secret, &totp.PendingSecret, &totp.Enabled, &totp.LastStep)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &totp, nil
+}
+
+// SetPendingTOTPSecret keeps a new secret until its first code confirms it.
+// The error is pgx.ErrNoRows when two-factor authentication is on already.
+func (d *DB) SetPendingTOTPSecret(ctx context.Context, userId int64, secret string) error {
+	tag, err := d.Pool.Exec(ctx, `
+		update users set totp_pending_secret = $1 where id = $2 and totp_enabled = false
+`, secret, userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.WithStack(pgx.ErrNoRows)
+	}
+	return nil
+}
+
+// EnableTOTP makes the pending secret the active one, provided it is still
+// the secret the code was checked against. The error is pgx.ErrNoRows
+// otherwise.
+func (d *DB) EnableTOTP(ctx context.Context, userId int64, secret string, step int64, codeHashes []string) error {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		update users set totp_secret = totp_pending_secret, totp_pending_secret = '', totp_enabled = true, totp_last_step = $1
+		where id = $2 and totp_enabled = false and totp_pending_secret = $3
+`, step, userId, secret)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.WithStack(pgx.ErrNoRows)
+	}
+	_, err = tx.Exec(ctx, `
+		delete from recovery_codes where user_id = $1
+`, userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, hash := range codeHashes {
+		_, err = tx.Exec(ctx, `
+		insert into recovery_codes (user_id, code_hash) values ($1, $2)
+`, userId, hash)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(tx.Commit(ctx))
+}
+
+func (d *DB) DisableTOTP(ctx context.Context, userId int64) error {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		update users set totp_secret = '', totp_pending_secret = '', totp_enabled = false, totp_last_step = 0 where id = $1
+`, userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = tx.Exec(ctx, `
+		delete from recovery_codes where user_id = $1
+`, userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(tx.Commit(ctx))
+}
+
+// UseTOTPStep records the step of an accepted code and reports false when
+// that step or a later one was already used.
+func (d *DB) UseTOTPStep(ctx context.Context, userId, step int64) (bool, error) {
+	tag, err := d.Pool.Exec(ctx, `
+		update users set totp_last_step = $1 where id = $2 and totp_last_step < $1
+`, step, userId)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (d *DB) UseRecoveryCode(ctx context.Context, userId int64, codeHash string) (bool, error) {
+	tag, err := d.Pool.Exec(ctx, `
+		update recovery_codes set used = true where user_id = $1 and code_hash = $2 and used = false
+`, userId, codeHash)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (d *DB) PutMFAChallenge(ctx context.Context, userId int64, tokenHash string, expire time.Time) error {
+	_, err := d.Pool.Exec(ctx, `
+		insert into mfa_challenges (user_id, token_hash, expire) values ($1, $2, $3)
+`, userId, tokenHash, expire)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// AttemptMFAChallenge counts an attempt against a pending challenge and
+// returns its user. Spent, expired and exhausted challenges are not found.
+func (d *DB) AttemptMFAChallenge(ctx context.Context, tokenHash string, maxAttempts int64) (userId int64, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		update mfa_challenges set attempts = attempts + 1
+		where token_hash = $1 and used = false and expire > current_timestamp and attempts < $2
+		returning user_id
+`, tokenHash, maxAttempts).Scan(&userId)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return userId, nil
+}
+
+// UseMFAChallenge spends a pending challenge. Of two requests completing
+// the same challenge only one gets through; for the other, and for an
+// expired challenge, the error is pgx.ErrNoRows.
+func (d *DB) UseMFAChallenge(ctx context.Context, tokenHash string) error {
+	tag, err := d.Pool.Exec(ctx, `
+		update mfa_challenges set used = true where token_hash = $1 and used = false and expire > current_timestamp
+`, tokenHash)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.WithStack(pgx.ErrNoRows)
+	}
+	return nil
+}
+
+// LockedUntil returns the latest lock among the given throttle keys.
+func (d *DB) LockedUntil(ctx context.Context, keys []string) (time.Time, error) {
+	var lockedUntil time.Time
+	err := d.Pool.QueryRow(ctx, `
+		select coalesce(max(locked_until), current_timestamp) from login_throttles where key = any($1)
+`, keys).Scan(&lockedUntil)
+	if err != nil {
+		return lockedUntil, errors.WithStack(err)
+	}
+	return lockedUntil, nil
+}
+
+// AddLoginFailure counts a failed attempt for the key, starting over when
+// the previous failure is older than reset, and returns the new count.
+func (d *DB) AddLoginFailure(ctx context.Context, key string, reset time.Duration) (failures int64, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		insert into login_throttles (key, failures) values ($1, 1)
+		on conflict (key) do update set
+			failures = case when login_throttles.last_failure < current_timestamp - $2 * interval '1 second' then 1
+			           else login_throttles.failures + 1 end,
+			last_failure = current_timestamp
+		returning failures
+`, key, int64(reset.Seconds())).Scan(&failures)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return failures, nil
+}
+
+func (d *DB) LockLogin(ctx context.Context, key string, lockedUntil time.Time) error {
+	_, err := d.Pool.Exec(ctx, `
+		update login_throttles set locked_until = greatest(locked_until, $1) where key = $2
+`, lockedUntil, key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) PutLockoutEvent(ctx context.Context, event *types.LockoutEvent) error {
+	_, err := d.Pool.Exec(ctx, `
+		insert into lockout_events (key, failures, locked_until) values ($1, $2, $3)
+`, event.Key, event.Failures, event.LockedUntil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := d.Pool.Exec(ctx, `
+		delete from login_throttles where key = $1
+`, key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) GetLockoutEvents(ctx context.Context) ([]*types.LockoutEvent, error) {
+	events := make([]*types.LockoutEvent, 0)
+	rows, err := d.Pool.Query(ctx, `
+		select id, key, failures, locked_until, created from lockout_events
+		order by id desc limit 100
+`)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event types.LockoutEvent
+		err := rows.Scan(&event.ID, &event.Key, &event.Failures, &event.LockedUntil, &event.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		events = append(events, &event)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return events, nil
+}
+
+func (d *DB) GetProfile(ctx context.Context, userId int64) (*types.Profile, error) {
+	var profile types.Profile
+	err := d.Pool.QueryRow(ctx, `
+		select id, name, bio, avatar_image_name, links, location, created from users
+		where id = $1 and active = true
+`, userId).Scan(&profile.ID, &profile.Name, &profile.Bio, &profile.Avatar, &profile.Links, &profile.Location, &profile.Created)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &profile, nil
+}
+
+func (d *DB) EditProfile(ctx context.Context, profile *types.Profile) error {
+	_, err := d.Pool.Exec(ctx, `
+		update users set name = $1, bio = $2, links = $3, location = $4 where id = $5
+`, profile.Name, profile.Bio, profile.Links, profile.Location, profile.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) EditAvatar(ctx context.Context, userId int64, imageName string) error {
+	_, err := d.Pool.Exec(ctx, `
+		update users set avatar_image_name = $1 where id = $2
+`, imageName, userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// AuthorStats counts only the books readers can see, under the pen names
+// linked to the account. Books of the other pen names would reveal who
+// writes them.
+func (d *DB) AuthorStats(ctx context.Context, userId int64) (books int64, likes int64, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		select count(distinct b.id), count(r.id) from books b
+		join pen_names p on p.id = b.pen_name_id and p.linked = true
+		left join ratings r on r.book_id = b.id
+		where b.author_id = $1 and b.active = true and b.hidden = false and b.access_read = true and b.status = 'published'
+`, userId).Scan(&books, &likes)
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	return books, likes, nil
+}
+
+func (d *DB) GetPasswordHashById(ctx context.Context, userId int64) (hash string, err error) {
+	err = d.Pool.QueryRow(ctx, `
+		select password from users where id = $1
+`, userId).Scan(&hash)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return hash, nil
+}
+
+// ChangePassword stores the new hash and revokes every session of the user
+// except the one making the change.
+func (d *DB) ChangePassword(ctx context.Context, userId int64, hash string, keepTokenHash string) error {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		update users set password = $1 where id = $2
+`, hash, userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = tx.Exec(ctx, `
+		update users_tokens set revoked = true where user_id = $1 and token_hash <> $2 and revoked = false
+`, userId, keepTokenHash)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(tx.Commit(ctx))
+}
+
+func (d *DB) CreatePenName(ctx context.Context, penName *types.PenName) error {
+	err := d.Pool.QueryRow(ctx, `
+		insert into pen_names (user_id, name, bio, linked) values ($1, $2, $3, $4)
+		returning id, active, created
+`, penName.UserId, penName.Name, penName.Bio, penName.Linked).Scan(&penName.ID, &penName.Active, &penName.Created)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// EditPenName updates the fields set in edit and leaves the others alone.
+func (d *DB) EditPenName(ctx context.Context, edit *types.PenNameEdit) error {
+	_, err := d.Pool.Exec(ctx, `
+		update pen_names set name = coalesce(nullif($1, ''), name), bio = coalesce($2, bio),
+		linked = coalesce($3, linked), active = coalesce($4, active) where id = $5
+`, edit.Name, edit.Bio, edit.Linked, edit.Active, edit.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) GetPenNamesByUserId(ctx context.Context, userId int64) ([]*types.PenName, error) {
+	penNames := make([]*types.PenName, 0)
+	rows, err := d.Pool.Query(ctx, `
+		select id, user_id, name, bio, linked, active, created from pen_names where user_id = $1 order by id
+`, userId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var penName types.PenName
+		err := rows.Scan(&penName.ID, &penName.UserId, &penName.Name, &penName.Bio, &penName.Linked, &penName.Active, &penName.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		penNames = append(penNames, &penName)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return penNames, nil
+}
+
+func (d *DB) PenNameResource(ctx context.Context, penNameId int64) (*policy.Resource, error) {
+	var resource policy.Resource
+	err := d.Pool.QueryRow(ctx, `
+		select user_id, active from pen_names where id = $1
+`, penNameId).Scan(&resource.OwnerId, &resource.Active)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &resource, nil
+}
+
+func (d *DB) CreateDataExport(ctx context.Context, export *types.DataExport) error {
+	err := d.Pool.QueryRow(ctx, `
+		insert into data_exports (user_id) values ($1) returning id, status, created
+`, export.UserId).Scan(&export.ID, &export.Status, &export.Created)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	return nil
 }
 
-func (d *DB) DeleteLike(ctx context.Context, ratingId *types.RatingID) error {
+// ClaimDataExport marks the oldest pending export as started and returns
+// it. Exports started before staleBefore are claimed again, as the server
+// building them is taken to have stopped, until maxAttempts is reached.
+func (d *DB) ClaimDataExport(ctx context.Context, staleBefore time.Time, maxAttempts int) (*types.DataExport, error) {
+	var export types.DataExport
+	err := d.Pool.QueryRow(ctx, `
+		update data_exports set started = current_timestamp, attempts = attempts + 1
+		where id = (
+			select id from data_exports
+			where status = 'pending' and attempts < $2 and (started is null or started < $1)
+			order by id limit 1
+			for update skip locked
+		)
+		returning id, user_id, status, file_name, created, finished
+`, staleBefore, maxAttempts).Scan(&export.ID, &export.UserId, &export.Status, &export.FileName, &export.Created, &export.Finished)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &export, nil
+}
+
+// FailStaleDataExports gives up on the exports that were started
+// maxAttempts times without finishing.
+func (d *DB) FailStaleDataExports(ctx context.Context, staleBefore time.Time, maxAttempts int) error {
 	_, err := d.Pool.Exec(ctx, `
-		 delete from ratings where id = $1
- `, ratingId.Id)
+		update data_exports set status = 'failed', finished = current_timestamp
+		where status = 'pending' and attempts >= $2 and started < $1
+`, staleBefore, maxAttempts)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	return nil
 }
 
-func (d *DB) GetLikeId(ctx context.Context, userId *int64, bookId *types.BookId) (*types.RatingID, error) {
-	var likeId types.RatingID
+// FinishDataExport returns pgx.ErrNoRows when the export was deleted with
+// its account while it was being built.
+func (d *DB) FinishDataExport(ctx context.Context, export *types.DataExport) error {
+	tag, err := d.Pool.Exec(ctx, `
+		update data_exports set status = $1, file_name = $2, finished = current_timestamp where id = $3
+`, export.Status, export.FileName, export.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.WithStack(pgx.ErrNoRows)
+	}
+	return nil
+}
+
+func (d *DB) GetDataExport(ctx context.Context, id, userId int64) (*types.DataExport, error) {
+	var export types.DataExport
 	err := d.Pool.QueryRow(ctx, `
-		select id from ratings where user_id = $1 and book_id = $2
-`, userId, bookId.Id).Scan(&likeId.Id)
+		select id, user_id, status, file_name, created, finished from data_exports where id = $1 and user_id = $2
+`, id, userId).Scan(&export.ID, &export.UserId, &export.Status, &export.FileName, &export.Created, &export.Finished)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &likeId, nil
+	return &export, nil
 }
 
-func (d *DB) BookLikes(ctx context.Context, bookId *types.BookId) (int64, error) {
-	var amount int64
+func (d *DB) CreateBookPDFExport(ctx context.Context, export *types.BookPDFExport) error {
 	err := d.Pool.QueryRow(ctx, `
-				select count(id) from ratings where book_id = $1
-`, bookId.Id).Scan(&amount)
+		insert into book_pdf_exports (book_id, user_id, page_size) values ($1, $2, $3) returning id, status, created
+`, export.BookId, export.UserId, export.PageSize).Scan(&export.ID, &export.Status, &export.Created)
 	if err != nil {
-		return 0, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
-	return amount, nil
+	return nil
+}
+
+// ClaimBookPDFExport marks the oldest pending export as started and returns
+// it. Exports started before staleBefore are claimed again, as the server
+// rendering them is taken to have stopped, until maxAttempts is reached.
+func (d *DB) ClaimBookPDFExport(ctx context.Context, staleBefore time.Time, maxAttempts int) (*types.BookPDFExport, error) {
+	var export types.BookPDFExport
+	err := d.Pool.QueryRow(ctx, `
+		update book_pdf_exports set started = current_timestamp, attempts = attempts + 1
+		where id = (
+			select id from book_pdf_exports
+			where status = 'pending' and attempts < $2 and (started is null or started < $1)
+			order by id limit 1
+			for update skip locked
+		)
+		returning id, book_id, user_id, page_size, status, file_name, created, finished
+`, staleBefore, maxAttempts).Scan(&export.ID, &export.BookId, &export.UserId, &export.PageSize, &export.Status,
+		&export.FileName, &export.Created, &export.Finished)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &export, nil
+}
+
+// FailStaleBookPDFExports gives up on the exports that were started
+// maxAttempts times without finishing.
+func (d *DB) FailStaleBookPDFExports(ctx context.Context, staleBefore time.Time, maxAttempts int) error {
+	_, err := d.Pool.Exec(ctx, `
+		update book_pdf_exports set status = 'failed', finished = current_timestamp
+		where status = 'pending' and attempts >= $2 and started < $1
+`, staleBefore, maxAttempts)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// FinishBookPDFExport returns pgx.ErrNoRows when the export was deleted
+// with its account while it was being rendered.
+func (d *DB) FinishBookPDFExport(ctx context.Context, export *types.BookPDFExport) error {
+	tag, err := d.Pool.Exec(ctx, `
+		update book_pdf_exports set status = $1, file_name = $2, finished = current_timestamp where id = $3
+`, export.Status, export.FileName, export.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.WithStack(pgx.ErrNoRows)
+	}
+	return nil
+}
+
+func (d *DB) GetBookPDFExport(ctx context.Context, id, userId int64) (*types.BookPDFExport, error) {
+	var export types.BookPDFExport
+	err := d.Pool.QueryRow(ctx, `
+		select id, book_id, user_id, page_size, status, file_name, created, finished from book_pdf_exports
+		where id = $1 and user_id = $2
+`, id, userId).Scan(&export.ID, &export.BookId, &export.UserId, &export.PageSize, &export.Status,
+		&export.FileName, &export.Created, &export.Finished)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &export, nil
+}
+
+// DeleteExpiredExports removes the exports of both kinds that finished
+// before finishedBefore and returns the names of their files.
+func (d *DB) DeleteExpiredExports(ctx context.Context, finishedBefore time.Time) ([]string, error) {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer tx.Rollback(ctx)
+
+	files, err := deleteExports(ctx, tx, "finished < $1", finishedBefore)
+	if err != nil {
+		return nil, err
+	}
+	return files, errors.WithStack(tx.Commit(ctx))
+}
+
+// deleteExports deletes the data and PDF exports matching condition, which
+// takes arg as $1, and returns the names of the files they leave behind.
+func deleteExports(ctx context.Context, tx pgx.Tx, condition string, arg interface{}) ([]string, error) {
+	files := make([]string, 0)
+	for _, table := range []string{"data_exports", "book_pdf_exports"} {
+		names, err := deleteExportsFrom(ctx, tx, table, condition, arg)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, names...)
+	}
+	return files, nil
+}
+
+func deleteExportsFrom(ctx context.Context, tx pgx.Tx, table, condition string, arg interface{}) ([]string, error) {
+	rows, err := tx.Query(ctx, `
+		delete from `+table+` where `+condition+` returning file_name
+`, arg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	files := make([]string, 0)
+	for rows.Next() {
+		var fileName string
+		err := rows.Scan(&fileName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if fileName != "" {
+			files = append(files, fileName)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return files, nil
+}
+
+// GetAllBooksOfUser returns every book of the user, deleted and hidden ones included.
+func (d *DB) GetAllBooksOfUser(ctx context.Context, userId int64) ([]*types.Book, error) {
+	books := make([]*types.Book, 0)
+	rows, err := d.Pool.Query(ctx, `
+		select id, title, genre_id, author_id, pen_name_id, (select name from pen_names where id = pen_name_id),
+		description, cover_image_name, access_read, active, hidden, created from books
+		where author_id = $1 order by id
+`, userId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var book types.Book
+		err := rows.Scan(&book.ID, &book.Title, &book.Genre, &book.AuthorId, &book.PenNameId, &book.PenName,
+			&book.Description, &book.Image, &book.AccessRead, &book.Active, &book.Hidden, &book.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		books = append(books, &book)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return books, nil
+}
+
+// GetAllChapters returns every chapter of the book with its content, deleted and hidden ones included.
+func (d *DB) GetAllChapters(ctx context.Context, bookId int64) ([]*types.Chapter, error) {
+	chapters := make([]*types.Chapter, 0)
+	rows, err := d.Pool.Query(ctx, `
+		select id, book_id, number, name, content, active, hidden, created from chapters
+		where book_id = $1 order by number
+`, bookId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var chapter types.Chapter
+		err := rows.Scan(&chapter.ID, &chapter.BookId, &chapter.Number, &chapter.Name, &chapter.Content,
+			&chapter.Active, &chapter.Hidden, &chapter.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		chapters = append(chapters, &chapter)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return chapters, nil
+}
+
+func (d *DB) GetLikesOfUser(ctx context.Context, userId int64) ([]*types.Like, error) {
+	likes := make([]*types.Like, 0)
+	rows, err := d.Pool.Query(ctx, `
+		select id, book_id, created from ratings where user_id = $1 order by id
+`, userId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var like types.Like
+		err := rows.Scan(&like.ID, &like.BookId, &like.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		likes = append(likes, &like)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return likes, nil
+}
+
+func (d *DB) PutAccountDeletion(ctx context.Context, deletion *types.AccountDeletion) error {
+	err := d.Pool.QueryRow(ctx, `
+		insert into account_deletions (user_id, books_action, execute_after) values ($1, $2, $3)
+		on conflict (user_id) do update set books_action = excluded.books_action
+		returning execute_after, created
+`, deletion.UserId, deletion.Books, deletion.ExecuteAfter).Scan(&deletion.ExecuteAfter, &deletion.Created)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) GetAccountDeletion(ctx context.Context, userId int64) (*types.AccountDeletion, error) {
+	var deletion types.AccountDeletion
+	err := d.Pool.QueryRow(ctx, `
+		select user_id, books_action, execute_after, created from account_deletions where user_id = $1
+`, userId).Scan(&deletion.UserId, &deletion.Books, &deletion.ExecuteAfter, &deletion.Created)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &deletion, nil
+}
+
+func (d *DB) CancelAccountDeletion(ctx context.Context, userId int64) error {
+	_, err := d.Pool.Exec(ctx, `
+		delete from account_deletions where user_id = $1
+`, userId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *DB) DueAccountDeletions(ctx context.Context) ([]*types.AccountDeletion, error) {
+	deletions := make([]*types.AccountDeletion, 0)
+	rows, err := d.Pool.Query(ctx, `
+		select user_id, books_action, execute_after, created from account_deletions
+		where execute_after <= current_timestamp
+`)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var deletion types.AccountDeletion
+		err := rows.Scan(&deletion.UserId, &deletion.Books, &deletion.ExecuteAfter, &deletion.Created)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		deletions = append(deletions, &deletion)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return deletions, nil
+}
+
+const deletedAuthorLogin = "deleted-author"
+
+// DeleteAccount anonymises the account, revokes its sessions, deletes its
+// exports and deletes or reassigns its books in one transaction. Deleted
+// books go with their chapters, revisions, volumes, ratings and PDF exports.
+// The row lock on the deletion request keeps two server instances from
+// processing it twice. It returns the export files and the images, covers
+// of deleted books and the avatar, which the caller removes once the rows
+// are gone.
+func (d *DB) DeleteAccount(ctx context.Context, userId int64) (exports, images []string, err error) {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var booksAction, avatar string
+	err = tx.QueryRow(ctx, `
+		select d.books_action, u.avatar_image_name from account_deletions d
+		join users u on u.id = d.user_id
+		where d.user_id = $1 and d.execute_after <= current_timestamp
+		for update of d skip locked
+`, userId).Scan(&booksAction, &avatar)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	images = make([]string, 0)
+	if avatar != "" {
+		images = append(images, avatar)
+	}
+
+	if booksAction == types.ReassignBooks {
+		var placeholderId, placeholderPenNameId int64
+		err = tx.QueryRow(ctx, `
+		insert into users (name, login, password, active) values ('Deleted author', $1, $1, false)
+		on conflict (login) do update set login = excluded.login
+		returning id
+`, deletedAuthorLogin).Scan(&placeholderId)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		err = tx.QueryRow(ctx, `
+		select id from pen_names where user_id = $1 order by id limit 1
+`, placeholderId).Scan(&placeholderPenNameId)
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = tx.QueryRow(ctx, `
+		insert into pen_names (user_id, name) values ($1, 'Deleted author') returning id
+`, placeholderId).Scan(&placeholderPenNameId)
+		}
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		_, err = tx.Exec(ctx, `
+		update books set author_id = $1, pen_name_id = $2 where author_id = $3
+`, placeholderId, placeholderPenNameId, userId)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	} else {
+		pdfs, err := deleteExportsFrom(ctx, tx, "book_pdf_exports", "book_id in (select id from books where author_id = $1)", userId)
+		if err != nil {
+			return nil, nil, err
+		}
+		exports = append(exports, pdfs...)
+		covers, err := deleteBooks(ctx, tx, userId)
+		if err != nil {
+			return nil, nil, err
+		}
+		images = append(images, covers...)
+	}
+
+	statements := []string{
+		`update users set name = 'Deleted user', login = 'deleted-' || id, email = null, email_verified = false,
+		password = 'deleted-' || id, totp_secret = '', totp_pending_secret = '', totp_enabled = false, bio = '', avatar_image_name = '',
+		links = '{}', location = '', role = 'user', active = false where id = $1`,
+		`update pen_names set name = 'Deleted author', bio = '', linked = false, active = false where user_id = $1`,
+		`update users_tokens set revoked = true where user_id = $1`,
+		`update api_keys set revoked = true where user_id = $1`,
+		`delete from user_identities where user_id = $1`,
+		`delete from oidc_logins where user_id = $1`,
+		`delete from recovery_codes where user_id = $1`,
+		`delete from ratings where user_id = $1`,
+		`delete from account_deletions where user_id = $1`,
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(ctx, statement, userId)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+	files, err := deleteExports(ctx, tx, "user_id = $1", userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	exports = append(exports, files...)
+	return exports, images, errors.WithStack(tx.Commit(ctx))
+}
+
+// deleteBooks deletes the books of an author with everything that refers
+// to them and returns the names of their covers.
+func deleteBooks(ctx context.Context, tx pgx.Tx, authorId int64) ([]string, error) {
+	statements := []string{
+		`delete from chapter_revisions where chapter_id in
+		(select c.id from chapters c join books b on b.id = c.book_id where b.author_id = $1)`,
+		`delete from chapters where book_id in (select id from books where author_id = $1)`,
+		`delete from volumes where book_id in (select id from books where author_id = $1)`,
+		`delete from ratings where book_id in (select id from books where author_id = $1)`,
+	}
+	for _, statement := range statements {
+		_, err := tx.Exec(ctx, statement, authorId)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	rows, err := tx.Query(ctx, `
+		delete from books where author_id = $1 returning cover_image_name
+`, authorId)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	covers := make([]string, 0)
+	for rows.Next() {
+		var cover string
+		err := rows.Scan(&cover)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if cover != "" {
+			covers = append(covers, cover)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return covers, nil
 }
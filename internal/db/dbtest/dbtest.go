@@ -0,0 +1,91 @@
+// Package dbtest gives tests a database schema of their own, created from
+// schema.sql and dropped when the test ends. Tests that need it are skipped
+// unless Env holds the URL of a Postgres database they may write to.
+package dbtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rustamfozilov/penhub/internal/db"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const Env = "PENHUB_TEST_DATABASE_URL"
+
+// URL returns the database URL, skipping the test when none is set.
+func URL(t testing.TB) string {
+	t.Helper()
+	address := os.Getenv(Env)
+	if address == "" {
+		t.Skip(Env + " is not set")
+	}
+	return address
+}
+
+func New(t testing.TB) *db.DB {
+	t.Helper()
+	address := URL(t)
+	ctx := context.Background()
+	schema := fmt.Sprintf("test_%d_%d", time.Now().UnixNano(), rand.Int63())
+	admin, err := pgxpool.Connect(ctx, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(admin.Close)
+	_, err = admin.Exec(ctx, "create schema "+schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, err := admin.Exec(context.Background(), "drop schema "+schema+" cascade")
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	config, err := pgxpool.ParseConfig(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	ddl, err := os.ReadFile(schemaPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pool.Exec(ctx, string(ddl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &db.DB{Pool: pool}
+}
+
+// schemaPath finds schema.sql at the root of the module, whichever package
+// the test runs in.
+func schemaPath() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "schema.sql")
+}
+
+// CreateUser adds an active user with a verified email and returns its id.
+func CreateUser(t testing.TB, d *db.DB, login, email, passwordHash string) int64 {
+	t.Helper()
+	var id int64
+	err := d.Pool.QueryRow(context.Background(), `
+		insert into users (name, login, email, email_verified, password) values ($1, $1, $2, true, $3) returning id
+`, login, email, passwordHash).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
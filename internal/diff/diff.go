@@ -0,0 +1,182 @@
+// Package diff computes the shortest edit script between two texts, line by
+// line or word by word, with the linear space variant of Myers' O((N+M)D)
+// algorithm.
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	Equal  = "equal"
+	Insert = "insert"
+	Delete = "delete"
+)
+
+// maxEdits bounds the work on texts that have little in common; a part
+// that needs more edits is reported as replaced as a whole.
+const maxEdits = 4000
+
+// Op is a run of tokens that both texts share, or that only the new text
+// (insert) or only the old text (delete) contains. Concatenating the
+// equal and delete runs gives the old text, equal and insert the new one.
+type Op struct {
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+}
+
+func Lines(a, b string) []Op {
+	return compute(splitLines(a), splitLines(b))
+}
+
+func Words(a, b string) []Op {
+	return compute(splitWords(a), splitWords(b))
+}
+
+// splitLines keeps the line breaks so that the ops join back into the text.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// splitWords cuts text into alternating runs of space and non-space.
+func splitWords(text string) []string {
+	words := make([]string, 0)
+	start := 0
+	space := false
+	for i, r := range text {
+		if i > start && unicode.IsSpace(r) != space {
+			words = append(words, text[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(r)
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
+
+func compute(a, b []string) []Op {
+	d := differ{ops: make([]Op, 0)}
+	d.diff(a, b)
+	return d.ops
+}
+
+type differ struct {
+	ops []Op
+}
+
+func (d *differ) add(kind string, tokens []string) {
+	if len(tokens) != 0 {
+		d.ops = appendOp(d.ops, kind, strings.Join(tokens, ""))
+	}
+}
+
+// diff adds the ops that turn a into b. The texts are split where the
+// middle snake of their edit script lies and the halves compared in turn,
+// so memory stays linear in maxEdits however long the texts are.
+func (d *differ) diff(a, b []string) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	d.add(Equal, a[:prefix])
+	oldMiddle, newMiddle := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	switch {
+	case len(oldMiddle) == 0:
+		d.add(Insert, newMiddle)
+	case len(newMiddle) == 0:
+		d.add(Delete, oldMiddle)
+	default:
+		// Both sides are left, so at least two edits are: each half
+		// needs fewer than the whole.
+		x, y, u, v, ok := middleSnake(oldMiddle, newMiddle)
+		if !ok {
+			d.add(Delete, oldMiddle)
+			d.add(Insert, newMiddle)
+			break
+		}
+		d.diff(oldMiddle[:x], newMiddle[:y])
+		d.add(Equal, oldMiddle[x:u])
+		d.diff(oldMiddle[u:], newMiddle[v:])
+	}
+	d.add(Equal, a[len(a)-suffix:])
+}
+
+// middleSnake runs the search for the shortest edit script from both ends
+// at once until the paths meet, and returns the run of equal tokens where
+// they do: a[x:u] equals b[y:v]. It gives up when either path would need
+// more than half of maxEdits.
+func middleSnake(a, b []string) (x, y, u, v int, ok bool) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	limit := (n + m + 1) / 2
+	if limit > maxEdits/2 {
+		limit = maxEdits / 2
+	}
+	// forward[k+offset] is the furthest x the forward search reached on
+	// diagonal k = x-y, backward[k+offset] the same for the search from the
+	// end, counted from the end. Diagonal k of one is delta-k of the other.
+	offset := limit + 1
+	forward := make([]int, 2*limit+3)
+	backward := make([]int, 2*limit+3)
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			x, y := furthest(forward, offset, k, d)
+			u, v := x, y
+			for u < n && v < m && a[u] == b[v] {
+				u++
+				v++
+			}
+			forward[offset+k] = u
+			c := delta - k
+			if odd && c >= -(d-1) && c <= d-1 && u+backward[offset+c] >= n {
+				return x, y, u, v, true
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			x, y := furthest(backward, offset, k, d)
+			u, v := x, y
+			for u < n && v < m && a[n-1-u] == b[m-1-v] {
+				u++
+				v++
+			}
+			backward[offset+k] = u
+			c := delta - k
+			if !odd && c >= -d && c <= d && u+forward[offset+c] >= n {
+				return n - u, m - v, n - x, m - y, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+// furthest returns where the path on diagonal k starts in round d: one
+// step down or right from the neighbouring diagonal that got further.
+func furthest(reached []int, offset, k, d int) (x, y int) {
+	if k == -d || (k != d && reached[offset+k-1] < reached[offset+k+1]) {
+		x = reached[offset+k+1]
+	} else {
+		x = reached[offset+k-1] + 1
+	}
+	return x, x - k
+}
+
+// appendOp merges consecutive tokens of the same kind into one op.
+func appendOp(ops []Op, kind, text string) []Op {
+	if len(ops) != 0 && ops[len(ops)-1].Kind == kind {
+		ops[len(ops)-1].Text += text
+		return ops
+	}
+	return append(ops, Op{Kind: kind, Text: text})
+}
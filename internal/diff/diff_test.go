@@ -0,0 +1,201 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rebuild joins the ops back into the old and the new text.
+func rebuild(ops []Op) (a, b string) {
+	var before, after strings.Builder
+	for _, op := range ops {
+		switch op.Kind {
+		case Equal:
+			before.WriteString(op.Text)
+			after.WriteString(op.Text)
+		case Delete:
+			before.WriteString(op.Text)
+		case Insert:
+			after.WriteString(op.Text)
+		}
+	}
+	return before.String(), after.String()
+}
+
+// edits counts the lines the ops insert or delete.
+func edits(ops []Op) int {
+	count := 0
+	for _, op := range ops {
+		if op.Kind != Equal {
+			count += len(splitLines(op.Text))
+		}
+	}
+	return count
+}
+
+// lcs is the length of the longest common subsequence, by the textbook
+// quadratic table.
+func lcs(a, b []string) int {
+	table := make([][]int, len(a)+1)
+	for i := range table {
+		table[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				table[i][j] = table[i+1][j+1] + 1
+			case table[i+1][j] > table[i][j+1]:
+				table[i][j] = table[i+1][j]
+			default:
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+	return table[0][0]
+}
+
+func checkLines(t *testing.T, a, b string) []Op {
+	t.Helper()
+	ops := Lines(a, b)
+	before, after := rebuild(ops)
+	if before != a || after != b {
+		t.Fatalf("Lines(%q, %q) = %v rebuilds %q and %q", a, b, ops, before, after)
+	}
+	for i, op := range ops {
+		if op.Text == "" {
+			t.Errorf("Lines(%q, %q): empty op %d", a, b, i)
+		}
+		if i > 0 && ops[i-1].Kind == op.Kind {
+			t.Errorf("Lines(%q, %q): ops %d and %d are both %s", a, b, i-1, i, op.Kind)
+		}
+	}
+	return ops
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Op
+	}{
+		{"both empty", "", "", []Op{}},
+		{"old empty", "", "one\ntwo\n", []Op{{Insert, "one\ntwo\n"}}},
+		{"new empty", "one\ntwo\n", "", []Op{{Delete, "one\ntwo\n"}}},
+		{"same", "one\ntwo\n", "one\ntwo\n", []Op{{Equal, "one\ntwo\n"}}},
+		{"insert", "one\nthree\n", "one\ntwo\nthree\n", []Op{{Equal, "one\n"}, {Insert, "two\n"}, {Equal, "three\n"}}},
+		{"delete", "one\ntwo\nthree\n", "one\nthree\n", []Op{{Equal, "one\n"}, {Delete, "two\n"}, {Equal, "three\n"}}},
+		{"replace", "one\ntwo\nthree\n", "one\n2\nthree\n", []Op{{Equal, "one\n"}, {Delete, "two\n"}, {Insert, "2\n"}, {Equal, "three\n"}}},
+		{"no trailing newline", "one\ntwo", "one\ntwo\n", []Op{{Equal, "one\n"}, {Delete, "two"}, {Insert, "two\n"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := checkLines(t, tt.a, tt.b)
+			if !reflect.DeepEqual(ops, tt.want) {
+				t.Errorf("Lines = %v, want %v", ops, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"\n", []string{"\n"}},
+		{"one", []string{"one"}},
+		{"one\ntwo", []string{"one\n", "two"}},
+		{"one\ntwo\n", []string{"one\n", "two\n"}},
+		{"one\n\n", []string{"one\n", "\n"}},
+	}
+	for _, tt := range tests {
+		if got := splitLines(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitLines(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"word", []string{"word"}},
+		{"  two words ", []string{"  ", "two", " ", "words", " "}},
+		{"no break", []string{"no", " ", "break"}},
+		{"иероглиф　и тире\tтаб", []string{"иероглиф", "　", "и", " ", "тире", "\t", "таб"}},
+		{"line\r\nnext", []string{"line", "\r\n", "next"}},
+	}
+	for _, tt := range tests {
+		if got := splitWords(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	a := "Мороз и солнце; день чудесный"
+	b := "Мороз и солнце; день прекрасный"
+	ops := Words(a, b)
+	before, after := rebuild(ops)
+	if before != a || after != b {
+		t.Fatalf("Words = %v rebuilds %q and %q", ops, before, after)
+	}
+	want := []Op{{Equal, "Мороз и солнце; день "}, {Delete, "чудесный"}, {Insert, "прекрасный"}}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("Words = %v, want %v", ops, want)
+	}
+}
+
+// Random texts over a small alphabet have many ways to line up. Every
+// script must rebuild both texts and be as short as the longest common
+// subsequence allows.
+func TestLinesShortest(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	text := func() string {
+		lines := make([]string, random.Intn(30))
+		for i := range lines {
+			lines[i] = fmt.Sprintf("%c\n", 'a'+random.Intn(4))
+		}
+		return strings.Join(lines, "")
+	}
+	for i := 0; i < 500; i++ {
+		a, b := text(), text()
+		ops := checkLines(t, a, b)
+		lines, otherLines := splitLines(a), splitLines(b)
+		want := len(lines) + len(otherLines) - 2*lcs(lines, otherLines)
+		if got := edits(ops); got != want {
+			t.Fatalf("Lines(%q, %q) makes %d edits, want %d: %v", a, b, got, want, ops)
+		}
+	}
+}
+
+// Texts that need more than maxEdits edits still rebuild; the part that
+// differs too much is replaced as a whole.
+func TestLinesMaxEdits(t *testing.T) {
+	before := make([]string, 0, maxEdits)
+	after := make([]string, 0, maxEdits)
+	for i := 0; i < maxEdits; i++ {
+		before = append(before, fmt.Sprintf("old %d\n", i))
+		after = append(after, fmt.Sprintf("new %d\n", i))
+	}
+	a := "first\n" + strings.Join(before, "") + "last\n"
+	b := "first\n" + strings.Join(after, "") + "last\n"
+	ops := checkLines(t, a, b)
+	want := []Op{{Equal, "first\n"}, {Delete, strings.Join(before, "")}, {Insert, strings.Join(after, "")}, {Equal, "last\n"}}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("Lines gave %d ops, want delete and insert of the middle", len(ops))
+	}
+
+	// A few shared lines in between do not change the outcome.
+	for i := 0; i < maxEdits; i += 1000 {
+		after[i] = before[i]
+	}
+	checkLines(t, strings.Join(before, ""), strings.Join(after, ""))
+}
@@ -0,0 +1,240 @@
+// Package epub writes EPUB 3 publications: the OCF container, a package
+// document with metadata, manifest and spine, and a navigation document.
+// Chapter bodies are XHTML fragments such as the output of the markup
+// package.
+package epub
+
+import (
+	"archive/zip"
+	"fmt"
+	"github.com/pkg/errors"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Book struct {
+	// Identifier is the unique identifier of the publication, such as a URN.
+	Identifier  string
+	Title       string
+	Language    string
+	Author      string
+	Description string
+	Modified    time.Time
+	Cover       *Cover
+	// Parts hold the chapters in reading order. Chapters of a part with a
+	// title are nested under it in the table of contents.
+	Parts []*Part
+}
+
+type Part struct {
+	Title    string
+	Chapters []*Chapter
+}
+
+type Chapter struct {
+	Title string
+	Body  string
+}
+
+type Cover struct {
+	// Extension of the image file, such as ".jpg".
+	Extension string
+	MediaType string
+	Data      []byte
+}
+
+const stylesheet = `body { margin: 0 5%; line-height: 1.4; }
+h1 { text-align: center; margin: 2em 0 1em; }
+p { margin: 0; text-indent: 1.5em; }
+hr { border: none; margin: 1.5em 0; text-align: center; }
+hr::after { content: "* * *"; }
+blockquote { margin: 1em 2em; }
+section.footnotes { font-size: 0.85em; margin-top: 2em; }
+.cover { text-align: center; margin: 0; }
+.cover img { max-width: 100%; max-height: 100%; }
+`
+
+// Write writes book as an EPUB file. The book needs at least one chapter.
+func Write(w io.Writer, book *Book) error {
+	archive := zip.NewWriter(w)
+	// The mimetype entry comes first and uncompressed so that the file can
+	// be recognised by its leading bytes.
+	mimetype, err := archive.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.WriteString(mimetype, "application/epub+zip")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	files := []file{
+		{"META-INF/container.xml", container},
+		{"OEBPS/content.opf", packageDocument(book)},
+		{"OEBPS/nav.xhtml", navigation(book)},
+		{"OEBPS/style.css", stylesheet},
+	}
+	if book.Cover != nil {
+		files = append(files, file{"OEBPS/cover.xhtml", coverPage(book)})
+	}
+	for i, chapter := range chapters(book) {
+		files = append(files, file{"OEBPS/" + chapterFile(i), chapterPage(book, chapter)})
+	}
+	for _, item := range files {
+		err = writeFile(archive, item.name, []byte(item.content))
+		if err != nil {
+			return err
+		}
+	}
+	if book.Cover != nil {
+		err = writeFile(archive, "OEBPS/cover"+book.Cover.Extension, book.Cover.Data)
+		if err != nil {
+			return err
+		}
+	}
+	return errors.WithStack(archive.Close())
+}
+
+type file struct {
+	name    string
+	content string
+}
+
+func writeFile(archive *zip.Writer, name string, data []byte) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = w.Write(data)
+	return errors.WithStack(err)
+}
+
+const container = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+func chapters(book *Book) []*Chapter {
+	result := make([]*Chapter, 0)
+	for _, part := range book.Parts {
+		result = append(result, part.Chapters...)
+	}
+	return result
+}
+
+func chapterFile(i int) string {
+	return fmt.Sprintf("chapter-%d.xhtml", i+1)
+}
+
+func escape(text string) string {
+	return html.EscapeString(text)
+}
+
+func packageDocument(book *Book) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="` + escape(book.Language) + `">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	b.WriteString(`    <dc:identifier id="book-id">` + escape(book.Identifier) + "</dc:identifier>\n")
+	b.WriteString("    <dc:title>" + escape(book.Title) + "</dc:title>\n")
+	b.WriteString("    <dc:language>" + escape(book.Language) + "</dc:language>\n")
+	if book.Author != "" {
+		b.WriteString("    <dc:creator>" + escape(book.Author) + "</dc:creator>\n")
+	}
+	if book.Description != "" {
+		b.WriteString("    <dc:description>" + escape(book.Description) + "</dc:description>\n")
+	}
+	b.WriteString(`    <meta property="dcterms:modified">` + book.Modified.UTC().Format("2006-01-02T15:04:05Z") + "</meta>\n")
+	if book.Cover != nil {
+		// Read by EPUB 2 readers that do not know the cover-image property.
+		b.WriteString(`    <meta name="cover" content="cover-image"/>` + "\n")
+	}
+	b.WriteString("  </metadata>\n  <manifest>\n")
+	b.WriteString(`    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+	b.WriteString(`    <item id="style" href="style.css" media-type="text/css"/>` + "\n")
+	if book.Cover != nil {
+		b.WriteString(`    <item id="cover-image" href="cover` + escape(book.Cover.Extension) + `" media-type="` +
+			escape(book.Cover.MediaType) + `" properties="cover-image"/>` + "\n")
+		b.WriteString(`    <item id="cover" href="cover.xhtml" media-type="application/xhtml+xml"/>` + "\n")
+	}
+	all := chapters(book)
+	for i := range all {
+		b.WriteString(fmt.Sprintf(`    <item id="chapter-%d" href="%s" media-type="application/xhtml+xml"/>`+"\n", i+1, chapterFile(i)))
+	}
+	b.WriteString("  </manifest>\n  <spine>\n")
+	if book.Cover != nil {
+		b.WriteString(`    <itemref idref="cover" linear="no"/>` + "\n")
+	}
+	for i := range all {
+		b.WriteString(fmt.Sprintf(`    <itemref idref="chapter-%d"/>`+"\n", i+1))
+	}
+	b.WriteString("  </spine>\n</package>\n")
+	return b.String()
+}
+
+func page(book *Book, title, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="` +
+		escape(book.Language) + `" lang="` + escape(book.Language) + `">
+<head>
+<meta charset="UTF-8"/>
+<title>` + escape(title) + `</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+` + body + `</body>
+</html>
+`
+}
+
+func navigation(book *Book) string {
+	var b strings.Builder
+	b.WriteString(`<nav epub:type="toc" id="toc">` + "\n<h1>" + escape(book.Title) + "</h1>\n<ol>\n")
+	i := 0
+	for _, part := range book.Parts {
+		if part.Title != "" && len(part.Chapters) != 0 {
+			b.WriteString(`<li><a href="` + chapterFile(i) + `">` + escape(part.Title) + "</a>\n<ol>\n")
+		}
+		for _, chapter := range part.Chapters {
+			b.WriteString(`<li><a href="` + chapterFile(i) + `">` + escape(chapter.Title) + "</a></li>\n")
+			i++
+		}
+		if part.Title != "" && len(part.Chapters) != 0 {
+			b.WriteString("</ol>\n</li>\n")
+		}
+	}
+	b.WriteString("</ol>\n</nav>\n")
+	if book.Cover != nil && i != 0 {
+		b.WriteString(`<nav epub:type="landmarks" hidden="hidden">` + "\n<ol>\n" +
+			`<li><a epub:type="cover" href="cover.xhtml">Cover</a></li>` + "\n" +
+			`<li><a epub:type="bodymatter" href="` + chapterFile(0) + `">Start</a></li>` + "\n</ol>\n</nav>\n")
+	}
+	return page(book, book.Title, b.String())
+}
+
+func coverPage(book *Book) string {
+	body := `<div class="cover"><img src="cover` + escape(book.Cover.Extension) + `" alt="` + escape(book.Title) + `"/></div>` + "\n"
+	return page(book, book.Title, body)
+}
+
+// image matches images as the markup package writes them, with the alt
+// text in the first group. They all refer to remote files.
+var image = regexp.MustCompile(`<img src="[^"]*" alt="([^"]*)"[^>]*/>`)
+
+// localBody replaces images by their alt text: an EPUB file may only refer
+// to remote audio, video and fonts.
+func localBody(body string) string {
+	return image.ReplaceAllString(body, "$1")
+}
+
+func chapterPage(book *Book, chapter *Chapter) string {
+	body := `<section epub:type="chapter">` + "\n<h1>" + escape(chapter.Title) + "</h1>\n" + localBody(chapter.Body) + "</section>\n"
+	return page(book, chapter.Title, body)
+}
@@ -0,0 +1,237 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testBook() *Book {
+	return &Book{
+		Identifier:  "urn:uuid:8b0d3c2e-6d1f-4f0e-9a57-0c1d2e3f4a5b",
+		Title:       "Сказки & <были>",
+		Language:    "ru",
+		Author:      "Шляпник",
+		Description: "Сказки на новый лад",
+		Modified:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Cover:       &Cover{Extension: ".png", MediaType: "image/png", Data: []byte("\x89PNG cover")},
+		Parts: []*Part{
+			{Chapters: []*Chapter{{Title: "Пролог", Body: "<p>Начало.</p>\n"}}},
+			{Title: "Часть первая", Chapters: []*Chapter{
+				{Title: "Глава 1", Body: `<p>Текст <img src="https://example.com/a.png" alt="рисунок"/> дальше.</p>` + "\n"},
+				{Title: "Глава 2", Body: "<p>Конец.</p>\n"},
+			}},
+		},
+	}
+}
+
+// readEPUB writes book and opens the result as a ZIP archive.
+func readEPUB(t *testing.T, book *Book) (*zip.Reader, map[string]string) {
+	t.Helper()
+	var buffer bytes.Buffer
+	err := Write(&buffer, book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(data)
+	}
+	return archive, files
+}
+
+// checkXML fails unless text is a well-formed XML document.
+func checkXML(t *testing.T, name, text string) {
+	t.Helper()
+	decoder := xml.NewDecoder(strings.NewReader(text))
+	decoder.Strict = true
+	decoder.Entity = xml.HTMLEntity
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("%s is not well-formed: %v", name, err)
+		}
+	}
+}
+
+// The mimetype entry must be the first one, stored uncompressed and without
+// extra fields, so that its content is found at byte 38 of the file.
+func TestWriteMimetype(t *testing.T) {
+	var buffer bytes.Buffer
+	err := Write(&buffer, testBook())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) || string(data[30:38]) != "mimetype" || string(data[38:58]) != "application/epub+zip" {
+		t.Errorf("file starts with %q", data[:58])
+	}
+	archive, _ := readEPUB(t, testBook())
+	first := archive.File[0]
+	if first.Name != "mimetype" || first.Method != zip.Store {
+		t.Errorf("first entry %s with method %d", first.Name, first.Method)
+	}
+}
+
+type opf struct {
+	Identifier string `xml:"metadata>identifier"`
+	Title      string `xml:"metadata>title"`
+	Language   string `xml:"metadata>language"`
+	Creator    string `xml:"metadata>creator"`
+	Items      []struct {
+		Id         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IdRef  string `xml:"idref,attr"`
+		Linear string `xml:"linear,attr"`
+	} `xml:"spine>itemref"`
+}
+
+func TestWriteStructure(t *testing.T) {
+	book := testBook()
+	_, files := readEPUB(t, book)
+	for name, content := range files {
+		if strings.HasSuffix(name, ".xml") || strings.HasSuffix(name, ".opf") || strings.HasSuffix(name, ".xhtml") {
+			checkXML(t, name, content)
+		}
+	}
+
+	var container struct {
+		RootFile struct {
+			Path      string `xml:"full-path,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	err := xml.Unmarshal([]byte(files["META-INF/container.xml"]), &container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := container.RootFile
+	if root.Path != "OEBPS/content.opf" || root.MediaType != "application/oebps-package+xml" {
+		t.Fatalf("root file %+v", root)
+	}
+
+	var pkg opf
+	err = xml.Unmarshal([]byte(files[root.Path]), &pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Identifier != book.Identifier || pkg.Title != book.Title || pkg.Language != "ru" || pkg.Creator != book.Author {
+		t.Errorf("metadata %+v", pkg)
+	}
+	hrefs := make(map[string]string)
+	properties := make(map[string]string)
+	for _, item := range pkg.Items {
+		if _, ok := files["OEBPS/"+item.Href]; !ok {
+			t.Errorf("manifest item %s has no file %s", item.Id, item.Href)
+		}
+		hrefs[item.Id] = item.Href
+		properties[item.Id] = item.Properties
+	}
+	if properties["nav"] != "nav" || properties["cover-image"] != "cover-image" {
+		t.Errorf("manifest properties %v", properties)
+	}
+	spine := make([]string, 0)
+	for _, ref := range pkg.Spine {
+		href, ok := hrefs[ref.IdRef]
+		if !ok {
+			t.Errorf("spine refers to unknown item %s", ref.IdRef)
+		}
+		if ref.Linear != "no" {
+			spine = append(spine, href)
+		}
+	}
+	want := []string{"chapter-1.xhtml", "chapter-2.xhtml", "chapter-3.xhtml"}
+	if strings.Join(spine, " ") != strings.Join(want, " ") {
+		t.Errorf("spine %v, want %v", spine, want)
+	}
+	if files["OEBPS/cover.png"] != "\x89PNG cover" {
+		t.Errorf("cover %q", files["OEBPS/cover.png"])
+	}
+}
+
+type navList struct {
+	Items []struct {
+		Link struct {
+			Href string `xml:"href,attr"`
+			Text string `xml:",chardata"`
+		} `xml:"a"`
+		Children *navList `xml:"ol"`
+	} `xml:"li"`
+}
+
+// The table of contents nests the chapters of a titled part under it.
+func TestWriteNavigation(t *testing.T) {
+	_, files := readEPUB(t, testBook())
+	var nav struct {
+		Navs []struct {
+			Type string  `xml:"http://www.idpf.org/2007/ops type,attr"`
+			List navList `xml:"ol"`
+		} `xml:"body>nav"`
+	}
+	err := xml.Unmarshal([]byte(files["OEBPS/nav.xhtml"]), &nav)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nav.Navs) != 2 || nav.Navs[0].Type != "toc" || nav.Navs[1].Type != "landmarks" {
+		t.Fatalf("navs %+v", nav.Navs)
+	}
+	toc := nav.Navs[0].List.Items
+	if len(toc) != 2 || toc[0].Link.Text != "Пролог" || toc[0].Link.Href != "chapter-1.xhtml" {
+		t.Fatalf("toc %+v", toc)
+	}
+	part := toc[1]
+	if part.Link.Text != "Часть первая" || part.Link.Href != "chapter-2.xhtml" || part.Children == nil {
+		t.Fatalf("part %+v", part)
+	}
+	children := part.Children.Items
+	if len(children) != 2 || children[0].Link.Href != "chapter-2.xhtml" || children[1].Link.Text != "Глава 2" {
+		t.Errorf("chapters of the part %+v", children)
+	}
+}
+
+// Remote images are not allowed in a publication and become their alt text.
+func TestWriteDropsRemoteImages(t *testing.T) {
+	_, files := readEPUB(t, testBook())
+	chapter := files["OEBPS/chapter-2.xhtml"]
+	if strings.Contains(chapter, "<img") || !strings.Contains(chapter, "Текст рисунок дальше.") {
+		t.Errorf("chapter 2:\n%s", chapter)
+	}
+}
+
+func TestWriteWithoutCover(t *testing.T) {
+	book := testBook()
+	book.Cover = nil
+	_, files := readEPUB(t, book)
+	for name := range files {
+		if strings.HasPrefix(name, "OEBPS/cover") {
+			t.Errorf("%s written for a book without cover", name)
+		}
+	}
+	if strings.Contains(files["OEBPS/content.opf"], "cover") {
+		t.Error("package document mentions a cover")
+	}
+}
@@ -0,0 +1,129 @@
+// Package fb2 writes FictionBook 2 documents. A document is written while
+// it is built, chapter by chapter, so a book is never held in memory as a
+// whole. Chapter contents are section content such as the FB2 output of the
+// markup package.
+package fb2
+
+import (
+	"bufio"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"html"
+	"io"
+	"time"
+)
+
+type Description struct {
+	// Id is the unique identifier of the document.
+	Id       string
+	Title    string
+	Language string
+	Author   string
+	// Genres are FictionBook genre codes such as "sf_fantasy".
+	Genres []string
+	// Annotation is section content, without a section.
+	Annotation string
+	Date       time.Time
+	Cover      *Cover
+}
+
+type Cover struct {
+	// Extension of the image file, such as ".jpg".
+	Extension string
+	MediaType string
+	Data      []byte
+}
+
+type Writer struct {
+	w     *bufio.Writer
+	cover *Cover
+	// part is the title of the part the next chapter belongs to; its section
+	// is opened with that chapter, so that a part is never empty.
+	part     string
+	partOpen bool
+	chapters int
+}
+
+// NewWriter writes the description and the book title to w. Write errors
+// are returned by Chapter and Close.
+func NewWriter(w io.Writer, description *Description) *Writer {
+	fw := &Writer{w: bufio.NewWriter(w), cover: description.Cover}
+	fw.w.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description>
+<title-info>
+`)
+	for _, genre := range description.Genres {
+		fw.w.WriteString("<genre>" + escape(genre) + "</genre>\n")
+	}
+	author := "<author><nickname>" + escape(description.Author) + "</nickname></author>\n"
+	fw.w.WriteString(author)
+	fw.w.WriteString("<book-title>" + escape(description.Title) + "</book-title>\n")
+	if description.Annotation != "" {
+		fw.w.WriteString("<annotation>\n" + description.Annotation + "</annotation>\n")
+	}
+	date := description.Date.UTC().Format("2006-01-02")
+	fw.w.WriteString(`<date value="` + date + `">` + date + "</date>\n")
+	if fw.cover != nil {
+		fw.w.WriteString(`<coverpage><image l:href="#` + coverId(fw.cover) + `"/></coverpage>` + "\n")
+	}
+	fw.w.WriteString("<lang>" + escape(description.Language) + "</lang>\n</title-info>\n<document-info>\n")
+	fw.w.WriteString(author)
+	fw.w.WriteString(`<date value="` + date + `">` + date + "</date>\n")
+	fw.w.WriteString("<id>" + escape(description.Id) + "</id>\n<version>1.0</version>\n</document-info>\n</description>\n")
+	fw.w.WriteString("<body>\n<title><p>" + escape(description.Author) + "</p><p>" + escape(description.Title) + "</p></title>\n")
+	return fw
+}
+
+// Part starts a part: the next chapters are nested in a section with the
+// title. An empty title puts them directly in the body.
+func (fw *Writer) Part(title string) {
+	fw.closePart()
+	fw.part = title
+}
+
+func (fw *Writer) closePart() {
+	if fw.partOpen {
+		fw.w.WriteString("</section>\n")
+		fw.partOpen = false
+	}
+}
+
+// Chapter writes a chapter; content is the section content.
+func (fw *Writer) Chapter(title, content string) error {
+	if fw.part != "" && !fw.partOpen {
+		fw.w.WriteString("<section>\n<title><p>" + escape(fw.part) + "</p></title>\n")
+		fw.partOpen = true
+	}
+	fw.w.WriteString("<section>\n<title><p>" + escape(title) + "</p></title>\n" + content + "</section>\n")
+	fw.chapters++
+	return errors.WithStack(fw.w.Flush())
+}
+
+// Close ends the body, writes the cover and flushes the document. It does
+// not close the underlying writer.
+func (fw *Writer) Close() error {
+	fw.closePart()
+	if fw.chapters == 0 {
+		// A body holds at least one section.
+		fw.w.WriteString("<section><empty-line/></section>\n")
+	}
+	fw.w.WriteString("</body>\n")
+	if fw.cover != nil {
+		fw.w.WriteString(`<binary id="` + coverId(fw.cover) + `" content-type="` + escape(fw.cover.MediaType) + `">`)
+		encoder := base64.NewEncoder(base64.StdEncoding, fw.w)
+		encoder.Write(fw.cover.Data)
+		encoder.Close()
+		fw.w.WriteString("</binary>\n")
+	}
+	fw.w.WriteString("</FictionBook>\n")
+	return errors.WithStack(fw.w.Flush())
+}
+
+func coverId(cover *Cover) string {
+	return "cover" + escape(cover.Extension)
+}
+
+func escape(text string) string {
+	return html.EscapeString(text)
+}
@@ -0,0 +1,136 @@
+package fb2
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fictionBook struct {
+	XMLName xml.Name `xml:"http://www.gribuser.ru/xml/fictionbook/2.0 FictionBook"`
+	Title   struct {
+		Genres    []string `xml:"genre"`
+		Author    string   `xml:"author>nickname"`
+		BookTitle string   `xml:"book-title"`
+		Cover     struct {
+			Href string `xml:"http://www.w3.org/1999/xlink href,attr"`
+		} `xml:"coverpage>image"`
+		Lang string `xml:"lang"`
+	} `xml:"description>title-info"`
+	Id       string    `xml:"description>document-info>id"`
+	Sections []section `xml:"body>section"`
+	Binaries []struct {
+		Id          string `xml:"id,attr"`
+		ContentType string `xml:"content-type,attr"`
+		Data        string `xml:",chardata"`
+	} `xml:"binary"`
+}
+
+type section struct {
+	Title      []string  `xml:"title>p"`
+	Paragraphs []string  `xml:"p"`
+	Sections   []section `xml:"section"`
+}
+
+func write(t *testing.T, description *Description, chapters func(fw *Writer)) (string, *fictionBook) {
+	t.Helper()
+	var buffer bytes.Buffer
+	fw := NewWriter(&buffer, description)
+	chapters(fw)
+	err := fw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var book fictionBook
+	decoder := xml.NewDecoder(bytes.NewReader(buffer.Bytes()))
+	decoder.Strict = true
+	err = decoder.Decode(&book)
+	if err != nil {
+		t.Fatalf("document is not well-formed: %v\n%s", err, buffer.String())
+	}
+	return buffer.String(), &book
+}
+
+func TestWriter(t *testing.T) {
+	cover := []byte("\x89PNG\r\n\x1a\n cover bytes \x00\xff")
+	document, book := write(t, &Description{
+		Id:         "penhub-book-7",
+		Title:      "Сказки & <были>",
+		Language:   "ru",
+		Author:     "Шляпник",
+		Genres:     []string{"sf_fantasy"},
+		Annotation: "<p>Сказки на новый лад</p>\n",
+		Date:       time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Cover:      &Cover{Extension: ".png", MediaType: "image/png", Data: cover},
+	}, func(fw *Writer) {
+		mustChapter(t, fw, "Пролог", "<p>Начало.</p>\n")
+		fw.Part("Часть первая")
+		mustChapter(t, fw, "Глава 1", "<p>Один.</p>\n")
+		mustChapter(t, fw, "Глава 2", "<p>Два.</p>\n")
+		fw.Part("")
+		mustChapter(t, fw, "Эпилог", "<p>Конец.</p>\n")
+	})
+
+	if book.Title.BookTitle != "Сказки & <были>" || book.Title.Author != "Шляпник" || book.Title.Lang != "ru" || book.Id != "penhub-book-7" {
+		t.Errorf("description %+v, id %q", book.Title, book.Id)
+	}
+	if len(book.Title.Genres) != 1 || book.Title.Genres[0] != "sf_fantasy" {
+		t.Errorf("genres %v", book.Title.Genres)
+	}
+
+	// Chapters outside any part sit next to the section of the part.
+	titles := make([]string, 0)
+	for _, s := range book.Sections {
+		titles = append(titles, strings.Join(s.Title, " / "))
+	}
+	if strings.Join(titles, "|") != "Пролог|Часть первая|Эпилог" {
+		t.Errorf("sections %q", titles)
+	}
+	part := book.Sections[1]
+	if len(part.Sections) != 2 || part.Sections[0].Title[0] != "Глава 1" || part.Sections[1].Paragraphs[0] != "Два." {
+		t.Errorf("part %+v", part)
+	}
+
+	if len(book.Binaries) != 1 {
+		t.Fatalf("%d binaries", len(book.Binaries))
+	}
+	binary := book.Binaries[0]
+	if "#"+binary.Id != book.Title.Cover.Href || binary.ContentType != "image/png" {
+		t.Errorf("cover %q refers to binary %q of type %s", book.Title.Cover.Href, binary.Id, binary.ContentType)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(binary.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, cover) {
+		t.Errorf("cover data %q, want %q", data, cover)
+	}
+	if !strings.HasPrefix(document, `<?xml version="1.0" encoding="UTF-8"?>`) {
+		t.Errorf("document starts with %q", document[:40])
+	}
+}
+
+// A body holds at least one section, and a part without chapters is left
+// out rather than written empty.
+func TestWriterWithoutChapters(t *testing.T) {
+	_, book := write(t, &Description{Id: "1", Title: "Пусто", Language: "ru", Author: "Автор"}, func(fw *Writer) {
+		fw.Part("Часть без глав")
+	})
+	if len(book.Sections) != 1 || len(book.Sections[0].Title) != 0 {
+		t.Errorf("sections %+v", book.Sections)
+	}
+	if len(book.Binaries) != 0 {
+		t.Errorf("%d binaries without a cover", len(book.Binaries))
+	}
+}
+
+func mustChapter(t *testing.T, fw *Writer, title, content string) {
+	t.Helper()
+	err := fw.Chapter(title, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/services"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"io"
+	"log"
+	"net/http"
+)
+
+func (h *Handler) RequestDataExport(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	export, err := h.Service.RequestDataExport(r.Context(), userId)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, export)
+}
+
+func (h *Handler) GetDataExport(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var exportId types.ExportId
+	err = json.NewDecoder(r.Body).Decode(&exportId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	export, err := h.Service.GetDataExport(r.Context(), userId, exportId.Id)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, export)
+}
+
+func (h *Handler) DownloadDataExport(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var exportId types.ExportId
+	err = json.NewDecoder(r.Body).Decode(&exportId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	file, err := h.Service.OpenDataExport(r.Context(), userId, exportId.Id)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if errors.Is(err, services.ErrExportNotReady) {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="penhub-export-%d.zip"`, exportId.Id))
+	_, err = io.Copy(w, file)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (h *Handler) RequestAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var deletion types.AccountDeletion
+	err = json.NewDecoder(r.Body).Decode(&deletion)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.ValidateAccountDeletion(&deletion)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	deletion.UserId = userId
+	err = h.Service.RequestAccountDeletion(r.Context(), &deletion)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, deletion)
+}
+
+func (h *Handler) GetAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	deletion, err := h.Service.GetAccountDeletion(r.Context(), userId)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, deletion)
+}
+
+func (h *Handler) CancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.CancelAccountDeletion(r.Context(), userId)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
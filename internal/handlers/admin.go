@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/http"
+)
+
+func (h *Handler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	var userRole types.UserRole
+	err := json.NewDecoder(r.Body).Decode(&userRole)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.ValidateRole(userRole.Role)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.SetUserRole(r.Context(), &userRole)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) SetUserActive(w http.ResponseWriter, r *http.Request) {
+	var userActive types.UserActive
+	err := json.NewDecoder(r.Body).Decode(&userActive)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.SetUserActive(r.Context(), &userActive)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) CreateGenre(w http.ResponseWriter, r *http.Request) {
+	var genre types.Genre
+	err := json.NewDecoder(r.Body).Decode(&genre)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.ValidateTitle(genre.Name)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.CreateGenre(r.Context(), &genre)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) UpdateGenre(w http.ResponseWriter, r *http.Request) {
+	var genre types.Genre
+	err := json.NewDecoder(r.Body).Decode(&genre)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.ValidateTitle(genre.Name)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.UpdateGenre(r.Context(), &genre)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) HideBook(w http.ResponseWriter, r *http.Request) {
+	var book types.Book
+	err := json.NewDecoder(r.Body).Decode(&book)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.Moderate, book.ID) {
+		return
+	}
+	err = h.Service.HideBook(r.Context(), &book)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) HideChapter(w http.ResponseWriter, r *http.Request) {
+	var chapter types.Chapter
+	err := json.NewDecoder(r.Body).Decode(&chapter)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.Moderate, chapter.ID) {
+		return
+	}
+	err = h.Service.HideChapter(r.Context(), &chapter)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) CreateInviteCode(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var invite types.InviteCode
+	err = json.NewDecoder(r.Body).Decode(&invite)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.ValidateInviteCode(&invite)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	invite.CreatedBy = userId
+	err = h.Service.CreateInviteCode(r.Context(), &invite)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, invite)
+}
+
+func (h *Handler) GetInviteCodes(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.Service.GetInviteCodes(r.Context())
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, invites)
+}
+
+func (h *Handler) GetLockoutEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := h.Service.GetLockoutEvents(r.Context())
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, events)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/services"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/http"
+)
+
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var key types.APIKey
+	err = json.NewDecoder(r.Body).Decode(&key)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.ValidateAPIKey(&key)
+	if err != nil {
+		invalidData(w, err)
+		return
+	}
+	key.UserId = userId
+	err = h.Service.CreateAPIKey(r.Context(), &key)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, key)
+}
+
+func (h *Handler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	keys, err := h.Service.GetAPIKeys(r.Context(), userId)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, keys)
+}
+
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var keyId types.APIKeyId
+	err = json.NewDecoder(r.Body).Decode(&keyId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.RevokeAPIKey(r.Context(), keyId.ID, userId)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
+	"github.com/rustamfozilov/penhub/internal/services"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+func (h *Handler) ExportEPUB(w http.ResponseWriter, r *http.Request) {
+	bookId, userId, ok := h.exportRequest(w, r)
+	if !ok {
+		return
+	}
+	file, book, err := h.Service.ExportEPUB(r.Context(), bookId, userId)
+	if !exportFound(w, err) {
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", attachment(book, ".epub"))
+	_, err = io.Copy(w, file)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (h *Handler) ExportFB2(w http.ResponseWriter, r *http.Request) {
+	bookId, userId, ok := h.exportRequest(w, r)
+	if !ok {
+		return
+	}
+	export, err := h.Service.ExportFB2(r.Context(), bookId, userId)
+	if !exportFound(w, err) {
+		return
+	}
+	stream(w, export, "application/x-fictionbook+xml", ".fb2")
+}
+
+func (h *Handler) ExportText(w http.ResponseWriter, r *http.Request) {
+	bookId, userId, ok := h.exportRequest(w, r)
+	if !ok {
+		return
+	}
+	export, err := h.Service.ExportText(r.Context(), bookId, userId)
+	if !exportFound(w, err) {
+		return
+	}
+	stream(w, export, "text/plain; charset=utf-8", ".txt")
+}
+
+// RequestPDFExport queues a PDF of a book for its author, who polls
+// GetPDFExport until it is ready.
+func (h *Handler) RequestPDFExport(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var request types.PDFExportRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.EditBook, request.BookId) {
+		return
+	}
+	export, err := h.Service.RequestPDFExport(r.Context(), userId, &request)
+	if errors.Is(err, services.ErrPDFExportDisabled) {
+		NotFoundError(w, err)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidData) {
+		invalidData(w, err)
+		return
+	}
+	if !exportFound(w, err) {
+		return
+	}
+	FormatAndSending(w, export)
+}
+
+func (h *Handler) GetPDFExport(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var exportId types.ExportId
+	err = json.NewDecoder(r.Body).Decode(&exportId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	export, err := h.Service.GetPDFExport(r.Context(), userId, exportId.Id)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, export)
+}
+
+func (h *Handler) DownloadPDFExport(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var exportId types.ExportId
+	err = json.NewDecoder(r.Body).Decode(&exportId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	file, book, err := h.Service.OpenPDFExport(r.Context(), userId, exportId.Id)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if errors.Is(err, services.ErrExportNotReady) {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", attachment(book, ".pdf"))
+	_, err = io.Copy(w, file)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// exportRequest reads the book to export and checks that the user may
+// export it.
+func (h *Handler) exportRequest(w http.ResponseWriter, r *http.Request) (*types.BookId, int64, bool) {
+	var bookId types.BookId
+	err := json.NewDecoder(r.Body).Decode(&bookId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return nil, 0, false
+	}
+	if !h.authorize(w, r, policy.ExportBook, bookId.Id) {
+		return nil, 0, false
+	}
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return nil, 0, false
+	}
+	return &bookId, userId, true
+}
+
+func exportFound(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return false
+	}
+	if errors.Is(err, services.ErrEmptyBook) {
+		badRequest(w, err)
+		return false
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return false
+	}
+	return true
+}
+
+// stream writes an export as it is built. The status is sent with the
+// first bytes, so a later failure can only cut the download short.
+func stream(w http.ResponseWriter, export *services.BookExport, contentType, extension string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", attachment(export.Book, extension))
+	err := export.Write(w)
+	if err != nil {
+		log.Printf("%+v\n", err)
+	}
+}
+
+// attachment names a downloaded book after its title. Clients that do not
+// understand the encoded filename* fall back to the book id.
+func attachment(book *types.Book, extension string) string {
+	return fmt.Sprintf(`attachment; filename="book-%d%s"; filename*=UTF-8''%s`,
+		book.ID, extension, encodeFilename(book.Title+extension))
+}
+
+// encodeFilename percent-encodes all but the unreserved characters, which
+// RFC 5987 allows unencoded in every header parameter.
+func encodeFilename(name string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			encoded.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&encoded, "%%%02X", c)
+	}
+	return encoded.String()
+}
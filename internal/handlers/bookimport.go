@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
+	"github.com/rustamfozilov/penhub/internal/services"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/http"
+)
+
+// ImportBook creates a book from an uploaded file. With dry_run set in the
+// data it only reports what would be imported, so that the author can check
+// the chapters before sending the same file again.
+func (h *Handler) ImportBook(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.Service.ImportMaxSize()+1<<20)
+	var options types.BookImport
+	err = json.Unmarshal([]byte(r.FormValue("data")), &options)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.ManagePenName, options.PenNameId) {
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	defer file.Close()
+	report, err := h.Service.ImportBook(r.Context(), userID, &options, file, header.Size, header.Filename)
+	if errors.Is(err, services.ErrInvalidData) {
+		invalidData(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, report)
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
 	"github.com/rustamfozilov/penhub/internal/services"
 	"github.com/rustamfozilov/penhub/internal/types"
 	"net/http"
@@ -32,12 +33,20 @@ func (h *Handler) CreateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	b.AuthorId = userID
+	if !h.authorize(w, r, policy.ManagePenName, b.PenNameId) {
+		return
+	}
 
 	err = h.Service.ValidateBook(&b)
 	if err != nil {
 		badRequest(w, errors.WithStack(err))
 		return
 	}
+	err = h.Service.ValidatePublication(b.Status, b.PublishAt)
+	if err != nil {
+		invalidData(w, err)
+		return
+	}
 	file, header, err := r.FormFile("image")
 	if err != nil {
 		err := errors.WithStack(err)
@@ -70,28 +79,52 @@ func (h *Handler) WriteChapter(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, err)
 		return
 	}
-	userId, err := GetIdFromContext(r.Context())
+	if !h.authorize(w, r, policy.EditBook, chapter.BookId) {
+		return
+	}
+
+	err = h.Service.ValidateChapter(&chapter)
 	if err != nil {
-		err := errors.WithStack(err)
-		InternalServerError(w, err)
+		badRequest(w, err)
 		return
 	}
-	access, err := h.Service.HaveAccessToEditBook(r.Context(), userId, chapter.BookId)
-	if !access {
-		Forbidden(w, errors.New("no access"))
+	err = h.Service.ValidatePublication(chapter.Status, chapter.PublishAt)
+	if err != nil {
+		invalidData(w, err)
+		return
+	}
+	err = h.Service.ValidateFormat(chapter.Format)
+	if err != nil {
+		invalidData(w, err)
+		return
+	}
+	err = h.Service.WriteChapter(r.Context(), &chapter)
+	if errors.Is(err, services.ErrInvalidPosition) || errors.Is(err, services.ErrNumberTaken) || errors.Is(err, services.ErrInvalidVolume) {
+		badRequest(w, err)
 		return
 	}
 	if err != nil {
 		InternalServerError(w, err)
 		return
 	}
+	FormatAndSending(w, types.ChapterId{Id: chapter.ID})
+}
 
-	err = h.Service.ValidateChapter(&chapter)
+func (h *Handler) ReorderChapters(w http.ResponseWriter, r *http.Request) {
+	var order types.ChapterOrder
+	err := json.NewDecoder(r.Body).Decode(&order)
 	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.EditBook, order.BookId) {
+		return
+	}
+	err = h.Service.ReorderChapters(r.Context(), &order)
+	if errors.Is(err, services.ErrInvalidOrder) {
 		badRequest(w, err)
 		return
 	}
-	err = h.Service.WriteChapter(r.Context(), &chapter)
 	if err != nil {
 		InternalServerError(w, err)
 		return
@@ -133,40 +166,89 @@ func (h *Handler) GetChaptersByBookId(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, err)
 		return
 	}
-	chapters, err := h.Service.GetChaptersByBookId(r.Context(), &BookIdReq)
+	if !h.authorize(w, r, policy.ReadBook, BookIdReq.Id) {
+		return
+	}
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	contents, err := h.Service.GetTableOfContents(r.Context(), &BookIdReq, userId)
 	if err != nil {
 		InternalServerError(w, err)
 		return
 	}
-	FormatAndSending(w, chapters)
+	FormatAndSending(w, contents)
 }
 
 func (h *Handler) ReadChapter(w http.ResponseWriter, r *http.Request) {
-	var chapterId types.ChapterId
-	err := json.NewDecoder(r.Body).Decode(&chapterId)
+	var read types.ChapterRead
+	err := json.NewDecoder(r.Body).Decode(&read)
 	if err != nil {
 		err := errors.WithStack(err)
 		badRequest(w, err)
 		return
 	}
-
-	chapter, err := h.Service.ReadChapter(r.Context(), &chapterId)
+	if !h.authorize(w, r, policy.ReadChapter, read.Id) {
+		return
+	}
+	err = h.Service.ValidateRender(read.Render)
+	if err != nil {
+		invalidData(w, err)
+		return
+	}
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	chapter, err := h.Service.ReadChapter(r.Context(), &read, userId)
 	if err != nil {
 		InternalServerError(w, err)
 		return
 	}
+	w.Header().Set("ETag", ETag(chapter.Version))
 	FormatAndSending(w, chapter)
 }
 
+func (h *Handler) GetBook(w http.ResponseWriter, r *http.Request) {
+	var bookId types.BookId
+	err := json.NewDecoder(r.Body).Decode(&bookId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.ReadBook, bookId.Id) {
+		return
+	}
+	book, err := h.Service.GetBook(r.Context(), &bookId)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	w.Header().Set("ETag", ETag(book.Version))
+	FormatAndSending(w, book)
+}
+
 func (h *Handler) GetBooksByAuthorId(w http.ResponseWriter, r *http.Request) {
-	var authorId types.AuthorId
-	err := json.NewDecoder(r.Body).Decode(&authorId)
+	var penNameId types.PenNameId
+	err := json.NewDecoder(r.Body).Decode(&penNameId)
 	if err != nil {
 		err := errors.WithStack(err)
 		badRequest(w, err)
 		return
 	}
-	books, err := h.Service.GetBooksById(r.Context(), &authorId)
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	books, err := h.Service.GetBooksByPenName(r.Context(), &penNameId, userId)
 	if err != nil {
 		InternalServerError(w, err)
 		return
@@ -227,6 +309,18 @@ func (h *Handler) GetImageByName(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, errors.WithStack(err))
 		return
 	}
+	bookId, err := h.Service.BookIdByImage(r.Context(), n.Name)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	if bookId != 0 && !h.authorize(w, r, policy.ReadBook, bookId) {
+		return
+	}
 	file, err := h.Service.GetImageByName(n.Name)
 	if err != nil {
 		InternalServerError(w, err)
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/rustamfozilov/penhub/internal/db/dbtest"
+	"github.com/rustamfozilov/penhub/internal/mail"
+	"github.com/rustamfozilov/penhub/internal/services"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// getImage asks for an image the way the client does, as userId.
+func getImage(t *testing.T, h *Handler, userId int64, name string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(types.ImageName{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/books/image", bytes.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), AuthenticateContextKey, userId))
+	w := httptest.NewRecorder()
+	h.GetImageByName(w, r)
+	return w
+}
+
+// Avatars have no book to authorize against and are shown to everyone.
+func TestGetImageByNameAvatar(t *testing.T) {
+	d := dbtest.New(t)
+	service := services.NewService(d, &types.Config{ImagesPath: t.TempDir()}, &mail.LogMailer{})
+	h := NewHandler(service)
+	writer := dbtest.CreateUser(t, d, "writer", "writer@example.com", "hash")
+	reader := dbtest.CreateUser(t, d, "reader", "reader@example.com", "hash")
+	err := service.EditAvatar(context.Background(), writer, strings.NewReader("avatar bytes"), "me.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := service.GetProfile(context.Background(), writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := getImage(t, h, reader, profile.Avatar)
+	if w.Code != http.StatusOK {
+		t.Fatalf("avatar: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Body.String(); got != "avatar bytes" {
+		t.Errorf("avatar body = %q", got)
+	}
+
+	w = getImage(t, h, reader, "00000000-0000-0000-0000-000000000000.png")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown image: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
+	"github.com/rustamfozilov/penhub/internal/services"
 	"github.com/rustamfozilov/penhub/internal/types"
 	"net/http"
 )
 
+// EditChapter requires If-Match with the version the edit is based on.
+// Everything is validated before the version is claimed, and the claim and
+// the changes are made in one transaction, so a rejected or failed edit
+// leaves the chapter and its version alone.
 func (h *Handler) EditChapter(w http.ResponseWriter, r *http.Request) {
 	var editChapter types.Chapter
 	err := json.NewDecoder(r.Body).Decode(&editChapter)
@@ -14,125 +21,94 @@ func (h *Handler) EditChapter(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, errors.WithStack(err))
 		return
 	}
-	userId, err := GetIdFromContext(r.Context())
-	if err != nil {
-		InternalServerError(w, errors.WithStack(err))
+	if !h.authorize(w, r, policy.EditChapter, editChapter.ID) {
 		return
 	}
-	access, err := h.Service.HaveAccessToEditBook(r.Context(), userId, editChapter.BookId)
+	userId, err := GetIdFromContext(r.Context())
 	if err != nil {
-		InternalServerError(w, err)
-		return
-	}
-	if !access {
-		Forbidden(w, errors.New("no access"))
+		InternalServerError(w, errors.WithStack(err))
 		return
 	}
-
-	if editChapter.Content != "" {
-		err = h.Service.EditContent(r.Context(), &editChapter)
-		if err != nil {
-			InternalServerError(w, err)
-			return
-		}
-	}
-
 	if editChapter.Name != "" {
 		err := h.Service.ValidateChapter(&editChapter)
 		if err != nil {
 			badRequest(w, errors.WithStack(err))
 			return
 		}
-
-		err = h.Service.EditChapterName(r.Context(), &editChapter)
-		if err != nil {
-			InternalServerError(w, err)
-			return
-		}
 	}
-	if editChapter.Number != 0 {
-		err = h.Service.EditChapterNumber(r.Context(), &editChapter)
+	if editChapter.Status != "" {
+		err = h.Service.ValidatePublication(editChapter.Status, editChapter.PublishAt)
 		if err != nil {
-			InternalServerError(w, err)
+			invalidData(w, err)
 			return
 		}
 	}
-
-}
-
-func (h *Handler) EditImage(w http.ResponseWriter, r *http.Request) {
-	var b types.Book
-	data := r.FormValue("data")
-	err := json.Unmarshal([]byte(data), &b)
-	if err != nil {
-		badRequest(w, errors.WithStack(err))
-		return
-	}
-	userId, err := GetIdFromContext(r.Context())
-	if err != nil {
-		InternalServerError(w, errors.WithStack(err))
-		return
-	}
-	access, err := h.Service.HaveAccessToEditBook(r.Context(), userId, b.ID)
+	err = h.Service.ValidateFormat(editChapter.Format)
 	if err != nil {
-		InternalServerError(w, err)
+		invalidData(w, err)
 		return
 	}
-	if !access {
-		Forbidden(w, errors.New("no access"))
+	version, ok := ifMatch(r)
+	if !ok {
+		PreconditionRequired(w, services.ErrVersionRequired)
 		return
 	}
-	file, header, err := r.FormFile("image")
-	if err != nil {
-		badRequest(w, errors.WithStack(err))
+	next, err := h.Service.EditChapter(r.Context(), &editChapter, version, userId)
+	var conflict *services.VersionConflictError
+	if errors.As(err, &conflict) {
+		PreconditionFailed(w, err, conflict.Current)
 		return
 	}
-	filename := header.Filename
-	err = h.Service.ValidateImage(header.Size)
-	if err != nil {
-		badRequest(w, errors.WithStack(err))
-		return
-	}
-	book, err := h.Service.SaveImage(file, filename, &b)
-	if err != nil {
-		InternalServerError(w, err)
+	if errors.Is(err, services.ErrInvalidVolume) {
+		badRequest(w, err)
 		return
 	}
-	err = h.Service.EditImage(r.Context(), book)
 	if err != nil {
 		InternalServerError(w, err)
 		return
 	}
+	w.Header().Set("ETag", ETag(next))
 }
 
-func (h *Handler) EditBook(w http.ResponseWriter, r *http.Request) {
-	var edit types.Book
-	err := json.NewDecoder(r.Body).Decode(&edit)
+// EditImage requires If-Match like EditChapter. The new cover is written
+// before the version is claimed and removed again if the edit fails.
+func (h *Handler) EditImage(w http.ResponseWriter, r *http.Request) {
+	var b types.Book
+	data := r.FormValue("data")
+	err := json.Unmarshal([]byte(data), &b)
 	if err != nil {
 		badRequest(w, errors.WithStack(err))
 		return
 	}
-	userId, err := GetIdFromContext(r.Context())
-	if err != nil {
-		InternalServerError(w, errors.WithStack(err))
+	if !h.authorize(w, r, policy.EditBook, b.ID) {
 		return
 	}
-	access, err := h.Service.HaveAccessToEditBook(r.Context(), userId, edit.ID)
+	file, header, err := r.FormFile("image")
 	if err != nil {
-		InternalServerError(w, err)
+		badRequest(w, errors.WithStack(err))
 		return
 	}
-	if !access {
-		Forbidden(w, errors.New("no access"))
+	defer file.Close()
+	err = h.Service.ValidateImage(header.Size)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
 		return
 	}
+	editVersion(w, r, func(ctx context.Context, version int64) (int64, error) {
+		return h.Service.EditImage(ctx, b.ID, file, header.Filename, version)
+	})
+}
+
+// EditBook requires If-Match like EditChapter and changes only the fields
+// the request sets, together with the version in one transaction.
+func (h *Handler) EditBook(w http.ResponseWriter, r *http.Request) {
+	var edit types.BookEdit
+	err := json.NewDecoder(r.Body).Decode(&edit)
 	if err != nil {
 		badRequest(w, errors.WithStack(err))
 		return
 	}
-	err = h.Service.EditAccess(r.Context(), &edit) //permanent change active
-	if err != nil {
-		InternalServerError(w, err)
+	if !h.authorize(w, r, policy.EditBook, edit.ID) {
 		return
 	}
 	if edit.Title != "" {
@@ -141,16 +117,16 @@ func (h *Handler) EditBook(w http.ResponseWriter, r *http.Request) {
 			badRequest(w, errors.WithStack(err))
 			return
 		}
-		err = h.Service.EditTitle(r.Context(), &edit)
-		if err != nil {
-			InternalServerError(w, err)
+	}
+	if edit.PenNameId != 0 {
+		if !h.authorize(w, r, policy.ManagePenName, edit.PenNameId) {
 			return
 		}
 	}
-	if edit.Genre != 0 {
-		err := h.Service.EditGenre(r.Context(), &edit)
+	if edit.Status != "" {
+		err = h.Service.ValidatePublication(edit.Status, edit.PublishAt)
 		if err != nil {
-			InternalServerError(w, err)
+			invalidData(w, err)
 			return
 		}
 	}
@@ -160,12 +136,10 @@ func (h *Handler) EditBook(w http.ResponseWriter, r *http.Request) {
 			badRequest(w, errors.WithStack(err))
 			return
 		}
-		err = h.Service.EditDescription(r.Context(), &edit)
-		if err != nil {
-			InternalServerError(w, err)
-			return
-		}
 	}
+	editVersion(w, r, func(ctx context.Context, version int64) (int64, error) {
+		return h.Service.EditBook(ctx, &edit, version)
+	})
 }
 
 func (h Handler) DeleteBook(w http.ResponseWriter, r *http.Request) {
@@ -175,18 +149,7 @@ func (h Handler) DeleteBook(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, errors.WithStack(err))
 		return
 	}
-	userId, err := GetIdFromContext(r.Context())
-	if err != nil {
-		InternalServerError(w, errors.WithStack(err))
-		return
-	}
-	access, err := h.Service.HaveAccessToEditBook(r.Context(), userId, edit.ID)
-	if err != nil {
-		InternalServerError(w, err)
-		return
-	}
-	if !access {
-		Forbidden(w, errors.New("no access"))
+	if !h.authorize(w, r, policy.EditBook, edit.ID) {
 		return
 	}
 	err = h.Service.DeleteBook(r.Context(), &edit)
@@ -203,18 +166,7 @@ func (h *Handler) DeleteChapter(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, errors.WithStack(err))
 		return
 	}
-	userId, err := GetIdFromContext(r.Context())
-	if err != nil {
-		InternalServerError(w, errors.WithStack(err))
-		return
-	}
-	access, err := h.Service.HaveAccessToEditBook(r.Context(), userId, editChapter.BookId)
-	if err != nil {
-		InternalServerError(w, err)
-		return
-	}
-	if !access {
-		Forbidden(w, errors.New("no access"))
+	if !h.authorize(w, r, policy.EditChapter, editChapter.ID) {
 		return
 	}
 	err = h.Service.DeleteChapter(r.Context(), &editChapter)
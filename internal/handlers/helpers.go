@@ -4,8 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
+	"github.com/rustamfozilov/penhub/internal/services"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func badRequest(w http.ResponseWriter, err error) {
@@ -13,6 +20,27 @@ func badRequest(w http.ResponseWriter, err error) {
 	http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 }
 
+// invalidData answers 400 with the field-level reasons when err carries them.
+func invalidData(w http.ResponseWriter, err error) {
+	var validation *services.ValidationError
+	if !errors.As(err, &validation) {
+		badRequest(w, err)
+		return
+	}
+	log.Println(err)
+	data, err := json.Marshal(map[string]interface{}{"errors": validation.Fields})
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_, err = w.Write(data)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 func InternalServerError(w http.ResponseWriter, err error) {
 	log.Printf("%v\n", err)
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -23,6 +51,96 @@ func Forbidden(w http.ResponseWriter, err error) {
 	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 }
 
+func NotFoundError(w http.ResponseWriter, err error) {
+	log.Println(err)
+	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+}
+
+func TooManyRequests(w http.ResponseWriter, err error, retryAfter time.Duration) {
+	log.Println(err)
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// PreconditionFailed answers a write based on an outdated version with the
+// current one, so the client can offer to merge.
+func PreconditionFailed(w http.ResponseWriter, err error, current int64) {
+	log.Println(err)
+	data, err := json.Marshal(map[string]int64{"version": current})
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	w.Header().Set("ETag", ETag(current))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	_, err = w.Write(data)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func PreconditionRequired(w http.ResponseWriter, err error) {
+	log.Println(err)
+	http.Error(w, http.StatusText(http.StatusPreconditionRequired), http.StatusPreconditionRequired)
+}
+
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatch reads the version named by the If-Match header. "*" matches any
+// version and is returned as zero.
+func ifMatch(r *http.Request) (version int64, ok bool) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "*" {
+		return 0, true
+	}
+	value = strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+type editFunc func(ctx context.Context, version int64) (int64, error)
+
+// editVersion runs an edit based on the version named by If-Match and
+// answers with the new version as ETag, or writes the error response.
+func editVersion(w http.ResponseWriter, r *http.Request, edit editFunc) {
+	version, ok := ifMatch(r)
+	if !ok {
+		PreconditionRequired(w, services.ErrVersionRequired)
+		return
+	}
+	next, err := edit(r.Context(), version)
+	var conflict *services.VersionConflictError
+	if errors.As(err, &conflict) {
+		PreconditionFailed(w, err, conflict.Current)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	w.Header().Set("ETag", ETag(next))
+}
+
+func Unauthorized(w http.ResponseWriter, err error) {
+	log.Println(err)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func GetIdFromContext(ctx context.Context) (id int64, err error) {
 	id, ok := ctx.Value(AuthenticateContextKey).(int64)
 	if !ok {
@@ -46,3 +164,31 @@ func FormatAndSending(w http.ResponseWriter, item interface{}) {
 		return
 	}
 }
+
+// authorize writes the error response itself and reports whether the
+// handler may go on.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, action policy.Action, id int64) bool {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return false
+	}
+	err = h.Service.Authorize(r.Context(), userId, action, id)
+	if errors.Is(err, services.ErrForbidden) {
+		Forbidden(w, err)
+		return false
+	}
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return false
+	}
+	if errors.Is(err, services.ErrNoAuthorization) {
+		Unauthorized(w, err)
+		return false
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return false
+	}
+	return true
+}
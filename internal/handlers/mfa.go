@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/services"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/http"
+)
+
+func (h *Handler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	enrollment, err := h.Service.EnrollTOTP(r.Context(), userId)
+	if errors.Is(err, services.ErrTOTPEnabled) {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, enrollment)
+}
+
+func (h *Handler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var code types.TOTPCode
+	err = json.NewDecoder(r.Body).Decode(&code)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	codes, err := h.Service.ConfirmTOTP(r.Context(), userId, code.Code)
+	if errors.Is(err, services.ErrInvalidCode) || errors.Is(err, services.ErrTOTPNotEnrolled) || errors.Is(err, services.ErrTOTPEnabled) {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, codes)
+}
+
+func (h *Handler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var code types.TOTPCode
+	err = json.NewDecoder(r.Body).Decode(&code)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.DisableTOTP(r.Context(), userId, code.Code, ClientIP(r))
+	var locked *services.LockedError
+	if errors.As(err, &locked) {
+		TooManyRequests(w, err, locked.RetryAfter)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidCode) || errors.Is(err, services.ErrTOTPNotEnrolled) {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) CompleteMFA(w http.ResponseWriter, r *http.Request) {
+	var verification types.MFAVerification
+	err := json.NewDecoder(r.Body).Decode(&verification)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	session := types.Session{UserAgent: r.UserAgent(), IP: ClientIP(r)}
+	item, err := h.Service.CompleteMFA(r.Context(), &verification, &session)
+	var locked *services.LockedError
+	if errors.As(err, &locked) {
+		TooManyRequests(w, err, locked.RetryAfter)
+		return
+	}
+	if errors.Is(err, services.ErrNoAuthorization) || errors.Is(err, services.ErrInvalidCode) {
+		Unauthorized(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, item)
+}
@@ -4,26 +4,31 @@ import (
 	"context"
 	"errors"
 	"github.com/go-chi/chi/v5"
+	"github.com/rustamfozilov/penhub/internal/policy"
 	"github.com/rustamfozilov/penhub/internal/services"
+	"github.com/rustamfozilov/penhub/internal/types"
 	"log"
 	"net/http"
 )
 
-type IDFunc func(ctx context.Context, token string) (id int64, err error)
+type PrincipalFunc func(ctx context.Context, token string) (principal *types.Principal, err error)
+
+type RoleFunc func(ctx context.Context, id int64) (role string, err error)
 
 type contextKey struct {
 	key string
 }
 
 var AuthenticateContextKey = &contextKey{key: "authentication key"}
+var ScopesContextKey = &contextKey{key: "scopes key"}
 
-func Authentication(idFunc IDFunc) func(handler http.Handler) http.Handler {
+func Authentication(principalFunc PrincipalFunc) func(handler http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			token := r.Header.Get("Authorization")
-			id, err := idFunc(r.Context(), token)
+			principal, err := principalFunc(r.Context(), token)
 			if errors.Is(err, services.ErrExpired) || errors.Is(err, services.ErrNoAuthorization) {
-				log.Println(err)
+				log.Println(services.TokenPrefix(token), err)
 				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 				return
 			}
@@ -31,13 +36,66 @@ func Authentication(idFunc IDFunc) func(handler http.Handler) http.Handler {
 				InternalServerError(w, err)
 				return
 			}
-			ctx := context.WithValue(r.Context(), AuthenticateContextKey, id)
+			ctx := context.WithValue(r.Context(), AuthenticateContextKey, principal.UserId)
+			ctx = context.WithValue(ctx, ScopesContextKey, principal.Scopes)
 			r = r.WithContext(ctx)
 			handler.ServeHTTP(w, r)
 		})
 	}
 }
 
+func RequireRole(roleFunc RoleFunc, roles ...string) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, err := GetIdFromContext(r.Context())
+			if err != nil {
+				InternalServerError(w, err)
+				return
+			}
+			role, err := roleFunc(r.Context(), id)
+			if errors.Is(err, services.ErrNoAuthorization) {
+				Unauthorized(w, err)
+				return
+			}
+			if err != nil {
+				InternalServerError(w, err)
+				return
+			}
+			for _, allowed := range roles {
+				if role == allowed {
+					handler.ServeHTTP(w, r)
+					return
+				}
+			}
+			Forbidden(w, services.ErrForbidden)
+		})
+	}
+}
+
+// RequireScope limits API keys to the read scope for GET requests and to
+// the write scope for everything else. Session tokens always pass.
+func RequireScope(read, write string) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope := write
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				scope = read
+			}
+			scopes, _ := r.Context().Value(ScopesContextKey).([]string)
+			if !policy.InScope(scopes, scope) {
+				Forbidden(w, services.ErrOutOfScope)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SessionOnly keeps API keys away from account and administration routes.
+func SessionOnly(handler http.Handler) http.Handler {
+	return RequireScope("", "")(handler)
+}
+
 func NotFound(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rctx := chi.RouteContext(r.Context())
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/services"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/http"
+)
+
+func (h *Handler) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	h.startOIDC(w, r, 0)
+}
+
+// LinkIdentity starts the same flow for a signed in user, linking the
+// identity they sign in with to their account.
+func (h *Handler) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	h.startOIDC(w, r, userId)
+}
+
+func (h *Handler) startOIDC(w http.ResponseWriter, r *http.Request, userId int64) {
+	redirect, err := h.Service.StartOIDCLogin(r.Context(), userId)
+	if errors.Is(err, services.ErrOIDCDisabled) {
+		NotFoundError(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, redirect)
+}
+
+func (h *Handler) CompleteOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var callback types.OIDCCallback
+	err := json.NewDecoder(r.Body).Decode(&callback)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	session := types.Session{UserAgent: r.UserAgent(), IP: ClientIP(r)}
+	item, err := h.Service.CompleteOIDCLogin(r.Context(), &callback, &session)
+	if errors.Is(err, services.ErrOIDCDisabled) {
+		NotFoundError(w, err)
+		return
+	}
+	if errors.Is(err, services.ErrOIDCState) || errors.Is(err, services.ErrIdentityLinked) {
+		badRequest(w, err)
+		return
+	}
+	if errors.Is(err, services.ErrNoAuthorization) || errors.Is(err, services.ErrNoLinkedAccount) {
+		Unauthorized(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, item)
+}
+
+func (h *Handler) GetIdentities(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	identities, err := h.Service.GetIdentities(r.Context(), userId)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, identities)
+}
+
+func (h *Handler) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var identityId types.IdentityId
+	err = json.NewDecoder(r.Body).Decode(&identityId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.UnlinkIdentity(r.Context(), identityId.ID, userId)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/services"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/http"
+)
+
+func (h *Handler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var request types.PasswordResetRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.ValidateEmail(request.Email)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.RequestPasswordReset(r.Context(), request.Email)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var reset types.PasswordReset
+	err := json.NewDecoder(r.Body).Decode(&reset)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.ResetPassword(r.Context(), reset.Token, reset.Password)
+	if errors.Is(err, services.ErrInvalidResetToken) {
+		badRequest(w, err)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidData) {
+		invalidData(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/http"
+)
+
+func (h *Handler) CreatePenName(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var penName types.PenName
+	err = json.NewDecoder(r.Body).Decode(&penName)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.ValidatePenName(&penName)
+	if err != nil {
+		invalidData(w, err)
+		return
+	}
+	penName.UserId = userId
+	err = h.Service.CreatePenName(r.Context(), &penName)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, penName)
+}
+
+// EditPenName changes only the fields the request sets.
+func (h *Handler) EditPenName(w http.ResponseWriter, r *http.Request) {
+	var edit types.PenNameEdit
+	err := json.NewDecoder(r.Body).Decode(&edit)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.ManagePenName, edit.ID) {
+		return
+	}
+	err = h.Service.ValidatePenNameEdit(&edit)
+	if err != nil {
+		invalidData(w, err)
+		return
+	}
+	err = h.Service.EditPenName(r.Context(), &edit)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) GetPenNames(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	penNames, err := h.Service.GetPenNamesByUserId(r.Context(), userId)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, penNames)
+}
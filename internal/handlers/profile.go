@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/services"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/http"
+)
+
+func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	profile, err := h.Service.GetProfile(r.Context(), userId)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, profile)
+}
+
+func (h *Handler) EditProfile(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var profile types.Profile
+	err = json.NewDecoder(r.Body).Decode(&profile)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.ValidateProfile(&profile)
+	if err != nil {
+		invalidData(w, err)
+		return
+	}
+	profile.ID = userId
+	err = h.Service.EditProfile(r.Context(), &profile)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) EditAvatar(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.ValidateImage(header.Size)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.EditAvatar(r.Context(), userId, file, header.Filename)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	var change types.PasswordChange
+	err = json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.ValidatePassword(r.Context(), userId, change.NewPassword)
+	if errors.Is(err, services.ErrInvalidData) {
+		invalidData(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	err = h.Service.ChangePassword(r.Context(), userId, r.Header.Get("Authorization"), &change)
+	if errors.Is(err, services.ErrInvalidPassword) {
+		Forbidden(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) GetAuthorPage(w http.ResponseWriter, r *http.Request) {
+	var authorId types.AuthorId
+	err := json.NewDecoder(r.Body).Decode(&authorId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	page, err := h.Service.GetAuthorPage(r.Context(), authorId.Id)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, page)
+}
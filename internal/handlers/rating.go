@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
 	"github.com/rustamfozilov/penhub/internal/types"
 	"net/http"
 )
@@ -21,6 +22,9 @@ func (h *Handler) AddLike(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, err)
 		return
 	}
+	if !h.authorize(w, r, policy.ReadBook, bookId.Id) {
+		return
+	}
 
 	err = h.Service.AddLike(r.Context(), &userID, &bookId)
 	if err != nil {
@@ -37,6 +41,9 @@ func (h *Handler) DeleteLike(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, err)
 		return
 	}
+	if !h.authorize(w, r, policy.DeleteLike, ratingId.Id) {
+		return
+	}
 
 	err = h.Service.DeleteLike(r.Context(), &ratingId)
 	if err != nil {
@@ -75,6 +82,9 @@ func (h *Handler) BookLikes(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, err)
 		return
 	}
+	if !h.authorize(w, r, policy.ReadBook, bookId.Id) {
+		return
+	}
 	likes, err := h.Service.BookLikes(r.Context(), &bookId)
 	if err != nil {
 		InternalServerError(w, err)
@@ -18,11 +18,11 @@ func (h *Handler) RegistrationUser(w http.ResponseWriter, r *http.Request) {
 
 	err = h.Service.ValidateUser(&u)
 	if err != nil {
-		badRequest(w, errors.WithStack(err))
+		invalidData(w, err)
 		return
 	}
 	err = h.Service.RegistrationUser(r.Context(), &u)
-	if errors.Is(err, services.ErrLoginUsed) {
+	if errors.Is(err, services.ErrLoginUsed) || errors.Is(err, services.ErrInvalidInvite) {
 		badRequest(w, err)
 		return
 	}
@@ -32,3 +32,21 @@ func (h *Handler) RegistrationUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var verification types.EmailVerification
+	err := json.NewDecoder(r.Body).Decode(&verification)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	err = h.Service.VerifyEmail(r.Context(), verification.Token)
+	if errors.Is(err, services.ErrInvalidVerificationToken) {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
+	"github.com/rustamfozilov/penhub/internal/services"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/http"
+)
+
+func (h *Handler) GetRevisions(w http.ResponseWriter, r *http.Request) {
+	var chapterId types.ChapterId
+	err := json.NewDecoder(r.Body).Decode(&chapterId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.EditChapter, chapterId.Id) {
+		return
+	}
+	revisions, err := h.Service.GetRevisions(r.Context(), chapterId.Id)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, revisions)
+}
+
+func (h *Handler) GetRevision(w http.ResponseWriter, r *http.Request) {
+	var revisionId types.RevisionId
+	err := json.NewDecoder(r.Body).Decode(&revisionId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.EditChapter, revisionId.ChapterId) {
+		return
+	}
+	revision, err := h.Service.GetRevision(r.Context(), &revisionId)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, revision)
+}
+
+func (h *Handler) DiffRevisions(w http.ResponseWriter, r *http.Request) {
+	var request types.DiffRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.EditChapter, request.ChapterId) {
+		return
+	}
+	err = h.Service.ValidateDiff(&request)
+	if err != nil {
+		invalidData(w, err)
+		return
+	}
+	result, err := h.Service.DiffRevisions(r.Context(), &request)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, result)
+}
+
+func (h *Handler) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	var revisionId types.RevisionId
+	err := json.NewDecoder(r.Body).Decode(&revisionId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.EditChapter, revisionId.ChapterId) {
+		return
+	}
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, errors.WithStack(err))
+		return
+	}
+	revision, err := h.Service.RestoreRevision(r.Context(), &revisionId, userId)
+	if errors.Is(err, services.ErrNotFound) {
+		NotFoundError(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, revision)
+}
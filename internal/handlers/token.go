@@ -15,13 +15,18 @@ func (h *Handler) GetTokenForUser(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, err)
 		return
 	}
-	u.Name = "Temple"
-	err = h.Service.ValidateUser(&u)
+	err = h.Service.ValidateCredentials(&u)
 	if err != nil {
 		badRequest(w, err)
 		return
 	}
-	token, err := h.Service.GetTokenForUser(r.Context(), &u)
+	session := types.Session{UserAgent: r.UserAgent(), IP: ClientIP(r)}
+	item, err := h.Service.GetTokenForUser(r.Context(), &u, &session)
+	var locked *services.LockedError
+	if errors.As(err, &locked) {
+		TooManyRequests(w, err, locked.RetryAfter)
+		return
+	}
 	if errors.Is(err, services.ErrNoSuchUser) || errors.Is(err, services.ErrInvalidPassword) {
 		badRequest(w, err)
 		return
@@ -30,6 +35,59 @@ func (h *Handler) GetTokenForUser(w http.ResponseWriter, r *http.Request) {
 		InternalServerError(w, err)
 		return
 	}
-	item := types.T{Token: token}
 	FormatAndSending(w, item)
 }
+
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var refresh types.RefreshToken
+	err := json.NewDecoder(r.Body).Decode(&refresh)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	item, err := h.Service.RefreshToken(r.Context(), refresh.Token)
+	if errors.Is(err, services.ErrNoAuthorization) {
+		Unauthorized(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, item)
+}
+
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	err := h.Service.Logout(r.Context(), r.Header.Get("Authorization"))
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	err = h.Service.LogoutAll(r.Context(), userId)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	userId, err := GetIdFromContext(r.Context())
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	sessions, err := h.Service.GetSessions(r.Context(), userId, r.Header.Get("Authorization"))
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, sessions)
+}
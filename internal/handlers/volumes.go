@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
+	"github.com/rustamfozilov/penhub/internal/services"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/http"
+)
+
+func (h *Handler) CreateVolume(w http.ResponseWriter, r *http.Request) {
+	var volume types.Volume
+	err := json.NewDecoder(r.Body).Decode(&volume)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.EditBook, volume.BookId) {
+		return
+	}
+	err = h.Service.ValidateVolume(&volume)
+	if err != nil {
+		invalidData(w, err)
+		return
+	}
+	err = h.Service.CreateVolume(r.Context(), &volume)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+	FormatAndSending(w, types.VolumeId{Id: volume.ID})
+}
+
+func (h *Handler) EditVolume(w http.ResponseWriter, r *http.Request) {
+	var volume types.Volume
+	err := json.NewDecoder(r.Body).Decode(&volume)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.EditVolume, volume.ID) {
+		return
+	}
+	err = h.Service.ValidateVolume(&volume)
+	if err != nil {
+		invalidData(w, err)
+		return
+	}
+	err = h.Service.EditVolume(r.Context(), &volume)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+// DeleteVolume keeps the chapters of the volume; they move out of it.
+func (h *Handler) DeleteVolume(w http.ResponseWriter, r *http.Request) {
+	var volumeId types.VolumeId
+	err := json.NewDecoder(r.Body).Decode(&volumeId)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.EditVolume, volumeId.Id) {
+		return
+	}
+	err = h.Service.DeleteVolume(r.Context(), &volumeId)
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
+
+func (h *Handler) ReorderVolumes(w http.ResponseWriter, r *http.Request) {
+	var order types.VolumeOrder
+	err := json.NewDecoder(r.Body).Decode(&order)
+	if err != nil {
+		badRequest(w, errors.WithStack(err))
+		return
+	}
+	if !h.authorize(w, r, policy.EditBook, order.BookId) {
+		return
+	}
+	err = h.Service.ReorderVolumes(r.Context(), &order)
+	if errors.Is(err, services.ErrInvalidOrder) {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		InternalServerError(w, err)
+		return
+	}
+}
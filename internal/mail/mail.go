@@ -0,0 +1,63 @@
+package mail
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"log"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strings"
+)
+
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+func NewMailer(config *types.MailConfig) Mailer {
+	if config.Host == "" {
+		return &LogMailer{}
+	}
+	return &SMTPMailer{config: config}
+}
+
+type SMTPMailer struct {
+	config *types.MailConfig
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	addr := net.JoinHostPort(m.config.Host, m.config.Port)
+	var auth smtp.Auth
+	if m.config.UserName != "" {
+		auth = smtp.PlainAuth("", m.config.UserName, m.config.Password, m.config.Host)
+	}
+	from, err := netmail.ParseAddress(m.config.From)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = smtp.SendMail(addr, auth, from.Address, []string{to}, message(m.config.From, to, subject, body))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// LogMailer writes messages to the log, a stand-in for SMTP during local development.
+type LogMailer struct{}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+	log.Printf("mail to %s: %s\n%s\n", to, subject, body)
+	return nil
+}
+
+func message(from, to, subject, body string) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + subject + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,272 @@
+package markup
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Kinds of blocks.
+const (
+	Paragraph    = "paragraph"
+	Heading      = "heading"
+	Preformatted = "preformatted"
+	SceneBreak   = "scene_break"
+)
+
+// Block is a block of text for typesetting. Quotes and lists add to the
+// indent of the blocks in them instead of being blocks of their own.
+type Block struct {
+	Kind string
+	// Level is the level of a heading, from 1.
+	Level  int
+	Indent int
+	// Marker leads the first line of a list item, such as "•" or "2.".
+	Marker string
+	Spans  []Span
+}
+
+// Span is a run of text in one style. A line break is a span of "\n".
+type Span struct {
+	Text                 string
+	Bold, Italic, Strike bool
+	Code                 bool
+}
+
+// blockInline are the inline elements blocks keep the style of. Other
+// inline elements keep only their text.
+var blockInline = map[string]bool{
+	"a": true, "abbr": true, "b": true, "code": true, "del": true, "em": true, "i": true, "s": true,
+	"small": true, "span": true, "strong": true, "sub": true, "sup": true, "u": true,
+}
+
+// Blocks returns the blocks of source in reading order. Whitespace is
+// collapsed outside preformatted blocks, and blocks without text are left
+// out.
+func Blocks(format, source string) []Block {
+	b := blockWriter{}
+	t := tokenizer{source: Render(format, source)}
+	for {
+		item, ok := t.next()
+		if !ok {
+			break
+		}
+		switch item.kind {
+		case textToken:
+			b.text(item.data)
+		case startToken:
+			b.start(&item)
+		case endToken:
+			b.end(item.data)
+		}
+	}
+	b.closeBlock()
+	return b.blocks
+}
+
+type blockWriter struct {
+	blocks  []Block
+	current *Block
+	// heading is the level of the open heading, pre the depth of open
+	// preformatted blocks and indent the one of quotes and lists.
+	heading, pre, indent int
+	inline               []string
+	marker               string
+	// lists holds the last number of each open ordered list, -1 for other lists.
+	lists []int
+	// hidden counts the open links back from footnotes.
+	hidden int
+}
+
+func (b *blockWriter) text(data string) {
+	if b.hidden > 0 {
+		return
+	}
+	if b.pre == 0 {
+		// No-break spaces are kept, they tie words together.
+		collapsed := strings.Join(strings.FieldsFunc(data, isHTMLSpace), " ")
+		if collapsed != "" && leadingSpace(data) {
+			collapsed = " " + collapsed
+		}
+		data = collapsed + trailingSpace(data)
+		if b.current == nil && strings.TrimSpace(data) == "" {
+			return
+		}
+	}
+	b.openBlock()
+	span := Span{Text: data}
+	for _, name := range b.inline {
+		switch name {
+		case "b", "strong":
+			span.Bold = true
+		case "em", "i":
+			span.Italic = true
+		case "del", "s":
+			span.Strike = true
+		case "code":
+			span.Code = true
+		}
+	}
+	spans := b.current.Spans
+	if last := len(spans) - 1; last >= 0 && spans[last].Text != "\n" && sameStyle(spans[last], span) {
+		spans[last].Text += span.Text
+		return
+	}
+	b.current.Spans = append(spans, span)
+}
+
+func (b *blockWriter) start(item *token) {
+	if blockInline[item.data] {
+		if href, _ := item.attribute("href"); item.data == "a" && strings.HasPrefix(href, "#fnref-") {
+			b.hidden++
+		}
+		b.inline = append(b.inline, item.data)
+		return
+	}
+	switch item.data {
+	case "br":
+		if b.current != nil {
+			b.current.Spans = append(b.current.Spans, Span{Text: "\n"})
+		}
+	case "img":
+		if alt, _ := item.attribute("alt"); alt != "" {
+			b.text(alt)
+		}
+	case "hr":
+		b.closeBlock()
+		b.blocks = append(b.blocks, Block{Kind: SceneBreak, Indent: b.indent})
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		b.closeBlock()
+		b.heading = int(item.data[1] - '0')
+	case "blockquote":
+		b.closeBlock()
+		b.indent++
+	case "ol":
+		b.closeBlock()
+		number := 1
+		if start, ok := item.attribute("start"); ok && isNumber(start) {
+			number, _ = strconv.Atoi(start)
+		}
+		b.lists = append(b.lists, number-1)
+		b.indent++
+	case "ul":
+		b.closeBlock()
+		b.lists = append(b.lists, -1)
+		b.indent++
+	case "li":
+		b.closeBlock()
+		b.marker = "•"
+		if last := len(b.lists) - 1; last >= 0 && b.lists[last] >= 0 {
+			b.lists[last]++
+			b.marker = strconv.Itoa(b.lists[last]) + "."
+		}
+	case "pre":
+		b.closeBlock()
+		b.pre++
+	default:
+		b.closeBlock()
+	}
+}
+
+func (b *blockWriter) end(name string) {
+	if blockInline[name] {
+		for i := len(b.inline) - 1; i >= 0; i-- {
+			if b.inline[i] == name {
+				if name == "a" && b.hidden > 0 {
+					b.hidden--
+				}
+				b.inline = append(b.inline[:i], b.inline[i+1:]...)
+				return
+			}
+		}
+		return
+	}
+	b.closeBlock()
+	switch name {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		b.heading = 0
+	case "blockquote":
+		if b.indent > 0 {
+			b.indent--
+		}
+	case "ol", "ul":
+		if len(b.lists) > 0 {
+			b.lists = b.lists[:len(b.lists)-1]
+			b.indent--
+		}
+		b.marker = ""
+	case "li":
+		b.marker = ""
+	case "pre":
+		b.pre--
+	}
+}
+
+func (b *blockWriter) openBlock() {
+	if b.current != nil {
+		return
+	}
+	block := Block{Kind: Paragraph, Indent: b.indent, Marker: b.marker}
+	switch {
+	case b.pre > 0:
+		block.Kind = Preformatted
+	case b.heading > 0:
+		block.Kind, block.Level = Heading, b.heading
+	}
+	b.marker = ""
+	b.current = &block
+}
+
+// closeBlock adds the open block, without the spaces around its text.
+func (b *blockWriter) closeBlock() {
+	block := b.current
+	b.current = nil
+	if block == nil {
+		return
+	}
+	if block.Kind == Preformatted {
+		spans := block.Spans
+		if len(spans) > 0 {
+			spans[0].Text = strings.TrimLeft(spans[0].Text, "\n")
+			spans[len(spans)-1].Text = strings.TrimRight(spans[len(spans)-1].Text, "\n")
+		}
+		for _, span := range spans {
+			if strings.TrimSpace(span.Text) != "" {
+				b.blocks = append(b.blocks, *block)
+				return
+			}
+		}
+		return
+	}
+	for len(block.Spans) > 0 && strings.TrimSpace(block.Spans[0].Text) == "" {
+		block.Spans = block.Spans[1:]
+	}
+	for len(block.Spans) > 0 && strings.TrimSpace(block.Spans[len(block.Spans)-1].Text) == "" {
+		block.Spans = block.Spans[:len(block.Spans)-1]
+	}
+	if len(block.Spans) == 0 {
+		return
+	}
+	block.Spans[0].Text = strings.TrimLeft(block.Spans[0].Text, " ")
+	last := len(block.Spans) - 1
+	block.Spans[last].Text = strings.TrimRight(block.Spans[last].Text, " ")
+	b.blocks = append(b.blocks, *block)
+}
+
+func sameStyle(a, b Span) bool {
+	return a.Bold == b.Bold && a.Italic == b.Italic && a.Strike == b.Strike && a.Code == b.Code
+}
+
+func isHTMLSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func leadingSpace(data string) bool {
+	return data != "" && isHTMLSpace(rune(data[0]))
+}
+
+func trailingSpace(data string) string {
+	if data != "" && isHTMLSpace(rune(data[len(data)-1])) {
+		return " "
+	}
+	return ""
+}
@@ -0,0 +1,245 @@
+package markup
+
+import (
+	"strconv"
+	"strings"
+)
+
+// fb2Inline maps inline elements to FictionBook ones. Elements mapped to ""
+// keep only their text.
+var fb2Inline = map[string]string{
+	"a": "a", "abbr": "", "b": "strong", "code": "code", "del": "strikethrough", "em": "emphasis",
+	"i": "emphasis", "s": "strikethrough", "small": "", "span": "", "strong": "strong",
+	"sub": "sub", "sup": "sup", "u": "",
+}
+
+// FB2 returns the content of a FictionBook section for source. FictionBook
+// paragraphs hold neither line breaks nor lists, so a line break starts a
+// new paragraph and list items become paragraphs led by their marker.
+// Headings and scene breaks become subtitles and quotes become cites.
+func FB2(format, source string) string {
+	return toFB2(Render(format, source))
+}
+
+type fb2Element struct {
+	// name is the HTML element and fb2 the FictionBook one, if any.
+	name, fb2 string
+	start     string
+	// hidden elements are left out with their text.
+	hidden bool
+}
+
+// fb2Writer writes rendered HTML as FictionBook. Paragraphs are opened by
+// their first text, so that blocks without text leave nothing behind, and
+// reopen the inline elements they are in.
+type fb2Writer struct {
+	out strings.Builder
+	// block is the element text opens: "p", or "subtitle" in a heading.
+	block  string
+	open   bool
+	inline []fb2Element
+	// marker leads the next paragraph, in a list item.
+	marker string
+	// lists holds the last number of each open ordered list, -1 for other lists.
+	lists []int
+	// Cites do not nest, so nested quotes share the outermost cite.
+	quotes int
+	cite   bool
+	pre    int
+	blocks int
+}
+
+func toFB2(rendered string) string {
+	f := fb2Writer{block: "p"}
+	t := tokenizer{source: rendered}
+	for {
+		item, ok := t.next()
+		if !ok {
+			break
+		}
+		switch item.kind {
+		case textToken:
+			f.text(item.data)
+		case startToken:
+			f.start(&item)
+		case endToken:
+			f.end(item.data)
+		}
+	}
+	f.closeBlock()
+	if f.cite {
+		f.out.WriteString("</cite>\n")
+	}
+	if f.blocks == 0 {
+		f.out.WriteString("<empty-line/>\n")
+	}
+	return f.out.String()
+}
+
+func (f *fb2Writer) text(data string) {
+	for _, element := range f.inline {
+		if element.hidden {
+			return
+		}
+	}
+	if f.pre > 0 {
+		for i, line := range strings.Split(data, "\n") {
+			if i > 0 {
+				f.closeBlock()
+			}
+			if line != "" {
+				f.openBlock()
+				f.out.WriteString(escape(line))
+			}
+		}
+		return
+	}
+	if !f.open && strings.TrimSpace(data) == "" {
+		return
+	}
+	f.openBlock()
+	f.out.WriteString(escape(data))
+}
+
+func (f *fb2Writer) start(item *token) {
+	if name, ok := fb2Inline[item.data]; ok {
+		element := fb2Element{name: item.data, fb2: name, start: "<" + name + ">"}
+		if name == "a" {
+			href, _ := item.attribute("href")
+			// Targets within the chapter do not survive the conversion, and
+			// the links back from footnotes would lead nowhere.
+			if strings.HasPrefix(href, "#") || f.inLink() {
+				element.fb2 = ""
+				element.hidden = strings.HasPrefix(href, "#fnref-")
+			} else {
+				element.start = `<a l:href="` + escape(href) + `">`
+			}
+		}
+		f.inline = append(f.inline, element)
+		if f.open && element.fb2 != "" {
+			f.out.WriteString(element.start)
+		}
+		return
+	}
+	switch item.data {
+	case "br":
+		f.closeBlock()
+	case "img":
+		alt, _ := item.attribute("alt")
+		if alt != "" {
+			f.text(alt)
+		}
+	case "hr":
+		f.closeBlock()
+		f.openCite()
+		f.out.WriteString("<subtitle>* * *</subtitle>\n")
+		f.blocks++
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		f.closeBlock()
+		f.block = "subtitle"
+	case "blockquote":
+		f.closeBlock()
+		f.quotes++
+	case "ol":
+		f.closeBlock()
+		number := 1
+		if start, ok := item.attribute("start"); ok && isNumber(start) {
+			number, _ = strconv.Atoi(start)
+		}
+		f.lists = append(f.lists, number-1)
+	case "ul":
+		f.closeBlock()
+		f.lists = append(f.lists, -1)
+	case "li":
+		f.closeBlock()
+		f.marker = "• "
+		if last := len(f.lists) - 1; last >= 0 && f.lists[last] >= 0 {
+			f.lists[last]++
+			f.marker = strconv.Itoa(f.lists[last]) + ". "
+		}
+	case "pre":
+		f.closeBlock()
+		f.pre++
+	default:
+		f.closeBlock()
+	}
+}
+
+func (f *fb2Writer) end(name string) {
+	if _, ok := fb2Inline[name]; ok {
+		last := len(f.inline) - 1
+		if last < 0 || f.inline[last].name != name {
+			return
+		}
+		if f.open && f.inline[last].fb2 != "" {
+			f.out.WriteString("</" + f.inline[last].fb2 + ">")
+		}
+		f.inline = f.inline[:last]
+		return
+	}
+	f.closeBlock()
+	switch name {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		f.block = "p"
+	case "blockquote":
+		f.quotes--
+		if f.quotes == 0 && f.cite {
+			f.out.WriteString("</cite>\n")
+			f.cite = false
+		}
+	case "ol", "ul":
+		if len(f.lists) > 0 {
+			f.lists = f.lists[:len(f.lists)-1]
+		}
+		f.marker = ""
+	case "li":
+		f.marker = ""
+	case "pre":
+		f.pre--
+	}
+}
+
+func (f *fb2Writer) inLink() bool {
+	for _, element := range f.inline {
+		if element.fb2 == "a" {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fb2Writer) openCite() {
+	if f.quotes > 0 && !f.cite {
+		f.out.WriteString("<cite>\n")
+		f.cite = true
+	}
+}
+
+func (f *fb2Writer) openBlock() {
+	if f.open {
+		return
+	}
+	f.openCite()
+	f.out.WriteString("<" + f.block + ">" + escape(f.marker))
+	f.marker = ""
+	for _, element := range f.inline {
+		if element.fb2 != "" {
+			f.out.WriteString(element.start)
+		}
+	}
+	f.open = true
+	f.blocks++
+}
+
+func (f *fb2Writer) closeBlock() {
+	if !f.open {
+		return
+	}
+	for i := len(f.inline) - 1; i >= 0; i-- {
+		if f.inline[i].fb2 != "" {
+			f.out.WriteString("</" + f.inline[i].fb2 + ">")
+		}
+	}
+	f.out.WriteString("</" + f.block + ">\n")
+	f.open = false
+}
@@ -0,0 +1,604 @@
+package markup
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The Markdown dialect covers what fiction needs: paragraphs, headings,
+// emphasis, scene breaks, block quotes, flat lists, code, links, images
+// and footnotes. Raw HTML is shown as text.
+
+const (
+	maxQuoteDepth  = 8
+	maxInlineDepth = 16
+)
+
+// hardBreak marks a line break inside a paragraph. normalize removes
+// control characters from the source, so it cannot occur there.
+const hardBreak = "\x00"
+
+var (
+	headingLine   = regexp.MustCompile(`^(#{1,6})(?:[ \t]+(.*?))?[ \t]*$`)
+	bulletItem    = regexp.MustCompile(`^[ ]{0,3}[-*+][ \t]+(.*)$`)
+	orderedItem   = regexp.MustCompile(`^[ ]{0,3}(\d{1,9})[.)][ \t]+(.*)$`)
+	noteLine      = regexp.MustCompile(`^[ ]{0,3}\[\^([^\]\s]+)\]:[ \t]*(.*)$`)
+	closingHashes = regexp.MustCompile(`[ \t]+#+$`)
+)
+
+type markdown struct {
+	out strings.Builder
+	// notes holds footnote texts by label; numbers are given in the order
+	// the notes are first referenced.
+	notes   map[string]string
+	numbers map[string]int
+	order   []string
+}
+
+func renderMarkdown(source string) string {
+	m := &markdown{notes: make(map[string]string), numbers: make(map[string]int)}
+	lines := m.collectNotes(strings.Split(source, "\n"))
+	m.blocks(lines, 0)
+	m.footnotes()
+	return m.out.String()
+}
+
+// collectNotes removes footnote definitions from lines. A definition goes
+// on over the following indented lines.
+func (m *markdown) collectNotes(lines []string) []string {
+	rest := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); i++ {
+		match := noteLine.FindStringSubmatch(lines[i])
+		if match == nil {
+			rest = append(rest, lines[i])
+			continue
+		}
+		text := []string{match[2]}
+		for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" &&
+			(strings.HasPrefix(lines[i+1], "    ") || strings.HasPrefix(lines[i+1], "\t")) {
+			i++
+			text = append(text, strings.TrimSpace(lines[i]))
+		}
+		if _, ok := m.notes[match[1]]; !ok {
+			m.notes[match[1]] = strings.Join(text, "\n")
+		}
+	}
+	return rest
+}
+
+func (m *markdown) blocks(lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		trimmed := strings.TrimSpace(lines[i])
+		switch {
+		case trimmed == "":
+			i++
+		case isFence(trimmed):
+			i = m.code(lines, i)
+		case isSceneBreak(trimmed):
+			m.out.WriteString("<hr />\n")
+			i++
+		case headingLine.MatchString(trimmed):
+			match := headingLine.FindStringSubmatch(trimmed)
+			level := strconv.Itoa(len(match[1]))
+			text := closingHashes.ReplaceAllString(match[2], "")
+			m.out.WriteString("<h" + level + ">" + m.inline(text, 0, false) + "</h" + level + ">\n")
+			i++
+		case strings.HasPrefix(trimmed, ">") && depth < maxQuoteDepth:
+			i = m.quote(lines, i, depth)
+		case bulletItem.MatchString(lines[i]) || orderedItem.MatchString(lines[i]):
+			i = m.list(lines, i)
+		default:
+			i = m.paragraph(lines, i)
+		}
+	}
+}
+
+func isFence(line string) bool {
+	return strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~")
+}
+
+// isSceneBreak matches three or more *, - or _, optionally spaced.
+func isSceneBreak(line string) bool {
+	compact := strings.Join(strings.Fields(line), "")
+	if len(compact) < 3 {
+		return false
+	}
+	return strings.Count(compact, compact[:1]) == len(compact) && strings.ContainsAny(compact[:1], "*-_")
+}
+
+// startsBlock tells whether line interrupts a paragraph.
+func startsBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || isFence(trimmed) || isSceneBreak(trimmed) || headingLine.MatchString(trimmed) ||
+		strings.HasPrefix(trimmed, ">") || bulletItem.MatchString(line) || orderedItem.MatchString(line)
+}
+
+func (m *markdown) code(lines []string, i int) int {
+	fence := strings.TrimSpace(lines[i])[:3]
+	body := make([]string, 0)
+	i++
+	for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+		body = append(body, lines[i])
+		i++
+	}
+	m.out.WriteString("<pre><code>" + escape(strings.Join(body, "\n")) + "</code></pre>\n")
+	return i + 1
+}
+
+func (m *markdown) quote(lines []string, i int, depth int) int {
+	body := make([]string, 0)
+	for i < len(lines) {
+		trimmed := strings.TrimLeft(lines[i], " ")
+		if !strings.HasPrefix(trimmed, ">") {
+			break
+		}
+		trimmed = strings.TrimPrefix(trimmed[1:], " ")
+		body = append(body, trimmed)
+		i++
+	}
+	m.out.WriteString("<blockquote>\n")
+	m.blocks(body, depth+1)
+	m.out.WriteString("</blockquote>\n")
+	return i
+}
+
+// list collects items of one kind; blank lines between items do not end
+// it, and other lines continue the current item.
+func (m *markdown) list(lines []string, i int) int {
+	ordered := orderedItem.MatchString(lines[i])
+	item := bulletItem
+	tag := "ul"
+	if ordered {
+		item = orderedItem
+		tag = "ol"
+	}
+	items := make([][]string, 0)
+	start := ""
+	for i < len(lines) {
+		match := item.FindStringSubmatch(lines[i])
+		switch {
+		case match != nil && !isSceneBreak(strings.TrimSpace(lines[i])):
+			if ordered && len(items) == 0 && strings.TrimLeft(match[1], "0") != "1" {
+				start = strings.TrimLeft(match[1], "0")
+				if start == "" {
+					start = "0"
+				}
+			}
+			items = append(items, []string{match[len(match)-1]})
+			i++
+			continue
+		case strings.TrimSpace(lines[i]) == "":
+			next := i
+			for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+				next++
+			}
+			if next < len(lines) && item.MatchString(lines[next]) {
+				i = next
+				continue
+			}
+		case !startsBlock(lines[i]):
+			last := len(items) - 1
+			items[last] = append(items[last], strings.TrimSpace(lines[i]))
+			i++
+			continue
+		}
+		break
+	}
+	if start != "" {
+		m.out.WriteString(`<ol start="` + start + `">` + "\n")
+	} else {
+		m.out.WriteString("<" + tag + ">\n")
+	}
+	for _, text := range items {
+		m.out.WriteString("<li>" + m.inline(joinLines(text), 0, false) + "</li>\n")
+	}
+	m.out.WriteString("</" + tag + ">\n")
+	return i
+}
+
+func (m *markdown) paragraph(lines []string, i int) int {
+	text := []string{lines[i]}
+	i++
+	for i < len(lines) && !startsBlock(lines[i]) {
+		text = append(text, lines[i])
+		i++
+	}
+	m.out.WriteString("<p>" + m.inline(joinLines(text), 0, false) + "</p>\n")
+	return i
+}
+
+// joinLines ends a line with a hard break when it ends with two spaces or
+// a backslash.
+func joinLines(lines []string) string {
+	var joined strings.Builder
+	for i, line := range lines {
+		line = strings.TrimLeft(line, " \t")
+		if i == len(lines)-1 {
+			joined.WriteString(strings.TrimRight(line, " \t"))
+			break
+		}
+		switch {
+		case strings.HasSuffix(line, "  "):
+			joined.WriteString(strings.TrimRight(line, " \t") + hardBreak)
+		case strings.HasSuffix(line, "\\"):
+			joined.WriteString(strings.TrimSuffix(line, "\\") + hardBreak)
+		default:
+			joined.WriteString(strings.TrimRight(line, " \t") + "\n")
+		}
+	}
+	return joined.String()
+}
+
+func (m *markdown) footnotes() {
+	if len(m.order) == 0 {
+		return
+	}
+	m.out.WriteString("<section class=\"footnotes\">\n<ol>\n")
+	// Rendering a note may reference further notes and extend the order.
+	for i := 0; i < len(m.order); i++ {
+		number := strconv.Itoa(i + 1)
+		m.out.WriteString(`<li id="fn-` + number + `">` + m.inline(m.notes[m.order[i]], 0, false) +
+			` <a href="#fnref-` + number + `">&#8617;</a></li>` + "\n")
+	}
+	m.out.WriteString("</ol>\n</section>\n")
+}
+
+// inline renders the text of one block. Searches for closing delimiters
+// remember their last answer so that unmatched delimiters cost linear time.
+func (m *markdown) inline(text string, depth int, inLink bool) string {
+	var out strings.Builder
+	find := &search{text: text, finders: make(map[string]*finder)}
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == hardBreak[0]:
+			out.WriteString("<br />\n")
+			i++
+			continue
+		case c == '\\' && i+1 < len(text) && isPunct(text[i+1]):
+			out.WriteString(escape(text[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '`':
+			if end, ok := m.codeSpan(&out, text, i, find); ok {
+				i = end
+				continue
+			}
+		case c == '!' && strings.HasPrefix(text[i+1:], "["):
+			if end, ok := m.image(&out, text, i, find); ok {
+				i = end
+				continue
+			}
+		case c == '[' && !inLink:
+			if end, ok := m.footnoteRef(&out, text, i, find); ok {
+				i = end
+				continue
+			}
+			if end, ok := m.link(&out, text, i, depth, find); ok {
+				i = end
+				continue
+			}
+		case c == '<' && !inLink:
+			if end, ok := autolink(&out, text, i, find); ok {
+				i = end
+				continue
+			}
+		case c == '*' || c == '_' || c == '~':
+			if end, ok := m.emphasis(&out, text, i, depth, inLink, find); ok {
+				i = end
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		out.WriteString(escape(text[i : i+size]))
+		i += size
+	}
+	return out.String()
+}
+
+// search looks for delimiters in the text of one inline call.
+type search struct {
+	text    string
+	finders map[string]*finder
+}
+
+// finder finds the next unescaped delimiter at or after a position that
+// valid accepts. Whether a position matches does not depend on where the
+// search started, so the last answer is cached: the first match after from
+// is at, and any search starting between from and at has the same answer.
+// Repeated searches over a text therefore take linear time in total.
+type finder struct {
+	text      string
+	delimiter string
+	valid     func(at int) bool
+	from, at  int
+	cached    bool
+}
+
+func (f *finder) next(from int) int {
+	if f.cached && from >= f.from && (f.at < 0 || from <= f.at) {
+		return f.at
+	}
+	f.from, f.at, f.cached = from, -1, true
+	for i := from; i <= len(f.text)-len(f.delimiter); {
+		j := strings.Index(f.text[i:], f.delimiter)
+		if j < 0 {
+			break
+		}
+		j += i
+		if (j == 0 || f.text[j-1] != '\\') && (f.valid == nil || f.valid(j)) {
+			f.at = j
+			break
+		}
+		i = j + 1
+	}
+	return f.at
+}
+
+// next finds the next unescaped delimiter.
+func (s *search) next(delimiter string, from int) int {
+	return s.finder(delimiter, delimiter, nil).next(from)
+}
+
+func (s *search) finder(key, delimiter string, valid func(at int) bool) *finder {
+	f, ok := s.finders[key]
+	if !ok {
+		f = &finder{text: s.text, delimiter: delimiter, valid: valid}
+		s.finders[key] = f
+	}
+	return f
+}
+
+// codeEnd finds a run of backticks exactly as long as fence.
+func (s *search) codeEnd(fence string, from int) int {
+	text := s.text
+	return s.finder("code"+fence, fence, func(at int) bool {
+		after := at + len(fence)
+		return !(after < len(text) && text[after] == '`' || at > 0 && text[at-1] == '`')
+	}).next(from)
+}
+
+// emphasisEnd finds a closing delimiter: one preceded by a non-space and,
+// for single delimiters, not part of a longer run, which belongs to a
+// nested delimiter. A closing underscore must end a word.
+func (s *search) emphasisEnd(delimiter string, from int) int {
+	text := s.text
+	c := delimiter[0]
+	return s.finder("emphasis"+delimiter, delimiter, func(at int) bool {
+		after := at + len(delimiter)
+		switch {
+		case at == 0 || isSpaceBefore(text[:at]):
+			return false
+		case len(delimiter) == 1 && (after < len(text) && text[after] == c || text[at-1] == c):
+			return false
+		case c == '_' && after < len(text) && isWordStart(text[after:]):
+			return false
+		}
+		return true
+	}).next(from)
+}
+
+func (m *markdown) codeSpan(out *strings.Builder, text string, i int, find *search) (int, bool) {
+	run := 0
+	for i+run < len(text) && text[i+run] == '`' {
+		run++
+	}
+	end := find.codeEnd(strings.Repeat("`", run), i+run)
+	if end < 0 {
+		return 0, false
+	}
+	code := text[i+run : end]
+	if strings.TrimSpace(code) != "" {
+		code = strings.TrimSpace(code)
+	}
+	out.WriteString("<code>" + escape(strings.ReplaceAll(code, hardBreak, " ")) + "</code>")
+	return end + run, true
+}
+
+// target reads "(url "title")" at i and returns the url, the title and the
+// position behind the closing parenthesis.
+func target(text string, i int, find *search) (string, string, int, bool) {
+	if i >= len(text) || text[i] != '(' {
+		return "", "", 0, false
+	}
+	end := find.next(")", i+1)
+	if end < 0 {
+		return "", "", 0, false
+	}
+	inner := strings.TrimSpace(text[i+1 : end])
+	address, title := inner, ""
+	if space := strings.IndexAny(inner, " \t\n"); space >= 0 {
+		address = inner[:space]
+		title = strings.TrimSpace(inner[space:])
+		if len(title) < 2 || !(title[0] == '"' && title[len(title)-1] == '"' || title[0] == '\'' && title[len(title)-1] == '\'') {
+			return "", "", 0, false
+		}
+		title = title[1 : len(title)-1]
+	}
+	address = strings.TrimSuffix(strings.TrimPrefix(address, "<"), ">")
+	return unescapePunct(address), unescapePunct(title), end + 1, true
+}
+
+func (m *markdown) link(out *strings.Builder, text string, i, depth int, find *search) (int, bool) {
+	close := find.next("]", i+1)
+	if close < 0 {
+		return 0, false
+	}
+	address, title, end, ok := target(text, close+1, find)
+	if !ok {
+		return 0, false
+	}
+	label := m.inline(text[i+1:close], depth+1, true)
+	address, ok = safeURL(address, linkSchemes...)
+	if !ok {
+		out.WriteString(label)
+		return end, true
+	}
+	out.WriteString(`<a href="` + escape(address) + `"`)
+	if title != "" {
+		out.WriteString(` title="` + escape(title) + `"`)
+	}
+	if !strings.HasPrefix(address, "#") {
+		out.WriteString(` rel="nofollow noopener noreferrer"`)
+	}
+	out.WriteString(">" + label + "</a>")
+	return end, true
+}
+
+func (m *markdown) image(out *strings.Builder, text string, i int, find *search) (int, bool) {
+	close := find.next("]", i+2)
+	if close < 0 {
+		return 0, false
+	}
+	address, title, end, ok := target(text, close+1, find)
+	if !ok {
+		return 0, false
+	}
+	alt := unescapePunct(strings.ReplaceAll(text[i+2:close], hardBreak, " "))
+	address, ok = safeURL(address, imageSchemes...)
+	if !ok {
+		out.WriteString(escape(alt))
+		return end, true
+	}
+	out.WriteString(`<img src="` + escape(address) + `" alt="` + escape(alt) + `"`)
+	if title != "" {
+		out.WriteString(` title="` + escape(title) + `"`)
+	}
+	out.WriteString(" />")
+	return end, true
+}
+
+func (m *markdown) footnoteRef(out *strings.Builder, text string, i int, find *search) (int, bool) {
+	if !strings.HasPrefix(text[i:], "[^") {
+		return 0, false
+	}
+	close := find.next("]", i+2)
+	if close < 0 {
+		return 0, false
+	}
+	label := text[i+2 : close]
+	if _, ok := m.notes[label]; !ok || strings.ContainsAny(label, " \t\n[") {
+		return 0, false
+	}
+	number, seen := m.numbers[label]
+	if !seen {
+		m.order = append(m.order, label)
+		number = len(m.order)
+		m.numbers[label] = number
+	}
+	n := strconv.Itoa(number)
+	if seen {
+		out.WriteString(`<sup><a href="#fn-` + n + `">` + n + `</a></sup>`)
+	} else {
+		out.WriteString(`<sup id="fnref-` + n + `"><a href="#fn-` + n + `">` + n + `</a></sup>`)
+	}
+	return close + 1, true
+}
+
+// autolink renders <https://example.com> and <mailto:...> as links.
+func autolink(out *strings.Builder, text string, i int, find *search) (int, bool) {
+	end := find.next(">", i+1)
+	if end < 0 {
+		return 0, false
+	}
+	inner := text[i+1 : end]
+	colon := strings.IndexByte(inner, ':')
+	if colon <= 0 || strings.ContainsAny(inner, " \t\n<"+hardBreak) {
+		return 0, false
+	}
+	address, ok := safeURL(inner, linkSchemes...)
+	if !ok {
+		return 0, false
+	}
+	out.WriteString(`<a href="` + escape(address) + `" rel="nofollow noopener noreferrer">` + escape(inner) + "</a>")
+	return end + 1, true
+}
+
+var emphasisTags = map[string][]string{
+	"***": {"em", "strong"}, "___": {"em", "strong"}, "**": {"strong"}, "__": {"strong"},
+	"*": {"em"}, "_": {"em"}, "~~": {"del"},
+}
+
+// emphasis matches an opening delimiter at i with the next closing one.
+// Openers must be followed and closers preceded by a non-space; underscores
+// inside words, as in snake_case, are left alone.
+func (m *markdown) emphasis(out *strings.Builder, text string, i, depth int, inLink bool, find *search) (int, bool) {
+	if depth >= maxInlineDepth {
+		return 0, false
+	}
+	c := text[i : i+1]
+	candidates := []string{c + c + c, c + c, c}
+	if c == "~" {
+		candidates = []string{"~~"}
+	}
+	for _, delimiter := range candidates {
+		if !strings.HasPrefix(text[i:], delimiter) {
+			continue
+		}
+		start := i + len(delimiter)
+		if start >= len(text) || isSpaceRune(text[start:]) {
+			continue
+		}
+		if c == "_" && i > 0 && isWordEnd(text[:i]) {
+			continue
+		}
+		if i > 0 && text[i-1] == c[0] || text[start] == c[0] {
+			// Part of a longer run that did not match as a whole.
+			continue
+		}
+		// The content must not be empty, so the closer starts behind start.
+		end := find.emphasisEnd(delimiter, start+1)
+		if end < 0 {
+			continue
+		}
+		inner := m.inline(text[start:end], depth+1, inLink)
+		tags := emphasisTags[delimiter]
+		for _, tag := range tags {
+			out.WriteString("<" + tag + ">")
+		}
+		out.WriteString(inner)
+		for j := len(tags) - 1; j >= 0; j-- {
+			out.WriteString("</" + tags[j] + ">")
+		}
+		return end + len(delimiter), true
+	}
+	return 0, false
+}
+
+func isPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+func unescapePunct(text string) string {
+	var out strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) && isPunct(text[i+1]) {
+			i++
+		}
+		out.WriteByte(text[i])
+	}
+	return out.String()
+}
+
+func isSpaceRune(text string) bool {
+	r, _ := utf8.DecodeRuneInString(text)
+	return unicode.IsSpace(r) || text[0] == hardBreak[0]
+}
+
+func isSpaceBefore(text string) bool {
+	r, _ := utf8.DecodeLastRuneInString(text)
+	return unicode.IsSpace(r) || text[len(text)-1] == hardBreak[0]
+}
+
+func isWordEnd(text string) bool {
+	r, _ := utf8.DecodeLastRuneInString(text)
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isWordStart(text string) bool {
+	r, _ := utf8.DecodeRuneInString(text)
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
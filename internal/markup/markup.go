@@ -0,0 +1,134 @@
+// Package markup renders chapter sources to sanitized HTML. Plain text and
+// Markdown are escaped in full, so the only tags in their output are the
+// ones the renderer writes itself; HTML sources are cut down to an
+// allowlist of elements and attributes. The output is well-formed XHTML
+// and depends on the source alone, so the same source always renders to
+// the same bytes.
+package markup
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Source formats of a chapter.
+const (
+	Plain    = "plain"
+	Markdown = "markdown"
+	HTML     = "html"
+)
+
+func Known(format string) bool {
+	switch format {
+	case Plain, Markdown, HTML:
+		return true
+	}
+	return false
+}
+
+// Render returns the sanitized HTML of source. Unknown formats are
+// rendered as plain text.
+func Render(format, source string) string {
+	source = normalize(source)
+	switch format {
+	case Markdown:
+		return renderMarkdown(source)
+	case HTML:
+		return sanitize(source)
+	}
+	return renderPlain(source)
+}
+
+// Text returns source without markup: paragraphs are separated by a blank
+// line and scene breaks become "* * *".
+func Text(format, source string) string {
+	return toText(Render(format, source))
+}
+
+// normalize unifies line breaks and drops what XML cannot carry: invalid
+// UTF-8, control characters other than tab and newline, and noncharacters.
+func normalize(source string) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	source = strings.ToValidUTF8(source, "\uFFFD")
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || r == 0xFFFE || r == 0xFFFF {
+			return -1
+		}
+		return r
+	}, source)
+}
+
+func escape(text string) string {
+	return html.EscapeString(text)
+}
+
+// renderPlain turns blank-line separated blocks into paragraphs and keeps
+// the line breaks inside them.
+func renderPlain(source string) string {
+	var out strings.Builder
+	for _, paragraph := range paragraphs(source) {
+		out.WriteString("<p>")
+		for i, line := range paragraph {
+			if i != 0 {
+				out.WriteString("<br />\n")
+			}
+			out.WriteString(escape(line))
+		}
+		out.WriteString("</p>\n")
+	}
+	return out.String()
+}
+
+func paragraphs(source string) [][]string {
+	result := make([][]string, 0)
+	current := make([]string, 0)
+	for _, line := range strings.Split(source, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(current) != 0 {
+				result = append(result, current)
+				current = make([]string, 0)
+			}
+			continue
+		}
+		current = append(current, strings.TrimRightFunc(line, unicode.IsSpace))
+	}
+	if len(current) != 0 {
+		result = append(result, current)
+	}
+	return result
+}
+
+// safeURL accepts relative URLs and absolute ones with a harmless scheme.
+// Browsers ignore tabs and line breaks anywhere in a URL, so they are
+// removed before the scheme is read.
+func safeURL(raw string, schemes ...string) (string, bool) {
+	address := strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(raw))
+	if address == "" {
+		return "", false
+	}
+	colon := strings.IndexByte(address, ':')
+	if colon < 0 || strings.ContainsAny(address[:colon], "/?#") {
+		return address, true
+	}
+	scheme := strings.ToLower(address[:colon])
+	for _, allowed := range schemes {
+		if scheme == allowed {
+			return address, true
+		}
+	}
+	return "", false
+}
+
+var linkSchemes = []string{"http", "https", "mailto"}
+
+var imageSchemes = []string{"http", "https"}
@@ -0,0 +1,363 @@
+package markup
+
+import (
+	"html"
+	"strings"
+)
+
+const (
+	textToken = iota
+	startToken
+	endToken
+)
+
+type token struct {
+	kind int
+	// data is the unescaped text of a text token or the lower-case tag name.
+	data        string
+	attributes  []attribute
+	selfClosing bool
+}
+
+type attribute struct {
+	name, value string
+}
+
+func (t *token) attribute(name string) (string, bool) {
+	for _, item := range t.attributes {
+		if item.name == name {
+			return item.value, true
+		}
+	}
+	return "", false
+}
+
+// rawText elements hold text up to their end tag that browsers do not
+// parse as markup. They are dropped with everything inside them.
+var rawText = map[string]bool{
+	"script": true, "style": true, "textarea": true, "title": true, "xmp": true,
+	"iframe": true, "noembed": true, "noframes": true, "noscript": true, "plaintext": true,
+}
+
+// tokenizer splits HTML into text and tags the way a browser would for the
+// elements we keep. Comments, doctypes and processing instructions are
+// skipped; a tag cut off by the end of input is dropped.
+type tokenizer struct {
+	source string
+	pos    int
+}
+
+func (t *tokenizer) next() (token, bool) {
+	for t.pos < len(t.source) {
+		rest := t.source[t.pos:]
+		if rest[0] != '<' {
+			end := strings.IndexByte(rest, '<')
+			if end < 0 {
+				end = len(rest)
+			}
+			t.pos += end
+			return token{kind: textToken, data: html.UnescapeString(rest[:end])}, true
+		}
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				t.pos = len(t.source)
+			} else {
+				t.pos += 4 + end + 3
+			}
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			t.skipPast('>')
+		case len(rest) > 2 && rest[1] == '/' && isLetter(rest[2]):
+			name := tagName(rest[2:])
+			if !t.skipPast('>') {
+				return token{}, false
+			}
+			return token{kind: endToken, data: name}, true
+		case len(rest) > 1 && isLetter(rest[1]):
+			item, ok := t.startTag()
+			if !ok {
+				return token{}, false
+			}
+			if rawText[item.data] {
+				t.skipRawText(item.data)
+				continue
+			}
+			return item, true
+		default:
+			t.pos++
+			return token{kind: textToken, data: "<"}, true
+		}
+	}
+	return token{}, false
+}
+
+// skipPast moves behind the next c, or to the end of input when there is none.
+func (t *tokenizer) skipPast(c byte) bool {
+	end := strings.IndexByte(t.source[t.pos:], c)
+	if end < 0 {
+		t.pos = len(t.source)
+		return false
+	}
+	t.pos += end + 1
+	return true
+}
+
+func (t *tokenizer) skipRawText(name string) {
+	closing := "</" + name
+	for {
+		end := strings.Index(strings.ToLower(t.source[t.pos:]), closing)
+		if end < 0 {
+			t.pos = len(t.source)
+			return
+		}
+		t.pos += end + len(closing)
+		if t.pos >= len(t.source) || !isNameByte(t.source[t.pos]) {
+			t.skipPast('>')
+			return
+		}
+	}
+}
+
+func (t *tokenizer) startTag() (token, bool) {
+	item := token{kind: startToken, data: tagName(t.source[t.pos+1:])}
+	t.pos += 1 + len(item.data)
+	for {
+		t.skipSpace()
+		if t.pos >= len(t.source) {
+			return token{}, false
+		}
+		switch t.source[t.pos] {
+		case '>':
+			t.pos++
+			return item, true
+		case '/':
+			t.pos++
+			if t.pos < len(t.source) && t.source[t.pos] == '>' {
+				item.selfClosing = true
+			}
+			continue
+		}
+		start := t.pos
+		t.pos++
+		for t.pos < len(t.source) && !isSpace(t.source[t.pos]) && !strings.ContainsRune("/>=", rune(t.source[t.pos])) {
+			t.pos++
+		}
+		name := strings.ToLower(t.source[start:t.pos])
+		value := ""
+		t.skipSpace()
+		if t.pos < len(t.source) && t.source[t.pos] == '=' {
+			t.pos++
+			t.skipSpace()
+			value = t.attributeValue()
+		}
+		if _, seen := item.attribute(name); !seen {
+			item.attributes = append(item.attributes, attribute{name: name, value: html.UnescapeString(value)})
+		}
+	}
+}
+
+func (t *tokenizer) attributeValue() string {
+	if t.pos >= len(t.source) {
+		return ""
+	}
+	quote := t.source[t.pos]
+	if quote == '"' || quote == '\'' {
+		end := strings.IndexByte(t.source[t.pos+1:], quote)
+		if end < 0 {
+			t.pos = len(t.source)
+			return ""
+		}
+		value := t.source[t.pos+1 : t.pos+1+end]
+		t.pos += end + 2
+		return value
+	}
+	start := t.pos
+	for t.pos < len(t.source) && !isSpace(t.source[t.pos]) && t.source[t.pos] != '>' {
+		t.pos++
+	}
+	return t.source[start:t.pos]
+}
+
+func (t *tokenizer) skipSpace() {
+	for t.pos < len(t.source) && isSpace(t.source[t.pos]) {
+		t.pos++
+	}
+}
+
+func tagName(text string) string {
+	end := 0
+	for end < len(text) && isNameByte(text[end]) {
+		end++
+	}
+	return strings.ToLower(text[:end])
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNameByte(c byte) bool {
+	return isLetter(c) || c >= '0' && c <= '9' || c == '-'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// allowed lists the elements kept by sanitize and, for each, the attributes
+// it keeps, in the order they are written.
+var allowed = map[string][]string{
+	"a": {"href", "title"}, "abbr": {"title"}, "b": nil, "blockquote": nil, "br": nil,
+	"code": nil, "del": nil, "em": nil, "h1": nil, "h2": nil, "h3": nil, "h4": nil,
+	"h5": nil, "h6": nil, "hr": nil, "i": nil, "img": {"src", "alt", "title"}, "li": nil,
+	"ol": {"start"}, "p": nil, "pre": nil, "s": nil, "section": nil, "small": nil,
+	"span": nil, "strong": nil, "sub": nil, "sup": nil, "u": nil, "ul": nil,
+}
+
+var void = map[string]bool{"br": true, "hr": true, "img": true}
+
+// blocks close an open paragraph when they start, as they do in browsers;
+// a paragraph cannot contain them.
+var blocks = map[string]bool{
+	"blockquote": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"hr": true, "ol": true, "p": true, "pre": true, "section": true, "ul": true,
+}
+
+// dropped elements are removed together with their content: foreign
+// content and elements whose content is not meant to be shown as text.
+var dropped = map[string]bool{
+	"svg": true, "math": true, "template": true, "object": true, "select": true, "head": true,
+}
+
+// sanitize keeps the allowed elements and attributes of source and escapes
+// all text. Other elements are removed but their text is kept. Elements
+// are closed in order, so the result is well-formed whatever the input.
+func sanitize(source string) string {
+	var out strings.Builder
+	open := make([]string, 0)
+	skipping, depth := "", 0
+	t := tokenizer{source: source}
+	for {
+		item, ok := t.next()
+		if !ok {
+			break
+		}
+		if skipping != "" {
+			if item.data == skipping && item.kind == startToken && !item.selfClosing {
+				depth++
+			}
+			if item.data == skipping && item.kind == endToken {
+				depth--
+				if depth == 0 {
+					skipping = ""
+				}
+			}
+			continue
+		}
+		switch item.kind {
+		case textToken:
+			out.WriteString(escape(item.data))
+		case startToken:
+			if dropped[item.data] {
+				if !item.selfClosing {
+					skipping, depth = item.data, 1
+				}
+				continue
+			}
+			if _, ok := allowed[item.data]; !ok {
+				continue
+			}
+			if item.data == "img" {
+				src, _ := item.attribute("src")
+				if _, ok := safeURL(src, imageSchemes...); !ok {
+					continue
+				}
+			}
+			switch {
+			case blocks[item.data]:
+				open = closeTo(&out, open, "p")
+			case item.data == "li":
+				open = closeTo(&out, open, "li")
+			}
+			writeStart(&out, &item)
+			if void[item.data] {
+				continue
+			}
+			open = append(open, item.data)
+		case endToken:
+			open = closeTo(&out, open, item.data)
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+	return out.String()
+}
+
+// closeTo closes the innermost open name element and the ones inside it.
+// A list closes no item of an outer list.
+func closeTo(out *strings.Builder, open []string, name string) []string {
+	for i := len(open) - 1; i >= 0; i-- {
+		if name == "li" && (open[i] == "ul" || open[i] == "ol") {
+			break
+		}
+		if open[i] == name {
+			for len(open) > i {
+				out.WriteString("</" + open[len(open)-1] + ">")
+				open = open[:len(open)-1]
+			}
+			break
+		}
+	}
+	return open
+}
+
+func writeStart(out *strings.Builder, item *token) {
+	out.WriteString("<" + item.data)
+	external := false
+	for _, name := range allowed[item.data] {
+		value, ok := item.attribute(name)
+		if !ok {
+			continue
+		}
+		switch name {
+		case "href":
+			value, ok = safeURL(value, linkSchemes...)
+			external = ok && !strings.HasPrefix(value, "#")
+		case "src":
+			value, ok = safeURL(value, imageSchemes...)
+		case "start":
+			ok = isNumber(value)
+		}
+		if !ok {
+			continue
+		}
+		out.WriteString(" " + name + `="` + escape(value) + `"`)
+	}
+	if item.data == "img" {
+		if _, ok := item.attribute("alt"); !ok {
+			out.WriteString(` alt=""`)
+		}
+	}
+	if external {
+		out.WriteString(` rel="nofollow noopener noreferrer"`)
+	}
+	if void[item.data] {
+		out.WriteString(" />")
+		return
+	}
+	out.WriteString(">")
+}
+
+func isNumber(text string) bool {
+	if text == "" || len(text) > 9 {
+		return false
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] < '0' || text[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package markup
+
+import (
+	"regexp"
+	"strings"
+)
+
+var blockElements = map[string]bool{
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "pre": true, "ul": true, "ol": true, "section": true,
+}
+
+var blankLines = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+`)
+
+// toText strips the tags from rendered HTML. Blocks are separated by a
+// blank line, list items and line breaks by a newline.
+func toText(rendered string) string {
+	var out strings.Builder
+	t := tokenizer{source: rendered}
+	for {
+		item, ok := t.next()
+		if !ok {
+			break
+		}
+		switch {
+		case item.kind == textToken:
+			// Line breaks between tags only lay out the HTML.
+			if strings.TrimSpace(item.data) != "" || !strings.Contains(item.data, "\n") {
+				out.WriteString(item.data)
+			}
+		case item.data == "br":
+			out.WriteString("\n")
+		case item.data == "hr":
+			out.WriteString("\n\n* * *\n\n")
+		case item.data == "li" && item.kind == startToken:
+			out.WriteString("\n- ")
+		case blockElements[item.data]:
+			out.WriteString("\n\n")
+		}
+	}
+	text := blankLines.ReplaceAllString(out.String(), "\n\n")
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,106 @@
+package markup
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// formats maps the extensions of the files in testdata to source formats.
+var formats = map[string]string{".txt": Plain, ".md": Markdown, ".html": HTML}
+
+// TestXSS renders every source in testdata/xss and compares the result
+// with the .golden file next to it. Run with -update after a deliberate
+// change of the output and review the diff of the golden files.
+func TestXSS(t *testing.T) {
+	sources, err := filepath.Glob(filepath.Join("testdata", "xss", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, path := range sources {
+		format, ok := formats[filepath.Ext(path)]
+		if !ok {
+			continue
+		}
+		count++
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := Render(format, string(source))
+			if again := Render(format, string(source)); again != got {
+				t.Errorf("rendering is not deterministic:\n%s\n---\n%s", got, again)
+			}
+			checkSafe(t, got)
+			golden := path + ".golden"
+			if *update {
+				err := os.WriteFile(golden, []byte(got), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+	if count == 0 {
+		t.Fatal("no sources in testdata/xss")
+	}
+}
+
+// checkSafe fails for output that could run script, independently of the
+// golden files: elements outside the allowlist, event handler or style
+// attributes, and links or images with a scheme that is not allowed.
+func checkSafe(t *testing.T, rendered string) {
+	t.Helper()
+	lower := strings.ToLower(rendered)
+	for name := range rawText {
+		if strings.Contains(lower, "<"+name) {
+			t.Errorf("output contains <%s", name)
+		}
+	}
+	for name := range dropped {
+		if strings.Contains(lower, "<"+name) {
+			t.Errorf("output contains <%s", name)
+		}
+	}
+	tokens := tokenizer{source: rendered}
+	for {
+		item, ok := tokens.next()
+		if !ok {
+			break
+		}
+		if item.kind != startToken {
+			continue
+		}
+		if _, ok := allowed[item.data]; !ok {
+			t.Errorf("output contains <%s>", item.data)
+		}
+		for _, attribute := range item.attributes {
+			switch {
+			case strings.HasPrefix(attribute.name, "on") || attribute.name == "style":
+				t.Errorf("<%s> has attribute %s", item.data, attribute.name)
+			case attribute.name == "href":
+				if _, ok := safeURL(attribute.value, linkSchemes...); !ok {
+					t.Errorf("<%s> links to %q", item.data, attribute.value)
+				}
+			case attribute.name == "src":
+				if _, ok := safeURL(attribute.value, imageSchemes...); !ok {
+					t.Errorf("<%s> loads %q", item.data, attribute.value)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Package oidc is a minimal OpenID Connect relying party: discovery, the
+// authorization code flow with PKCE (RFC 7636) and validation of RS256
+// signed ID tokens against the provider's JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrDisabled = errors.New("openid connect is not configured")
+
+type Config struct {
+	// Issuer is the provider URL; discovery reads Issuer/.well-known/openid-configuration.
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// RedirectURL is the client page that receives code and state from the provider.
+	RedirectURL string `json:"redirect_url"`
+}
+
+// Metadata is the part of the discovery document the flow needs.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to one issuer. Discovery and signing keys are fetched on
+// first use and cached; keys are refetched when a token names an unknown key.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu          sync.Mutex
+	metadata    *Metadata
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+func NewProvider(config Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{config: config, client: client}
+}
+
+func (p *Provider) Enabled() bool {
+	return p.config.Issuer != "" && p.config.ClientID != ""
+}
+
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// AuthCodeURL is where the user agent is sent to sign in. The provider
+// echoes state back; nonce comes back inside the ID token.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", "openid email profile")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code for the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	var token struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	err = p.do(request, &token)
+	if err != nil {
+		return "", err
+	}
+	if token.Error != "" {
+		return "", errors.Errorf("token endpoint: %s", token.Error)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("token endpoint returned no id_token")
+	}
+	return token.IDToken, nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*Metadata, error) {
+	if !p.Enabled() {
+		return nil, ErrDisabled
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	address := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var metadata Metadata
+	err = p.do(request, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Issuer != p.config.Issuer {
+		return nil, errors.Errorf("discovery issuer %q does not match %q", metadata.Issuer, p.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("incomplete discovery document")
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+func (p *Provider) do(request *http.Request, item interface{}) error {
+	response, err := p.client.Do(request)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusBadRequest {
+		return errors.Errorf("%s: unexpected status %s", request.URL, response.Status)
+	}
+	return errors.WithStack(json.Unmarshal(body, item))
+}
+
+// NewVerifier returns a random PKCE code verifier, also usable for state and nonce.
+func NewVerifier() (string, error) {
+	buffer := make([]byte, 32)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// Challenge is the S256 code challenge for verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,224 @@
+package oidc
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/oidc/oidctest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newProvider(issuer *oidctest.Issuer) *Provider {
+	return NewProvider(Config{
+		Issuer:       issuer.URL,
+		ClientID:     oidctest.ClientID,
+		ClientSecret: oidctest.ClientSecret,
+		RedirectURL:  oidctest.RedirectURL,
+	}, nil)
+}
+
+// signIn runs the code flow up to the raw ID token, with the claims changed
+// by edit.
+func signIn(t *testing.T, provider *Provider, issuer *oidctest.Issuer, nonce string, edit func(map[string]interface{})) string {
+	t.Helper()
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := issuer.Authorize(authURL, edit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawToken, err := provider.Exchange(context.Background(), code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rawToken
+}
+
+func TestCodeFlow(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := newProvider(issuer)
+	rawToken := signIn(t, provider, issuer, "nonce", nil)
+	claims, err := provider.Verify(context.Background(), rawToken, "nonce")
+	if err != nil {
+		t.Fatalf("Verify: %+v", err)
+	}
+	if claims.Issuer != issuer.URL || claims.Subject != oidctest.Subject || claims.Email != oidctest.Email || !claims.EmailVerified {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	authURL, err := newProvider(issuer).AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := address.Query()
+	for name, want := range map[string]string{
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        Challenge("verifier"),
+		"code_challenge_method": "S256",
+	} {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// The code is bound to the challenge of the login that asked for it.
+func TestExchangeWrongVerifier(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := newProvider(issuer)
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := issuer.Authorize(authURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Exchange(context.Background(), code, "another verifier")
+	if err == nil {
+		t.Fatal("Exchange accepted a wrong code verifier")
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	forger := oidctest.NewKey(t)
+	tests := []struct {
+		name  string
+		token func(claims map[string]interface{}) string
+	}{
+		{"bad signature", func(claims map[string]interface{}) string {
+			return oidctest.Sign(forger, oidctest.KeyId, "RS256", claims)
+		}},
+		{"unknown kid", func(claims map[string]interface{}) string {
+			return oidctest.Sign(issuer.Key, "key-2", "RS256", claims)
+		}},
+		{"algorithm none", func(claims map[string]interface{}) string {
+			return oidctest.Sign(issuer.Key, oidctest.KeyId, "none", claims)
+		}},
+		{"algorithm HS256", func(claims map[string]interface{}) string {
+			return oidctest.Sign(issuer.Key, oidctest.KeyId, "HS256", claims)
+		}},
+		{"wrong iss", func(claims map[string]interface{}) string {
+			claims["iss"] = "https://evil.example"
+			return issuer.Sign(claims)
+		}},
+		{"wrong aud", func(claims map[string]interface{}) string {
+			claims["aud"] = "another-client"
+			return issuer.Sign(claims)
+		}},
+		{"aud list without azp", func(claims map[string]interface{}) string {
+			claims["aud"] = []string{oidctest.ClientID, "another-client"}
+			return issuer.Sign(claims)
+		}},
+		{"wrong nonce", func(claims map[string]interface{}) string {
+			claims["nonce"] = "another nonce"
+			return issuer.Sign(claims)
+		}},
+		{"no nonce", func(claims map[string]interface{}) string {
+			delete(claims, "nonce")
+			return issuer.Sign(claims)
+		}},
+		{"expired", func(claims map[string]interface{}) string {
+			claims["exp"] = time.Now().Add(-2 * leeway).Unix()
+			return issuer.Sign(claims)
+		}},
+		{"issued in the future", func(claims map[string]interface{}) string {
+			claims["iat"] = time.Now().Add(2 * leeway).Unix()
+			return issuer.Sign(claims)
+		}},
+		{"no subject", func(claims map[string]interface{}) string {
+			delete(claims, "sub")
+			return issuer.Sign(claims)
+		}},
+		{"tampered claims", func(claims map[string]interface{}) string {
+			valid := issuer.Sign(claims)
+			claims["sub"] = "another subject"
+			forged := issuer.Sign(claims)
+			return valid[:strings.Index(valid, ".")] + forged[strings.Index(forged, "."):strings.LastIndex(forged, ".")] + valid[strings.LastIndex(valid, "."):]
+		}},
+		{"malformed", func(claims map[string]interface{}) string {
+			return "not.a-token"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newProvider(issuer)
+			rawToken := tt.token(issuer.Claims("nonce"))
+			_, err := provider.Verify(context.Background(), rawToken, "nonce")
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+// Claims rejected after a real exchange too, where they come from the token
+// endpoint rather than being handed to Verify.
+func TestCodeFlowRejects(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	tests := []struct {
+		name string
+		edit func(claims map[string]interface{})
+	}{
+		{"wrong iss", func(claims map[string]interface{}) { claims["iss"] = issuer.URL + "/other" }},
+		{"wrong aud", func(claims map[string]interface{}) { claims["aud"] = "another-client" }},
+		{"wrong nonce", func(claims map[string]interface{}) { claims["nonce"] = "replayed" }},
+		{"expired", func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newProvider(issuer)
+			rawToken := signIn(t, provider, issuer, "nonce", tt.edit)
+			_, err := provider.Verify(context.Background(), rawToken, "nonce")
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+// A stream of unknown key ids fetches the JWKS once per refresh interval.
+func TestUnknownKeyFetchesOnce(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := newProvider(issuer)
+	for i := 0; i < 3; i++ {
+		rawToken := oidctest.Sign(issuer.Key, "unknown", "RS256", issuer.Claims("nonce"))
+		_, err := provider.Verify(context.Background(), rawToken, "nonce")
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Verify error = %v, want ErrInvalidToken", err)
+		}
+	}
+	if fetches := issuer.KeyFetches(); fetches != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", fetches)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := NewProvider(Config{
+		Issuer:      issuer.URL + "/",
+		ClientID:    oidctest.ClientID,
+		RedirectURL: oidctest.RedirectURL,
+	}, nil)
+	_, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err == nil {
+		t.Fatal("AuthCodeURL accepted a discovery document of another issuer")
+	}
+}
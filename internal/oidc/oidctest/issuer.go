@@ -0,0 +1,227 @@
+// Package oidctest runs an OpenID Connect provider for tests: discovery,
+// JWKS and a token endpoint that checks the client, the redirect URL and
+// the PKCE verifier of every code it redeems.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	ClientID     = "penhub"
+	ClientSecret = "secret"
+	RedirectURL  = "https://penhub.example/oidc/callback"
+	KeyId        = "key-1"
+	Subject      = "subject-1"
+	Email        = "reader@example.com"
+)
+
+// Issuer is a running provider. Its server is closed when the test ends.
+type Issuer struct {
+	URL string
+	// Key signs the ID tokens; its public half is served under KeyId.
+	Key *rsa.PrivateKey
+
+	mu         sync.Mutex
+	codes      map[string]grant
+	keyFetches int
+}
+
+// grant is an issued authorization code waiting to be redeemed.
+type grant struct {
+	challenge string
+	claims    map[string]interface{}
+}
+
+func NewIssuer(t testing.TB) *Issuer {
+	t.Helper()
+	issuer := &Issuer{Key: NewKey(t), codes: make(map[string]grant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("/jwks", issuer.jwks)
+	mux.HandleFunc("/token", issuer.token)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	issuer.URL = server.URL
+	return issuer
+}
+
+// NewKey returns a fresh RSA key, for the issuer or for forged tokens.
+func NewKey(t testing.TB) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Claims are the claims of a valid ID token for the test client.
+func (i *Issuer) Claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            i.URL,
+		"sub":            Subject,
+		"aud":            ClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          Email,
+		"email_verified": true,
+		"name":           "Reader",
+	}
+}
+
+// Authorize plays the user signing in at authURL and returns the code the
+// provider would send back. edit, when not nil, changes the claims of the
+// ID token the code is redeemed for.
+func (i *Issuer) Authorize(authURL string, edit func(claims map[string]interface{})) (string, error) {
+	address, err := url.Parse(authURL)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	query := address.Query()
+	switch {
+	case query.Get("response_type") != "code":
+		return "", errors.Errorf("response_type %q", query.Get("response_type"))
+	case query.Get("client_id") != ClientID:
+		return "", errors.Errorf("client_id %q", query.Get("client_id"))
+	case query.Get("redirect_uri") != RedirectURL:
+		return "", errors.Errorf("redirect_uri %q", query.Get("redirect_uri"))
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		return "", errors.New("no S256 code challenge")
+	case query.Get("state") == "":
+		return "", errors.New("no state")
+	}
+	claims := i.Claims(query.Get("nonce"))
+	if edit != nil {
+		edit(claims)
+	}
+	code := randomString()
+	i.mu.Lock()
+	i.codes[code] = grant{challenge: query.Get("code_challenge"), claims: claims}
+	i.mu.Unlock()
+	return code, nil
+}
+
+// Sign returns claims as an RS256 ID token signed with the issuer's key.
+func (i *Issuer) Sign(claims map[string]interface{}) string {
+	return Sign(i.Key, KeyId, "RS256", claims)
+}
+
+// KeyFetches counts the requests for the JWKS.
+func (i *Issuer) KeyFetches() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.keyFetches
+}
+
+// Sign returns claims as a JWT signed with key under keyId. The algorithm
+// is written to the header as given but the signature is always RS256, so
+// that tokens naming another algorithm can be forged.
+func Sign(key *rsa.PrivateKey, keyId, algorithm string, claims map[string]interface{}) string {
+	head, _ := json.Marshal(map[string]string{"alg": algorithm, "kid": keyId, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := encode(head) + "." + encode(body)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + encode(signature)
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 i.URL,
+		"authorization_endpoint": i.URL + "/authorize",
+		"token_endpoint":         i.URL + "/token",
+		"jwks_uri":               i.URL + "/jwks",
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	i.mu.Lock()
+	i.keyFetches++
+	i.mu.Unlock()
+	public := i.Key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": KeyId,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   encode(public.N.Bytes()),
+			"e":   encode(big.NewInt(int64(public.E)).Bytes()),
+		}},
+	})
+}
+
+// token redeems a code once, for the client it was issued to and with the
+// verifier of its challenge.
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != ClientID || secret != ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	i.mu.Lock()
+	item, ok := i.codes[r.PostForm.Get("code")]
+	delete(i.codes, r.PostForm.Get("code"))
+	i.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code":
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+	case !ok || r.PostForm.Get("redirect_uri") != RedirectURL || encode(sum[:]) != item.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	default:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": randomString(),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     i.Sign(item.claims),
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, item interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(item)
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func randomString() string {
+	buffer := make([]byte, 16)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		panic(err)
+	}
+	return encode(buffer)
+}
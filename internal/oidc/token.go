@@ -0,0 +1,227 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid id token")
+
+// leeway tolerates clock drift between us and the provider.
+const leeway = time.Minute
+
+// keysRefreshInterval stops a stream of forged key ids from hammering the provider.
+const keysRefreshInterval = time.Minute
+
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience accepts both forms of the aud claim: a string or a list of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var single string
+		err := json.Unmarshal(data, &single)
+		if err != nil {
+			return err
+		}
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(data, &list)
+	if err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(clientId string) bool {
+	for _, item := range a {
+		if item == clientId {
+			return true
+		}
+	}
+	return false
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+}
+
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// Verify checks the signature and the standard claims of a raw ID token
+// and that it was issued for the login that carried nonce.
+func (p *Provider) Verify(ctx context.Context, rawToken, nonce string) (*Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var head header
+	err := decodeSegment(parts[0], &head)
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := algorithms[head.Algorithm]
+	if !ok {
+		return nil, errors.Wrapf(ErrInvalidToken, "unsupported algorithm %q", head.Algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+	key, err := p.key(ctx, head.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	digest := hash.New()
+	digest.Write([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, hash, digest.Sum(nil), signature)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, "bad signature")
+	}
+	var claims Claims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+	err = p.checkClaims(&claims, nonce, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (p *Provider) checkClaims(claims *Claims, nonce string, now time.Time) error {
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return errors.Wrap(ErrInvalidToken, "issuer mismatch")
+	case claims.Subject == "":
+		return errors.Wrap(ErrInvalidToken, "missing subject")
+	case !claims.Audience.contains(p.config.ClientID):
+		return errors.Wrap(ErrInvalidToken, "audience mismatch")
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != p.config.ClientID:
+		return errors.Wrap(ErrInvalidToken, "authorized party mismatch")
+	case now.Add(-leeway).After(time.Unix(claims.Expiry, 0)):
+		return errors.Wrap(ErrInvalidToken, "token expired")
+	case now.Add(leeway).Before(time.Unix(claims.IssuedAt, 0)):
+		return errors.Wrap(ErrInvalidToken, "token issued in the future")
+	case claims.Nonce != nonce:
+		return errors.Wrap(ErrInvalidToken, "nonce mismatch")
+	}
+	return nil
+}
+
+func decodeSegment(segment string, item interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.Wrap(ErrInvalidToken, err.Error())
+	}
+	err = json.Unmarshal(data, item)
+	if err != nil {
+		return errors.Wrap(ErrInvalidToken, err.Error())
+	}
+	return nil
+}
+
+func (p *Provider) key(ctx context.Context, keyId string) (*rsa.PublicKey, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.keys[keyId]
+	if ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < keysRefreshInterval {
+		return nil, errors.Wrapf(ErrInvalidToken, "unknown key %q", keyId)
+	}
+	keys, err := p.fetchKeys(ctx, metadata.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+	key, ok = p.keys[keyId]
+	if !ok {
+		return nil, errors.Wrapf(ErrInvalidToken, "unknown key %q", keyId)
+	}
+	return key, nil
+}
+
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyId   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+func (p *Provider) fetchKeys(ctx context.Context, address string) (map[string]*rsa.PublicKey, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = p.do(request, &set)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeys(set.Keys)
+}
+
+// parseKeys turns the RSA signing keys of a JWKS into public keys by key id.
+// Keys of other types or for encryption are skipped.
+func parseKeys(set []jwk) (map[string]*rsa.PublicKey, error) {
+	keys := make(map[string]*rsa.PublicKey)
+	for _, item := range set {
+		if item.KeyType != "RSA" || (item.Use != "" && item.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(item.N)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(item.E)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.Errorf("key %q: bad exponent", item.KeyId)
+		}
+		keys[item.KeyId] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+	}
+	return keys, nil
+}
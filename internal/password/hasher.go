@@ -0,0 +1,210 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// Hasher is one password hashing scheme.
+type Hasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) (bool, error)
+	// Identifies reports whether hash was produced by this scheme.
+	Identifies(hash string) bool
+	// Outdated reports whether hash was made with other parameters than the
+	// ones the hasher uses now.
+	Outdated(hash string) bool
+}
+
+type HashingConfig struct {
+	// Algorithm for new hashes, argon2id unless set to bcrypt.
+	Algorithm  string       `json:"algorithm"`
+	Argon2     Argon2Params `json:"argon2"`
+	BcryptCost int          `json:"bcrypt_cost"`
+}
+
+// Chain hashes new passwords with its first hasher and verifies stored
+// hashes with whichever hasher produced them.
+type Chain []Hasher
+
+func NewChain(config HashingConfig) Chain {
+	argon := &Argon2idHasher{Params: config.Argon2.WithDefaults()}
+	cost := config.BcryptCost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	bcryptHasher := &BcryptHasher{Cost: cost}
+	if config.Algorithm == Bcrypt {
+		return Chain{bcryptHasher, argon}
+	}
+	return Chain{argon, bcryptHasher}
+}
+
+func (c Chain) Hash(password string) (string, error) {
+	return c[0].Hash(password)
+}
+
+// Verify also reports whether a matching hash should be replaced, because it
+// uses another scheme or weaker parameters than the current default.
+func (c Chain) Verify(hash, password string) (ok, rehash bool, err error) {
+	for i, hasher := range c {
+		if !hasher.Identifies(hash) {
+			continue
+		}
+		ok, err = hasher.Verify(hash, password)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, i != 0 || hasher.Outdated(hash), nil
+	}
+	return false, false, ErrUnknownHash
+}
+
+type Argon2Params struct {
+	// Memory in KiB.
+	Memory      uint32 `json:"memory"`
+	Iterations  uint32 `json:"iterations"`
+	Parallelism uint8  `json:"parallelism"`
+	SaltLength  uint32 `json:"salt_length"`
+	KeyLength   uint32 `json:"key_length"`
+}
+
+// DefaultArgon2Params follow the second recommended option of RFC 9106.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func (p Argon2Params) WithDefaults() Argon2Params {
+	if p.Memory == 0 {
+		p.Memory = DefaultArgon2Params.Memory
+	}
+	if p.Iterations == 0 {
+		p.Iterations = DefaultArgon2Params.Iterations
+	}
+	if p.Parallelism == 0 {
+		p.Parallelism = DefaultArgon2Params.Parallelism
+	}
+	if p.SaltLength == 0 {
+		p.SaltLength = DefaultArgon2Params.SaltLength
+	}
+	if p.KeyLength == 0 {
+		p.KeyLength = DefaultArgon2Params.KeyLength
+	}
+	return p
+}
+
+// Argon2idHasher stores hashes in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+type Argon2idHasher struct {
+	Params Argon2Params
+}
+
+var b64 = base64.RawStdEncoding
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Params.Iterations, h.Params.Memory, h.Params.Parallelism, h.Params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		h.Params.Memory, h.Params.Iterations, h.Params.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(hash, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) Identifies(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (h *Argon2idHasher) Outdated(hash string) bool {
+	params, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params != h.Params
+}
+
+func decodeArgon2id(hash string) (params Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return params, nil, nil, ErrUnknownHash
+	}
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	salt, err = b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	key, err = b64.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return true, nil
+}
+
+func (h *BcryptHasher) Identifies(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h *BcryptHasher) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.Cost
+}
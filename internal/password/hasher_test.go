@@ -0,0 +1,96 @@
+package password
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+// testArgon2 keeps the tests fast; only the defaults matter in production.
+var testArgon2 = Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func testChain() Chain {
+	return NewChain(HashingConfig{Argon2: testArgon2, BcryptCost: bcrypt.MinCost})
+}
+
+func TestChainArgon2id(t *testing.T) {
+	chain := testChain()
+	hash, err := chain.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("hash = %q", hash)
+	}
+	ok, rehash, err := chain.Verify(hash, "correct horse")
+	if err != nil || !ok || rehash {
+		t.Errorf("Verify = %t, %t, %v, want true, false, nil", ok, rehash, err)
+	}
+	ok, _, err = chain.Verify(hash, "wrong horse")
+	if err != nil || ok {
+		t.Errorf("Verify of a wrong password = %t, %v", ok, err)
+	}
+	again, err := chain.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == hash {
+		t.Error("two hashes of a password share their salt")
+	}
+}
+
+// Hashes from before argon2id still log in and are then replaced.
+func TestChainLegacyBcrypt(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, rehash, err := testChain().Verify(string(legacy), "correct horse")
+	if err != nil || !ok || !rehash {
+		t.Errorf("Verify = %t, %t, %v, want true, true, nil", ok, rehash, err)
+	}
+	ok, rehash, err = testChain().Verify(string(legacy), "wrong horse")
+	if err != nil || ok || rehash {
+		t.Errorf("Verify of a wrong password = %t, %t, %v", ok, rehash, err)
+	}
+}
+
+func TestChainRehash(t *testing.T) {
+	weak := Chain{&Argon2idHasher{Params: Argon2Params{Memory: 512, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}}}
+	hash, err := weak.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, rehash, err := testChain().Verify(hash, "correct horse")
+	if err != nil || !ok || !rehash {
+		t.Errorf("argon2id with other parameters: Verify = %t, %t, %v, want true, true, nil", ok, rehash, err)
+	}
+
+	bcryptFirst := NewChain(HashingConfig{Algorithm: Bcrypt, Argon2: testArgon2, BcryptCost: bcrypt.MinCost + 1})
+	cheap, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, rehash, err = bcryptFirst.Verify(string(cheap), "correct horse")
+	if err != nil || !ok || !rehash {
+		t.Errorf("bcrypt below the cost: Verify = %t, %t, %v, want true, true, nil", ok, rehash, err)
+	}
+	hash, err = bcryptFirst.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, rehash, err = bcryptFirst.Verify(hash, "correct horse")
+	if err != nil || !ok || rehash {
+		t.Errorf("current bcrypt: Verify = %t, %t, %v, want true, false, nil", ok, rehash, err)
+	}
+}
+
+func TestChainUnknownHash(t *testing.T) {
+	for _, hash := range []string{"", "secret", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$broken"} {
+		_, _, err := testChain().Verify(hash, "secret")
+		if !errors.Is(err, ErrUnknownHash) {
+			t.Errorf("Verify(%q) error = %v, want ErrUnknownHash", hash, err)
+		}
+	}
+}
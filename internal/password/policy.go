@@ -0,0 +1,163 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Reasons a password is rejected, returned to clients as-is.
+const (
+	TooShort     = "too_short"
+	TooLong      = "too_long"
+	TooWeak      = "too_weak"
+	Common       = "common"
+	ContainsName = "contains_name"
+)
+
+//go:embed common.txt
+var commonList string
+
+var common = loadCommon(commonList)
+
+// Policy describes what an acceptable password is. Lengths are counted in
+// Unicode characters, not bytes.
+type Policy struct {
+	MinLength int `json:"min_length"`
+	MaxLength int `json:"max_length"`
+	// MinEntropy is the lowest accepted strength estimate in bits.
+	MinEntropy float64 `json:"min_entropy"`
+}
+
+var DefaultPolicy = Policy{
+	MinLength:  8,
+	MaxLength:  128,
+	MinEntropy: 36,
+}
+
+// WithDefaults fills zero fields from DefaultPolicy.
+func (p Policy) WithDefaults() Policy {
+	if p.MinLength == 0 {
+		p.MinLength = DefaultPolicy.MinLength
+	}
+	if p.MaxLength == 0 {
+		p.MaxLength = DefaultPolicy.MaxLength
+	}
+	if p.MinEntropy == 0 {
+		p.MinEntropy = DefaultPolicy.MinEntropy
+	}
+	return p
+}
+
+// Check returns every reason the password breaks the policy. The names are
+// the account's own identifiers, such as login and display name, which must
+// not be part of the password.
+func (p Policy) Check(password string, names ...string) []string {
+	reasons := make([]string, 0)
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		reasons = append(reasons, TooShort)
+	}
+	if length > p.MaxLength {
+		reasons = append(reasons, TooLong)
+	}
+	lower := strings.ToLower(password)
+	if common[lower] {
+		reasons = append(reasons, Common)
+	}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if utf8.RuneCountInString(name) >= 3 && strings.Contains(lower, name) {
+			reasons = append(reasons, ContainsName)
+			break
+		}
+	}
+	if Entropy(password) < p.MinEntropy {
+		reasons = append(reasons, TooWeak)
+	}
+	return reasons
+}
+
+// Entropy is a rough strength estimate in bits: the size of the character
+// pool the password draws from, raised to its length, where repeated
+// characters and the continuation of runs such as "abc" or "321" add nothing.
+func Entropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r < utf8.RuneSelf && unicode.IsLower(r):
+			lower = true
+		case r < utf8.RuneSelf && unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		// Cyrillic and other alphabets, roughly both cases of one script.
+		pool += 66
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(effectiveLength(password)) * math.Log2(float64(pool))
+}
+
+func effectiveLength(password string) int {
+	length := 0
+	var prev rune
+	var step rune
+	first := true
+	for _, r := range password {
+		if first {
+			length++
+			first = false
+			prev = r
+			continue
+		}
+		diff := r - prev
+		switch {
+		case diff == 0:
+			// repeated character
+		case (diff == 1 || diff == -1) && diff == step:
+			// continuing sequence
+		default:
+			length++
+		}
+		step = diff
+		prev = r
+	}
+	return length
+}
+
+func loadCommon(list string) map[string]bool {
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+	return passwords
+}
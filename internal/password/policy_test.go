@@ -0,0 +1,100 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func contains(reasons []string, reason string) bool {
+	for _, r := range reasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		names    []string
+		// want lists the reasons expected, nil for an accepted password.
+		want []string
+	}{
+		{"minimum length", "Kp7#vX2q", nil, nil},
+		{"below minimum length", "Kp7#vX2", nil, []string{TooShort}},
+		{"length in characters", "пароль12", nil, nil},
+		{"short in characters, long in bytes", "пар0ль", nil, []string{TooShort}},
+		{"formerly banned punctuation", `horse_-@#$%&*():./\,;?"!~`, nil, nil},
+		{"passphrase", "correct horse battery staple", nil, nil},
+		{"common", "iloveyou", nil, []string{Common}},
+		{"common in other case", "PASSWORD1", nil, []string{Common}},
+		{"repeated character", "aaaaaaaaaaaaaaaa", nil, []string{TooWeak}},
+		{"sequence", "abcdefghijklmnop", nil, []string{TooWeak}},
+		{"descending digits", "98765432109876", nil, []string{TooWeak}},
+		{"contains login", "Xy7#writer-2024", []string{"writer"}, []string{ContainsName}},
+		{"short names are ignored", "Xy7#ab-qZ2024", []string{"ab"}, nil},
+		{"maximum length", strings.Repeat("Kp7#vX2q", 16), nil, nil},
+		{"above maximum length", strings.Repeat("Kp7#vX2q", 16) + "z", nil, []string{TooLong}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DefaultPolicy.Check(tt.password, tt.names...)
+			for _, reason := range tt.want {
+				if !contains(got, reason) {
+					t.Errorf("Check(%q) = %v, want %s among them", tt.password, got, reason)
+				}
+			}
+			if tt.want == nil && len(got) != 0 {
+				t.Errorf("Check(%q) = %v, want none", tt.password, got)
+			}
+		})
+	}
+}
+
+// Each character the old validation refused is accepted on its own.
+func TestPolicyAcceptsPunctuation(t *testing.T) {
+	for _, r := range `_-@#$%&*():./\,;?"!~` {
+		password := "Tr0ub4dor" + string(r) + "Zebra"
+		if reasons := DefaultPolicy.Check(password); len(reasons) != 0 {
+			t.Errorf("Check(%q) = %v", password, reasons)
+		}
+	}
+}
+
+func TestPolicyWithDefaults(t *testing.T) {
+	p := Policy{MinLength: 12}.WithDefaults()
+	if p.MinLength != 12 || p.MaxLength != DefaultPolicy.MaxLength || p.MinEntropy != DefaultPolicy.MinEntropy {
+		t.Errorf("WithDefaults = %+v", p)
+	}
+	if reasons := p.Check("Kp7#vX2qLm"); !contains(reasons, TooShort) {
+		t.Errorf("Check with MinLength 12 = %v, want %s", reasons, TooShort)
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		password string
+		want     float64
+	}{
+		{"", 0},
+		{"a", math.Log2(26)},
+		{"aaaa", math.Log2(26)},
+		// A run counts its first two characters, which set its direction.
+		{"abcd", 2 * math.Log2(26)},
+		{"dcba", 2 * math.Log2(26)},
+		{"acegi", 5 * math.Log2(26)},
+		{"aB3$", 4 * math.Log2(95)},
+		{"1234", 2 * math.Log2(10)},
+		{"жук", 3 * math.Log2(66)},
+		{"ж1", 2 * math.Log2(76)},
+	}
+	for _, tt := range tests {
+		got := Entropy(tt.password)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Entropy(%q) = %.2f, want %.2f", tt.password, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,827 @@
+// Package pdf typesets books as PDF files for print. Text is set in
+// TrueType fonts embedded as subsets, so every script the fonts cover
+// prints as it is. Body pages are written as they are set, chapter by
+// chapter; the cover, the title page and the table of contents are written
+// last and put in front, so that a book is never held in memory as a whole.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/markup"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Page sizes.
+const (
+	A5 = "a5"
+	A4 = "a4"
+	// Trade is the 6 by 9 inch trade paperback.
+	Trade = "6x9"
+)
+
+type pageLayout struct {
+	width, height float64
+	// inner is the margin at the binding and outer the one across from it.
+	inner, outer, top, bottom float64
+	// size is the size of body text.
+	size float64
+}
+
+// layouts are in points.
+var layouts = map[string]pageLayout{
+	A5:    {width: 419.53, height: 595.28, inner: 54, outer: 40, top: 50, bottom: 54, size: 10.5},
+	A4:    {width: 595.28, height: 841.89, inner: 72, outer: 60, top: 68, bottom: 72, size: 12},
+	Trade: {width: 432, height: 648, inner: 58, outer: 44, top: 54, bottom: 58, size: 11},
+}
+
+// KnownPageSize tells whether size is one of the page sizes.
+func KnownPageSize(size string) bool {
+	_, ok := layouts[size]
+	return ok
+}
+
+// Fonts are the fonts a book is set in. Bold, Italic and BoldItalic may be
+// nil; those styles are then drawn from the other fonts, slanted or
+// stroked.
+type Fonts struct {
+	Regular, Bold, Italic, BoldItalic *Font
+}
+
+// Book describes the book a writer sets.
+type Book struct {
+	Title    string
+	Author   string
+	Language string
+	PageSize string
+	// Contents is the heading of the table of contents.
+	Contents string
+	// Cover is a JPEG, PNG or GIF image, if any.
+	Cover []byte
+	Fonts Fonts
+}
+
+// Text styles, an index into the faces of a writer.
+const (
+	regular = 0
+	bold    = 1
+	italic  = 2
+)
+
+// face is a font as drawn in a style, slanted or stroked when the style
+// has no font of its own.
+type face struct {
+	resource     *fontResource
+	bold, italic bool
+}
+
+// Alignments of lines.
+const (
+	alignLeft = iota
+	alignCenter
+	alignJustify
+)
+
+type fragment struct {
+	text        string
+	face        face
+	size, width float64
+	strike      bool
+}
+
+// word is text set without a break. space is the width of the space after
+// it, in the style of its end.
+type word struct {
+	fragments    []fragment
+	width, space float64
+	breakAfter   bool
+}
+
+type line struct {
+	words []word
+	// width is the width without the space after the last word.
+	width   float64
+	justify bool
+}
+
+type page struct {
+	id int
+	// number is the page number of body pages, zero in the front matter.
+	number  int
+	left    float64
+	content bytes.Buffer
+	// head is the running head; opening pages of chapters and parts have
+	// none, and part pages no number either.
+	head     string
+	numbered bool
+	// empty tells that nothing is set on the page yet.
+	empty  bool
+	annots []string
+}
+
+type tocEntry struct {
+	title string
+	// part entries hold the chapter entries of level 1 after them.
+	part   bool
+	level  int
+	number int
+	pageId int
+}
+
+// Writer sets a book chapter by chapter.
+type Writer struct {
+	o      *objectWriter
+	book   *Book
+	layout pageLayout
+	faces  [4]face
+	fonts  []*fontResource
+	// pages is the page tree, resources the resources all pages share.
+	pages, resources, cover int
+	body                    []int
+	page                    *page
+	// y is where the next line starts, from the bottom of the page.
+	y float64
+	// indent tells whether the next paragraph has its first line indented.
+	indent      bool
+	part        string
+	partPending bool
+	chapter     string
+	toc         []*tocEntry
+}
+
+// NewWriter starts a book on w. Write errors are returned by Chapter and
+// Close.
+func NewWriter(w io.Writer, book *Book) (*Writer, error) {
+	layout, ok := layouts[book.PageSize]
+	if !ok {
+		return nil, errors.Errorf("unknown page size %q", book.PageSize)
+	}
+	if book.Fonts.Regular == nil {
+		return nil, errors.New("no regular font")
+	}
+	pw := &Writer{o: newObjectWriter(w), book: book, layout: layout}
+	pw.pages, pw.resources = pw.o.reserve(), pw.o.reserve()
+	if len(book.Cover) != 0 {
+		pw.cover = pw.o.reserve()
+	}
+	resources := make(map[*Font]*fontResource)
+	resource := func(font *Font) *fontResource {
+		if resources[font] == nil {
+			resources[font] = &fontResource{font: font, id: pw.o.reserve(), name: "F" + strconv.Itoa(len(pw.fonts)+1), used: make(map[uint16]rune)}
+			pw.fonts = append(pw.fonts, resources[font])
+		}
+		return resources[font]
+	}
+	fonts := book.Fonts
+	pw.faces[regular] = face{resource: resource(fonts.Regular)}
+	pw.faces[bold] = face{resource: pw.faces[regular].resource, bold: true}
+	if fonts.Bold != nil {
+		pw.faces[bold] = face{resource: resource(fonts.Bold)}
+	}
+	pw.faces[italic] = face{resource: pw.faces[regular].resource, italic: true}
+	if fonts.Italic != nil {
+		pw.faces[italic] = face{resource: resource(fonts.Italic)}
+	}
+	switch {
+	case fonts.BoldItalic != nil:
+		pw.faces[bold|italic] = face{resource: resource(fonts.BoldItalic)}
+	case fonts.Bold != nil:
+		pw.faces[bold|italic] = face{resource: pw.faces[bold].resource, italic: true}
+	default:
+		pw.faces[bold|italic] = face{resource: pw.faces[italic].resource, bold: true, italic: pw.faces[italic].italic}
+	}
+	return pw, nil
+}
+
+// Part starts a part: the chapters after it belong to it until the next.
+// The page of a part is set with its first chapter, so a part without
+// chapters leaves nothing behind. An empty title ends the current part.
+func (w *Writer) Part(title string) {
+	w.part, w.partPending = title, title != ""
+}
+
+// Chapter sets a chapter, starting on a new page.
+func (w *Writer) Chapter(title string, blocks []markup.Block) error {
+	if w.partPending {
+		w.partPage()
+	}
+	size := w.layout.size
+	leading := size * 1.35
+	w.newBodyPage(true, true)
+	w.chapter = title
+	level := 0
+	if w.part != "" {
+		level = 1
+	}
+	w.toc = append(w.toc, &tocEntry{title: title, level: level, number: w.page.number, pageId: w.page.id})
+	w.y -= (w.layout.height - w.layout.top - w.layout.bottom) * 0.12
+	w.title(title, size*1.6)
+	w.skip(leading * 2)
+	w.indent = false
+	previous := 0
+	for _, block := range blocks {
+		if block.Indent != previous {
+			w.skip(leading * 0.5)
+			previous = block.Indent
+		}
+		w.block(&block)
+	}
+	return w.o.err
+}
+
+func (w *Writer) partPage() {
+	w.partPending = false
+	w.newBodyPage(true, false)
+	w.chapter = ""
+	w.toc = append(w.toc, &tocEntry{title: w.part, part: true, number: w.page.number, pageId: w.page.id})
+	size := w.layout.size * 1.8
+	lines := w.wrap(w.words([]markup.Span{{Text: w.part}}, size, bold), w.textWidth(), w.textWidth())
+	w.y = (w.layout.height + float64(len(lines))*size*1.25) / 2
+	w.setLines(lines, 0, 0, w.textWidth(), size*1.25, alignCenter, "")
+}
+
+func (w *Writer) textWidth() float64 {
+	return w.layout.width - w.layout.inner - w.layout.outer
+}
+
+// title sets a chapter or contents heading.
+func (w *Writer) title(text string, size float64) {
+	lines := w.wrap(w.words([]markup.Span{{Text: text}}, size, bold), w.textWidth(), w.textWidth())
+	w.setLines(lines, 0, 0, w.textWidth(), size*1.25, alignCenter, "")
+}
+
+func (w *Writer) block(block *markup.Block) {
+	size := w.layout.size
+	leading := size * 1.35
+	step := size * 1.5
+	x := float64(block.Indent) * step
+	width := w.textWidth() - x
+	switch block.Kind {
+	case markup.SceneBreak:
+		w.skip(leading * 0.5)
+		w.setLines(w.wrap(w.words([]markup.Span{{Text: "*  *  *"}}, size, regular), width, width), x, 0, width, leading, alignCenter, "")
+		w.skip(leading * 0.5)
+		w.indent = false
+	case markup.Heading:
+		headingSize := size
+		switch {
+		case block.Level <= 2:
+			headingSize = size * 1.3
+		case block.Level <= 4:
+			headingSize = size * 1.15
+		}
+		lines := w.wrap(w.words(block.Spans, headingSize, bold), width, width)
+		// A heading is kept with the first lines after it.
+		needed := leading + float64(len(lines))*headingSize*1.3 + 2*leading
+		if !w.page.empty && w.y-needed < w.layout.bottom {
+			w.newBodyPage(false, true)
+		}
+		w.skip(leading)
+		w.setLines(lines, x, 0, width, headingSize*1.3, alignCenter, "")
+		w.skip(leading * 0.5)
+		w.indent = false
+	case markup.Preformatted:
+		preSize := size * 0.9
+		w.skip(leading * 0.5)
+		w.setLines(w.wrap(w.preformatted(block.Spans, preSize), width, width), x, 0, width, preSize*1.3, alignLeft, "")
+		w.skip(leading * 0.5)
+		w.indent = false
+	default:
+		first := width
+		if w.indent && block.Indent == 0 && block.Marker == "" {
+			first -= step
+		}
+		lines := w.wrap(w.words(block.Spans, size, regular), first, width)
+		w.setLines(lines, x, width-first, width, leading, alignJustify, block.Marker)
+		w.indent = block.Indent == 0 && block.Marker == ""
+	}
+}
+
+// words splits spans into words set in the base style and the span's own.
+func (w *Writer) words(spans []markup.Span, size float64, base int) []word {
+	words := make([]word, 0)
+	var current word
+	flush := func(space float64) {
+		if len(current.fragments) != 0 {
+			current.space = space
+			words = append(words, current)
+		}
+		current = word{}
+	}
+	for _, span := range spans {
+		if span.Text == "\n" {
+			flush(0)
+			if len(words) != 0 {
+				words[len(words)-1].breakAfter = true
+			}
+			continue
+		}
+		f := w.faces[w.style(span, base)]
+		for i, part := range strings.Split(span.Text, " ") {
+			if i > 0 {
+				flush(f.resource.font.width(" ", size))
+			}
+			part = strings.ReplaceAll(part, "\u00ad", "")
+			if part != "" {
+				current.fragments = append(current.fragments, w.fragment(part, f, size, span.Strike))
+				current.width += current.fragments[len(current.fragments)-1].width
+			}
+		}
+	}
+	flush(0)
+	return words
+}
+
+// preformatted makes a word of each line, keeping its spaces.
+func (w *Writer) preformatted(spans []markup.Span, size float64) []word {
+	words := make([]word, 0)
+	current := word{breakAfter: true}
+	for _, span := range spans {
+		f := w.faces[w.style(span, regular)]
+		text := strings.ReplaceAll(span.Text, "\t", "    ")
+		for i, part := range strings.Split(text, "\n") {
+			if i > 0 {
+				words = append(words, current)
+				current = word{breakAfter: true}
+			}
+			if part != "" {
+				current.fragments = append(current.fragments, w.fragment(part, f, size, span.Strike))
+				current.width += current.fragments[len(current.fragments)-1].width
+			}
+		}
+	}
+	return append(words, current)
+}
+
+func (w *Writer) style(span markup.Span, base int) int {
+	style := base
+	if span.Bold {
+		style |= bold
+	}
+	if span.Italic {
+		style |= italic
+	}
+	return style
+}
+
+func (w *Writer) fragment(text string, f face, size float64, strike bool) fragment {
+	return fragment{text: text, face: f, size: size, width: f.resource.font.width(text, size), strike: strike}
+}
+
+// wrap breaks words into lines, the first one first wide and the others
+// rest wide. A word wider than a line is broken between characters.
+func (w *Writer) wrap(words []word, first, rest float64) []line {
+	lines := make([]line, 0)
+	var current line
+	width := first
+	for i := 0; i < len(words); i++ {
+		item := words[i]
+		if len(current.words) == 0 && item.width > width && len(item.fragments) != 0 {
+			head, tail := splitWord(item, width)
+			lines = append(lines, line{words: []word{head}, width: head.width})
+			width = rest
+			if len(tail.fragments) != 0 {
+				words[i] = tail
+				i--
+			}
+			continue
+		}
+		add := item.width
+		if len(current.words) != 0 {
+			add += current.words[len(current.words)-1].space
+		}
+		if len(current.words) != 0 && current.width+add > width {
+			current.justify = true
+			lines = append(lines, current)
+			current, width = line{}, rest
+			i--
+			continue
+		}
+		current.words = append(current.words, item)
+		current.width += add
+		if item.breakAfter {
+			lines = append(lines, current)
+			current, width = line{}, rest
+		}
+	}
+	if len(current.words) != 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// splitWord splits off the start of a word that fits in width, at least
+// one character.
+func splitWord(item word, width float64) (word, word) {
+	var head word
+	for i, f := range item.fragments {
+		for at, c := range f.text {
+			advance := f.face.resource.font.width(string(c), f.size)
+			if head.width+advance > width && (head.width > 0 || at > 0) {
+				tail := word{space: item.space, breakAfter: item.breakAfter}
+				rest := f
+				rest.text, rest.width = f.text[at:], f.face.resource.font.width(f.text[at:], f.size)
+				tail.fragments = append([]fragment{rest}, item.fragments[i+1:]...)
+				for _, next := range tail.fragments {
+					tail.width += next.width
+				}
+				if at > 0 {
+					part := f
+					part.text, part.width = f.text[:at], f.face.resource.font.width(f.text[:at], f.size)
+					head.fragments = append(head.fragments, part)
+				}
+				return head, tail
+			}
+			head.width += advance
+		}
+		head.fragments = append(head.fragments, f)
+	}
+	head.width = 0
+	for _, f := range head.fragments {
+		head.width += f.width
+	}
+	return head, word{}
+}
+
+// setLines sets lines from the top of the space left, offset from the left
+// margin and with the first line indented, breaking the page as needed. A paragraph leaves neither its first line alone at the foot of
+// a page nor its last one alone at the head. The marker is hung in front of
+// the first line.
+func (w *Writer) setLines(lines []line, offset, indent, width, leading float64, align int, marker string) {
+	for i := 0; i < len(lines); {
+		take := w.room(leading)
+		if take > len(lines)-i {
+			take = len(lines) - i
+		}
+		if left := len(lines) - i - take; left > 0 {
+			switch {
+			case i == 0 && take == 1:
+				take = 0
+			case left == 1 && take > 1 && !(i == 0 && take == 2):
+				take--
+			}
+		}
+		if take == 0 && w.page.empty {
+			take = 1
+		}
+		if take == 0 {
+			w.newBodyPage(false, true)
+			continue
+		}
+		for _, item := range lines[i : i+take] {
+			baseline, x := w.y-leading*0.78, w.page.left+offset
+			if i == 0 && marker != "" {
+				size, f := w.layout.size, w.faces[regular]
+				w.show(x-f.resource.font.width(marker, size)-size*0.5, baseline, f, size, marker)
+			}
+			if i == 0 {
+				w.drawLine(item, x+indent, baseline, width-indent, align, i == len(lines)-1)
+			} else {
+				w.drawLine(item, x, baseline, width, align, i == len(lines)-1)
+			}
+			w.y -= leading
+			w.page.empty = false
+			i++
+		}
+		if i < len(lines) {
+			w.newBodyPage(false, true)
+		}
+	}
+}
+
+// room returns the number of lines that fit on the page.
+func (w *Writer) room(leading float64) int {
+	if w.y < w.layout.bottom {
+		return 0
+	}
+	return int((w.y - w.layout.bottom + 0.01) / leading)
+}
+
+// skip leaves space, unless at the top of a page.
+func (w *Writer) skip(space float64) {
+	if !w.page.empty {
+		w.y -= space
+	}
+}
+
+// drawLine sets a line. Neighbouring fragments in the same face are drawn
+// as one text object, with the space between words set by offsets.
+func (w *Writer) drawLine(item line, x, baseline, width float64, align int, last bool) {
+	extra := 0.0
+	switch {
+	case align == alignCenter:
+		x += (width - item.width) / 2
+	case align == alignJustify && item.justify && !last && len(item.words) > 1:
+		extra = (width - item.width) / float64(len(item.words)-1)
+	}
+	var run strings.Builder
+	var current fragment
+	start, gap := x, 0.0
+	flush := func() {
+		if run.Len() != 0 {
+			w.showArray(start, baseline, current.face, current.size, run.String())
+			run.Reset()
+		}
+	}
+	for _, wd := range item.words {
+		for _, f := range wd.fragments {
+			if run.Len() != 0 && (f.face != current.face || f.size != current.size) {
+				flush()
+			}
+			if run.Len() == 0 {
+				start, current = x, f
+			} else if gap != 0 {
+				font := f.face.resource.font
+				space := 0.0
+				// The space itself is drawn for text to be copied with it.
+				if font.has(' ') {
+					run.WriteString(f.face.resource.encode(" "))
+					space = font.width(" ", f.size)
+				}
+				run.WriteString(" " + number(-(gap-space)*1000/f.size) + " ")
+			}
+			gap = 0
+			run.WriteString(f.face.resource.encode(f.text))
+			if f.strike {
+				w.rule(x, baseline+f.size*0.3, x+f.width, f.size*0.05)
+			}
+			x += f.width
+		}
+		gap = wd.space + extra
+		x += gap
+	}
+	flush()
+}
+
+// show draws text with its baseline at y.
+func (w *Writer) show(x, y float64, f face, size float64, text string) {
+	w.showArray(x, y, f, size, f.resource.encode(text))
+}
+
+// showArray draws the elements of a TJ array: glyph strings and offsets in
+// thousandths of the size.
+func (w *Writer) showArray(x, y float64, f face, size float64, elements string) {
+	skew := "0"
+	if f.italic {
+		skew = "0.2"
+	}
+	content := &w.page.content
+	fmt.Fprintf(content, "BT /%s %s Tf 1 0 %s 1 %s %s Tm ", f.resource.name, number(size), skew, number(x), number(y))
+	if f.bold {
+		fmt.Fprintf(content, "2 Tr %s w [%s] TJ 0 Tr ET\n", number(size*0.03), elements)
+		return
+	}
+	content.WriteString("[" + elements + "] TJ ET\n")
+}
+
+func (w *Writer) rule(x1, y, x2, thickness float64) {
+	fmt.Fprintf(&w.page.content, "%s w %s %s m %s %s l S\n", number(thickness), number(x1), number(y), number(x2), number(y))
+}
+
+// newBodyPage finishes the page and starts the next body page. Odd pages
+// are on the right, with the binding on their left.
+func (w *Writer) newBodyPage(opening, numbered bool) {
+	w.finishPage()
+	number := len(w.body) + 1
+	w.page = &page{id: w.o.reserve(), number: number, left: w.layout.outer, numbered: numbered, empty: true}
+	if number%2 == 1 {
+		w.page.left = w.layout.inner
+	}
+	if !opening {
+		w.page.head = w.chapter
+		if number%2 == 0 {
+			w.page.head = w.book.Title
+		}
+	}
+	w.body = append(w.body, w.page.id)
+	w.y = w.layout.height - w.layout.top
+}
+
+// finishPage adds the running head and the page number to a body page and
+// writes it.
+func (w *Writer) finishPage() {
+	p := w.page
+	if p == nil {
+		return
+	}
+	if p.number != 0 {
+		size := w.layout.size * 0.85
+		if p.numbered {
+			text := strconv.Itoa(p.number)
+			f := w.faces[regular]
+			w.show(p.left+(w.textWidth()-f.resource.font.width(text, size))/2, w.layout.bottom*0.5, f, size, text)
+		}
+		if p.head != "" {
+			f := w.faces[italic]
+			text := fit(p.head, f, size, w.textWidth())
+			w.show(p.left+(w.textWidth()-f.resource.font.width(text, size))/2, w.layout.height-w.layout.top*0.6, f, size, text)
+		}
+	}
+	w.page = nil
+	contents := w.o.reserve()
+	w.o.stream(contents, "", p.content.Bytes())
+	annots := ""
+	if len(p.annots) != 0 {
+		annots = " /Annots [" + strings.Join(p.annots, " ") + "]"
+	}
+	w.o.object(p.id, fmt.Sprintf("<< /Type /Page /Parent %s /MediaBox [0 0 %s %s] /Resources %s /Contents %s%s >>",
+		ref(w.pages), number(w.layout.width), number(w.layout.height), ref(w.resources), ref(contents), annots))
+}
+
+// fit shortens text with an ellipsis to fit in width.
+func fit(text string, f face, size, width float64) string {
+	if f.resource.font.width(text, size) <= width {
+		return text
+	}
+	for text != "" {
+		_, n := utf8.DecodeLastRuneInString(text)
+		text = text[:len(text)-n]
+		if f.resource.font.width(text+"…", size) <= width {
+			return strings.TrimSpace(text) + "…"
+		}
+	}
+	return ""
+}
+
+// Close sets the front matter and finishes the file.
+func (w *Writer) Close() error {
+	w.finishPage()
+	front := make([]int, 0)
+	newFrontPage := func() {
+		w.finishPage()
+		w.page = &page{id: w.o.reserve(), left: w.layout.outer, empty: true}
+		if len(front)%2 == 0 {
+			w.page.left = w.layout.inner
+		}
+		front = append(front, w.page.id)
+		w.y = w.layout.height - w.layout.top
+	}
+	xobjects := ""
+	if w.cover != 0 {
+		width, height, err := writeImage(w.o, w.cover, w.book.Cover)
+		if err != nil {
+			return err
+		}
+		newFrontPage()
+		scale := w.layout.width / float64(width)
+		if s := w.layout.height / float64(height); s < scale {
+			scale = s
+		}
+		drawn, drawnHeight := float64(width)*scale, float64(height)*scale
+		fmt.Fprintf(&w.page.content, "q %s 0 0 %s %s %s cm /Cover Do Q\n", number(drawn), number(drawnHeight),
+			number((w.layout.width-drawn)/2), number((w.layout.height-drawnHeight)/2))
+		xobjects = " /XObject << /Cover " + ref(w.cover) + " >>"
+	}
+
+	newFrontPage()
+	size := w.layout.size
+	if w.book.Author != "" {
+		w.y = w.layout.height * 0.75
+		lines := w.wrap(w.words([]markup.Span{{Text: w.book.Author}}, size*1.2, regular), w.textWidth(), w.textWidth())
+		w.setLines(lines, 0, 0, w.textWidth(), size*1.6, alignCenter, "")
+	}
+	w.y = w.layout.height * 0.62
+	w.title(w.book.Title, size*2.2)
+
+	newFrontPage()
+	w.title(w.book.Contents, size*1.6)
+	w.skip(size * 2)
+	w.contents(newFrontPage)
+	if len(front)%2 == 1 {
+		// The first body page is on the right.
+		newFrontPage()
+	}
+	w.finishPage()
+
+	kids := make([]string, 0, len(front)+len(w.body))
+	for _, id := range append(front, w.body...) {
+		kids = append(kids, ref(id))
+	}
+	w.o.object(w.pages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	fonts := make([]string, 0, len(w.fonts))
+	for _, font := range w.fonts {
+		fonts = append(fonts, "/"+font.name+" "+ref(font.id))
+	}
+	w.o.object(w.resources, fmt.Sprintf("<< /Font << %s >>%s >>", strings.Join(fonts, " "), xobjects))
+	for _, font := range w.fonts {
+		err := font.write(w.o)
+		if err != nil {
+			return err
+		}
+	}
+	outlines := w.outlines()
+	info, catalog := w.o.reserve(), w.o.reserve()
+	w.o.object(info, fmt.Sprintf("<< /Title %s /Author %s /Creator (penhub) >>", textString(w.book.Title), textString(w.book.Author)))
+	language := ""
+	if w.book.Language != "" {
+		language = " /Lang " + textString(w.book.Language)
+	}
+	w.o.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %s /Outlines %s /PageLabels << /Nums [0 << /S /r >> %d << /S /D >>] >>%s >>",
+		ref(w.pages), ref(outlines), len(front), language))
+	return w.o.close(catalog, info)
+}
+
+// contents sets the table of contents: the titles with leaders to their
+// page numbers, each linked to its page.
+func (w *Writer) contents(newPage func()) {
+	size := w.layout.size
+	leading := size * 1.35
+	numberWidth := 0.0
+	for _, entry := range w.toc {
+		if width := w.faces[bold].resource.font.width(strconv.Itoa(entry.number), size); width > numberWidth {
+			numberWidth = width
+		}
+	}
+	dot := w.faces[regular].resource.font.width(" .", size)
+	for _, entry := range w.toc {
+		style, indent := regular, float64(entry.level)*size*1.5
+		if entry.part {
+			style = bold
+			w.skip(leading * 0.5)
+		}
+		width := w.textWidth() - indent - numberWidth - size
+		lines := w.wrap(w.words([]markup.Span{{Text: entry.title}}, size, style), width, width)
+		if len(lines) == 0 {
+			continue
+		}
+		if w.room(leading) < len(lines) {
+			newPage()
+		}
+		x, right := w.page.left+indent, w.page.left+w.textWidth()
+		top := w.y
+		for i, item := range lines {
+			baseline := w.y - leading*0.78
+			w.drawLine(item, x, baseline, width, alignLeft, true)
+			if i == len(lines)-1 {
+				f := w.faces[style]
+				text := strconv.Itoa(entry.number)
+				textWidth := f.resource.font.width(text, size)
+				w.show(right-textWidth, baseline, f, size, text)
+				dots := int((right - numberWidth - size*0.5 - x - item.width) / dot)
+				if dots > 0 {
+					w.show(right-numberWidth-size*0.5-float64(dots)*dot, baseline, w.faces[regular], size, strings.Repeat(" .", dots))
+				}
+			}
+			w.y -= leading
+			w.page.empty = false
+		}
+		w.page.annots = append(w.page.annots, fmt.Sprintf("<< /Type /Annot /Subtype /Link /Rect [%s %s %s %s] /Border [0 0 0] /Dest [%s /Fit] >>",
+			number(x), number(w.y), number(right), number(top), ref(entry.pageId)))
+	}
+}
+
+// outlines writes the bookmarks of the table of contents and returns the
+// outline dictionary.
+func (w *Writer) outlines() int {
+	type item struct {
+		id       int
+		entry    *tocEntry
+		children []*item
+	}
+	top := make([]*item, 0)
+	var part *item
+	for _, entry := range w.toc {
+		current := &item{id: w.o.reserve(), entry: entry}
+		switch {
+		case entry.level == 1 && part != nil:
+			part.children = append(part.children, current)
+		default:
+			top = append(top, current)
+			part = nil
+			if entry.part {
+				part = current
+			}
+		}
+	}
+	root := w.o.reserve()
+	var write func(items []*item, parent int)
+	write = func(items []*item, parent int) {
+		for i, current := range items {
+			var entries strings.Builder
+			fmt.Fprintf(&entries, "<< /Title %s /Parent %s /Dest [%s /Fit]", textString(current.entry.title), ref(parent), ref(current.entry.pageId))
+			if i > 0 {
+				entries.WriteString(" /Prev " + ref(items[i-1].id))
+			}
+			if i < len(items)-1 {
+				entries.WriteString(" /Next " + ref(items[i+1].id))
+			}
+			if len(current.children) != 0 {
+				fmt.Fprintf(&entries, " /First %s /Last %s /Count %d", ref(current.children[0].id),
+					ref(current.children[len(current.children)-1].id), len(current.children))
+			}
+			w.o.object(current.id, entries.String()+" >>")
+			write(current.children, current.id)
+		}
+	}
+	write(top, root)
+	if len(top) == 0 {
+		w.o.object(root, "<< /Type /Outlines /Count 0 >>")
+		return root
+	}
+	w.o.object(root, fmt.Sprintf("<< /Type /Outlines /First %s /Last %s /Count %d >>", ref(top[0].id), ref(top[len(top)-1].id), len(w.toc)))
+	return root
+}
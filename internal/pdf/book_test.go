@@ -0,0 +1,297 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"github.com/rustamfozilov/penhub/internal/markup"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// testRanges are the runes of the test font, each range mapped to the
+// glyphs from first on.
+var testRanges = []struct {
+	start, end rune
+	first      uint16
+}{
+	{' ', '~', 1},
+	{'Ё', 'Ё', 96},
+	{'А', 'я', 97},
+	{'ё', 'ё', 161},
+	{'…', '…', 162},
+}
+
+const testGlyphs = 163
+
+// testFont builds a TrueType font with Latin and Cyrillic letters. Every
+// glyph but the space has an outline of its own, so the subset can be told
+// from the font.
+func testFont(t *testing.T) *Font {
+	t.Helper()
+	u16 := func(values ...int) []byte {
+		data := make([]byte, 2*len(values))
+		for i, value := range values {
+			binary.BigEndian.PutUint16(data[2*i:], uint16(value))
+		}
+		return data
+	}
+	u32 := func(values ...int) []byte {
+		data := make([]byte, 4*len(values))
+		for i, value := range values {
+			binary.BigEndian.PutUint32(data[4*i:], uint32(value))
+		}
+		return data
+	}
+	head := make([]byte, 54)
+	copy(head, u32(0x00010000))
+	copy(head[12:], u32(0x5f0f3cf5))
+	copy(head[18:], u16(1000))
+	copy(head[36:], u16(0, -200, 600, 800))
+	copy(head[50:], u16(1))
+	hhea := make([]byte, 36)
+	copy(hhea, u32(0x00010000))
+	copy(hhea[4:], u16(800, -200))
+	copy(hhea[34:], u16(testGlyphs))
+
+	var glyf, loca, hmtx bytes.Buffer
+	for glyph := 0; glyph < testGlyphs; glyph++ {
+		loca.Write(u32(glyf.Len()))
+		advance := 500
+		if glyph == 1 {
+			advance = 250
+		} else {
+			glyf.Write(append(u16(0, glyph, 0, 500, 700), u16(glyph)...))
+		}
+		hmtx.Write(u16(advance, 0))
+	}
+	loca.Write(u32(glyf.Len()))
+
+	var groups bytes.Buffer
+	for _, r := range testRanges {
+		groups.Write(u32(int(r.start), int(r.end), int(r.first)))
+	}
+	cmap := append(u16(0, 1, 3, 10), u32(12)...)
+	cmap = append(cmap, u16(12, 0)...)
+	cmap = append(cmap, u32(16+groups.Len(), 0, len(testRanges))...)
+	cmap = append(cmap, groups.Bytes()...)
+	name := append(u16(0, 1, 18, 1, 0, 0, 6, len("PenhubTest"), 0), "PenhubTest"...)
+
+	data, _ := writeFont(map[string][]byte{
+		"cmap": cmap, "glyf": glyf.Bytes(), "head": head, "hhea": hhea, "hmtx": hmtx.Bytes(),
+		"loca": loca.Bytes(), "maxp": append(u32(0x00005000), u16(testGlyphs)...), "name": name,
+	})
+	font, err := parseFont(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return font
+}
+
+// object is an object of a PDF file, with its stream decoded.
+type object struct {
+	dict   string
+	stream []byte
+}
+
+// readObjects checks the cross-reference table of a PDF file and returns
+// its objects by number.
+func readObjects(t *testing.T, data []byte) map[int]object {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatalf("no PDF header or end: %q...%q", data[:16], data[len(data)-16:])
+	}
+	match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if match == nil {
+		t.Fatal("no startxref")
+	}
+	start, _ := strconv.Atoi(string(match[1]))
+	var size int
+	_, err := fmt.Sscanf(string(data[start:]), "xref\n0 %d\n", &size)
+	if err != nil {
+		t.Fatalf("no xref table at %d: %v", start, err)
+	}
+	table := data[start+len(fmt.Sprintf("xref\n0 %d\n", size)):]
+	if len(table) < 20*size || string(table[:20]) != "0000000000 65535 f \n" {
+		t.Fatalf("xref table is cut short or starts with %q", table[:20])
+	}
+	if !bytes.Contains(table[20*size:], []byte(fmt.Sprintf("/Size %d ", size))) {
+		t.Errorf("trailer does not give the size %d", size)
+	}
+
+	objects := make(map[int]object, size)
+	for id := 1; id < size; id++ {
+		entry := string(table[20*id : 20*id+20])
+		var offset int
+		_, err := fmt.Sscanf(entry, "%010d 00000 n \n", &offset)
+		if err != nil || len(entry) != 20 {
+			t.Fatalf("xref entry %d is %q", id, entry)
+		}
+		header := fmt.Sprintf("%d 0 obj\n", id)
+		if offset >= start || !bytes.HasPrefix(data[offset:], []byte(header)) {
+			t.Fatalf("xref entry %d points at %q", id, data[offset:offset+16])
+		}
+		body := data[offset+len(header):]
+		body = body[:bytes.Index(body, []byte("\nendobj\n"))]
+		at := bytes.Index(body, []byte(">>\nstream\n"))
+		if at < 0 {
+			objects[id] = object{dict: string(body)}
+			continue
+		}
+		item := object{dict: string(body[:at+2]), stream: bytes.TrimSuffix(body[at+len(">>\nstream\n"):], []byte("\nendstream"))}
+		length := regexp.MustCompile(`/Length (\d+)`).FindStringSubmatch(item.dict)
+		if length == nil || length[1] != strconv.Itoa(len(item.stream)) {
+			t.Errorf("object %d: %s for a stream of %d bytes", id, item.dict, len(item.stream))
+		}
+		if strings.Contains(item.dict, "/FlateDecode") {
+			z, err := zlib.NewReader(bytes.NewReader(item.stream))
+			if err != nil {
+				t.Fatalf("object %d: %v", id, err)
+			}
+			item.stream, err = io.ReadAll(z)
+			if err != nil {
+				t.Fatalf("object %d: %v", id, err)
+			}
+		}
+		objects[id] = item
+	}
+	return objects
+}
+
+func reference(t *testing.T, dict, key string) int {
+	t.Helper()
+	match := regexp.MustCompile("/" + key + ` \[?(\d+) 0 R`).FindStringSubmatch(dict)
+	if match == nil {
+		t.Fatalf("no %s in %s", key, dict)
+	}
+	id, _ := strconv.Atoi(match[1])
+	return id
+}
+
+// fontTables returns the tables of a font file.
+func fontTables(data []byte) map[string][]byte {
+	tables := make(map[string][]byte)
+	for i := 0; i < int(u16(data, 4)); i++ {
+		record := 12 + 16*i
+		offset, length := int(u32(data, record+8)), int(u32(data, record+12))
+		tables[string(data[record:record+4])] = data[offset : offset+length]
+	}
+	return tables
+}
+
+func TestWriter(t *testing.T) {
+	font := testFont(t)
+	var out bytes.Buffer
+	w, err := NewWriter(&out, &Book{
+		Title: "Сказки", Author: "А. С. Пушкин", Language: "ru", PageSize: A5, Contents: "Содержание",
+		Fonts: Fonts{Regular: font},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Chapter("Глава первая", markup.Blocks("markdown", "Жили-были старик со старухой.\n\nУ самого синего моря."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := readObjects(t, out.Bytes())
+
+	var fontObject object
+	var pages []object
+	for _, item := range objects {
+		switch {
+		case strings.Contains(item.dict, "/Subtype /Type0"):
+			fontObject = item
+		case strings.Contains(item.dict, "/Type /Page "):
+			pages = append(pages, objects[reference(t, item.dict, "Contents")])
+		}
+	}
+	if fontObject.dict == "" {
+		t.Fatal("no font")
+	}
+	// The glyphs drawn map back to the text they were drawn for.
+	runes := make(map[string]rune)
+	toUnicode := objects[reference(t, fontObject.dict, "ToUnicode")].stream
+	for _, match := range regexp.MustCompile(`<([0-9A-F]{4})> <([0-9A-F]+)>`).FindAllStringSubmatch(string(toUnicode), -1) {
+		units := make([]uint16, len(match[2])/4)
+		for i := range units {
+			unit, _ := strconv.ParseUint(match[2][4*i:4*i+4], 16, 16)
+			units[i] = uint16(unit)
+		}
+		runes[match[1]] = utf16.Decode(units)[0]
+	}
+	var text strings.Builder
+	glyphs := make(map[uint16]bool)
+	for _, page := range pages {
+		for _, match := range regexp.MustCompile(`<([0-9A-F]*)>`).FindAllStringSubmatch(string(page.stream), -1) {
+			for i := 0; i < len(match[1]); i += 4 {
+				c, ok := runes[match[1][i:i+4]]
+				if !ok {
+					t.Fatalf("glyph %s is not in the ToUnicode map", match[1][i:i+4])
+				}
+				text.WriteRune(c)
+				glyphs[font.glyph(c)] = true
+			}
+		}
+		text.WriteString("\n")
+	}
+	for _, want := range []string{"Глава первая", "Жили-были старик со старухой.", "У самого синего моря.", "Содержание", "Сказки", "А. С. Пушкин"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("%q not found in the text drawn:\n%s", want, text.String())
+		}
+	}
+
+	// The subset keeps the outlines of the glyphs drawn and only those.
+	descriptor := objects[reference(t, objects[reference(t, fontObject.dict, "DescendantFonts")].dict, "FontDescriptor")]
+	file := objects[reference(t, descriptor.dict, "FontFile2")]
+	if !strings.Contains(file.dict, fmt.Sprintf("/Length1 %d ", len(file.stream))) {
+		t.Errorf("font file of %d bytes: %s", len(file.stream), file.dict)
+	}
+	tables := fontTables(file.stream)
+	loca, glyf := tables["loca"], tables["glyf"]
+	if len(loca) != 4*(testGlyphs+1) {
+		t.Fatalf("loca of %d bytes", len(loca))
+	}
+	for glyph := uint16(2); glyph < testGlyphs; glyph++ {
+		outline := glyf[u32(loca, 4*int(glyph)):u32(loca, 4*int(glyph)+4)]
+		switch {
+		case glyphs[glyph] && (len(outline) != 12 || u16(outline, 10) != glyph):
+			t.Errorf("glyph %d drawn with the outline %x", glyph, outline)
+		case !glyphs[glyph] && len(outline) != 0:
+			t.Errorf("glyph %d embedded, but not drawn", glyph)
+		}
+	}
+	if !glyphs[font.glyph('Ж')] || glyphs[font.glyph('Щ')] {
+		t.Error("test text does not tell drawn glyphs from others")
+	}
+
+	for _, item := range objects {
+		if strings.Contains(item.dict, "/Creator (penhub)") && !strings.Contains(item.dict, "/Title "+textString("Сказки")) {
+			t.Errorf("info %s", item.dict)
+		}
+	}
+}
+
+func TestTextString(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"Tales (new)", `(Tales \(new\))`},
+		{`a\b`, `(a\\b)`},
+		{"Сказ", "<FEFF0421043A04300437>"},
+		{"𝄞", "<FEFFD834DD1E>"},
+	}
+	for _, tt := range tests {
+		if got := textString(tt.text); got != tt.want {
+			t.Errorf("textString(%q) = %s, want %s", tt.text, got, tt.want)
+		}
+	}
+}
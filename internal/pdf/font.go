@@ -0,0 +1,548 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+var ErrFont = errors.New("unsupported font")
+
+// Font is a TrueType font. Glyphs are looked up by rune and measured in
+// font units; the glyphs a document uses are embedded as a subset.
+type Font struct {
+	name       string
+	tables     map[string][]byte
+	unitsPerEm float64
+	glyphs     map[rune]uint16
+	advances   []uint16
+	numGlyphs  int
+	// Metrics for the font descriptor, in font units.
+	ascent, descent, capHeight int16
+	bbox                       [4]int16
+	italicAngle                float64
+	fixedPitch                 bool
+}
+
+// LoadFont reads a TrueType font file. Fonts with PostScript outlines, font
+// collections and fonts whose licence forbids embedding are refused.
+func LoadFont(path string) (*Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	font, err := parseFont(data)
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+	return font, nil
+}
+
+func parseFont(data []byte) (*Font, error) {
+	if len(data) < 12 {
+		return nil, errors.Wrap(ErrFont, "file is too short")
+	}
+	switch string(data[:4]) {
+	case "\x00\x01\x00\x00", "true":
+	case "OTTO":
+		return nil, errors.Wrap(ErrFont, "PostScript outlines")
+	case "ttcf":
+		return nil, errors.Wrap(ErrFont, "font collection")
+	default:
+		return nil, errors.Wrap(ErrFont, "not a TrueType font")
+	}
+	f := &Font{tables: make(map[string][]byte)}
+	count := int(u16(data, 4))
+	for i := 0; i < count; i++ {
+		record := 12 + 16*i
+		if record+16 > len(data) {
+			return nil, errors.Wrap(ErrFont, "table directory is cut short")
+		}
+		offset, length := int(u32(data, record+8)), int(u32(data, record+12))
+		if offset < 0 || length < 0 || offset+length > len(data) {
+			return nil, errors.Wrap(ErrFont, "table outside the file")
+		}
+		f.tables[string(data[record:record+4])] = data[offset : offset+length]
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "maxp", "cmap", "loca", "glyf"} {
+		if f.tables[tag] == nil {
+			return nil, errors.Wrapf(ErrFont, "no %s table", tag)
+		}
+	}
+	head, hhea, maxp := f.tables["head"], f.tables["hhea"], f.tables["maxp"]
+	if len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 {
+		return nil, errors.Wrap(ErrFont, "table is cut short")
+	}
+	f.unitsPerEm = float64(u16(head, 18))
+	if f.unitsPerEm == 0 {
+		return nil, errors.Wrap(ErrFont, "no units per em")
+	}
+	for i := range f.bbox {
+		f.bbox[i] = int16(u16(head, 36+2*i))
+	}
+	f.ascent, f.descent = int16(u16(hhea, 4)), int16(u16(hhea, 6))
+	f.capHeight = f.ascent
+	f.numGlyphs = int(u16(maxp, 4))
+	if os2 := f.tables["OS/2"]; len(os2) >= 10 {
+		if u16(os2, 8)&0x000f == 0x0002 {
+			return nil, errors.Wrap(ErrFont, "licence forbids embedding")
+		}
+		if u16(os2, 0) >= 2 && len(os2) >= 90 {
+			f.capHeight = int16(u16(os2, 88))
+		}
+	}
+	if post := f.tables["post"]; len(post) >= 16 {
+		f.italicAngle = float64(int32(u32(post, 4))) / 65536
+		f.fixedPitch = u32(post, 12) != 0
+	}
+	err := f.readMetrics()
+	if err != nil {
+		return nil, err
+	}
+	f.glyphs, err = readCmap(f.tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	f.name = postScriptName(f.tables["name"])
+	return f, nil
+}
+
+func (f *Font) readMetrics() error {
+	hmtx := f.tables["hmtx"]
+	metrics := int(u16(f.tables["hhea"], 34))
+	if metrics == 0 || len(hmtx) < 4*metrics {
+		return errors.Wrap(ErrFont, "hmtx table is cut short")
+	}
+	f.advances = make([]uint16, f.numGlyphs)
+	for i := range f.advances {
+		if i < metrics {
+			f.advances[i] = u16(hmtx, 4*i)
+		} else {
+			f.advances[i] = f.advances[metrics-1]
+		}
+	}
+	return nil
+}
+
+// readCmap maps runes to glyphs with the Unicode subtable of the font:
+// format 12 for fonts beyond the Basic Multilingual Plane, else format 4.
+func readCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, errors.Wrap(ErrFont, "cmap table is cut short")
+	}
+	var format4, format12 []byte
+	for i := 0; i < int(u16(cmap, 2)); i++ {
+		record := 4 + 8*i
+		if record+8 > len(cmap) {
+			break
+		}
+		platform, encoding, offset := u16(cmap, record), u16(cmap, record+2), int(u32(cmap, record+4))
+		if offset+4 > len(cmap) {
+			continue
+		}
+		unicode := platform == 0 || platform == 3 && (encoding == 1 || encoding == 10)
+		switch {
+		case unicode && u16(cmap, offset) == 4:
+			format4 = cmap[offset:]
+		case unicode && u16(cmap, offset) == 12:
+			format12 = cmap[offset:]
+		}
+	}
+	glyphs := make(map[rune]uint16)
+	switch {
+	case len(format12) >= 16:
+		groups := int(u32(format12, 12))
+		for i := 0; i < groups && 16+12*i+12 <= len(format12); i++ {
+			group := 16 + 12*i
+			start, end, glyph := u32(format12, group), u32(format12, group+4), u32(format12, group+8)
+			for c := start; c <= end && c <= 0x10ffff; c++ {
+				glyphs[rune(c)] = uint16(glyph + c - start)
+			}
+		}
+	case len(format4) >= 14:
+		segments := int(u16(format4, 6)) / 2
+		ends, starts := 14, 16+2*segments
+		deltas, ranges := starts+2*segments, starts+4*segments
+		if ranges+2*segments > len(format4) {
+			return nil, errors.Wrap(ErrFont, "cmap subtable is cut short")
+		}
+		for i := 0; i < segments; i++ {
+			start, end := u16(format4, starts+2*i), u16(format4, ends+2*i)
+			delta, rangeOffset := u16(format4, deltas+2*i), int(u16(format4, ranges+2*i))
+			for c := int(start); c <= int(end) && c != 0xffff; c++ {
+				glyph := uint16(c) + delta
+				if rangeOffset != 0 {
+					at := ranges + 2*i + rangeOffset + 2*(c-int(start))
+					if at+2 > len(format4) {
+						continue
+					}
+					glyph = u16(format4, at)
+					if glyph != 0 {
+						glyph += delta
+					}
+				}
+				if glyph != 0 {
+					glyphs[rune(c)] = glyph
+				}
+			}
+		}
+	default:
+		return nil, errors.Wrap(ErrFont, "no Unicode cmap")
+	}
+	return glyphs, nil
+}
+
+// postScriptName returns the PostScript name of the font, which PDF uses as
+// its base font name.
+func postScriptName(table []byte) string {
+	name := ""
+	if len(table) >= 6 {
+		count, storage := int(u16(table, 2)), int(u16(table, 4))
+		for i := 0; i < count && 6+12*i+12 <= len(table); i++ {
+			record := 6 + 12*i
+			platform, id := u16(table, record), u16(table, record+6)
+			length, offset := int(u16(table, record+8)), storage+int(u16(table, record+10))
+			if id != 6 || offset+length > len(table) {
+				continue
+			}
+			value := table[offset : offset+length]
+			if platform == 3 || platform == 0 {
+				units := make([]uint16, len(value)/2)
+				for j := range units {
+					units[j] = u16(value, 2*j)
+				}
+				name = string(utf16.Decode(units))
+			} else {
+				name = string(value)
+			}
+			break
+		}
+	}
+	name = cleanName(name)
+	if name == "" {
+		return "Font"
+	}
+	return name
+}
+
+func cleanName(name string) string {
+	var out strings.Builder
+	for _, c := range name {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' {
+			out.WriteRune(c)
+		}
+	}
+	return out.String()
+}
+
+func (f *Font) glyph(c rune) uint16 {
+	return f.glyphs[c]
+}
+
+func (f *Font) has(c rune) bool {
+	_, ok := f.glyphs[c]
+	return ok
+}
+
+// width returns the advance of text at size points.
+func (f *Font) width(text string, size float64) float64 {
+	units := 0
+	for _, c := range text {
+		glyph := int(f.glyph(c))
+		if glyph < len(f.advances) {
+			units += int(f.advances[glyph])
+		}
+	}
+	return float64(units) * size / f.unitsPerEm
+}
+
+// subset returns a font file with only the given glyphs and the ones they
+// are composed of. Glyph ids are kept, the outlines of the other glyphs
+// are left empty.
+func (f *Font) subset(used map[uint16]bool) ([]byte, error) {
+	loca, glyf := f.tables["loca"], f.tables["glyf"]
+	long := u16(f.tables["head"], 50) == 1
+	offsets := make([]int, f.numGlyphs+1)
+	for i := range offsets {
+		switch {
+		case long && 4*i+4 <= len(loca):
+			offsets[i] = int(u32(loca, 4*i))
+		case !long && 2*i+2 <= len(loca):
+			offsets[i] = 2 * int(u16(loca, 2*i))
+		default:
+			return nil, errors.Wrap(ErrFont, "loca table is cut short")
+		}
+		if offsets[i] > len(glyf) {
+			return nil, errors.Wrap(ErrFont, "glyph outside the glyf table")
+		}
+	}
+	outline := func(glyph uint16) []byte {
+		if int(glyph) >= f.numGlyphs || offsets[glyph] > offsets[glyph+1] {
+			return nil
+		}
+		return glyf[offsets[glyph]:offsets[glyph+1]]
+	}
+	keep := make(map[uint16]bool, len(used)+1)
+	queue := []uint16{0}
+	for glyph := range used {
+		queue = append(queue, glyph)
+	}
+	for len(queue) != 0 {
+		glyph := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if keep[glyph] || int(glyph) >= f.numGlyphs {
+			continue
+		}
+		keep[glyph] = true
+		queue = append(queue, components(outline(glyph))...)
+	}
+
+	var newGlyf bytes.Buffer
+	newLoca := make([]byte, 4*(f.numGlyphs+1))
+	for i := 0; i < f.numGlyphs; i++ {
+		binary.BigEndian.PutUint32(newLoca[4*i:], uint32(newGlyf.Len()))
+		if keep[uint16(i)] {
+			newGlyf.Write(outline(uint16(i)))
+			for newGlyf.Len()%4 != 0 {
+				newGlyf.WriteByte(0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(newLoca[4*f.numGlyphs:], uint32(newGlyf.Len()))
+	head := append([]byte(nil), f.tables["head"]...)
+	binary.BigEndian.PutUint32(head[8:], 0)
+	binary.BigEndian.PutUint16(head[50:], 1)
+
+	tables := map[string][]byte{
+		"glyf": newGlyf.Bytes(), "head": head, "hhea": f.tables["hhea"], "hmtx": f.tables["hmtx"],
+		"loca": newLoca, "maxp": f.tables["maxp"],
+	}
+	for _, tag := range []string{"cvt ", "fpgm", "prep"} {
+		if f.tables[tag] != nil {
+			tables[tag] = f.tables[tag]
+		}
+	}
+	data, at := writeFont(tables)
+	binary.BigEndian.PutUint32(data[at["head"]+8:], 0xb1b0afba-checksum(data))
+	return data, nil
+}
+
+// components returns the glyphs a composite glyph is made of.
+func components(outline []byte) []uint16 {
+	if len(outline) < 10 || int16(u16(outline, 0)) >= 0 {
+		return nil
+	}
+	var glyphs []uint16
+	at := 10
+	for at+4 <= len(outline) {
+		flags := u16(outline, at)
+		glyphs = append(glyphs, u16(outline, at+2))
+		at += 4
+		if flags&0x0001 != 0 {
+			at += 4
+		} else {
+			at += 2
+		}
+		switch {
+		case flags&0x0008 != 0:
+			at += 2
+		case flags&0x0040 != 0:
+			at += 4
+		case flags&0x0080 != 0:
+			at += 8
+		}
+		if flags&0x0020 == 0 {
+			break
+		}
+	}
+	return glyphs
+}
+
+// writeFont lays out a font file with the tables sorted by tag and returns
+// it with the offsets of the tables.
+func writeFont(tables map[string][]byte) ([]byte, map[string]int) {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	power, log := 1, 0
+	for power*2 <= len(tags) {
+		power, log = power*2, log+1
+	}
+	header := make([]byte, 12+16*len(tags))
+	binary.BigEndian.PutUint32(header, 0x00010000)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(tags)))
+	binary.BigEndian.PutUint16(header[6:], uint16(16*power))
+	binary.BigEndian.PutUint16(header[8:], uint16(log))
+	binary.BigEndian.PutUint16(header[10:], uint16(16*(len(tags)-power)))
+	var body bytes.Buffer
+	offsets := make(map[string]int, len(tags))
+	for i, tag := range tags {
+		table := tables[tag]
+		record := header[12+16*i:]
+		copy(record, tag)
+		binary.BigEndian.PutUint32(record[4:], checksum(table))
+		offsets[tag] = len(header) + body.Len()
+		binary.BigEndian.PutUint32(record[8:], uint32(offsets[tag]))
+		binary.BigEndian.PutUint32(record[12:], uint32(len(table)))
+		body.Write(table)
+		for body.Len()%4 != 0 {
+			body.WriteByte(0)
+		}
+	}
+	return append(header, body.Bytes()...), offsets
+}
+
+func checksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
+
+// subsetTag prefixes the name of an embedded subset, as PDF asks, with six
+// capital letters derived from the glyphs it holds.
+func subsetTag(used map[uint16]bool) string {
+	glyphs := make([]int, 0, len(used))
+	for glyph := range used {
+		glyphs = append(glyphs, int(glyph))
+	}
+	sort.Ints(glyphs)
+	hash := uint32(2166136261)
+	for _, glyph := range glyphs {
+		hash = (hash ^ uint32(glyph)) * 16777619
+	}
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = 'A' + byte(hash%26)
+		hash /= 26
+	}
+	return fmt.Sprintf("%s+", tag)
+}
+
+func u16(data []byte, at int) uint16 {
+	if at < 0 || at+2 > len(data) {
+		return 0
+	}
+	return binary.BigEndian.Uint16(data[at:])
+}
+
+func u32(data []byte, at int) uint32 {
+	if at < 0 || at+4 > len(data) {
+		return 0
+	}
+	return binary.BigEndian.Uint32(data[at:])
+}
+
+// fontResource is a font as used in a document: the glyphs drawn with it
+// and the runes they stand for, for copying text out of the file.
+type fontResource struct {
+	font *Font
+	id   int
+	name string
+	used map[uint16]rune
+}
+
+// encode returns text as a hexadecimal string of glyph ids.
+func (r *fontResource) encode(text string) string {
+	var hex strings.Builder
+	hex.WriteString("<")
+	for _, c := range text {
+		glyph := r.font.glyph(c)
+		if _, ok := r.used[glyph]; !ok {
+			r.used[glyph] = c
+		}
+		fmt.Fprintf(&hex, "%04X", glyph)
+	}
+	hex.WriteString(">")
+	return hex.String()
+}
+
+// write writes the font as a composite font with the subset of the glyphs
+// it was used for embedded.
+func (r *fontResource) write(o *objectWriter) error {
+	f := r.font
+	used := make(map[uint16]bool, len(r.used))
+	for glyph := range r.used {
+		used[glyph] = true
+	}
+	data, err := f.subset(used)
+	if err != nil {
+		return err
+	}
+	name := subsetTag(used) + f.name
+	scale := func(units int16) string {
+		return strconv.Itoa(int(math.Round(float64(units) * 1000 / f.unitsPerEm)))
+	}
+	glyphs := make([]int, 0, len(r.used))
+	for glyph := range r.used {
+		glyphs = append(glyphs, int(glyph))
+	}
+	sort.Ints(glyphs)
+	var widths strings.Builder
+	for _, glyph := range glyphs {
+		advance := 0.0
+		if glyph < len(f.advances) {
+			advance = float64(f.advances[glyph])
+		}
+		fmt.Fprintf(&widths, "%d [%d] ", glyph, int(math.Round(advance*1000/f.unitsPerEm)))
+	}
+	flags := 32
+	if f.fixedPitch {
+		flags |= 1
+	}
+	if f.italicAngle != 0 {
+		flags |= 64
+	}
+
+	cid, descriptor, file, toUnicode := o.reserve(), o.reserve(), o.reserve(), o.reserve()
+	o.object(r.id, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%s] /ToUnicode %s >>",
+		name, ref(cid), ref(toUnicode)))
+	o.object(cid, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s "+
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> "+
+		"/FontDescriptor %s /CIDToGIDMap /Identity /W [%s] >>", name, ref(descriptor), widths.String()))
+	o.object(descriptor, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags %d /FontBBox [%s %s %s %s] "+
+		"/ItalicAngle %s /Ascent %s /Descent %s /CapHeight %s /StemV 80 /FontFile2 %s >>",
+		name, flags, scale(f.bbox[0]), scale(f.bbox[1]), scale(f.bbox[2]), scale(f.bbox[3]),
+		number(f.italicAngle), scale(f.ascent), scale(f.descent), scale(f.capHeight), ref(file)))
+	o.stream(file, fmt.Sprintf("/Length1 %d", len(data)), data)
+	o.stream(toUnicode, "", toUnicodeMap(glyphs, r.used))
+	return nil
+}
+
+// toUnicodeMap maps the glyphs back to the text they were drawn for.
+func toUnicodeMap(glyphs []int, runes map[uint16]rune) []byte {
+	var cmap bytes.Buffer
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(glyphs); start += 100 {
+		end := start + 100
+		if end > len(glyphs) {
+			end = len(glyphs)
+		}
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", end-start)
+		for _, glyph := range glyphs[start:end] {
+			fmt.Fprintf(&cmap, "<%04X> <", glyph)
+			for _, unit := range utf16.Encode([]rune{runes[uint16(glyph)]}) {
+				fmt.Fprintf(&cmap, "%04X", unit)
+			}
+			cmap.WriteString(">\n")
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return cmap.Bytes()
+}
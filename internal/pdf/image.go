@@ -0,0 +1,51 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// writeImage writes a JPEG, PNG or GIF image as an image object and returns
+// its size in pixels. JPEG files are embedded as they are; other images are
+// laid on white, as transparency would print as black on some printers.
+func writeImage(o *objectWriter, id int, data []byte) (int, int, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	if format == "jpeg" {
+		colorSpace, decode := "/DeviceRGB", ""
+		switch config.ColorModel {
+		case color.GrayModel:
+			colorSpace = "/DeviceGray"
+		case color.CMYKModel:
+			// CMYK JPEG files are written inverted by most programs.
+			colorSpace, decode = "/DeviceCMYK", " /Decode [1 0 1 0 1 0 1 0]"
+		}
+		o.rawStream(id, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8%s /Filter /DCTDecode",
+			config.Width, config.Height, colorSpace, decode), data)
+		return config.Width, config.Height, nil
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	bounds := decoded.Bounds()
+	canvas := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(canvas, canvas.Bounds(), decoded, bounds.Min, draw.Over)
+	pixels := make([]byte, 0, 3*bounds.Dx()*bounds.Dy())
+	for i := 0; i < len(canvas.Pix); i += 4 {
+		pixels = append(pixels, canvas.Pix[i], canvas.Pix[i+1], canvas.Pix[i+2])
+	}
+	o.stream(id, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8",
+		bounds.Dx(), bounds.Dy()), pixels)
+	return bounds.Dx(), bounds.Dy(), nil
+}
@@ -0,0 +1,135 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// objectWriter writes the objects of a PDF file and remembers where each
+// starts for the cross-reference table. Object numbers are reserved ahead,
+// so objects can refer to ones written later. The first write error is kept
+// and ends the writing.
+type objectWriter struct {
+	out     *bufio.Writer
+	written int64
+	offsets []int64
+	err     error
+}
+
+func newObjectWriter(w io.Writer) *objectWriter {
+	o := &objectWriter{out: bufio.NewWriter(w)}
+	// The comment of high bytes marks the file as binary.
+	o.write("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	return o
+}
+
+func (o *objectWriter) write(data string) {
+	if o.err != nil {
+		return
+	}
+	n, err := o.out.WriteString(data)
+	o.written += int64(n)
+	o.err = errors.WithStack(err)
+}
+
+// reserve returns the number of a new object.
+func (o *objectWriter) reserve() int {
+	o.offsets = append(o.offsets, 0)
+	return len(o.offsets)
+}
+
+func (o *objectWriter) begin(id int) {
+	o.offsets[id-1] = o.written
+	o.write(strconv.Itoa(id) + " 0 obj\n")
+}
+
+// object writes the object id with the given body.
+func (o *objectWriter) object(id int, body string) {
+	o.begin(id)
+	o.write(body + "\nendobj\n")
+}
+
+// stream writes the object id as a compressed stream. The entries of dict
+// come without brackets, Length and Filter are added.
+func (o *objectWriter) stream(id int, dict string, data []byte) {
+	var compressed bytes.Buffer
+	z, _ := zlib.NewWriterLevel(&compressed, zlib.BestCompression)
+	z.Write(data)
+	z.Close()
+	o.begin(id)
+	o.write(fmt.Sprintf("<< %s /Length %d /Filter /FlateDecode >>\nstream\n", dict, compressed.Len()))
+	o.write(compressed.String())
+	o.write("\nendstream\nendobj\n")
+}
+
+// rawStream writes the object id as a stream of data that is encoded
+// already, as dict says.
+func (o *objectWriter) rawStream(id int, dict string, data []byte) {
+	o.begin(id)
+	o.write(fmt.Sprintf("<< %s /Length %d >>\nstream\n", dict, len(data)))
+	o.write(string(data))
+	o.write("\nendstream\nendobj\n")
+}
+
+// close writes the cross-reference table and the trailer.
+func (o *objectWriter) close(root, info int) error {
+	start := o.written
+	o.write(fmt.Sprintf("xref\n0 %d\n0000000000 65535 f \n", len(o.offsets)+1))
+	for _, offset := range o.offsets {
+		o.write(fmt.Sprintf("%010d 00000 n \n", offset))
+	}
+	o.write(fmt.Sprintf("trailer\n<< /Size %d /Root %s /Info %s >>\nstartxref\n%d\n%%%%EOF\n",
+		len(o.offsets)+1, ref(root), ref(info), start))
+	if o.err != nil {
+		return o.err
+	}
+	for id, offset := range o.offsets {
+		if offset == 0 {
+			return errors.Errorf("object %d reserved but not written", id+1)
+		}
+	}
+	return errors.WithStack(o.out.Flush())
+}
+
+func ref(id int) string {
+	return strconv.Itoa(id) + " 0 R"
+}
+
+// number formats a coordinate with two decimals at most.
+func number(value float64) string {
+	text := strconv.FormatFloat(value, 'f', 2, 64)
+	text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	if text == "-0" {
+		return "0"
+	}
+	return text
+}
+
+// textString encodes text as a PDF text string: literal when it is
+// printable ASCII, else UTF-16 with a byte order mark.
+func textString(text string) string {
+	ascii := true
+	for _, c := range text {
+		if c < 0x20 || c > 0x7e {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return "(" + strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(text) + ")"
+	}
+	var hex strings.Builder
+	hex.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(text)) {
+		fmt.Fprintf(&hex, "%04X", unit)
+	}
+	hex.WriteString(">")
+	return hex.String()
+}
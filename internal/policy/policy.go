@@ -0,0 +1,100 @@
+package policy
+
+import "github.com/rustamfozilov/penhub/internal/types"
+
+type Action int
+
+const (
+	ReadBook Action = iota
+	EditBook
+	ReadChapter
+	EditChapter
+	DeleteLike
+	Moderate
+	// ManagePenName covers editing a pen name and publishing books under it.
+	ManagePenName
+	EditVolume
+	// ExportBook is downloading a whole book as a file.
+	ExportBook
+)
+
+// Subject is the user performing an action.
+type Subject struct {
+	UserId int64
+	Role   string
+}
+
+// Resource describes the object an action targets. For chapters the flags
+// combine the chapter with its book, so a chapter of a deleted or
+// unpublished book is inactive or unpublished too.
+type Resource struct {
+	OwnerId    int64
+	AccessRead bool
+	Active     bool
+	Hidden     bool
+	Published  bool
+	// Downloadable is set on books whose readers may export them.
+	Downloadable bool
+}
+
+func Allowed(subject Subject, action Action, resource Resource) bool {
+	switch action {
+	case ReadBook, ReadChapter:
+		if isOwner(subject, resource) || isModerator(subject) {
+			return true
+		}
+		return resource.Active && !resource.Hidden && resource.AccessRead && resource.Published
+	case EditBook, EditChapter, EditVolume, DeleteLike, ManagePenName:
+		return isOwner(subject, resource)
+	case ExportBook:
+		if isOwner(subject, resource) {
+			return true
+		}
+		return resource.Downloadable && Allowed(subject, ReadBook, resource)
+	case Moderate:
+		return isModerator(subject)
+	}
+	return false
+}
+
+func isOwner(subject Subject, resource Resource) bool {
+	return subject.UserId != 0 && subject.UserId == resource.OwnerId
+}
+
+func isModerator(subject Subject) bool {
+	return subject.Role == types.RoleModerator || subject.Role == types.RoleAdmin
+}
+
+// Scopes an API key can be granted. Each covers the route group of the same name.
+const (
+	ScopeBooksRead     = "books:read"
+	ScopeBooksWrite    = "books:write"
+	ScopeChaptersRead  = "chapters:read"
+	ScopeChaptersWrite = "chapters:write"
+	ScopeRatingRead    = "rating:read"
+	ScopeRatingWrite   = "rating:write"
+)
+
+var Scopes = []string{
+	ScopeBooksRead, ScopeBooksWrite,
+	ScopeChaptersRead, ScopeChaptersWrite,
+	ScopeRatingRead, ScopeRatingWrite,
+}
+
+// InScope reports whether a caller holding scopes may use scope. Nil scopes
+// belong to a session token and allow everything; an empty scope is
+// reserved for sessions and is never granted to a key.
+func InScope(scopes []string, scope string) bool {
+	if scopes == nil {
+		return true
+	}
+	if scope == "" {
+		return false
+	}
+	for _, granted := range scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package policy
+
+import (
+	"fmt"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"testing"
+)
+
+const ownerId = 1
+
+var (
+	owner     = Subject{UserId: ownerId, Role: types.RoleUser}
+	stranger  = Subject{UserId: 2, Role: types.RoleUser}
+	moderator = Subject{UserId: 3, Role: types.RoleModerator}
+)
+
+// Every resource below belongs to owner and differs from a public book in
+// one flag.
+var resources = map[string]Resource{
+	"public":      {OwnerId: ownerId, AccessRead: true, Active: true, Published: true},
+	"private":     {OwnerId: ownerId, AccessRead: false, Active: true, Published: true},
+	"inactive":    {OwnerId: ownerId, AccessRead: true, Active: false, Published: true},
+	"unpublished": {OwnerId: ownerId, AccessRead: true, Active: true, Published: false},
+	"hidden":      {OwnerId: ownerId, AccessRead: true, Active: true, Published: true, Hidden: true},
+}
+
+var actions = map[Action]string{
+	ReadBook:      "ReadBook",
+	EditBook:      "EditBook",
+	ReadChapter:   "ReadChapter",
+	EditChapter:   "EditChapter",
+	DeleteLike:    "DeleteLike",
+	Moderate:      "Moderate",
+	ManagePenName: "ManagePenName",
+	EditVolume:    "EditVolume",
+	ExportBook:    "ExportBook",
+}
+
+// want is the expected outcome for owner, stranger and moderator.
+type want struct {
+	owner, stranger, moderator bool
+}
+
+type allowedCase struct {
+	action       Action
+	resource     string
+	downloadable bool
+	want         want
+}
+
+func TestAllowed(t *testing.T) {
+	var (
+		everyone   = want{true, true, true}
+		privileged = want{true, false, true}
+		ownerOnly  = want{true, false, false}
+		moderators = want{false, false, true}
+	)
+	tests := []allowedCase{
+		{ReadBook, "public", false, everyone},
+		{ReadBook, "private", false, privileged},
+		{ReadBook, "inactive", false, privileged},
+		{ReadBook, "unpublished", false, privileged},
+		{ReadBook, "hidden", false, privileged},
+
+		{ReadChapter, "public", false, everyone},
+		{ReadChapter, "private", false, privileged},
+		{ReadChapter, "inactive", false, privileged},
+		{ReadChapter, "unpublished", false, privileged},
+		{ReadChapter, "hidden", false, privileged},
+
+		{ExportBook, "public", false, ownerOnly},
+		{ExportBook, "private", false, ownerOnly},
+		{ExportBook, "inactive", false, ownerOnly},
+		{ExportBook, "unpublished", false, ownerOnly},
+		{ExportBook, "hidden", false, ownerOnly},
+		{ExportBook, "public", true, everyone},
+		{ExportBook, "private", true, privileged},
+		{ExportBook, "inactive", true, privileged},
+		{ExportBook, "unpublished", true, privileged},
+		{ExportBook, "hidden", true, privileged},
+
+		{Moderate, "public", false, moderators},
+		{Moderate, "private", false, moderators},
+		{Moderate, "inactive", false, moderators},
+		{Moderate, "unpublished", false, moderators},
+	}
+	for _, action := range []Action{EditBook, EditChapter, EditVolume, DeleteLike, ManagePenName} {
+		for _, resource := range []string{"public", "private", "inactive", "unpublished", "hidden"} {
+			tests = append(tests, allowedCase{action, resource, false, ownerOnly})
+		}
+	}
+
+	covered := make(map[Action]bool)
+	for _, tt := range tests {
+		covered[tt.action] = true
+		resource := resources[tt.resource]
+		resource.Downloadable = tt.downloadable
+		name := fmt.Sprintf("%s/%s", actions[tt.action], tt.resource)
+		if tt.downloadable {
+			name += "/downloadable"
+		}
+		t.Run(name, func(t *testing.T) {
+			for _, subject := range []struct {
+				name    string
+				subject Subject
+				want    bool
+			}{
+				{"owner", owner, tt.want.owner},
+				{"stranger", stranger, tt.want.stranger},
+				{"moderator", moderator, tt.want.moderator},
+			} {
+				got := Allowed(subject.subject, tt.action, resource)
+				if got != subject.want {
+					t.Errorf("%s: Allowed = %v, want %v", subject.name, got, subject.want)
+				}
+			}
+		})
+	}
+	for action, name := range actions {
+		if !covered[action] {
+			t.Errorf("%s has no cases", name)
+		}
+	}
+}
+
+// An admin has the rights of a moderator.
+func TestAllowedAdmin(t *testing.T) {
+	admin := Subject{UserId: 4, Role: types.RoleAdmin}
+	for action, name := range actions {
+		for resourceName, resource := range resources {
+			if got, want := Allowed(admin, action, resource), Allowed(moderator, action, resource); got != want {
+				t.Errorf("%s/%s: Allowed = %v, want %v", name, resourceName, got, want)
+			}
+		}
+	}
+}
+
+// A caller without a user id never owns anything, not even a resource
+// whose owner is unknown.
+func TestAllowedAnonymous(t *testing.T) {
+	anonymous := Subject{}
+	resource := Resource{AccessRead: true, Active: true, Published: false}
+	for action, name := range actions {
+		if Allowed(anonymous, action, resource) {
+			t.Errorf("%s: anonymous caller allowed on an unowned unpublished resource", name)
+		}
+	}
+}
+
+func TestAllowedUnknownAction(t *testing.T) {
+	if Allowed(owner, Action(-1), resources["public"]) {
+		t.Error("unknown action allowed")
+	}
+}
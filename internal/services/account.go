@@ -0,0 +1,282 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrExportNotReady = errors.New("export is not ready")
+
+const defaultDeletionGraceDays = 14
+
+const defaultExportRetentionDays = 7
+
+// A data export that takes longer than dataExportTimeout is taken to have
+// died with its server and is started again, dataExportAttempts times at most.
+const (
+	dataExportTimeout  = time.Hour
+	dataExportAttempts = 3
+)
+
+// RequestDataExport queues the archive; the client polls GetDataExport
+// until it is ready.
+func (s *Service) RequestDataExport(ctx context.Context, userId int64) (*types.DataExport, error) {
+	export := types.DataExport{UserId: userId}
+	err := s.db.CreateDataExport(ctx, &export)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		err := s.buildDataExports(context.Background())
+		if err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}()
+	return &export, nil
+}
+
+// buildDataExports builds pending exports until none is left. It runs
+// after every request and as a job, which picks up the exports of servers
+// that stopped while building.
+func (s *Service) buildDataExports(ctx context.Context) error {
+	staleBefore := time.Now().Add(-dataExportTimeout)
+	err := s.db.FailStaleDataExports(ctx, staleBefore, dataExportAttempts)
+	if err != nil {
+		return err
+	}
+	for {
+		export, err := s.db.ClaimDataExport(ctx, staleBefore, dataExportAttempts)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fileName := uuid.New().String() + ".zip"
+		err = s.writeDataExport(ctx, export.UserId, filepath.Join(s.config.ExportsPath, fileName))
+		if err != nil {
+			log.Printf("data export %d: %+v\n", export.ID, err)
+			export.Status = types.ExportFailed
+		} else {
+			export.Status = types.ExportReady
+			export.FileName = fileName
+		}
+		err = s.db.FinishDataExport(ctx, export)
+		if errors.Is(err, pgx.ErrNoRows) {
+			s.removeExportFiles(export.FileName)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Service) writeDataExport(ctx context.Context, userId int64, path string) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		closeErr := file.Close()
+		if err == nil {
+			err = errors.WithStack(closeErr)
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+	archive := zip.NewWriter(file)
+
+	profile, err := s.db.GetProfile(ctx, userId)
+	if err != nil {
+		return err
+	}
+	err = writeJSON(archive, "profile.json", profile)
+	if err != nil {
+		return err
+	}
+	penNames, err := s.db.GetPenNamesByUserId(ctx, userId)
+	if err != nil {
+		return err
+	}
+	err = writeJSON(archive, "pen_names.json", penNames)
+	if err != nil {
+		return err
+	}
+	likes, err := s.db.GetLikesOfUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	err = writeJSON(archive, "likes.json", likes)
+	if err != nil {
+		return err
+	}
+	books, err := s.db.GetAllBooksOfUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	err = writeJSON(archive, "books.json", books)
+	if err != nil {
+		return err
+	}
+	for _, book := range books {
+		chapters, err := s.db.GetAllChapters(ctx, book.ID)
+		if err != nil {
+			return err
+		}
+		err = writeJSON(archive, fmt.Sprintf("books/%d/chapters.json", book.ID), chapters)
+		if err != nil {
+			return err
+		}
+		err = s.copyImage(archive, fmt.Sprintf("books/%d/", book.ID), book.Image)
+		if err != nil {
+			return err
+		}
+	}
+	if profile.Avatar != "" {
+		err = s.copyImage(archive, "", profile.Avatar)
+		if err != nil {
+			return err
+		}
+	}
+	return errors.WithStack(archive.Close())
+}
+
+func writeJSON(archive *zip.Writer, name string, item interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return errors.WithStack(encoder.Encode(item))
+}
+
+// copyImage adds an image from imagesDirPath to the archive. Missing files
+// are skipped, an export should not fail because a cover was lost.
+func (s *Service) copyImage(archive *zip.Writer, dir, imageName string) error {
+	image, err := os.Open(filepath.Join(s.imagesDirPath, filepath.Base(imageName)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer image.Close()
+	w, err := archive.Create(dir + filepath.Base(imageName))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.Copy(w, image)
+	return errors.WithStack(err)
+}
+
+func (s *Service) GetDataExport(ctx context.Context, userId, exportId int64) (*types.DataExport, error) {
+	export, err := s.db.GetDataExport(ctx, exportId, userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return export, err
+}
+
+func (s *Service) OpenDataExport(ctx context.Context, userId, exportId int64) (*os.File, error) {
+	export, err := s.GetDataExport(ctx, userId, exportId)
+	if err != nil {
+		return nil, err
+	}
+	if export.Status != types.ExportReady {
+		return nil, ErrExportNotReady
+	}
+	file, err := os.Open(filepath.Join(s.config.ExportsPath, export.FileName))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return file, nil
+}
+
+func (s *Service) RequestAccountDeletion(ctx context.Context, deletion *types.AccountDeletion) error {
+	days := s.config.DeletionGraceDays
+	if days == 0 {
+		days = defaultDeletionGraceDays
+	}
+	deletion.ExecuteAfter = time.Now().AddDate(0, 0, days)
+	return s.db.PutAccountDeletion(ctx, deletion)
+}
+
+func (s *Service) GetAccountDeletion(ctx context.Context, userId int64) (*types.AccountDeletion, error) {
+	deletion, err := s.db.GetAccountDeletion(ctx, userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return deletion, err
+}
+
+func (s *Service) CancelAccountDeletion(ctx context.Context, userId int64) error {
+	return s.db.CancelAccountDeletion(ctx, userId)
+}
+
+func (s *Service) processAccountDeletions(ctx context.Context) error {
+	deletions, err := s.db.DueAccountDeletions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, deletion := range deletions {
+		exports, images, err := s.db.DeleteAccount(ctx, deletion.UserId)
+		if err != nil {
+			log.Printf("delete account %d: %+v\n", deletion.UserId, err)
+			continue
+		}
+		s.removeExportFiles(exports...)
+		s.removeImages(images...)
+		log.Printf("account %d deleted, books: %s\n", deletion.UserId, deletion.Books)
+	}
+	return nil
+}
+
+func (s *Service) ValidateAccountDeletion(deletion *types.AccountDeletion) error {
+	if deletion.Books != types.DeleteBooks && deletion.Books != types.ReassignBooks {
+		return ErrInvalidData
+	}
+	return nil
+}
+
+// deleteExpiredExports removes the exports of both kinds, with their files,
+// once they have been finished for longer than the retention period.
+func (s *Service) deleteExpiredExports(ctx context.Context) error {
+	days := s.config.ExportRetentionDays
+	if days == 0 {
+		days = defaultExportRetentionDays
+	}
+	files, err := s.db.DeleteExpiredExports(ctx, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return err
+	}
+	s.removeExportFiles(files...)
+	return nil
+}
+
+// removeExportFiles deletes export files whose rows are gone. A file that
+// cannot be removed is only logged, its row cannot be brought back.
+func (s *Service) removeExportFiles(names ...string) {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		err := os.Remove(filepath.Join(s.config.ExportsPath, filepath.Base(name)))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("%+v\n", errors.WithStack(err))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// deleteTestAccount has an author with an avatar and a book with a cover
+// and a revised chapter deleted right away, keeping or deleting the books.
+func deleteTestAccount(t *testing.T, books string) (s *Service, images string, book *types.Book, avatar string) {
+	t.Helper()
+	images = t.TempDir()
+	s, _ = testService(t, &types.Config{ImagesPath: images, ExportsPath: t.TempDir()})
+	ctx := context.Background()
+	userId := createTestUser(t, s, "writer", "writer@example.com", "Old-Pass-1234-xyz")
+	err := s.EditAvatar(ctx, userId, strings.NewReader("avatar"), "me.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := s.GetProfile(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book = createTestBook(t, s, userId, "Title")
+	err = os.WriteFile(filepath.Join(images, book.Image), []byte("cover"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chapter := &types.Chapter{BookId: book.ID, Name: "One", Content: "First draft"}
+	err = s.WriteChapter(ctx, chapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.EditChapter(ctx, &types.Chapter{ID: chapter.ID, Content: "Second draft"}, 0, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.RequestAccountDeletion(ctx, &types.AccountDeletion{UserId: userId, Books: books})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.db.Pool.Exec(ctx, `
+		update account_deletions set execute_after = current_timestamp - interval '1 second'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.processAccountDeletions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, images, book, profile.Avatar
+}
+
+func countRows(t *testing.T, s *Service, query string, args ...interface{}) int {
+	t.Helper()
+	var count int
+	err := s.db.Pool.QueryRow(context.Background(), query, args...).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestDeleteAccountDeletesBooks(t *testing.T) {
+	s, images, book, avatar := deleteTestAccount(t, types.DeleteBooks)
+	for table, query := range map[string]string{
+		"books":    `select count(*) from books where id = $1`,
+		"chapters": `select count(*) from chapters where book_id = $1`,
+	} {
+		if count := countRows(t, s, query, book.ID); count != 0 {
+			t.Errorf("%d rows left in %s", count, table)
+		}
+	}
+	if count := countRows(t, s, `select count(*) from chapter_revisions`); count != 0 {
+		t.Errorf("%d revisions left", count)
+	}
+	if exists(filepath.Join(images, book.Image)) {
+		t.Error("cover left behind")
+	}
+	if exists(filepath.Join(images, avatar)) {
+		t.Error("avatar left behind")
+	}
+}
+
+// Reassigned books keep their cover; only the avatar goes.
+func TestDeleteAccountReassignsBooks(t *testing.T) {
+	s, images, book, avatar := deleteTestAccount(t, types.ReassignBooks)
+	if count := countRows(t, s, `select count(*) from chapters where book_id = $1`, book.ID); count != 1 {
+		t.Errorf("%d chapters, want 1", count)
+	}
+	if count := countRows(t, s, `select count(*) from books where id = $1 and author_id <> $2`, book.ID, book.AuthorId); count != 1 {
+		t.Error("book not reassigned")
+	}
+	if !exists(filepath.Join(images, book.Image)) {
+		t.Error("cover of a reassigned book removed")
+	}
+	if exists(filepath.Join(images, avatar)) {
+		t.Error("avatar left behind")
+	}
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/types"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+func (s *Service) RoleById(ctx context.Context, userId int64) (string, error) {
+	role, err := s.db.GetUserRole(ctx, userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNoAuthorization
+	}
+	if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+func (s *Service) SetUserRole(ctx context.Context, userRole *types.UserRole) error {
+	return s.db.SetUserRole(ctx, userRole)
+}
+
+func (s *Service) SetUserActive(ctx context.Context, userActive *types.UserActive) error {
+	err := s.db.SetUserActive(ctx, userActive)
+	if err != nil {
+		return err
+	}
+	if !userActive.Active {
+		return s.db.RevokeAllTokens(ctx, userActive.Id)
+	}
+	return nil
+}
+
+func (s *Service) HideBook(ctx context.Context, book *types.Book) error {
+	return s.db.HideBook(ctx, book)
+}
+
+func (s *Service) HideChapter(ctx context.Context, chapter *types.Chapter) error {
+	return s.db.HideChapter(ctx, chapter)
+}
+
+func (s *Service) CreateGenre(ctx context.Context, genre *types.Genre) error {
+	return s.db.CreateGenre(ctx, genre)
+}
+
+func (s *Service) UpdateGenre(ctx context.Context, genre *types.Genre) error {
+	return s.db.UpdateGenre(ctx, genre)
+}
+
+func (s *Service) ValidateRole(role string) error {
+	switch role {
+	case types.RoleUser, types.RoleModerator, types.RoleAdmin:
+		return nil
+	}
+	return ErrInvalidData
+}
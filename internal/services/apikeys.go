@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrOutOfScope = errors.New("out of api key scope")
+
+// apiKeyPrefix tells API keys apart from session tokens in the Authorization header.
+const apiKeyPrefix = "phk_"
+const apiKeyLength = 32
+
+func (s *Service) CreateAPIKey(ctx context.Context, key *types.APIKey) error {
+	buffer := make([]byte, apiKeyLength)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	key.Key = apiKeyPrefix + hex.EncodeToString(buffer)
+	key.KeyHash = HashToken(key.Key)
+	key.Prefix = key.Key[:len(apiKeyPrefix)+tokenPrefixLength]
+	return s.db.CreateAPIKey(ctx, key)
+}
+
+func (s *Service) GetAPIKeys(ctx context.Context, userId int64) ([]*types.APIKey, error) {
+	return s.db.GetAPIKeys(ctx, userId)
+}
+
+func (s *Service) RevokeAPIKey(ctx context.Context, id, userId int64) error {
+	err := s.db.RevokeAPIKey(ctx, id, userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Authenticate resolves either a session token or an API key to its caller.
+func (s *Service) Authenticate(ctx context.Context, token string) (*types.Principal, error) {
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		id, err := s.IdByToken(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		return &types.Principal{UserId: id}, nil
+	}
+	id, scopes, err := s.db.APIKeyUser(ctx, HashToken(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoAuthorization
+	}
+	if err != nil {
+		return nil, err
+	}
+	if scopes == nil {
+		scopes = make([]string, 0)
+	}
+	return &types.Principal{UserId: id, Scopes: scopes}, nil
+}
+
+func (s *Service) ValidateAPIKey(key *types.APIKey) error {
+	fields := make([]types.FieldError, 0)
+	length := utf8.RuneCountInString(key.Name)
+	if length > 64 || length < 1 {
+		fields = append(fields, types.FieldError{Field: "name", Reason: "invalid_length"})
+	}
+	if len(key.Scopes) == 0 {
+		fields = append(fields, types.FieldError{Field: "scopes", Reason: "required"})
+	}
+	for _, scope := range key.Scopes {
+		if !knownScope(scope) {
+			fields = append(fields, types.FieldError{Field: "scopes", Reason: "unknown_scope"})
+			break
+		}
+	}
+	if len(fields) != 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+func knownScope(scope string) bool {
+	for _, known := range policy.Scopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
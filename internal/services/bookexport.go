@@ -0,0 +1,449 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/epub"
+	"github.com/rustamfozilov/penhub/internal/fb2"
+	"github.com/rustamfozilov/penhub/internal/markup"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var ErrEmptyBook = errors.New("book has no chapters to export")
+
+const defaultExportLanguage = "und"
+
+// bookExportLayout is part of every cache key. Bump it when the exported
+// files change so that cached ones are rebuilt.
+const bookExportLayout = 1
+
+// bookContents is what an export of a book is built from: the book, its
+// volumes and the chapters the viewer may read, without their content but
+// with its checksums.
+type bookContents struct {
+	book      *types.Book
+	volumes   []*types.Volume
+	chapters  []*types.Chapter
+	checksums map[int64]string
+	viewerId  int64
+}
+
+func (s *Service) bookContents(ctx context.Context, bookId, viewerId int64) (*bookContents, error) {
+	book, err := s.db.GetBook(ctx, bookId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := s.db.GetVolumes(ctx, bookId)
+	if err != nil {
+		return nil, err
+	}
+	chapters, err := s.db.GetChaptersByBookId(ctx, bookId, viewerId)
+	if err != nil {
+		return nil, err
+	}
+	if len(chapters) == 0 {
+		return nil, ErrEmptyBook
+	}
+	checksums, err := s.db.ChapterChecksums(ctx, bookId, viewerId)
+	if err != nil {
+		return nil, err
+	}
+	return &bookContents{book: book, volumes: volumes, chapters: chapters, checksums: checksums, viewerId: viewerId}, nil
+}
+
+// cacheName names the cached export of contents. Authors see their drafts,
+// so their exports are cached apart from the readers' ones. The hash
+// covers everything an export shows. Versions would not do: an edit claims
+// the new version before it writes, and content is read after the hash,
+// so a file can be newer than its name but never older.
+func (s *Service) cacheName(contents *bookContents, extension string) string {
+	type volume struct {
+		ID    int64
+		Title string
+	}
+	type chapter struct {
+		ID       int64
+		Number   int64
+		VolumeId *int64
+		Checksum string
+	}
+	fingerprint := struct {
+		Layout      int
+		Language    string
+		Title       string
+		PenName     string
+		Description string
+		Image       string
+		Volumes     []volume
+		Chapters    []chapter
+	}{
+		Layout: bookExportLayout, Language: s.exportLanguage(),
+		Title: contents.book.Title, PenName: contents.book.PenName,
+		Description: contents.book.Description, Image: contents.book.Image,
+	}
+	for _, item := range contents.volumes {
+		fingerprint.Volumes = append(fingerprint.Volumes, volume{item.ID, item.Title})
+	}
+	for _, item := range contents.chapters {
+		fingerprint.Chapters = append(fingerprint.Chapters, chapter{item.ID, item.Number, item.VolumeId, contents.checksums[item.ID]})
+	}
+	data, _ := json.Marshal(fingerprint)
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s%x%s", s.cachePrefix(contents), sum[:16], extension)
+}
+
+func (s *Service) cachePrefix(contents *bookContents) string {
+	variant := "reader"
+	if contents.viewerId == contents.book.AuthorId {
+		variant = "author"
+	}
+	return fmt.Sprintf("book-%d-%s-", contents.book.ID, variant)
+}
+
+// bookUUID identifies a book in exports. It is derived from the book's
+// address, so every export of a book carries the same one.
+func (s *Service) bookUUID(book *types.Book) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(s.config.BaseURL+"/books/"+strconv.FormatInt(book.ID, 10))).String()
+}
+
+func (s *Service) exportLanguage() string {
+	if s.config.BookExport.Language != "" {
+		return s.config.BookExport.Language
+	}
+	return defaultExportLanguage
+}
+
+// openCachedExport opens the cached export named name, building it with
+// write first when it is missing. Files are built under a temporary name
+// and renamed, so concurrent requests never see a partial file; the
+// outdated exports of the same book and viewer are removed afterwards.
+func (s *Service) openCachedExport(contents *bookContents, name string, write func(w io.Writer) error) (*os.File, error) {
+	path := filepath.Join(s.config.ExportsPath, name)
+	file, err := os.Open(path)
+	if err == nil {
+		return file, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+	temp, err := os.CreateTemp(s.config.ExportsPath, ".book-*.tmp")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer os.Remove(temp.Name())
+	err = write(temp)
+	closeErr := temp.Close()
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, errors.WithStack(closeErr)
+	}
+	err = os.Rename(temp.Name(), path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	file, err = os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	outdated, _ := filepath.Glob(filepath.Join(s.config.ExportsPath, s.cachePrefix(contents)+"*"+filepath.Ext(name)))
+	for _, old := range outdated {
+		if old != path {
+			os.Remove(old)
+		}
+	}
+	return file, nil
+}
+
+// ExportEPUB returns the EPUB file of a book as the viewer may read it,
+// together with the book.
+func (s *Service) ExportEPUB(ctx context.Context, bookId *types.BookId, viewerId int64) (*os.File, *types.Book, error) {
+	contents, err := s.bookContents(ctx, bookId.Id, viewerId)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := s.openCachedExport(contents, s.cacheName(contents, ".epub"), func(w io.Writer) error {
+		book, err := s.epubBook(ctx, contents)
+		if err != nil {
+			return err
+		}
+		return epub.Write(w, book)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, contents.book, nil
+}
+
+func (s *Service) epubBook(ctx context.Context, contents *bookContents) (*epub.Book, error) {
+	book := &epub.Book{
+		Identifier:  "urn:uuid:" + s.bookUUID(contents.book),
+		Title:       contents.book.Title,
+		Language:    s.exportLanguage(),
+		Author:      contents.book.PenName,
+		Description: contents.book.Description,
+		Modified:    time.Now(),
+		Cover:       s.epubCover(contents.book.Image),
+	}
+	chapters, err := s.chapterContents(ctx, contents)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts(contents, chapters) {
+		item := &epub.Part{Title: part.title}
+		for _, chapter := range part.chapters {
+			item.Chapters = append(item.Chapters, &epub.Chapter{Title: chapter.Name, Body: markup.Render(chapter.Format, chapter.Content)})
+		}
+		book.Parts = append(book.Parts, item)
+	}
+	return book, nil
+}
+
+// chapterContents reads the listed chapters with their content. Chapters
+// deleted since the list was read are left out.
+func (s *Service) chapterContents(ctx context.Context, contents *bookContents) ([]*types.Chapter, error) {
+	chapters := make([]*types.Chapter, 0, len(contents.chapters))
+	for _, item := range contents.chapters {
+		chapter, err := s.db.ReadChapter(ctx, item.ID, contents.viewerId)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, chapter)
+	}
+	if len(chapters) == 0 {
+		return nil, ErrEmptyBook
+	}
+	return chapters, nil
+}
+
+type part struct {
+	title    string
+	chapters []*types.Chapter
+}
+
+// parts splits chapters in reading order into runs of the same volume.
+// Chapters outside any volume form untitled parts.
+func parts(contents *bookContents, chapters []*types.Chapter) []*part {
+	titles := make(map[int64]string, len(contents.volumes))
+	for _, volume := range contents.volumes {
+		titles[volume.ID] = volume.Title
+	}
+	result := make([]*part, 0)
+	var current *part
+	var currentVolume int64
+	for _, chapter := range chapters {
+		var volume int64
+		if chapter.VolumeId != nil {
+			volume = *chapter.VolumeId
+		}
+		if current == nil || volume != currentVolume {
+			current = &part{title: titles[volume]}
+			currentVolume = volume
+			result = append(result, current)
+		}
+		current.chapters = append(current.chapters, chapter)
+	}
+	return result
+}
+
+// epubCover loads the cover image. A missing cover or one in a format EPUB
+// readers need not support is left out rather than failing the export.
+func (s *Service) epubCover(imageName string) *epub.Cover {
+	data, extension, mediaType, ok := s.readCover(imageName, "image/jpeg", "image/png", "image/gif", "image/webp")
+	if !ok {
+		return nil
+	}
+	return &epub.Cover{Extension: extension, MediaType: mediaType, Data: data}
+}
+
+// readCover reads the cover image when it has one of mediaTypes.
+func (s *Service) readCover(imageName string, mediaTypes ...string) (data []byte, extension, mediaType string, ok bool) {
+	extension = filepath.Ext(imageName)
+	mediaType = mime.TypeByExtension(extension)
+	for _, item := range mediaTypes {
+		if item != mediaType {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.imagesDirPath, filepath.Base(imageName)))
+		if err != nil {
+			return nil, "", "", false
+		}
+		return data, extension, mediaType, true
+	}
+	return nil, "", "", false
+}
+
+// BookExport is an export written while its chapters are read, one at a
+// time, so that a book is never held in memory as a whole.
+type BookExport struct {
+	Book  *types.Book
+	write func(w io.Writer) error
+}
+
+// Write writes the export to w. It can fail after part of the export is
+// written.
+func (e *BookExport) Write(w io.Writer) error {
+	return e.write(w)
+}
+
+// streamChapters reads the chapters of contents in reading order and
+// passes each with the part it belongs to. Chapters deleted since the list
+// was read are left out.
+func (s *Service) streamChapters(ctx context.Context, contents *bookContents, fn func(part *part, chapter *types.Chapter) error) error {
+	for _, item := range parts(contents, contents.chapters) {
+		for _, listed := range item.chapters {
+			chapter, err := s.db.ReadChapter(ctx, listed.ID, contents.viewerId)
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			err = fn(item, chapter)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fb2Genres maps the names of our genres to FictionBook genre codes.
+var fb2Genres = map[string]string{
+	"novel":           "prose_contemporary",
+	"novella":         "prose_contemporary",
+	"tale":            "prose_contemporary",
+	"fable":           "child_tale",
+	"fairy tale":      "child_tale",
+	"detective":       "detective",
+	"science fiction": "sf",
+	"non-fiction":     "nonfiction",
+	"mythology":       "antique_myths",
+	"poem":            "poetry",
+	"biography":       "nonf_biography",
+	"manual":          "ref_guide",
+	"historical":      "prose_history",
+	"note":            "nonf_publicism",
+	"fantasy":         "sf_fantasy",
+}
+
+const defaultFB2Genre = "prose_contemporary"
+
+func (s *Service) fb2Genre(ctx context.Context, genreId int64) (string, error) {
+	genre, err := s.db.GetGenreById(ctx, types.GenreID{Id: genreId})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return defaultFB2Genre, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	code, ok := fb2Genres[strings.ToLower(genre.Name)]
+	if !ok {
+		return defaultFB2Genre, nil
+	}
+	return code, nil
+}
+
+// ExportFB2 returns the FictionBook 2 export of a book as the viewer may
+// read it.
+func (s *Service) ExportFB2(ctx context.Context, bookId *types.BookId, viewerId int64) (*BookExport, error) {
+	contents, err := s.bookContents(ctx, bookId.Id, viewerId)
+	if err != nil {
+		return nil, err
+	}
+	genre, err := s.fb2Genre(ctx, contents.book.Genre)
+	if err != nil {
+		return nil, err
+	}
+	description := &fb2.Description{
+		Id:       s.bookUUID(contents.book),
+		Title:    contents.book.Title,
+		Language: s.exportLanguage(),
+		Author:   contents.book.PenName,
+		Genres:   []string{genre},
+		Date:     time.Now(),
+	}
+	if contents.book.Description != "" {
+		description.Annotation = markup.FB2(markup.Plain, contents.book.Description)
+	}
+	data, extension, mediaType, ok := s.readCover(contents.book.Image, "image/jpeg", "image/png")
+	if ok {
+		description.Cover = &fb2.Cover{Extension: extension, MediaType: mediaType, Data: data}
+	}
+	return &BookExport{Book: contents.book, write: func(w io.Writer) error {
+		book := fb2.NewWriter(w, description)
+		var current *part
+		err := s.streamChapters(ctx, contents, func(part *part, chapter *types.Chapter) error {
+			if part != current {
+				book.Part(part.title)
+				current = part
+			}
+			return book.Chapter(chapter.Name, markup.FB2(chapter.Format, chapter.Content))
+		})
+		if err != nil {
+			return err
+		}
+		return book.Close()
+	}}, nil
+}
+
+// ExportText returns the plain text export of a book as the viewer may
+// read it. Titles are underlined, volumes with "=" and chapters with "-".
+func (s *Service) ExportText(ctx context.Context, bookId *types.BookId, viewerId int64) (*BookExport, error) {
+	contents, err := s.bookContents(ctx, bookId.Id, viewerId)
+	if err != nil {
+		return nil, err
+	}
+	return &BookExport{Book: contents.book, write: func(w io.Writer) error {
+		out := bufio.NewWriter(w)
+		out.WriteString(underline(contents.book.Title, '='))
+		if contents.book.PenName != "" {
+			out.WriteString(contents.book.PenName + "\n")
+		}
+		if contents.book.Description != "" {
+			out.WriteString("\n" + markup.Text(markup.Plain, contents.book.Description) + "\n")
+		}
+		var current *part
+		err := s.streamChapters(ctx, contents, func(part *part, chapter *types.Chapter) error {
+			if part != current && part.title != "" {
+				out.WriteString("\n\n" + underline(part.title, '='))
+			}
+			current = part
+			out.WriteString("\n\n" + underline(chapter.Name, '-') + "\n")
+			text := markup.Text(chapter.Format, chapter.Content)
+			if text != "" {
+				out.WriteString(text + "\n")
+			}
+			return errors.WithStack(out.Flush())
+		})
+		if err != nil {
+			return err
+		}
+		return errors.WithStack(out.Flush())
+	}}, nil
+}
+
+func underline(title string, c rune) string {
+	return title + "\n" + strings.Repeat(string(c), utf8.RuneCountInString(title)) + "\n"
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/bookimport"
+	"github.com/rustamfozilov/penhub/internal/markup"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultImportMaxSize     = 50 << 20
+	defaultImportMaxUnpacked = 200 << 20
+)
+
+// importReasons are the field error reasons of files that cannot be read.
+var importReasons = map[error]string{
+	bookimport.ErrUnsupported: "unsupported_format",
+	bookimport.ErrTooLarge:    "too_large",
+	bookimport.ErrInvalid:     "damaged",
+	bookimport.ErrNoChapters:  "no_chapters",
+}
+
+// ImportMaxSize is the largest file a book is imported from, in bytes.
+func (s *Service) ImportMaxSize() int64 {
+	if s.config.BookImport.MaxSize > 0 {
+		return s.config.BookImport.MaxSize
+	}
+	return defaultImportMaxSize
+}
+
+func (s *Service) importMaxUnpacked() int64 {
+	if s.config.BookImport.MaxUnpackedSize > 0 {
+		return s.config.BookImport.MaxUnpackedSize
+	}
+	return defaultImportMaxUnpacked
+}
+
+// ImportBook creates a draft book with draft chapters from an EPUB, FB2 or
+// DOCX file or a ZIP archive of Markdown files. The title and description
+// given in options win over the ones in the file; those taken from the file
+// are shortened to fit, with a warning. In a dry run nothing is created and
+// the report shows what would be.
+func (s *Service) ImportBook(ctx context.Context, authorId int64, options *types.BookImport, file io.ReaderAt, size int64, fileName string) (*types.ImportReport, error) {
+	if size > s.ImportMaxSize() {
+		return nil, &ValidationError{Fields: []types.FieldError{{Field: "file", Reason: "too_large"}}}
+	}
+	parsed, err := bookimport.Read(file, size, s.importMaxUnpacked())
+	if err != nil {
+		for cause, reason := range importReasons {
+			if errors.Is(err, cause) {
+				log.Println(err)
+				return nil, &ValidationError{Fields: []types.FieldError{{Field: "file", Reason: reason}}}
+			}
+		}
+		return nil, err
+	}
+	report := &types.ImportReport{
+		Format:   parsed.Format,
+		Chapters: make([]*types.ImportedChapter, 0, len(parsed.Chapters)),
+		Warnings: append(make([]string, 0), parsed.Warnings...),
+	}
+	book := &types.Book{
+		AuthorId:     authorId,
+		PenNameId:    options.PenNameId,
+		Genre:        options.Genre,
+		AccessRead:   options.AccessRead,
+		Downloadable: options.Downloadable,
+		Status:       types.StatusDraft,
+	}
+
+	book.Title = options.Title
+	if book.Title == "" {
+		title := parsed.Title
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+		}
+		book.Title = shorten(report, "title", title, 20)
+	}
+	book.Description = options.Description
+	if book.Description == "" {
+		book.Description = shorten(report, "description", parsed.Description, 250)
+	}
+	err = s.validateImport(ctx, book)
+	if err != nil {
+		return nil, err
+	}
+	report.Title, report.Description = book.Title, book.Description
+
+	chapters := make([]*types.Chapter, 0, len(parsed.Chapters))
+	for i, imported := range parsed.Chapters {
+		chapter := &types.Chapter{
+			Number:  int64(i + 1),
+			Name:    shorten(report, "name of chapter "+strconv.Itoa(i+1), imported.Title, 20),
+			Content: imported.Content,
+			Format:  imported.Format,
+			Status:  types.StatusDraft,
+		}
+		if chapter.Name == "" {
+			chapter.Name = "Chapter " + strconv.Itoa(i+1)
+		}
+		chapters = append(chapters, chapter)
+		report.Chapters = append(report.Chapters, &types.ImportedChapter{
+			Number: chapter.Number,
+			Name:   chapter.Name,
+			Format: chapter.Format,
+			Length: utf8.RuneCountInString(markup.Text(chapter.Format, chapter.Content)),
+		})
+	}
+
+	cover := parsed.Cover
+	if cover != nil && cover.Extension == ".jpeg" {
+		cover.Extension = ".jpg"
+	}
+	if cover != nil && cover.Extension != ".jpg" && cover.Extension != ".png" && cover.Extension != ".gif" {
+		report.Warnings = append(report.Warnings, "the cover image is in an unsupported format and left out")
+		cover = nil
+	}
+	report.Cover = cover != nil
+	if options.DryRun {
+		return report, nil
+	}
+
+	if cover != nil {
+		book.Image, err = s.storeImage(bytes.NewReader(cover.Data), "cover"+cover.Extension)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = s.db.ImportBook(ctx, book, chapters)
+	if err != nil {
+		if book.Image != "" {
+			os.Remove(filepath.Join(s.imagesDirPath, book.Image))
+		}
+		return nil, err
+	}
+	report.BookId = book.ID
+	return report, nil
+}
+
+// validateImport checks the book like ValidateBook does, but names the
+// fields at fault.
+func (s *Service) validateImport(ctx context.Context, book *types.Book) error {
+	fields := make([]types.FieldError, 0)
+	if len(book.Title) > 20 || len(book.Title) < 1 {
+		fields = append(fields, types.FieldError{Field: "title", Reason: "invalid_length"})
+	}
+	if len(book.Description) > 250 || len(book.Description) < 5 {
+		fields = append(fields, types.FieldError{Field: "description", Reason: "invalid_length"})
+	}
+	_, err := s.db.GetGenreById(ctx, types.GenreID{Id: book.Genre})
+	if errors.Is(err, pgx.ErrNoRows) {
+		fields = append(fields, types.FieldError{Field: "genre", Reason: "not_found"})
+	} else if err != nil {
+		return err
+	}
+	if len(fields) != 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// shorten cuts a value taken from the imported file to at most max bytes on
+// a character boundary, and warns when it does.
+func shorten(report *types.ImportReport, field, value string, max int) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if len(value) <= max {
+		return value
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	shortened := strings.TrimSpace(value[:cut])
+	report.Warnings = append(report.Warnings, "the "+field+" is shortened to \""+shortened+"\"")
+	return shortened
+}
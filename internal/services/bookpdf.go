@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/markup"
+	"github.com/rustamfozilov/penhub/internal/pdf"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrPDFExportDisabled = errors.New("pdf export is not configured")
+
+const defaultPDFPageSize = pdf.A5
+
+// A render that takes longer than pdfExportTimeout is taken to have died
+// with its server and is started again, pdfExportAttempts times at most.
+const (
+	pdfExportTimeout  = time.Hour
+	pdfExportAttempts = 3
+)
+
+// RequestPDFExport queues a PDF of the book for its author. The file is
+// rendered in the background; the client polls GetPDFExport until it is
+// ready.
+func (s *Service) RequestPDFExport(ctx context.Context, userId int64, request *types.PDFExportRequest) (*types.BookPDFExport, error) {
+	if s.config.BookExport.PDF.RegularFont == "" {
+		return nil, ErrPDFExportDisabled
+	}
+	pageSize := request.PageSize
+	if pageSize == "" {
+		pageSize = s.pdfPageSize()
+	}
+	if !pdf.KnownPageSize(pageSize) {
+		return nil, &ValidationError{Fields: []types.FieldError{{Field: "page_size", Reason: "unknown_page_size"}}}
+	}
+	_, err := s.bookContents(ctx, request.BookId, userId)
+	if err != nil {
+		return nil, err
+	}
+	export := types.BookPDFExport{BookId: request.BookId, UserId: userId, PageSize: pageSize}
+	err = s.db.CreateBookPDFExport(ctx, &export)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		err := s.renderPDFExports(context.Background())
+		if err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}()
+	return &export, nil
+}
+
+func (s *Service) pdfPageSize() string {
+	if s.config.BookExport.PDF.PageSize != "" {
+		return s.config.BookExport.PDF.PageSize
+	}
+	return defaultPDFPageSize
+}
+
+// renderPDFExports renders pending exports until none is left. It runs
+// after every request and as a job, which picks up the exports of servers
+// that stopped while rendering.
+func (s *Service) renderPDFExports(ctx context.Context) error {
+	staleBefore := time.Now().Add(-pdfExportTimeout)
+	err := s.db.FailStaleBookPDFExports(ctx, staleBefore, pdfExportAttempts)
+	if err != nil {
+		return err
+	}
+	for {
+		export, err := s.db.ClaimBookPDFExport(ctx, staleBefore, pdfExportAttempts)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fileName := uuid.New().String() + ".pdf"
+		err = s.writePDFExport(ctx, export, fileName)
+		if err != nil {
+			log.Printf("pdf export %d: %+v\n", export.ID, err)
+			export.Status = types.ExportFailed
+		} else {
+			export.Status = types.ExportReady
+			export.FileName = fileName
+		}
+		err = s.db.FinishBookPDFExport(ctx, export)
+		if errors.Is(err, pgx.ErrNoRows) {
+			s.removeExportFiles(export.FileName)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writePDFExport renders the book as the user who asked for it may read it.
+// The file is written under a temporary name, so that it is never seen
+// half written.
+func (s *Service) writePDFExport(ctx context.Context, export *types.BookPDFExport, fileName string) error {
+	contents, err := s.bookContents(ctx, export.BookId, export.UserId)
+	if err != nil {
+		return err
+	}
+	fonts, err := s.pdfFonts()
+	if err != nil {
+		return err
+	}
+	book := &pdf.Book{
+		Title:    contents.book.Title,
+		Author:   contents.book.PenName,
+		Language: s.exportLanguage(),
+		PageSize: export.PageSize,
+		Contents: contentsTitle(s.exportLanguage()),
+		Fonts:    *fonts,
+	}
+	cover, _, _, ok := s.readCover(contents.book.Image, "image/jpeg", "image/png", "image/gif")
+	if ok {
+		book.Cover = cover
+	}
+	temp, err := os.CreateTemp(s.config.ExportsPath, ".book-*.tmp")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(temp.Name())
+	err = s.writePDF(ctx, temp, book, contents)
+	closeErr := temp.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return errors.WithStack(closeErr)
+	}
+	return errors.WithStack(os.Rename(temp.Name(), filepath.Join(s.config.ExportsPath, fileName)))
+}
+
+func (s *Service) writePDF(ctx context.Context, out io.Writer, book *pdf.Book, contents *bookContents) error {
+	w, err := pdf.NewWriter(out, book)
+	if err != nil {
+		return err
+	}
+	var current *part
+	err = s.streamChapters(ctx, contents, func(part *part, chapter *types.Chapter) error {
+		if part != current {
+			w.Part(part.title)
+			current = part
+		}
+		return w.Chapter(chapter.Name, markup.Blocks(chapter.Format, chapter.Content))
+	})
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// pdfFonts loads the configured fonts. They are read for every export, so
+// that replaced font files are picked up without a restart.
+func (s *Service) pdfFonts() (*pdf.Fonts, error) {
+	config := s.config.BookExport.PDF
+	var fonts pdf.Fonts
+	for _, item := range []struct {
+		path string
+		font **pdf.Font
+	}{
+		{config.RegularFont, &fonts.Regular},
+		{config.BoldFont, &fonts.Bold},
+		{config.ItalicFont, &fonts.Italic},
+		{config.BoldItalicFont, &fonts.BoldItalic},
+	} {
+		if item.path == "" {
+			continue
+		}
+		font, err := pdf.LoadFont(item.path)
+		if err != nil {
+			return nil, errors.WithMessage(err, item.path)
+		}
+		*item.font = font
+	}
+	return &fonts, nil
+}
+
+// contentsTitle is the heading of the table of contents in a language.
+func contentsTitle(language string) string {
+	switch strings.ToLower(strings.SplitN(language, "-", 2)[0]) {
+	case "ru":
+		return "Содержание"
+	case "uk":
+		return "Зміст"
+	default:
+		return "Contents"
+	}
+}
+
+func (s *Service) GetPDFExport(ctx context.Context, userId, exportId int64) (*types.BookPDFExport, error) {
+	export, err := s.db.GetBookPDFExport(ctx, exportId, userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return export, err
+}
+
+// OpenPDFExport opens a ready export together with its book, which names
+// the download.
+func (s *Service) OpenPDFExport(ctx context.Context, userId, exportId int64) (*os.File, *types.Book, error) {
+	export, err := s.GetPDFExport(ctx, userId, exportId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if export.Status != types.ExportReady {
+		return nil, nil, ErrExportNotReady
+	}
+	book, err := s.db.GetBook(ctx, export.BookId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(filepath.Join(s.config.ExportsPath, export.FileName))
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return file, book, nil
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"github.com/rustamfozilov/penhub/internal/db/dbtest"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"sync"
+	"testing"
+)
+
+// testService returns a service backed by a schema of its own and the
+// mailer that keeps the messages it sends.
+func testService(t *testing.T, config *types.Config) (*Service, *testMailer) {
+	t.Helper()
+	mailer := &testMailer{}
+	return NewService(dbtest.New(t), config, mailer), mailer
+}
+
+type testMail struct {
+	to, subject, body string
+}
+
+type testMailer struct {
+	mu   sync.Mutex
+	sent []testMail
+}
+
+func (m *testMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, testMail{to: to, subject: subject, body: body})
+	return nil
+}
+
+// last returns the latest message sent to address.
+func (m *testMailer) last(t *testing.T, address string) testMail {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].to == address {
+			return m.sent[i]
+		}
+	}
+	t.Fatalf("no mail sent to %s", address)
+	return testMail{}
+}
+
+// createTestUser adds an active user with a verified email.
+func createTestUser(t *testing.T, s *Service, login, email, password string) int64 {
+	t.Helper()
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dbtest.CreateUser(t, s.db, login, email, hash)
+}
+
+// createTestBook adds a published, readable book of userId under a pen name
+// of its own, and the genre it needs.
+func createTestBook(t *testing.T, s *Service, userId int64, title string) *types.Book {
+	t.Helper()
+	penName := &types.PenName{UserId: userId, Name: title + " author"}
+	err := s.CreatePenName(context.Background(), penName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := &types.Book{Title: title, AuthorId: userId, PenNameId: penName.ID, Description: "description",
+		Image: "cover.png", AccessRead: true, Downloadable: true}
+	err = s.db.Pool.QueryRow(context.Background(), `
+		insert into genres (name) values ('genre') returning id
+`).Scan(&book.Genre)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.CreateBook(context.Background(), book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return book
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"github.com/rustamfozilov/penhub/internal/markup"
+	"github.com/rustamfozilov/penhub/internal/types"
+)
+
+// ValidateFormat checks the source format of a chapter. An empty format
+// means plain text on write and no change on edit.
+func (s *Service) ValidateFormat(format string) error {
+	if format != "" && !markup.Known(format) {
+		return &ValidationError{Fields: []types.FieldError{{Field: "format", Reason: "unknown_format"}}}
+	}
+	return nil
+}
+
+func (s *Service) ValidateRender(render string) error {
+	switch render {
+	case "", types.RenderSource, types.RenderHTML, types.RenderText:
+		return nil
+	}
+	return &ValidationError{Fields: []types.FieldError{{Field: "render", Reason: "unknown_render"}}}
+}
+
+// render replaces the source of a chapter with the representation the
+// reader asked for. Format still names the source format.
+func render(chapter *types.Chapter, representation string) {
+	switch representation {
+	case types.RenderHTML:
+		chapter.Content = markup.Render(chapter.Format, chapter.Content)
+	case types.RenderText:
+		chapter.Content = markup.Text(chapter.Format, chapter.Content)
+	}
+}
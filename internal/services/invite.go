@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"log"
+	"net/url"
+	"time"
+)
+
+var ErrInvalidInvite = errors.New("invalid invite code")
+var ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+const emailVerificationLifetime = time.Hour * 48
+const inviteCodeBytes = 8
+
+func (s *Service) sendVerification(email, token string) error {
+	body := "Welcome to PenHub! Confirm your email address to activate the account:\n\n" +
+		s.config.BaseURL + "/verify-email?token=" + url.QueryEscape(token) + "\n\n" +
+		"The link is valid for two days."
+	err := s.mailer.Send(email, "Confirm your PenHub account", body)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	err := s.db.VerifyEmail(ctx, HashToken(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidVerificationToken
+	}
+	return err
+}
+
+func (s *Service) CreateInviteCode(ctx context.Context, invite *types.InviteCode) error {
+	buffer := make([]byte, inviteCodeBytes)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	invite.Code = hex.EncodeToString(buffer)
+	invite.Redemptions = make([]*types.InviteRedemption, 0)
+	return s.db.CreateInviteCode(ctx, invite)
+}
+
+func (s *Service) GetInviteCodes(ctx context.Context) ([]*types.InviteCode, error) {
+	return s.db.GetInviteCodes(ctx)
+}
+
+func (s *Service) ValidateInviteCode(invite *types.InviteCode) error {
+	if invite.MaxUses < 1 || invite.MaxUses > 1000 {
+		return ErrInvalidData
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const jobsInterval = time.Minute
+
+// RunJobs performs periodic background work until ctx is cancelled. Every
+// job must be safe to run on several server instances at once.
+func (s *Service) RunJobs(ctx context.Context) {
+	ticker := time.NewTicker(jobsInterval)
+	defer ticker.Stop()
+	for {
+		s.runJobs(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) runJobs(ctx context.Context) {
+	jobs := []struct {
+		name string
+		run  func(ctx context.Context) error
+	}{
+		{"scheduled publications", s.publishScheduled},
+		{"account deletions", s.processAccountDeletions},
+		{"revision pruning", s.pruneRevisions},
+		{"data exports", s.buildDataExports},
+		{"pdf exports", s.renderPDFExports},
+		{"expired exports", s.deleteExpiredExports},
+		{"expired oidc logins", s.db.DeleteExpiredOIDCLogins},
+	}
+	for _, job := range jobs {
+		err := job.run(ctx)
+		if err != nil {
+			log.Printf("%s: %+v\n", job.name, err)
+		}
+	}
+}
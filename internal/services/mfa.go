@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/totp"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"log"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCode = errors.New("invalid code")
+var ErrTOTPNotEnrolled = errors.New("two-factor authentication is not enrolled")
+var ErrTOTPEnabled = errors.New("two-factor authentication is enabled already")
+
+const totpIssuer = "PenHub"
+const mfaChallengeLifetime = time.Minute * 5
+const mfaChallengeAttempts = 5
+const recoveryCodesCount = 10
+
+// EnrollTOTP issues a new secret, which replaces nothing until ConfirmTOTP.
+// While two-factor authentication is on it has to be disabled, with a
+// code, before enrolling again.
+func (s *Service) EnrollTOTP(ctx context.Context, userId int64) (*types.TOTPEnrollment, error) {
+	login, err := s.db.GetUserLogin(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.SetPendingTOTPSecret(ctx, userId, secret)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTOTPEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &types.TOTPEnrollment{Secret: secret, URI: totp.URI(totpIssuer, login, secret)}, nil
+}
+
+// ConfirmTOTP turns two-factor authentication on once the first code from
+// the authenticator app matches, and hands out the recovery codes.
+func (s *Service) ConfirmTOTP(ctx context.Context, userId int64, code string) (*types.RecoveryCodes, error) {
+	state, err := s.db.GetTOTP(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if state.Enabled {
+		return nil, ErrTOTPEnabled
+	}
+	if state.PendingSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	step, ok := totp.Validate(state.PendingSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		code, err := makeRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, HashToken(normalizeRecoveryCode(code)))
+	}
+	err = s.db.EnableTOTP(ctx, userId, state.PendingSecret, step, hashes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Enrolled again or confirmed by another request meanwhile.
+		return nil, ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &types.RecoveryCodes{Codes: codes}, nil
+}
+
+// DisableTOTP turns two-factor authentication off with a current code.
+// Wrong codes count against the login throttle like wrong passwords.
+func (s *Service) DisableTOTP(ctx context.Context, userId int64, code, ip string) error {
+	login, err := s.db.GetUserLogin(ctx, userId)
+	if err != nil {
+		return err
+	}
+	err = s.checkLoginThrottle(ctx, login, ip)
+	if err != nil {
+		return err
+	}
+	ok, err := s.checkSecondFactor(ctx, userId, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.loginFailed(ctx, login, ip)
+		return ErrInvalidCode
+	}
+	return s.db.DisableTOTP(ctx, userId)
+}
+
+func (s *Service) newMFAChallenge(ctx context.Context, userId int64) (*types.T, error) {
+	token, err := s.MakeToken("")
+	if err != nil {
+		return nil, err
+	}
+	expire := time.Now().Add(mfaChallengeLifetime)
+	err = s.db.PutMFAChallenge(ctx, userId, HashToken(token), expire)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	return &types.T{MFAToken: token, Expire: expire}, nil
+}
+
+// CompleteMFA exchanges a pending challenge and a valid TOTP or recovery code
+// for a real session. Wrong codes count against the same login and IP
+// throttle as wrong passwords, which is only reset once the session is
+// issued.
+func (s *Service) CompleteMFA(ctx context.Context, verification *types.MFAVerification, session *types.Session) (*types.T, error) {
+	challengeHash := HashToken(verification.Token)
+	userId, err := s.db.AttemptMFAChallenge(ctx, challengeHash, mfaChallengeAttempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoAuthorization
+	}
+	if err != nil {
+		return nil, err
+	}
+	login, err := s.db.GetUserLogin(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkLoginThrottle(ctx, login, session.IP)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.checkSecondFactor(ctx, userId, verification.Code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.loginFailed(ctx, login, session.IP)
+		return nil, ErrInvalidCode
+	}
+	err = s.db.UseMFAChallenge(ctx, challengeHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoAuthorization
+	}
+	if err != nil {
+		return nil, err
+	}
+	session.UserId = userId
+	item, err := s.startSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	s.loginSucceeded(ctx, login)
+	return item, nil
+}
+
+func (s *Service) checkSecondFactor(ctx context.Context, userId int64, code string) (bool, error) {
+	state, err := s.db.GetTOTP(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	if !state.Enabled {
+		return false, ErrTOTPNotEnrolled
+	}
+	step, ok := totp.Validate(state.Secret, code, time.Now())
+	if ok {
+		return s.db.UseTOTPStep(ctx, userId, step)
+	}
+	return s.db.UseRecoveryCode(ctx, userId, HashToken(normalizeRecoveryCode(code)))
+}
+
+func makeRecoveryCode() (string, error) {
+	buffer := make([]byte, 5)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		log.Println(err)
+		return "", ErrInternal
+	}
+	code := hex.EncodeToString(buffer)
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/totp"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"testing"
+	"time"
+)
+
+// mfaTestService returns a service with a user who has confirmed TOTP, and
+// the user's secret.
+func mfaTestService(t *testing.T) (*Service, int64, string) {
+	t.Helper()
+	s, _ := testService(t, &types.Config{})
+	userId := createTestUser(t, s, "writer", "writer@example.com", "Old-Pass-1234-xyz")
+	enrollment, err := s.EnrollTOTP(context.Background(), userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ConfirmTOTP(context.Background(), userId, totpCode(t, enrollment.Secret, -1))
+	if err != nil {
+		t.Fatalf("ConfirmTOTP: %+v", err)
+	}
+	return s, userId, enrollment.Secret
+}
+
+// totpCode returns the code offset periods away from now.
+func totpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func completeMFA(s *Service, token, code string) error {
+	_, err := s.CompleteMFA(context.Background(), &types.MFAVerification{Token: token, Code: code}, &types.Session{IP: "192.0.2.1"})
+	return err
+}
+
+func TestCompleteMFAChallengeIsSingleUse(t *testing.T) {
+	s, userId, secret := mfaTestService(t)
+	challenge, err := s.newMFAChallenge(context.Background(), userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = completeMFA(s, challenge.MFAToken, totpCode(t, secret, 0))
+	if err != nil {
+		t.Fatalf("CompleteMFA: %+v", err)
+	}
+	err = completeMFA(s, challenge.MFAToken, totpCode(t, secret, 1))
+	if !errors.Is(err, ErrNoAuthorization) {
+		t.Errorf("spent challenge: error = %v, want ErrNoAuthorization", err)
+	}
+}
+
+// A code is accepted once, even with a fresh challenge.
+func TestCompleteMFACodeReuse(t *testing.T) {
+	s, userId, secret := mfaTestService(t)
+	code := totpCode(t, secret, 0)
+	first, err := s.newMFAChallenge(context.Background(), userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = completeMFA(s, first.MFAToken, code)
+	if err != nil {
+		t.Fatalf("CompleteMFA: %+v", err)
+	}
+	second, err := s.newMFAChallenge(context.Background(), userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = completeMFA(s, second.MFAToken, code)
+	if !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("reused code: error = %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestUseMFAChallenge(t *testing.T) {
+	s, userId, _ := mfaTestService(t)
+	ctx := context.Background()
+	err := s.db.PutMFAChallenge(ctx, userId, HashToken("spent"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.db.UseMFAChallenge(ctx, HashToken("spent"))
+	if err != nil {
+		t.Fatalf("UseMFAChallenge: %+v", err)
+	}
+	err = s.db.UseMFAChallenge(ctx, HashToken("spent"))
+	if err == nil {
+		t.Error("a spent challenge was used again")
+	}
+	err = s.db.PutMFAChallenge(ctx, userId, HashToken("expired"), time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.db.UseMFAChallenge(ctx, HashToken("expired"))
+	if err == nil {
+		t.Error("an expired challenge was used")
+	}
+	err = s.db.UseMFAChallenge(ctx, HashToken("unknown"))
+	if err == nil {
+		t.Error("an unknown challenge was used")
+	}
+}
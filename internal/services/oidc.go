@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/oidc"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"log"
+	"time"
+)
+
+var ErrOIDCDisabled = oidc.ErrDisabled
+var ErrOIDCState = errors.New("unknown or expired sign-in state")
+var ErrNoLinkedAccount = errors.New("no account is linked to this identity")
+var ErrIdentityLinked = errors.New("identity is linked to another account")
+
+const oidcLoginLifetime = time.Minute * 10
+
+// StartOIDCLogin begins the authorization code flow. With a non-zero userId
+// the identity the user signs in with is linked to that account.
+func (s *Service) StartOIDCLogin(ctx context.Context, userId int64) (*types.OIDCRedirect, error) {
+	if !s.oidc.Enabled() {
+		return nil, ErrOIDCDisabled
+	}
+	values := make([]string, 3)
+	for i := range values {
+		value, err := oidc.NewVerifier()
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+	url, err := s.oidc.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return nil, err
+	}
+	login := types.OIDCLogin{
+		State:        HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		UserId:       userId,
+		Expire:       time.Now().Add(oidcLoginLifetime),
+	}
+	err = s.db.PutOIDCLogin(ctx, &login)
+	if err != nil {
+		return nil, err
+	}
+	return &types.OIDCRedirect{URL: url}, nil
+}
+
+// CompleteOIDCLogin exchanges the code the provider sent back for a
+// verified ID token and signs in the account linked to it.
+func (s *Service) CompleteOIDCLogin(ctx context.Context, callback *types.OIDCCallback, session *types.Session) (*types.T, error) {
+	if !s.oidc.Enabled() {
+		return nil, ErrOIDCDisabled
+	}
+	login, err := s.db.UseOIDCLogin(ctx, HashToken(callback.State))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOIDCState
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(login.Expire) {
+		return nil, ErrOIDCState
+	}
+	rawToken, err := s.oidc.Exchange(ctx, callback.Code, login.CodeVerifier)
+	if err != nil {
+		log.Printf("%+v\n", err)
+		return nil, ErrNoAuthorization
+	}
+	claims, err := s.oidc.Verify(ctx, rawToken, login.Nonce)
+	if err != nil {
+		log.Printf("%+v\n", err)
+		return nil, ErrNoAuthorization
+	}
+	userId, err := s.identityUser(ctx, login.UserId, claims)
+	if err != nil {
+		return nil, err
+	}
+	totp, err := s.db.GetTOTP(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Enabled {
+		return s.newMFAChallenge(ctx, userId)
+	}
+	session.UserId = userId
+	return s.startSession(ctx, session)
+}
+
+// identityUser finds the account for an external identity. An unknown
+// identity is linked to linkTo when a signed in user started the flow, or
+// else to the account whose verified email the provider also vouches for.
+func (s *Service) identityUser(ctx context.Context, linkTo int64, claims *oidc.Claims) (int64, error) {
+	id, active, err := s.db.UserIdByIdentity(ctx, claims.Issuer, claims.Subject)
+	if err == nil {
+		if linkTo != 0 && linkTo != id {
+			return 0, ErrIdentityLinked
+		}
+		if !active {
+			return 0, ErrNoAuthorization
+		}
+		return id, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+	if linkTo == 0 {
+		if !claims.EmailVerified || claims.Email == "" {
+			return 0, ErrNoLinkedAccount
+		}
+		linkTo, err = s.db.UserIdByVerifiedEmail(ctx, claims.Email)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNoLinkedAccount
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	identity := types.Identity{UserId: linkTo, Issuer: claims.Issuer, Subject: claims.Subject, Email: claims.Email}
+	err = s.db.CreateIdentity(ctx, &identity)
+	if err != nil {
+		return 0, err
+	}
+	log.Println("identity linked:", identity.UserId, identity.Issuer)
+	return linkTo, nil
+}
+
+func (s *Service) GetIdentities(ctx context.Context, userId int64) ([]*types.Identity, error) {
+	return s.db.GetIdentities(ctx, userId)
+}
+
+func (s *Service) UnlinkIdentity(ctx context.Context, id, userId int64) error {
+	err := s.db.DeleteIdentity(ctx, id, userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/db/dbtest"
+	"github.com/rustamfozilov/penhub/internal/oidc"
+	"github.com/rustamfozilov/penhub/internal/oidc/oidctest"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/url"
+	"testing"
+)
+
+func oidcTestService(t *testing.T) (*Service, *oidctest.Issuer) {
+	t.Helper()
+	dbtest.URL(t)
+	issuer := oidctest.NewIssuer(t)
+	s, _ := testService(t, &types.Config{OIDC: oidc.Config{
+		Issuer:       issuer.URL,
+		ClientID:     oidctest.ClientID,
+		ClientSecret: oidctest.ClientSecret,
+		RedirectURL:  oidctest.RedirectURL,
+	}})
+	createTestUser(t, s, "reader", oidctest.Email, "a long enough password")
+	return s, issuer
+}
+
+// startOIDCLogin returns the callback the provider sends back once the user
+// signed in.
+func startOIDCLogin(t *testing.T, s *Service, issuer *oidctest.Issuer) *types.OIDCCallback {
+	t.Helper()
+	redirect, err := s.StartOIDCLogin(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := issuer.Authorize(redirect.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := url.Parse(redirect.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &types.OIDCCallback{Code: code, State: address.Query().Get("state")}
+}
+
+func TestOIDCStateIsSingleUse(t *testing.T) {
+	s, issuer := oidcTestService(t)
+	callback := startOIDCLogin(t, s, issuer)
+	item, err := s.CompleteOIDCLogin(context.Background(), callback, &types.Session{})
+	if err != nil {
+		t.Fatalf("CompleteOIDCLogin: %+v", err)
+	}
+	if item.Token == "" {
+		t.Fatal("no session token")
+	}
+	_, err = s.CompleteOIDCLogin(context.Background(), callback, &types.Session{})
+	if !errors.Is(err, ErrOIDCState) {
+		t.Errorf("replayed state: error = %v, want ErrOIDCState", err)
+	}
+}
+
+// A state the provider never saw, or one from a login that failed, does not
+// start a session.
+func TestOIDCUnknownState(t *testing.T) {
+	s, issuer := oidcTestService(t)
+	callback := startOIDCLogin(t, s, issuer)
+	forged := *callback
+	forged.State = "forged"
+	_, err := s.CompleteOIDCLogin(context.Background(), &forged, &types.Session{})
+	if !errors.Is(err, ErrOIDCState) {
+		t.Errorf("forged state: error = %v, want ErrOIDCState", err)
+	}
+
+	failed := *callback
+	failed.Code = "wrong code"
+	_, err = s.CompleteOIDCLogin(context.Background(), &failed, &types.Session{})
+	if !errors.Is(err, ErrNoAuthorization) {
+		t.Fatalf("wrong code: error = %v, want ErrNoAuthorization", err)
+	}
+	_, err = s.CompleteOIDCLogin(context.Background(), callback, &types.Session{})
+	if !errors.Is(err, ErrOIDCState) {
+		t.Errorf("state of a failed login: error = %v, want ErrOIDCState", err)
+	}
+}
+
+func TestOIDCExpiredState(t *testing.T) {
+	s, issuer := oidcTestService(t)
+	callback := startOIDCLogin(t, s, issuer)
+	_, err := s.db.Pool.Exec(context.Background(), `
+		update oidc_logins set expire = current_timestamp - interval '1 second'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.CompleteOIDCLogin(context.Background(), callback, &types.Session{})
+	if !errors.Is(err, ErrOIDCState) {
+		t.Errorf("expired state: error = %v, want ErrOIDCState", err)
+	}
+}
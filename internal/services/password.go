@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"log"
+	"net/url"
+	"time"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+const passwordResetLifetime = time.Hour
+
+// RequestPasswordReset mails a single-use reset token. Unknown addresses are
+// not reported, so the endpoint cannot be used to probe for accounts. With
+// email verification on, only verified addresses get the token: anyone can
+// sign up with an address that is not theirs.
+func (s *Service) RequestPasswordReset(ctx context.Context, email string) error {
+	userIdByEmail := s.db.UserIdByEmail
+	if s.config.VerifyEmail {
+		userIdByEmail = s.db.UserIdByVerifiedEmail
+	}
+	userId, err := userIdByEmail(ctx, email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	token, err := s.MakeToken("")
+	if err != nil {
+		return err
+	}
+	err = s.db.PutPasswordReset(ctx, userId, HashToken(token), time.Now().Add(passwordResetLifetime))
+	if err != nil {
+		return err
+	}
+	body := "To set a new password for your PenHub account, use this link within an hour:\n\n" +
+		s.config.BaseURL + "/reset-password?token=" + url.QueryEscape(token) + "\n\n" +
+		"If you did not ask for a password reset, ignore this message."
+	err = s.mailer.Send(email, "PenHub password reset", body)
+	if err != nil {
+		log.Println(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// ResetPassword sets the password of the user the token was mailed to. The
+// password is checked against the policy before the token is used up.
+func (s *Service) ResetPassword(ctx context.Context, token, password string) error {
+	userId, err := s.db.PasswordResetUser(ctx, HashToken(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	err = s.ValidatePassword(ctx, userId, password)
+	if err != nil {
+		return err
+	}
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	err = s.db.ResetPassword(ctx, HashToken(token), hash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidResetToken
+	}
+	return err
+}
+
+// ValidateLoginAndPassword returns the id of the account when the password
+// matches. A hash made with an outdated scheme or parameters is replaced
+// while the plaintext is at hand.
+func (s *Service) ValidateLoginAndPassword(ctx context.Context, login, password string) (int64, error) {
+	id, hash, err := s.db.GetPasswordHash(ctx, login)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNoSuchUser
+	}
+	if err != nil {
+		return 0, err
+	}
+	ok, rehash, err := s.hasher.Verify(hash, password)
+	if err != nil {
+		log.Println(err)
+		return 0, ErrInvalidPassword
+	}
+	if !ok {
+		return 0, ErrInvalidPassword
+	}
+	if rehash {
+		newHash, err := s.hasher.Hash(password)
+		if err == nil {
+			err = s.db.UpdatePasswordHash(ctx, id, newHash)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return id, nil
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+const (
+	resetEmail       = "writer@example.com"
+	resetOldPassword = "Old-Pass-1234-xyz"
+	resetNewPassword = "New-Pass-5678-qrs"
+)
+
+var resetLink = regexp.MustCompile(`token=(\S+)`)
+
+func resetTestService(t *testing.T) (*Service, *testMailer, int64) {
+	t.Helper()
+	s, mailer := testService(t, &types.Config{BaseURL: "https://penhub.example"})
+	userId := createTestUser(t, s, "writer", resetEmail, resetOldPassword)
+	return s, mailer, userId
+}
+
+// requestReset asks for a reset and returns the token from the mail.
+func requestReset(t *testing.T, s *Service, mailer *testMailer) string {
+	t.Helper()
+	err := s.RequestPasswordReset(context.Background(), resetEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := resetLink.FindStringSubmatch(mailer.last(t, resetEmail).body)
+	if match == nil {
+		t.Fatal("no reset link in the mail")
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func checkPassword(t *testing.T, s *Service, password string, want bool) {
+	t.Helper()
+	_, err := s.ValidateLoginAndPassword(context.Background(), "writer", password)
+	if want && err != nil {
+		t.Errorf("password %q rejected: %v", password, err)
+	}
+	if !want && !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("password %q: error = %v, want ErrInvalidPassword", password, err)
+	}
+}
+
+func TestPasswordResetIsSingleUse(t *testing.T) {
+	s, mailer, _ := resetTestService(t)
+	token := requestReset(t, s, mailer)
+	err := s.ResetPassword(context.Background(), token, resetNewPassword)
+	if err != nil {
+		t.Fatalf("ResetPassword: %+v", err)
+	}
+	checkPassword(t, s, resetNewPassword, true)
+	checkPassword(t, s, resetOldPassword, false)
+
+	err = s.ResetPassword(context.Background(), token, "Third-Pass-9012-abc")
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("second use: error = %v, want ErrInvalidResetToken", err)
+	}
+	checkPassword(t, s, resetNewPassword, true)
+}
+
+func TestPasswordResetExpires(t *testing.T) {
+	s, mailer, _ := resetTestService(t)
+	token := requestReset(t, s, mailer)
+	_, err := s.db.Pool.Exec(context.Background(), `
+		update password_resets set expire = current_timestamp - interval '1 second'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.ResetPassword(context.Background(), token, resetNewPassword)
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("expired token: error = %v, want ErrInvalidResetToken", err)
+	}
+	checkPassword(t, s, resetOldPassword, true)
+}
+
+func TestPasswordResetUnknownToken(t *testing.T) {
+	s, mailer, _ := resetTestService(t)
+	requestReset(t, s, mailer)
+	err := s.ResetPassword(context.Background(), "forged", resetNewPassword)
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("forged token: error = %v, want ErrInvalidResetToken", err)
+	}
+	checkPassword(t, s, resetOldPassword, true)
+}
+
+// A password the policy rejects leaves the token for another try.
+func TestPasswordResetKeepsTokenForRejectedPassword(t *testing.T) {
+	s, mailer, _ := resetTestService(t)
+	token := requestReset(t, s, mailer)
+	for _, password := range []string{"short", "writer-Pass-1234"} {
+		err := s.ResetPassword(context.Background(), token, password)
+		if !errors.Is(err, ErrInvalidData) {
+			t.Errorf("password %q: error = %v, want ErrInvalidData", password, err)
+		}
+	}
+	err := s.ResetPassword(context.Background(), token, resetNewPassword)
+	if err != nil {
+		t.Fatalf("ResetPassword: %+v", err)
+	}
+	checkPassword(t, s, resetNewPassword, true)
+}
+
+func TestPasswordResetRevokesSessions(t *testing.T) {
+	s, mailer, userId := resetTestService(t)
+	tokens := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		item, err := s.startSession(context.Background(), &types.Session{UserId: userId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, item.Token)
+	}
+	resetToken := requestReset(t, s, mailer)
+	err := s.ResetPassword(context.Background(), resetToken, resetNewPassword)
+	if err != nil {
+		t.Fatalf("ResetPassword: %+v", err)
+	}
+	for _, token := range tokens {
+		_, err := s.IdByToken(context.Background(), token)
+		if !errors.Is(err, ErrNoAuthorization) {
+			t.Errorf("session after reset: error = %v, want ErrNoAuthorization", err)
+		}
+	}
+	var active int
+	err = s.db.Pool.QueryRow(context.Background(), `
+		select count(*) from users_tokens where user_id = $1 and revoked = false
+`, userId).Scan(&active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active != 0 {
+		t.Errorf("%d sessions left unrevoked", active)
+	}
+}
+
+// Unknown addresses get no mail and no error, so accounts cannot be probed.
+func TestPasswordResetUnknownEmail(t *testing.T) {
+	s, mailer, _ := resetTestService(t)
+	err := s.RequestPasswordReset(context.Background(), "nobody@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sent) != 0 {
+		t.Errorf("%d mails sent for an unknown address", len(mailer.sent))
+	}
+}
+
+// With verification on, an address that was never verified gets no reset.
+func TestPasswordResetUnverifiedEmail(t *testing.T) {
+	s, mailer := testService(t, &types.Config{BaseURL: "https://penhub.example", VerifyEmail: true})
+	userId := createTestUser(t, s, "writer", resetEmail, resetOldPassword)
+	_, err := s.db.Pool.Exec(context.Background(), `
+		update users set email_verified = false where id = $1
+`, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.RequestPasswordReset(context.Background(), resetEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sent) != 0 {
+		t.Errorf("%d mails sent to an unverified address", len(mailer.sent))
+	}
+
+	_, err = s.db.Pool.Exec(context.Background(), `
+		update users set email_verified = true where id = $1
+`, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestReset(t, s, mailer)
+}
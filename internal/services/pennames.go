@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"unicode/utf8"
+)
+
+func (s *Service) CreatePenName(ctx context.Context, penName *types.PenName) error {
+	return s.db.CreatePenName(ctx, penName)
+}
+
+func (s *Service) EditPenName(ctx context.Context, edit *types.PenNameEdit) error {
+	return s.db.EditPenName(ctx, edit)
+}
+
+func (s *Service) GetPenNamesByUserId(ctx context.Context, userId int64) ([]*types.PenName, error) {
+	return s.db.GetPenNamesByUserId(ctx, userId)
+}
+
+func (s *Service) GetBooksByPenName(ctx context.Context, penNameId *types.PenNameId, viewerId int64) ([]*types.Book, error) {
+	return s.db.GetBooksByPenName(ctx, penNameId, viewerId)
+}
+
+// hideAccount removes the owning account from a pen name readers see, unless
+// the owner chose to link them.
+func hideAccount(penName *types.PenName) {
+	if !penName.Linked {
+		penName.UserId = 0
+	}
+}
+
+func (s *Service) ValidatePenName(penName *types.PenName) error {
+	return validatePenName(&penName.Name, &penName.Bio)
+}
+
+// ValidatePenNameEdit checks the fields an edit sets.
+func (s *Service) ValidatePenNameEdit(edit *types.PenNameEdit) error {
+	var name *string
+	if edit.Name != "" {
+		name = &edit.Name
+	}
+	return validatePenName(name, edit.Bio)
+}
+
+// validatePenName checks the fields that are not nil.
+func validatePenName(name, bio *string) error {
+	fields := make([]types.FieldError, 0)
+	if name != nil {
+		length := utf8.RuneCountInString(*name)
+		if length > 40 || length < 2 {
+			fields = append(fields, types.FieldError{Field: "name", Reason: "invalid_length"})
+		}
+	}
+	if bio != nil && utf8.RuneCountInString(*bio) > 1000 {
+		fields = append(fields, types.FieldError{Field: "bio", Reason: "invalid_length"})
+	}
+	if len(fields) != 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
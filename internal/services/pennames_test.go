@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"strings"
+	"testing"
+)
+
+func getTestPenName(t *testing.T, s *Service, userId, id int64) *types.PenName {
+	t.Helper()
+	penNames, err := s.GetPenNamesByUserId(context.Background(), userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, penName := range penNames {
+		if penName.ID == id {
+			return penName
+		}
+	}
+	t.Fatalf("no pen name %d", id)
+	return nil
+}
+
+// An edit that leaves out linked and active keeps them.
+func TestEditPenNameKeepsFieldsLeftOut(t *testing.T) {
+	s, _ := testService(t, &types.Config{})
+	userId := createTestUser(t, s, "writer", "writer@example.com", "Old-Pass-1234-xyz")
+	book := createTestBook(t, s, userId, "Title")
+	linked := true
+	err := s.EditPenName(context.Background(), &types.PenNameEdit{ID: book.PenNameId, Linked: &linked})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bio := "New bio"
+	err = s.EditPenName(context.Background(), &types.PenNameEdit{ID: book.PenNameId, Bio: &bio})
+	if err != nil {
+		t.Fatal(err)
+	}
+	penName := getTestPenName(t, s, userId, book.PenNameId)
+	if penName.Bio != bio || penName.Name != "Title author" || !penName.Linked || !penName.Active {
+		t.Errorf("pen name after a bio edit: %+v", penName)
+	}
+
+	inactive := false
+	err = s.EditPenName(context.Background(), &types.PenNameEdit{ID: book.PenNameId, Active: &inactive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	penName = getTestPenName(t, s, userId, book.PenNameId)
+	if penName.Active || !penName.Linked || penName.Bio != bio {
+		t.Errorf("pen name after deactivation: %+v", penName)
+	}
+}
+
+// Books under pen names the author has not linked stay out of the stats
+// on the author's page.
+func TestAuthorPageCountsLinkedPenNames(t *testing.T) {
+	s, _ := testService(t, &types.Config{})
+	userId := createTestUser(t, s, "writer", "writer@example.com", "Old-Pass-1234-xyz")
+	public := createTestBook(t, s, userId, "Public")
+	createTestBook(t, s, userId, "Secret")
+	linked := true
+	err := s.EditPenName(context.Background(), &types.PenNameEdit{ID: public.PenNameId, Linked: &linked})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := s.GetAuthorPage(context.Background(), userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.BookCount != 1 {
+		t.Errorf("book count = %d, want 1", page.BookCount)
+	}
+}
+
+func TestValidatePenNameEdit(t *testing.T) {
+	s := &Service{}
+	long := strings.Repeat("ж", 1001)
+	tests := []struct {
+		name string
+		edit types.PenNameEdit
+		ok   bool
+	}{
+		{"nothing set", types.PenNameEdit{ID: 1}, true},
+		{"name", types.PenNameEdit{ID: 1, Name: "Шляпник"}, true},
+		{"short name", types.PenNameEdit{ID: 1, Name: "Ш"}, false},
+		{"empty bio", types.PenNameEdit{ID: 1, Bio: new(string)}, true},
+		{"long bio", types.PenNameEdit{ID: 1, Bio: &long}, false},
+	}
+	for _, tt := range tests {
+		err := s.ValidatePenNameEdit(&tt.edit)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error = %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/policy"
+)
+
+// Authorize checks whether the user may perform action on the object with
+// the given id: a book id for book actions, a chapter id for chapter actions,
+// a volume id for EditVolume, a like id for DeleteLike and a pen name id
+// for ManagePenName.
+func (s *Service) Authorize(ctx context.Context, userId int64, action policy.Action, id int64) error {
+	role, err := s.RoleById(ctx, userId)
+	if err != nil {
+		return err
+	}
+	subject := policy.Subject{UserId: userId, Role: role}
+
+	var resource *policy.Resource
+	switch action {
+	case policy.ReadBook, policy.EditBook, policy.ExportBook:
+		resource, err = s.db.BookResource(ctx, id)
+	case policy.ReadChapter, policy.EditChapter:
+		resource, err = s.db.ChapterResource(ctx, id)
+	case policy.EditVolume:
+		resource, err = s.db.VolumeResource(ctx, id)
+	case policy.DeleteLike:
+		resource, err = s.db.RatingResource(ctx, id)
+	case policy.ManagePenName:
+		resource, err = s.db.PenNameResource(ctx, id)
+	default:
+		resource = &policy.Resource{}
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !policy.Allowed(subject, action, *resource) {
+		return ErrForbidden
+	}
+	return nil
+}
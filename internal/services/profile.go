@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"io"
+	"net/url"
+	"unicode/utf8"
+)
+
+const maxProfileLinks = 5
+
+func (s *Service) GetProfile(ctx context.Context, userId int64) (*types.Profile, error) {
+	profile, err := s.db.GetProfile(ctx, userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return profile, err
+}
+
+func (s *Service) EditProfile(ctx context.Context, profile *types.Profile) error {
+	if profile.Links == nil {
+		profile.Links = make([]string, 0)
+	}
+	return s.db.EditProfile(ctx, profile)
+}
+
+func (s *Service) EditAvatar(ctx context.Context, userId int64, file io.Reader, fileName string) error {
+	imageName, err := s.storeImage(file, fileName)
+	if err != nil {
+		return err
+	}
+	return s.db.EditAvatar(ctx, userId, imageName)
+}
+
+func (s *Service) GetAuthorPage(ctx context.Context, authorId int64) (*types.AuthorPage, error) {
+	profile, err := s.GetProfile(ctx, authorId)
+	if err != nil {
+		return nil, err
+	}
+	books, likes, err := s.db.AuthorStats(ctx, authorId)
+	if err != nil {
+		return nil, err
+	}
+	return &types.AuthorPage{Profile: *profile, BookCount: books, Likes: likes}, nil
+}
+
+// ChangePassword keeps the session identified by token and signs out all others.
+func (s *Service) ChangePassword(ctx context.Context, userId int64, token string, change *types.PasswordChange) error {
+	hash, err := s.db.GetPasswordHashById(ctx, userId)
+	if err != nil {
+		return err
+	}
+	ok, _, err := s.hasher.Verify(hash, change.OldPassword)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidPassword
+	}
+	newHash, err := s.hasher.Hash(change.NewPassword)
+	if err != nil {
+		return err
+	}
+	return s.db.ChangePassword(ctx, userId, newHash, HashToken(token))
+}
+
+func (s *Service) ValidateProfile(profile *types.Profile) error {
+	fields := make([]types.FieldError, 0)
+	if len(profile.Name) > 20 || len(profile.Name) < 3 {
+		fields = append(fields, types.FieldError{Field: "name", Reason: "invalid_length"})
+	}
+	if utf8.RuneCountInString(profile.Bio) > 1000 {
+		fields = append(fields, types.FieldError{Field: "bio", Reason: "invalid_length"})
+	}
+	if utf8.RuneCountInString(profile.Location) > 100 {
+		fields = append(fields, types.FieldError{Field: "location", Reason: "invalid_length"})
+	}
+	if len(profile.Links) > maxProfileLinks {
+		fields = append(fields, types.FieldError{Field: "links", Reason: "too_many"})
+	}
+	for _, link := range profile.Links {
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(link) > 200 {
+			fields = append(fields, types.FieldError{Field: "links", Reason: "invalid_url"})
+			break
+		}
+	}
+	if len(fields) != 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"log"
+	"time"
+)
+
+// ValidatePublication checks a requested publication state. An empty status
+// means published, so clients unaware of drafts keep publishing at once.
+func (s *Service) ValidatePublication(status string, publishAt *time.Time) error {
+	fields := make([]types.FieldError, 0)
+	switch status {
+	case "", types.StatusDraft, types.StatusPublished:
+	case types.StatusScheduled:
+		if publishAt == nil {
+			fields = append(fields, types.FieldError{Field: "publish_at", Reason: "required"})
+		} else if !publishAt.After(time.Now()) {
+			fields = append(fields, types.FieldError{Field: "publish_at", Reason: "not_in_future"})
+		}
+	default:
+		fields = append(fields, types.FieldError{Field: "status", Reason: "unknown_status"})
+	}
+	if len(fields) != 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// publication normalizes a validated state: published items are stamped
+// with the current time and drafts carry no publication time.
+func publication(status string, publishAt *time.Time) (string, *time.Time) {
+	switch status {
+	case types.StatusDraft:
+		return status, nil
+	case types.StatusScheduled:
+		return status, publishAt
+	}
+	now := time.Now()
+	return types.StatusPublished, &now
+}
+
+func (s *Service) publishScheduled(ctx context.Context) error {
+	books, chapters, err := s.db.PublishScheduled(ctx)
+	if err != nil {
+		return err
+	}
+	if books != 0 || chapters != 0 {
+		log.Printf("published %d scheduled books and %d chapters\n", books, chapters)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/diff"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"log"
+)
+
+var defaultRevisionRetention = types.RevisionRetentionConfig{
+	KeepLast: 50,
+	KeepDays: 90,
+}
+
+func (s *Service) revisionRetention() types.RevisionRetentionConfig {
+	config := s.config.RevisionRetention
+	if config.KeepLast == 0 {
+		config.KeepLast = defaultRevisionRetention.KeepLast
+	}
+	if config.KeepDays == 0 {
+		config.KeepDays = defaultRevisionRetention.KeepDays
+	}
+	return config
+}
+
+func (s *Service) GetRevisions(ctx context.Context, chapterId int64) ([]*types.Revision, error) {
+	return s.db.GetRevisions(ctx, chapterId)
+}
+
+func (s *Service) GetRevision(ctx context.Context, revisionId *types.RevisionId) (*types.Revision, error) {
+	revision, err := s.db.GetRevision(ctx, revisionId.ChapterId, revisionId.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return revision, err
+}
+
+func (s *Service) DiffRevisions(ctx context.Context, request *types.DiffRequest) (*types.RevisionDiff, error) {
+	from, err := s.GetRevision(ctx, &types.RevisionId{ChapterId: request.ChapterId, ID: request.From})
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.GetRevision(ctx, &types.RevisionId{ChapterId: request.ChapterId, ID: request.To})
+	if err != nil {
+		return nil, err
+	}
+	compare := diff.Lines
+	if request.Mode == "word" {
+		compare = diff.Words
+	}
+	return &types.RevisionDiff{
+		From:    from.ID,
+		To:      to.ID,
+		Name:    diff.Words(from.Name, to.Name),
+		Content: compare(from.Content, to.Content),
+	}, nil
+}
+
+func (s *Service) RestoreRevision(ctx context.Context, revisionId *types.RevisionId, authorId int64) (*types.Revision, error) {
+	revision, err := s.db.RestoreRevision(ctx, revisionId.ChapterId, revisionId.ID, authorId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return revision, err
+}
+
+func (s *Service) ValidateDiff(request *types.DiffRequest) error {
+	if request.Mode != "" && request.Mode != "line" && request.Mode != "word" {
+		return &ValidationError{Fields: []types.FieldError{{Field: "mode", Reason: "unknown_mode"}}}
+	}
+	return nil
+}
+
+func (s *Service) pruneRevisions(ctx context.Context) error {
+	retention := s.revisionRetention()
+	pruned, err := s.db.PruneRevisions(ctx, retention.KeepLast, retention.KeepDays)
+	if err != nil {
+		return err
+	}
+	if pruned != 0 {
+		log.Println("pruned chapter revisions:", pruned)
+	}
+	return nil
+}
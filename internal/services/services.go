@@ -5,25 +5,41 @@ import (
 	"github.com/google/uuid"
 	errors "github.com/pkg/errors"
 	"github.com/rustamfozilov/penhub/internal/db"
+	"github.com/rustamfozilov/penhub/internal/mail"
+	"github.com/rustamfozilov/penhub/internal/markup"
+	"github.com/rustamfozilov/penhub/internal/oidc"
+	"github.com/rustamfozilov/penhub/internal/password"
 	"github.com/rustamfozilov/penhub/internal/types"
-	"golang.org/x/crypto/bcrypt"
 	"io"
+	"log"
+	netmail "net/mail"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type Service struct {
 	db            *db.DB
+	config        *types.Config
+	mailer        mail.Mailer
+	hasher        password.Chain
+	oidc          *oidc.Provider
 	imagesDirPath string
 }
 
-func NewService(db *db.DB, imagesDirPath string) *Service {
-	return &Service{db: db, imagesDirPath: imagesDirPath}
+func NewService(db *db.DB, config *types.Config, mailer mail.Mailer) *Service {
+	return &Service{
+		db:            db,
+		config:        config,
+		mailer:        mailer,
+		hasher:        password.NewChain(config.PasswordHashing),
+		oidc:          oidc.NewProvider(config.OIDC, nil),
+		imagesDirPath: config.ImagesPath,
+	}
 }
 
-
-
 var ErrExpired = errors.New("token expired")
 var ErrInternal = errors.New("internal error")
 var ErrLoginUsed = errors.New("login already registered")
@@ -31,8 +47,30 @@ var ErrNoSuchUser = errors.New("no such user")
 var ErrInvalidPassword = errors.New("invalid password")
 var ErrNotFound = errors.New("not found")
 var ErrInvalidData = errors.New("invalid data")
+var ErrInvalidPosition = errors.New("invalid chapter position")
+var ErrNumberTaken = errors.New("chapter number already taken")
+var ErrInvalidOrder = errors.New("order must list every chapter of the book once")
+
+// ValidationError lists every invalid field of a request. It matches
+// ErrInvalidData for callers that only need to know the data was rejected.
+type ValidationError struct {
+	Fields []types.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		reasons = append(reasons, field.Field+": "+field.Reason)
+	}
+	return ErrInvalidData.Error() + ": " + strings.Join(reasons, ", ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidData
+}
 
 func (s *Service) CreateBook(ctx context.Context, book *types.Book) error {
+	book.Status, book.PublishAt = publication(book.Status, book.PublishAt)
 	return s.db.CreateBook(ctx, book)
 }
 
@@ -40,15 +78,39 @@ func (s *Service) RegistrationUser(ctx context.Context, user *types.User) error
 	if s.db.IsLoginUsed(ctx, user.Login) {
 		return ErrLoginUsed
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if s.config.InviteOnly && user.InviteCode == "" {
+		return ErrInvalidInvite
+	}
+	if s.config.VerifyEmail && user.Email == "" {
+		return ErrInvalidData
+	}
+	hash, err := s.hasher.Hash(user.Password)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
+	}
+	var token string
+	var verification *types.VerificationToken
+	if s.config.VerifyEmail {
+		token, err = s.MakeToken(token)
+		if err != nil {
+			return err
+		}
+		verification = &types.VerificationToken{
+			TokenHash: HashToken(token),
+			Expire:    time.Now().Add(emailVerificationLifetime),
+		}
+	}
+	err = s.db.RegistrationUser(ctx, user, hash, verification)
+	if errors.Is(err, db.ErrInvalidInvite) {
+		return ErrInvalidInvite
 	}
-	err = s.db.RegistrationUser(ctx, user, hash)
 	if err != nil {
 		err := errors.WithStack(err)
 		return err
 	}
+	if verification != nil {
+		return s.sendVerification(user.Email, token)
+	}
 	return nil
 }
 
@@ -56,46 +118,50 @@ func (s *Service) GetBookId(ctx context.Context, bookName *types.BookTitle) (id
 	return s.db.GetBookId(ctx, bookName.Title)
 }
 
-func (s *Service) HaveAccessToEditBook(ctx context.Context, userId, bookId int64) (bool, error) {
-
-	return s.db.BookAccess(ctx, userId, bookId)
+func (s *Service) WriteChapter(ctx context.Context, chapter *types.Chapter) error {
+	chapter.Status, chapter.PublishAt = publication(chapter.Status, chapter.PublishAt)
+	if chapter.Format == "" {
+		chapter.Format = markup.Plain
+	}
+	if chapter.VolumeId != nil && *chapter.VolumeId == 0 {
+		chapter.VolumeId = nil
+	}
+	err := s.db.WriteChapter(ctx, chapter)
+	if errors.Is(err, db.ErrInvalidVolume) {
+		return ErrInvalidVolume
+	}
+	if errors.Is(err, db.ErrInvalidPosition) {
+		return ErrInvalidPosition
+	}
+	if errors.Is(err, db.ErrNumberTaken) {
+		return ErrNumberTaken
+	}
+	return err
 }
 
-func (s *Service) WriteChapter(ctx context.Context, chapter *types.Chapter) error {
-	return s.db.WriteChapter(ctx, chapter)
+func (s *Service) ReorderChapters(ctx context.Context, order *types.ChapterOrder) error {
+	err := s.db.ReorderChapters(ctx, order)
+	if errors.Is(err, db.ErrInvalidOrder) {
+		return ErrInvalidOrder
+	}
+	return err
 }
 
 func (s *Service) GetBooksById(ctx context.Context, id *types.AuthorId) ([]*types.Book, error) {
 	return s.db.GetBooksById(ctx, id)
 }
 
-func (s *Service) GetChaptersByBookId(ctx context.Context, bookId *types.BookId) ([]*types.Chapter, error) {
-	return s.db.GetChaptersByBookId(ctx, bookId.Id)
-}
-
-func (s *Service) ReadChapter(ctx context.Context, chapterId *types.ChapterId) (*types.Chapter, error) {
-	return s.db.ReadChapter(ctx, chapterId.Id)
-
-}
-
-func (s *Service) EditTitle(ctx context.Context, edit *types.Book) error {
-	return s.db.EditTitle(ctx, edit.ID, edit.Title)
-}
-
-func (s *Service) EditContent(ctx context.Context, edit *types.Chapter) error {
-	return s.db.EditContent(ctx, edit)
+func (s *Service) GetChaptersByBookId(ctx context.Context, bookId *types.BookId, viewerId int64) ([]*types.Chapter, error) {
+	return s.db.GetChaptersByBookId(ctx, bookId.Id, viewerId)
 }
 
-func (s *Service) EditAccess(ctx context.Context, edit *types.Book) error {
-	return s.db.EditAccess(ctx, edit)
-}
-
-func (s *Service) EditChapterName(ctx context.Context, edit *types.Chapter) error {
-	return s.db.EditChapterName(ctx, edit)
-}
-
-func (s *Service) EditChapterNumber(ctx context.Context, edit *types.Chapter) error {
-	return s.db.EditChapterNumber(ctx, edit)
+func (s *Service) ReadChapter(ctx context.Context, read *types.ChapterRead, viewerId int64) (*types.Chapter, error) {
+	chapter, err := s.db.ReadChapter(ctx, read.Id, viewerId)
+	if err != nil {
+		return nil, err
+	}
+	render(chapter, read.Render)
+	return chapter, nil
 }
 
 func (s *Service) SearchByTitle(ctx context.Context, title *types.BookTitle) ([]*types.Book, error) {
@@ -109,8 +175,15 @@ func (s *Service) SearchByTitle(ctx context.Context, title *types.BookTitle) ([]
 	return books, nil
 }
 
-func (s *Service) SearchByAuthor(ctx context.Context, author *types.AuthorName) ([]*types.User, error) {
-	return s.db.SearchByAuthor(ctx, author)
+func (s *Service) SearchByAuthor(ctx context.Context, author *types.AuthorName) ([]*types.PenName, error) {
+	penNames, err := s.db.SearchByAuthor(ctx, author)
+	if err != nil {
+		return nil, err
+	}
+	for _, penName := range penNames {
+		hideAccount(penName)
+	}
+	return penNames, nil
 }
 
 func (s *Service) GetAllGenres(ctx context.Context) ([]*types.Genre, error) {
@@ -130,28 +203,44 @@ func (s *Service) GetGenreById(ctx context.Context, genreId types.GenreID) (*typ
 }
 
 func (s *Service) SaveImage(file io.Reader, fileName string, book *types.Book) (*types.Book, error) {
+	imageName, err := s.storeImage(file, fileName)
+	if err != nil {
+		return nil, err
+	}
+	book.Image = imageName
+	return book, nil
+}
 
+func (s *Service) storeImage(file io.Reader, fileName string) (string, error) {
 	extension := fileName[len(fileName)-4:]
 	imageName := uuid.New().String()
 	path := filepath.Join(s.imagesDirPath, imageName+extension)
 	imageFile, err := os.Create(path)
 	if err != nil {
 		err := errors.WithStack(err)
-		return nil, err
+		return "", err
 	}
 	defer imageFile.Close()
 
 	_, err = io.Copy(imageFile, file)
 	if err != nil {
 		err := errors.WithStack(err)
-		return nil, err
+		return "", err
+	}
+	return imageName + extension, nil
+}
+
+// removeImages deletes stored images that nothing refers to any more.
+func (s *Service) removeImages(names ...string) {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		err := os.Remove(filepath.Join(s.imagesDirPath, filepath.Base(name)))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("%+v\n", errors.WithStack(err))
+		}
 	}
-	book.Image = imageName + extension
-	return book, nil
-}
-
-func (s *Service) EditImage(ctx context.Context, book *types.Book) error {
-	return s.db.EditImageName(ctx, book)
 }
 
 func (s *Service) GetImageByName(name string) ([]byte, error) {
@@ -164,12 +253,17 @@ func (s *Service) GetImageByName(name string) ([]byte, error) {
 	return file, nil
 }
 
-func (s *Service) EditGenre(ctx context.Context, book *types.Book) error {
-	return s.db.EditGenre(ctx, book)
-}
-
-func (s *Service) EditDescription(ctx context.Context, book *types.Book) error {
-	return s.db.EditDescription(ctx, book)
+// BookIdByImage returns the book a cover image belongs to, which decides
+// who may see it. Avatars are public and give zero.
+func (s *Service) BookIdByImage(ctx context.Context, name string) (int64, error) {
+	bookId, avatar, err := s.db.ImageOwner(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	if bookId == 0 && !avatar {
+		return 0, ErrNotFound
+	}
+	return bookId, nil
 }
 
 func (s *Service) DeleteBook(ctx context.Context, book *types.Book) error {
@@ -196,48 +290,67 @@ func (s *Service) BookLikes(ctx context.Context, id *types.BookId) (int64, error
 }
 
 func (s *Service) ValidateUser(user *types.User) error {
+	fields := make([]types.FieldError, 0)
 	if len(user.Name) > 20 || len(user.Name) < 3 {
-		return ErrInvalidData
+		fields = append(fields, types.FieldError{Field: "name", Reason: "invalid_length"})
 	}
 	if len(user.Login) > 20 || len(user.Login) < 3 {
-		return ErrInvalidData
+		fields = append(fields, types.FieldError{Field: "login", Reason: "invalid_length"})
 	}
-	if len(user.Password) > 20 || len(user.Password) < 6 {
-		return ErrInvalidData
+	if user.Email != "" && s.ValidateEmail(user.Email) != nil {
+		fields = append(fields, types.FieldError{Field: "email", Reason: "invalid_format"})
 	}
-	if strings.Contains(user.Password, "_") || strings.Contains(user.Password, "-") {
-		return ErrInvalidData
+	for _, reason := range s.passwordPolicy().Check(user.Password, user.Login, user.Name, user.Email) {
+		fields = append(fields, types.FieldError{Field: "password", Reason: reason})
 	}
-	if strings.Contains(user.Password, "@") || strings.Contains(user.Password, "#") {
-		return ErrInvalidData
+	if len(fields) != 0 {
+		return &ValidationError{Fields: fields}
 	}
-	if strings.Contains(user.Password, "$") || strings.Contains(user.Password, "%") {
-		return ErrInvalidData
-	}
-	if strings.Contains(user.Password, "&") || strings.Contains(user.Password, "*") {
-		return ErrInvalidData
-	}
-	if strings.Contains(user.Password, "(") || strings.Contains(user.Password, ")") {
+	return nil
+}
+
+// ValidateCredentials checks only the shape of a login attempt. The password
+// policy is not applied, so accounts created under an older policy can still
+// sign in.
+func (s *Service) ValidateCredentials(user *types.User) error {
+	if len(user.Login) > 20 || len(user.Login) < 3 {
 		return ErrInvalidData
 	}
-	if strings.Contains(user.Password, ":") || strings.Contains(user.Password, ".") {
+	if user.Password == "" || utf8.RuneCountInString(user.Password) > s.passwordPolicy().MaxLength {
 		return ErrInvalidData
 	}
-	if strings.Contains(user.Password, "/") || strings.Contains(user.Password, `\`) {
+	return nil
+}
+
+func (s *Service) ValidateEmail(email string) error {
+	address, err := netmail.ParseAddress(email)
+	if err != nil || address.Address != email {
 		return ErrInvalidData
 	}
-	if strings.Contains(user.Password, ",") || strings.Contains(user.Password, ";") {
-		return ErrInvalidData
+	return nil
+}
+
+// ValidatePassword checks a new password of an existing user, who is known
+// by the same identifiers as at registration.
+func (s *Service) ValidatePassword(ctx context.Context, userId int64, password string) error {
+	login, name, email, err := s.db.GetUserNames(ctx, userId)
+	if err != nil {
+		return err
 	}
-	if strings.Contains(user.Password, "?") || strings.Contains(user.Password, `"`) {
-		return ErrInvalidData
+	fields := make([]types.FieldError, 0)
+	for _, reason := range s.passwordPolicy().Check(password, login, name, email) {
+		fields = append(fields, types.FieldError{Field: "password", Reason: reason})
 	}
-	if strings.Contains(user.Password, "!") || strings.Contains(user.Password, "~") {
-		return ErrInvalidData
+	if len(fields) != 0 {
+		return &ValidationError{Fields: fields}
 	}
 	return nil
 }
 
+func (s *Service) passwordPolicy() password.Policy {
+	return s.config.PasswordPolicy.WithDefaults()
+}
+
 func (s *Service) ValidateBook(book *types.Book) error {
 	if len(book.Title) > 20 || len(book.Title) < 1 {
 		return ErrInvalidData
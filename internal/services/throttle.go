@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"log"
+	"math"
+	"time"
+)
+
+// LockedError is returned while a login or client IP is throttled.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry after %v", e.RetryAfter)
+}
+
+var defaultLoginThrottle = types.LoginThrottleConfig{
+	BackoffAfter:     3,
+	BaseDelaySeconds: 1,
+	MaxDelaySeconds:  60,
+	LockoutAfter:     10,
+	LockoutSeconds:   900,
+	ResetSeconds:     3600,
+}
+
+func (s *Service) loginThrottle() types.LoginThrottleConfig {
+	config := s.config.LoginThrottle
+	if config.BackoffAfter == 0 {
+		config.BackoffAfter = defaultLoginThrottle.BackoffAfter
+	}
+	if config.BaseDelaySeconds == 0 {
+		config.BaseDelaySeconds = defaultLoginThrottle.BaseDelaySeconds
+	}
+	if config.MaxDelaySeconds == 0 {
+		config.MaxDelaySeconds = defaultLoginThrottle.MaxDelaySeconds
+	}
+	if config.LockoutAfter == 0 {
+		config.LockoutAfter = defaultLoginThrottle.LockoutAfter
+	}
+	if config.LockoutSeconds == 0 {
+		config.LockoutSeconds = defaultLoginThrottle.LockoutSeconds
+	}
+	if config.ResetSeconds == 0 {
+		config.ResetSeconds = defaultLoginThrottle.ResetSeconds
+	}
+	return config
+}
+
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func throttleKeys(login, ip string) []string {
+	return []string{loginKey(login), "ip:" + ip}
+}
+
+// checkLoginThrottle is called before the password is compared, so a locked
+// login costs no bcrypt work.
+func (s *Service) checkLoginThrottle(ctx context.Context, login, ip string) error {
+	lockedUntil, err := s.db.LockedUntil(ctx, throttleKeys(login, ip))
+	if err != nil {
+		return err
+	}
+	retryAfter := time.Until(lockedUntil)
+	if retryAfter > 0 {
+		return &LockedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+func (s *Service) loginFailed(ctx context.Context, login, ip string) {
+	config := s.loginThrottle()
+	for _, key := range throttleKeys(login, ip) {
+		failures, err := s.db.AddLoginFailure(ctx, key, time.Duration(config.ResetSeconds)*time.Second)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		delay := throttleDelay(config, failures)
+		if delay == 0 {
+			continue
+		}
+		lockedUntil := time.Now().Add(delay)
+		err = s.db.LockLogin(ctx, key, lockedUntil)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if failures >= config.LockoutAfter {
+			err = s.db.PutLockoutEvent(ctx, &types.LockoutEvent{Key: key, Failures: failures, LockedUntil: lockedUntil})
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+func (s *Service) loginSucceeded(ctx context.Context, login string) {
+	err := s.db.ResetLoginFailures(ctx, loginKey(login))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func throttleDelay(config types.LoginThrottleConfig, failures int64) time.Duration {
+	if failures >= config.LockoutAfter {
+		return time.Duration(config.LockoutSeconds) * time.Second
+	}
+	if failures < config.BackoffAfter {
+		return 0
+	}
+	seconds := float64(config.BaseDelaySeconds) * math.Pow(2, float64(failures-config.BackoffAfter))
+	if seconds > float64(config.MaxDelaySeconds) {
+		seconds = float64(config.MaxDelaySeconds)
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (s *Service) GetLockoutEvents(ctx context.Context) ([]*types.LockoutEvent, error) {
+	return s.db.GetLockoutEvents(ctx)
+}
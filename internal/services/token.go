@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"github.com/jackc/pgx/v4"
 	errors "github.com/pkg/errors"
@@ -13,24 +14,106 @@ import (
 
 var ErrNoAuthorization = errors.New("no authorization")
 
-func (s *Service) GetTokenForUser(ctx context.Context, user *types.User) (token string, err error) {
-	ok, id, err := s.db.ValidateLoginAndPassword(ctx, user.Login, user.Password)
-	if errors.Is(err, pgx.ErrNoRows) {
-		return "", ErrNoSuchUser
+const accessTokenLifetime = time.Minute * 15
+const refreshTokenLifetime = time.Hour * 24 * 30
+const tokenPrefixLength = 8
+
+func (s *Service) GetTokenForUser(ctx context.Context, user *types.User, session *types.Session) (*types.T, error) {
+	err := s.checkLoginThrottle(ctx, user.Login, session.IP)
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.ValidateLoginAndPassword(ctx, user.Login, user.Password)
+	if errors.Is(err, ErrNoSuchUser) || errors.Is(err, ErrInvalidPassword) {
+		s.loginFailed(ctx, user.Login, session.IP)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	totp, err := s.db.GetTOTP(ctx, id)
+	if err != nil {
+		return nil, err
 	}
-	if !ok {
-		return "", ErrInvalidPassword
+	// With two-factor authentication the failures are kept until the code
+	// is right too, so new challenges do not bring new attempts.
+	if totp.Enabled {
+		return s.newMFAChallenge(ctx, id)
+	}
+	session.UserId = id
+	item, err := s.startSession(ctx, session)
+	if err != nil {
+		return nil, err
 	}
-	token, err = s.MakeToken(token)
+	s.loginSucceeded(ctx, user.Login)
+	return item, nil
+}
+
+func (s *Service) startSession(ctx context.Context, session *types.Session) (*types.T, error) {
+	item, err := s.newTokenPair(session)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	err = s.db.PutNewToken(ctx, token, id)
+	err = s.db.PutNewToken(ctx, session)
 	if err != nil {
 		log.Println(err)
-		return "", ErrInternal
+		return nil, ErrInternal
 	}
-	return token, nil
+	return item, nil
+}
+
+func (s *Service) RefreshToken(ctx context.Context, refreshToken string) (*types.T, error) {
+	var session types.Session
+	item, err := s.newTokenPair(&session)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.RotateToken(ctx, HashToken(refreshToken), &session)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoAuthorization
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInternal
+	}
+	log.Println("token refreshed:", session.TokenPrefix)
+	return item, nil
+}
+
+// newTokenPair returns fresh plaintext tokens for the client and fills the
+// session with their digests, the only form that is ever stored.
+func (s *Service) newTokenPair(session *types.Session) (*types.T, error) {
+	var item types.T
+	var err error
+	item.Token, err = s.MakeToken(item.Token)
+	if err != nil {
+		return nil, err
+	}
+	item.RefreshToken, err = s.MakeToken(item.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	item.Expire = now.Add(accessTokenLifetime)
+	session.Token = HashToken(item.Token)
+	session.TokenPrefix = TokenPrefix(item.Token)
+	session.RefreshToken = HashToken(item.RefreshToken)
+	session.Expire = item.Expire
+	session.RefreshExpire = now.Add(refreshTokenLifetime)
+	return &item, nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenPrefix is the public part of a token, safe to show in logs and session lists.
+func TokenPrefix(token string) string {
+	if len(token) < tokenPrefixLength {
+		return token
+	}
+	return token[:tokenPrefixLength]
 }
 
 func (s *Service) MakeToken(token string) (string, error) {
@@ -48,7 +131,7 @@ func (s *Service) MakeToken(token string) (string, error) {
 }
 
 func (s *Service) IdByToken(cxt context.Context, token string) (id int64, err error) {
-	id, expire, err := s.db.IdByToken(cxt, token)
+	id, expire, err := s.db.IdByToken(cxt, HashToken(token))
 	if errors.Is(err, pgx.ErrNoRows) {
 		return 0, ErrNoAuthorization
 	}
@@ -60,3 +143,15 @@ func (s *Service) IdByToken(cxt context.Context, token string) (id int64, err er
 	}
 	return id, nil
 }
+
+func (s *Service) Logout(ctx context.Context, token string) error {
+	return s.db.RevokeToken(ctx, HashToken(token))
+}
+
+func (s *Service) LogoutAll(ctx context.Context, userId int64) error {
+	return s.db.RevokeAllTokens(ctx, userId)
+}
+
+func (s *Service) GetSessions(ctx context.Context, userId int64, token string) ([]*types.Session, error) {
+	return s.db.GetSessions(ctx, userId, HashToken(token))
+}
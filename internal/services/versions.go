@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	errors "github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/db"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"io"
+)
+
+var ErrVersionRequired = errors.New("edit must name the version it is based on")
+
+// VersionConflictError is returned for an edit based on an outdated version.
+type VersionConflictError struct {
+	Current int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict, current version is %d", e.Current)
+}
+
+// EditBook applies an edit based on version, or on any version when it is
+// zero, and returns the new version.
+func (s *Service) EditBook(ctx context.Context, edit *types.BookEdit, version int64) (int64, error) {
+	if edit.Status != "" {
+		edit.Status, edit.PublishAt = publication(edit.Status, edit.PublishAt)
+	}
+	return claimVersion(s.db.EditBook(ctx, edit, version))
+}
+
+// EditImage stores a new cover and sets it in an edit based on version. The
+// file is written before the edit and removed again if the edit fails.
+func (s *Service) EditImage(ctx context.Context, bookId int64, file io.Reader, fileName string, version int64) (int64, error) {
+	imageName, err := s.storeImage(file, fileName)
+	if err != nil {
+		return 0, err
+	}
+	next, err := s.EditBook(ctx, &types.BookEdit{ID: bookId, Image: imageName}, version)
+	if err != nil {
+		s.removeImages(imageName)
+		return 0, err
+	}
+	return next, nil
+}
+
+// EditChapter applies an edit based on version, or on any version when it
+// is zero, and returns the new version.
+func (s *Service) EditChapter(ctx context.Context, edit *types.Chapter, version, authorId int64) (int64, error) {
+	if edit.Status != "" {
+		edit.Status, edit.PublishAt = publication(edit.Status, edit.PublishAt)
+	}
+	current, err := s.db.EditChapter(ctx, edit, version, authorId)
+	if errors.Is(err, db.ErrInvalidVolume) {
+		return 0, ErrInvalidVolume
+	}
+	return claimVersion(current, err)
+}
+
+func claimVersion(current int64, err error) (int64, error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, &VersionConflictError{Current: current}
+	}
+	return current, err
+}
+
+func (s *Service) GetBook(ctx context.Context, bookId *types.BookId) (*types.Book, error) {
+	book, err := s.db.GetBook(ctx, bookId.Id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return book, err
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func getTestBook(t *testing.T, s *Service, id int64) *types.Book {
+	t.Helper()
+	book, err := s.GetBook(context.Background(), &types.BookId{Id: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return book
+}
+
+// Fields an edit leaves out keep their values, the flags included.
+func TestEditBookKeepsFieldsLeftOut(t *testing.T) {
+	s, _ := testService(t, &types.Config{})
+	userId := createTestUser(t, s, "writer", "writer@example.com", "Old-Pass-1234-xyz")
+	book := createTestBook(t, s, userId, "Title")
+	before := getTestBook(t, s, book.ID)
+
+	next, err := s.EditBook(context.Background(), &types.BookEdit{ID: book.ID, Title: "Edited"}, before.Version)
+	if err != nil {
+		t.Fatalf("EditBook: %+v", err)
+	}
+	after := getTestBook(t, s, book.ID)
+	if after.Title != "Edited" || after.Version != next || next != before.Version+1 {
+		t.Errorf("title %q, version %d after edit returning %d, want %q and %d", after.Title, after.Version, next, "Edited", before.Version+1)
+	}
+	if !after.AccessRead || !after.Downloadable || after.Description != before.Description || after.Genre != before.Genre ||
+		after.PenNameId != before.PenNameId || after.Status != before.Status || after.Image != before.Image {
+		t.Errorf("title edit changed other fields: %+v, was %+v", after, before)
+	}
+
+	closed := false
+	_, err = s.EditBook(context.Background(), &types.BookEdit{ID: book.ID, AccessRead: &closed}, next)
+	if err != nil {
+		t.Fatalf("EditBook: %+v", err)
+	}
+	after = getTestBook(t, s, book.ID)
+	if after.AccessRead || !after.Downloadable || after.Title != "Edited" {
+		t.Errorf("access edit: access_read %t, downloadable %t, title %q", after.AccessRead, after.Downloadable, after.Title)
+	}
+}
+
+func TestEditBookVersionConflict(t *testing.T) {
+	s, _ := testService(t, &types.Config{})
+	userId := createTestUser(t, s, "writer", "writer@example.com", "Old-Pass-1234-xyz")
+	book := createTestBook(t, s, userId, "Title")
+	version := getTestBook(t, s, book.ID).Version
+	_, err := s.EditBook(context.Background(), &types.BookEdit{ID: book.ID, Title: "First"}, version)
+	if err != nil {
+		t.Fatalf("EditBook: %+v", err)
+	}
+	_, err = s.EditBook(context.Background(), &types.BookEdit{ID: book.ID, Title: "Second"}, version)
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) || conflict.Current != version+1 {
+		t.Fatalf("stale edit: error = %v, want a conflict at version %d", err, version+1)
+	}
+	if title := getTestBook(t, s, book.ID).Title; title != "First" {
+		t.Errorf("title = %q after a rejected edit", title)
+	}
+}
+
+// A cover whose edit is rejected is not left behind in the images directory.
+func TestEditImageConflictRemovesFile(t *testing.T) {
+	images := t.TempDir()
+	s, _ := testService(t, &types.Config{ImagesPath: images})
+	userId := createTestUser(t, s, "writer", "writer@example.com", "Old-Pass-1234-xyz")
+	book := createTestBook(t, s, userId, "Title")
+	version := getTestBook(t, s, book.ID).Version
+
+	_, err := s.EditImage(context.Background(), book.ID, strings.NewReader("stale"), "new.png", version+1)
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("stale cover: error = %v, want a conflict", err)
+	}
+	files, err := os.ReadDir(images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("%d files left after a rejected cover", len(files))
+	}
+
+	_, err = s.EditImage(context.Background(), book.ID, strings.NewReader("cover"), "new.png", version)
+	if err != nil {
+		t.Fatalf("EditImage: %+v", err)
+	}
+	image := getTestBook(t, s, book.ID).Image
+	data, err := os.ReadFile(filepath.Join(images, image))
+	if err != nil || string(data) != "cover" {
+		t.Errorf("cover %q: %q, %v", image, data, err)
+	}
+}
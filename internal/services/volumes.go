@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rustamfozilov/penhub/internal/db"
+	"github.com/rustamfozilov/penhub/internal/types"
+	"unicode/utf8"
+)
+
+var ErrInvalidVolume = errors.New("volume belongs to another book")
+
+const (
+	maxVolumeTitle       = 100
+	maxVolumeDescription = 1000
+)
+
+func (s *Service) ValidateVolume(volume *types.Volume) error {
+	fields := make([]types.FieldError, 0)
+	title := utf8.RuneCountInString(volume.Title)
+	if title < 1 || title > maxVolumeTitle {
+		fields = append(fields, types.FieldError{Field: "title", Reason: "length"})
+	}
+	if utf8.RuneCountInString(volume.Description) > maxVolumeDescription {
+		fields = append(fields, types.FieldError{Field: "description", Reason: "length"})
+	}
+	if len(fields) != 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+func (s *Service) CreateVolume(ctx context.Context, volume *types.Volume) error {
+	return s.db.CreateVolume(ctx, volume)
+}
+
+func (s *Service) EditVolume(ctx context.Context, volume *types.Volume) error {
+	return s.db.EditVolume(ctx, volume)
+}
+
+func (s *Service) DeleteVolume(ctx context.Context, volumeId *types.VolumeId) error {
+	return s.db.DeleteVolume(ctx, volumeId.Id)
+}
+
+func (s *Service) ReorderVolumes(ctx context.Context, order *types.VolumeOrder) error {
+	err := s.db.ReorderVolumes(ctx, order)
+	if errors.Is(err, db.ErrInvalidOrder) {
+		return ErrInvalidOrder
+	}
+	return err
+}
+
+// GetTableOfContents nests the chapters the viewer may see into their
+// volumes. Volumes without visible chapters are listed too, so authors
+// can see the structure they are filling.
+func (s *Service) GetTableOfContents(ctx context.Context, bookId *types.BookId, viewerId int64) (*types.TableOfContents, error) {
+	volumes, err := s.db.GetVolumes(ctx, bookId.Id)
+	if err != nil {
+		return nil, err
+	}
+	chapters, err := s.db.GetChaptersByBookId(ctx, bookId.Id, viewerId)
+	if err != nil {
+		return nil, err
+	}
+	return tableOfContents(volumes, chapters), nil
+}
+
+// tableOfContents keeps the order of chapters within each volume.
+func tableOfContents(volumes []*types.Volume, chapters []*types.Chapter) *types.TableOfContents {
+	contents := &types.TableOfContents{Volumes: volumes, Chapters: make([]*types.Chapter, 0)}
+	byId := make(map[int64]*types.Volume, len(volumes))
+	for _, volume := range volumes {
+		volume.Chapters = make([]*types.Chapter, 0)
+		byId[volume.ID] = volume
+	}
+	for _, chapter := range chapters {
+		if chapter.VolumeId != nil {
+			volume, ok := byId[*chapter.VolumeId]
+			if ok {
+				volume.Chapters = append(volume.Chapters, chapter)
+				continue
+			}
+		}
+		contents.Chapters = append(contents.Chapters, chapter)
+	}
+	return contents
+}
@@ -0,0 +1,119 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238 Appendix B, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks the SHA-1 vectors of RFC 6238 Appendix B. The RFC
+// lists eight digits, of which six-digit codes are the last six.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	got, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "287082" {
+		t.Errorf("Code = %s, want 287082", got)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	_, err := Code("not base32!", 1)
+	if err == nil {
+		t.Error("Code accepted a secret that is not base32")
+	}
+}
+
+// Codes of the steps next to now are accepted for clock drift, older and
+// newer ones are not.
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+	tests := []struct {
+		offset int64
+		ok     bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, step+tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := Validate(rfcSecret, code, now)
+		if ok != tt.ok {
+			t.Errorf("code of step %+d: ok = %t, want %t", tt.offset, ok, tt.ok)
+		}
+		if ok && got != step+tt.offset {
+			t.Errorf("code of step %+d: step = %d, want %d", tt.offset, got, step+tt.offset)
+		}
+	}
+}
+
+// A code used again, even a period later while it is still inside the skew
+// window, gives the step it was issued for, which callers record to refuse
+// it the second time.
+func TestValidateReuseGivesSameStep(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, ok := Validate(rfcSecret, code, now)
+	if !ok {
+		t.Fatal("code rejected")
+	}
+	second, ok := Validate(rfcSecret, code, now.Add(Period*time.Second))
+	if !ok {
+		t.Fatal("code rejected a period later")
+	}
+	if first != second {
+		t.Errorf("steps %d and %d for the same code", first, second)
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []string{"", "12345", "1234567", code + "0", "abcdef"} {
+		if _, ok := Validate(rfcSecret, input, now); ok {
+			t.Errorf("Validate accepted %q", input)
+		}
+	}
+	if _, ok := Validate(rfcSecret, " "+code+" ", now); !ok {
+		t.Error("Validate rejected a code with surrounding spaces")
+	}
+}
@@ -1,42 +1,352 @@
 package types
 
-import "time"
+import (
+	"github.com/rustamfozilov/penhub/internal/diff"
+	"github.com/rustamfozilov/penhub/internal/oidc"
+	"github.com/rustamfozilov/penhub/internal/password"
+	"time"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
 
 type Book struct {
-	ID          int64     `json:"id"`
-	Title       string    `json:"title"`
-	AuthorId    int64     `json:"-"`
-	Genre       int64     `json:"genre"`
-	Description string    `json:"description"`
-	Image       string    `json:"cover_image_name"`
-	AccessRead  bool      `json:"access_read"`
-	Active      bool      `json:"active"`
-	Created     time.Time `json:"created"`
+	ID          int64      `json:"id"`
+	Title       string     `json:"title"`
+	AuthorId    int64      `json:"-"`
+	PenNameId   int64      `json:"pen_name_id"`
+	PenName     string     `json:"pen_name"`
+	Genre       int64      `json:"genre"`
+	Description string     `json:"description"`
+	Image       string     `json:"cover_image_name"`
+	AccessRead  bool       `json:"access_read"`
+	Active      bool       `json:"active"`
+	Hidden      bool       `json:"hidden"`
+	Status      string     `json:"status"`
+	PublishAt   *time.Time `json:"publish_at"`
+	Version     int64      `json:"version"`
+	Created     time.Time  `json:"created"`
+	// Downloadable lets readers export the book, which its author always can.
+	Downloadable bool `json:"downloadable"`
+}
+
+// BookEdit holds the fields an edit of a book changes. Empty strings, zero
+// ids and nil flags leave a field as it is. The cover is only set through
+// the image upload.
+type BookEdit struct {
+	ID           int64      `json:"id"`
+	Title        string     `json:"title"`
+	PenNameId    int64      `json:"pen_name_id"`
+	Genre        int64      `json:"genre"`
+	Description  string     `json:"description"`
+	Image        string     `json:"-"`
+	AccessRead   *bool      `json:"access_read"`
+	Downloadable *bool      `json:"downloadable"`
+	Status       string     `json:"status"`
+	PublishAt    *time.Time `json:"publish_at"`
 }
 
+// Publication states of books and chapters. Readers only see published
+// ones; PublishAt is when an item went or goes public and is nil for drafts.
+const (
+	StatusDraft     = "draft"
+	StatusScheduled = "scheduled"
+	StatusPublished = "published"
+)
+
 type User struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Login      string    `json:"login"`
+	Email      string    `json:"email"`
+	Password   string    `json:"password"`
+	InviteCode string    `json:"invite_code,omitempty"`
+	Role       string    `json:"role"`
+	Active     bool      `json:"active"`
+	Created    time.Time `json:"created"`
+}
+
+type Profile struct {
 	ID       int64     `json:"id"`
 	Name     string    `json:"name"`
-	Login    string    `json:"login"`
-	Password string    `json:"password"`
-	Active   bool      `json:"active"`
+	Bio      string    `json:"bio"`
+	Avatar   string    `json:"avatar_image_name"`
+	Links    []string  `json:"links"`
+	Location string    `json:"location"`
 	Created  time.Time `json:"created"`
 }
 
-type T struct {
-	Token string `json:"token"`
+type AuthorPage struct {
+	Profile
+	BookCount int64 `json:"book_count"`
+	Likes     int64 `json:"likes"`
 }
 
-type Chapter struct {
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+type DataExport struct {
+	ID       int64      `json:"id"`
+	UserId   int64      `json:"-"`
+	Status   string     `json:"status"`
+	FileName string     `json:"-"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished"`
+}
+
+type ExportId struct {
+	Id int64 `json:"export_id"`
+}
+
+// BookPDFExport is a PDF file of a book rendered in the background for its
+// author.
+type BookPDFExport struct {
+	ID       int64      `json:"id"`
+	BookId   int64      `json:"book_id"`
+	UserId   int64      `json:"-"`
+	PageSize string     `json:"page_size"`
+	Status   string     `json:"status"`
+	FileName string     `json:"-"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished"`
+}
+
+type PDFExportRequest struct {
+	BookId int64 `json:"book_id"`
+	// PageSize is "a5", "a4" or "6x9"; empty means the configured default.
+	PageSize string `json:"page_size"`
+}
+
+const (
+	DeleteBooks   = "delete"
+	ReassignBooks = "reassign"
+)
+
+// AccountDeletion is a pending request to delete an account. Books says
+// whether the user's books are removed or handed to the deleted author
+// placeholder.
+type AccountDeletion struct {
+	UserId       int64     `json:"-"`
+	Books        string    `json:"books"`
+	ExecuteAfter time.Time `json:"execute_after"`
+	Created      time.Time `json:"created"`
+}
+
+type Like struct {
 	ID      int64     `json:"id"`
 	BookId  int64     `json:"book_id"`
-	Number  int64     `json:"number"`
-	Name    string    `json:"name"`
-	Content string    `json:"content"`
-	Active  bool      `json:"active"`
 	Created time.Time `json:"created"`
 }
 
+type PasswordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type T struct {
+	Token        string    `json:"token,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expire       time.Time `json:"expire"`
+	// MFAToken is returned instead of a token when the account has two-factor
+	// authentication enabled and must be exchanged through the mfa endpoint.
+	MFAToken string `json:"mfa_token,omitempty"`
+}
+
+type MFAVerification struct {
+	Token string `json:"mfa_token"`
+	Code  string `json:"code"`
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPCode struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// TOTP is the two-factor state of an account.
+type TOTP struct {
+	Secret string
+	// PendingSecret is enrolled but not confirmed yet.
+	PendingSecret string
+	Enabled       bool
+	LastStep      int64
+}
+
+type RefreshToken struct {
+	Token string `json:"refresh_token"`
+}
+
+type Session struct {
+	ID            int64     `json:"id"`
+	UserId        int64     `json:"-"`
+	Token         string    `json:"-"`
+	TokenPrefix   string    `json:"token_prefix"`
+	RefreshToken  string    `json:"-"`
+	Expire        time.Time `json:"-"`
+	RefreshExpire time.Time `json:"-"`
+	UserAgent     string    `json:"user_agent"`
+	IP            string    `json:"ip"`
+	Current       bool      `json:"current"`
+	LastSeen      time.Time `json:"last_seen"`
+	Created       time.Time `json:"created"`
+}
+
+// APIKey is a long-lived credential for scripts. Key holds the plaintext
+// and is only filled in the response that creates it.
+type APIKey struct {
+	ID       int64      `json:"id"`
+	UserId   int64      `json:"-"`
+	Name     string     `json:"name"`
+	Key      string     `json:"key,omitempty"`
+	KeyHash  string     `json:"-"`
+	Prefix   string     `json:"prefix"`
+	Scopes   []string   `json:"scopes"`
+	LastUsed *time.Time `json:"last_used"`
+	Created  time.Time  `json:"created"`
+}
+
+type APIKeyId struct {
+	ID int64 `json:"api_key_id"`
+}
+
+// Principal is the authenticated caller. Scopes is nil for session tokens,
+// which may do everything their user can.
+type Principal struct {
+	UserId int64
+	Scopes []string
+}
+
+// Identity is an external OpenID Connect account linked to a user.
+type Identity struct {
+	ID      int64     `json:"id"`
+	UserId  int64     `json:"-"`
+	Issuer  string    `json:"issuer"`
+	Subject string    `json:"subject"`
+	Email   string    `json:"email"`
+	Created time.Time `json:"created"`
+}
+
+type IdentityId struct {
+	ID int64 `json:"identity_id"`
+}
+
+// OIDCLogin is a pending authorization request. UserId is set when a
+// signed in user links a new identity instead of signing in.
+type OIDCLogin struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	UserId       int64
+	Expire       time.Time
+}
+
+type OIDCRedirect struct {
+	URL string `json:"url"`
+}
+
+type OIDCCallback struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+type Chapter struct {
+	ID        int64      `json:"id"`
+	BookId    int64      `json:"book_id"`
+	Number    int64      `json:"number"`
+	Name      string     `json:"name"`
+	Content   string     `json:"content"`
+	Format    string     `json:"format"`
+	Active    bool       `json:"active"`
+	Hidden    bool       `json:"hidden"`
+	Status    string     `json:"status"`
+	PublishAt *time.Time `json:"publish_at"`
+	Version   int64      `json:"version"`
+	Created   time.Time  `json:"created"`
+	// VolumeId is nil for chapters outside any volume. In edits nil leaves
+	// the volume alone and zero takes the chapter out of its volume.
+	VolumeId *int64 `json:"volume_id"`
+	// After places a new chapter behind the chapter with this id, or first
+	// when it is zero. Without it the chapter takes Number, or goes last.
+	After *int64 `json:"after,omitempty"`
+}
+
+// Volume groups chapters of a book, such as a volume, part or arc.
+type Volume struct {
+	ID          int64      `json:"id"`
+	BookId      int64      `json:"book_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Number      int64      `json:"number"`
+	Chapters    []*Chapter `json:"chapters"`
+	Created     time.Time  `json:"created"`
+}
+
+type VolumeId struct {
+	Id int64 `json:"volume_id"`
+}
+
+type VolumeOrder struct {
+	BookId    int64   `json:"book_id"`
+	VolumeIds []int64 `json:"volume_ids"`
+}
+
+// TableOfContents holds the chapters of a book by volume, in volume order,
+// followed by the chapters that belong to no volume.
+type TableOfContents struct {
+	Volumes  []*Volume  `json:"volumes"`
+	Chapters []*Chapter `json:"chapters"`
+}
+
+// ChapterOrder lists every chapter of a book in its new order.
+type ChapterOrder struct {
+	BookId     int64   `json:"book_id"`
+	ChapterIds []int64 `json:"chapter_ids"`
+}
+
+// Revision is a saved state of a chapter. Content is left out of lists.
+type Revision struct {
+	ID        int64     `json:"id"`
+	ChapterId int64     `json:"chapter_id"`
+	Name      string    `json:"name"`
+	Content   string    `json:"content,omitempty"`
+	Format    string    `json:"format"`
+	AuthorId  int64     `json:"author_id"`
+	Created   time.Time `json:"created"`
+}
+
+type RevisionId struct {
+	ChapterId int64 `json:"chapter_id"`
+	ID        int64 `json:"revision_id"`
+}
+
+// DiffRequest compares two revisions of a chapter, by "line" (the default) or "word".
+type DiffRequest struct {
+	ChapterId int64  `json:"chapter_id"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+	Mode      string `json:"mode"`
+}
+
+type RevisionDiff struct {
+	From    int64     `json:"from"`
+	To      int64     `json:"to"`
+	Name    []diff.Op `json:"name"`
+	Content []diff.Op `json:"content"`
+}
+
 type Genre struct {
 	Id     int64  `json:"id"`
 	Name   string `json:"name"`
@@ -55,6 +365,53 @@ type ChapterId struct {
 	Id int64 `json:"chapter_id"`
 }
 
+// Representations of chapter content a reader can ask for.
+const (
+	RenderSource = "source"
+	RenderHTML   = "html"
+	RenderText   = "text"
+)
+
+// ChapterRead asks for a chapter with its content as stored (the default),
+// as sanitized HTML or as plain text.
+type ChapterRead struct {
+	Id     int64  `json:"chapter_id"`
+	Render string `json:"render"`
+}
+
+// BookImport holds the settings of a book imported from a file. An empty
+// title or description is taken from the file.
+type BookImport struct {
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	Genre        int64  `json:"genre"`
+	PenNameId    int64  `json:"pen_name_id"`
+	AccessRead   bool   `json:"access_read"`
+	Downloadable bool   `json:"downloadable"`
+	// DryRun reports what would be imported without creating anything.
+	DryRun bool `json:"dry_run"`
+}
+
+// ImportReport tells what was, or in a dry run would be, imported.
+type ImportReport struct {
+	// BookId is zero in a dry run.
+	BookId      int64              `json:"book_id"`
+	Format      string             `json:"format"`
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Cover       bool               `json:"cover"`
+	Chapters    []*ImportedChapter `json:"chapters"`
+	Warnings    []string           `json:"warnings"`
+}
+
+type ImportedChapter struct {
+	Number int64  `json:"number"`
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	// Length is the number of characters of the chapter text.
+	Length int `json:"length"`
+}
+
 type AuthorName struct {
 	Name string `json:"author"`
 }
@@ -64,6 +421,33 @@ type AuthorId struct {
 	LastBookId int64 `json:"last_id"`
 }
 
+// PenName is an author identity. The owning account is only disclosed when
+// the owner has linked the pen name to it.
+type PenName struct {
+	ID      int64     `json:"id"`
+	UserId  int64     `json:"user_id,omitempty"`
+	Name    string    `json:"name"`
+	Bio     string    `json:"bio"`
+	Linked  bool      `json:"linked"`
+	Active  bool      `json:"active"`
+	Created time.Time `json:"created"`
+}
+
+// PenNameEdit holds the fields an edit of a pen name changes. An empty name
+// and nil fields are left as they are.
+type PenNameEdit struct {
+	ID     int64   `json:"id"`
+	Name   string  `json:"name"`
+	Bio    *string `json:"bio"`
+	Linked *bool   `json:"linked"`
+	Active *bool   `json:"active"`
+}
+
+type PenNameId struct {
+	Id         int64 `json:"pen_name_id"`
+	LastBookId int64 `json:"last_id"`
+}
+
 type GenreName struct {
 	Name string `json:"genre_name"`
 }
@@ -81,11 +465,146 @@ type RatingID struct {
 	Id int64 `json:"like_id"`
 }
 
+type UserRole struct {
+	Id   int64  `json:"user_id"`
+	Role string `json:"role"`
+}
+
+type UserActive struct {
+	Id     int64 `json:"user_id"`
+	Active bool  `json:"active"`
+}
+
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type PasswordReset struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type EmailVerification struct {
+	Token string `json:"token"`
+}
+
+// VerificationToken is the stored form of an email verification link.
+type VerificationToken struct {
+	TokenHash string
+	Expire    time.Time
+}
+
+type InviteCode struct {
+	ID          int64               `json:"id"`
+	Code        string              `json:"code"`
+	MaxUses     int64               `json:"max_uses"`
+	Uses        int64               `json:"uses"`
+	CreatedBy   int64               `json:"created_by"`
+	Created     time.Time           `json:"created"`
+	Redemptions []*InviteRedemption `json:"redemptions"`
+}
+
+type InviteRedemption struct {
+	UserId  int64     `json:"user_id"`
+	Login   string    `json:"login"`
+	Created time.Time `json:"created"`
+}
+
 type Config struct {
-	UserName   string `json:"username"`
-	Password   string `json:"password"`
-	Host       string `json:"host"`
-	Port       string `json:"port"`
-	Database   string `json:"database"`
-	ImagesPath string `json:"images_path"`
+	UserName   string     `json:"username"`
+	Password   string     `json:"password"`
+	Host       string     `json:"host"`
+	Port       string     `json:"port"`
+	Database   string     `json:"database"`
+	ImagesPath string     `json:"images_path"`
+	BaseURL    string     `json:"base_url"`
+	Mail       MailConfig `json:"mail"`
+	// VerifyEmail keeps new accounts inactive until the emailed link is opened.
+	VerifyEmail bool `json:"verify_email"`
+	// InviteOnly makes registration require an invite code created by an admin.
+	InviteOnly      bool                   `json:"invite_only"`
+	LoginThrottle   LoginThrottleConfig    `json:"login_throttle"`
+	PasswordPolicy  password.Policy        `json:"password_policy"`
+	PasswordHashing password.HashingConfig `json:"password_hashing"`
+	ExportsPath     string                 `json:"exports_path"`
+	// ExportRetentionDays is how long finished exports can be downloaded.
+	ExportRetentionDays int `json:"export_retention_days"`
+	// DeletionGraceDays is how long a deletion request can still be cancelled.
+	DeletionGraceDays int                     `json:"deletion_grace_days"`
+	RevisionRetention RevisionRetentionConfig `json:"revision_retention"`
+	BookExport        BookExportConfig        `json:"book_export"`
+	BookImport        BookImportConfig        `json:"book_import"`
+	// OIDC enables signing in with an external OpenID Connect provider when its issuer is set.
+	OIDC oidc.Config `json:"oidc"`
+}
+
+// LoginThrottleConfig limits password guessing per login and per client IP.
+// Zero values fall back to the defaults in the services package.
+type LoginThrottleConfig struct {
+	// BackoffAfter failed attempts start an exponential delay beginning at BaseDelaySeconds.
+	BackoffAfter     int64 `json:"backoff_after"`
+	BaseDelaySeconds int64 `json:"base_delay_seconds"`
+	MaxDelaySeconds  int64 `json:"max_delay_seconds"`
+	// LockoutAfter failed attempts lock the login or IP for LockoutSeconds.
+	LockoutAfter   int64 `json:"lockout_after"`
+	LockoutSeconds int64 `json:"lockout_seconds"`
+	// ResetSeconds without a failure forget the earlier ones.
+	ResetSeconds int64 `json:"reset_seconds"`
+}
+
+// RevisionRetentionConfig decides which chapter revisions are pruned: those
+// older than KeepDays that are not among the KeepLast newest of their
+// chapter. Zero values fall back to the defaults in the services package.
+type RevisionRetentionConfig struct {
+	KeepLast int64 `json:"keep_last"`
+	KeepDays int64 `json:"keep_days"`
+}
+
+// BookExportConfig configures the files readers download, which are cached in
+// ExportsPath. Zero values fall back to the defaults in the services package.
+type BookExportConfig struct {
+	// Language is the BCP 47 tag written into exported books.
+	Language string          `json:"language"`
+	PDF      PDFExportConfig `json:"pdf"`
+}
+
+// PDFExportConfig sets the fonts PDF books are set in, which must be
+// TrueType files. Without a regular font PDF export is off; missing styles
+// are drawn from the regular and bold fonts.
+type PDFExportConfig struct {
+	PageSize       string `json:"page_size"`
+	RegularFont    string `json:"regular_font"`
+	BoldFont       string `json:"bold_font"`
+	ItalicFont     string `json:"italic_font"`
+	BoldItalicFont string `json:"bold_italic_font"`
+}
+
+// BookImportConfig limits the files books are imported from, in bytes.
+// Zero values fall back to the defaults in the services package.
+type BookImportConfig struct {
+	MaxSize int64 `json:"max_size"`
+	// MaxUnpackedSize limits what the files in an archive unpack to together.
+	MaxUnpackedSize int64 `json:"max_unpacked_size"`
+}
+
+type LockoutEvent struct {
+	ID          int64     `json:"id"`
+	Key         string    `json:"key"`
+	Failures    int64     `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	Created     time.Time `json:"created"`
+}
+
+// MailConfig without a host makes the server log outgoing mail instead of sending it.
+type MailConfig struct {
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	UserName string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
 }
@@ -0,0 +1,19 @@
+-- Sessions gain an id, a refresh token and the device they were opened on.
+-- Existing sessions keep their access token until it expires; their refresh
+-- token is expired from the start, so they cannot be extended.
+
+alter table users_tokens add column id bigserial primary key;
+alter table users_tokens alter column expire drop default;
+alter table users_tokens add column refresh_token text unique;
+alter table users_tokens add column refresh_expire timestamptz;
+alter table users_tokens add column revoked boolean not null default false;
+alter table users_tokens add column user_agent text not null default '';
+alter table users_tokens add column ip text not null default '';
+alter table users_tokens add column last_seen timestamptz not null default current_timestamp;
+
+update users_tokens
+set refresh_token  = 'expired-' || token,
+    refresh_expire = current_timestamp;
+
+alter table users_tokens alter column refresh_token set not null;
+alter table users_tokens alter column refresh_expire set not null;
@@ -0,0 +1,14 @@
+-- Tokens used to be stored in plaintext. Keep existing sessions alive by
+-- replacing every stored token with its SHA-256 digest, the same form the
+-- service now writes and looks up.
+
+alter table users_tokens rename column token to token_hash;
+alter table users_tokens rename column refresh_token to refresh_token_hash;
+alter table users_tokens add column token_prefix text not null default '';
+
+update users_tokens
+set token_prefix       = left(token_hash, 8),
+    token_hash         = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex'),
+    refresh_token_hash = encode(sha256(convert_to(refresh_token_hash, 'UTF8')), 'hex');
+
+alter table users_tokens alter column token_prefix drop default;
@@ -0,0 +1,6 @@
+-- Roles for moderation, and the hidden flag moderators set on books and
+-- chapters.
+
+alter table users add column role text not null default 'user' check (role in ('user', 'moderator', 'admin'));
+alter table books add column hidden boolean not null default false;
+alter table chapters add column hidden boolean not null default false;
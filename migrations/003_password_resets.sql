@@ -0,0 +1,11 @@
+alter table users add column email text unique;
+
+create table password_resets
+(
+    id         bigserial primary key,
+    user_id    bigint      not null references users,
+    token_hash text        not null unique,
+    expire     timestamptz not null,
+    used       boolean     not null default false,
+    created    timestamptz not null default current_timestamp
+);
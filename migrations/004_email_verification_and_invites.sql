@@ -0,0 +1,28 @@
+alter table users add column email_verified boolean not null default false;
+
+create table email_verifications
+(
+    id         bigserial primary key,
+    user_id    bigint      not null references users,
+    token_hash text        not null unique,
+    expire     timestamptz not null,
+    used       boolean     not null default false,
+    created    timestamptz not null default current_timestamp
+);
+
+create table invite_codes
+(
+    id         bigserial primary key,
+    code       text        not null unique,
+    max_uses   bigint      not null default 1,
+    uses       bigint      not null default 0,
+    created_by bigint      not null references users,
+    created    timestamptz not null default current_timestamp
+);
+
+create table invite_redemptions
+(
+    invite_id bigint      not null references invite_codes,
+    user_id   bigint      not null unique references users,
+    created   timestamptz not null default current_timestamp
+);
@@ -0,0 +1,23 @@
+alter table users add column totp_secret text not null default '';
+alter table users add column totp_enabled boolean not null default false;
+alter table users add column totp_last_step bigint not null default 0;
+
+create table recovery_codes
+(
+    id        bigserial primary key,
+    user_id   bigint      not null references users,
+    code_hash text        not null,
+    used      boolean     not null default false,
+    created   timestamptz not null default current_timestamp
+);
+
+create table mfa_challenges
+(
+    id         bigserial primary key,
+    user_id    bigint      not null references users,
+    token_hash text        not null unique,
+    expire     timestamptz not null,
+    attempts   bigint      not null default 0,
+    used       boolean     not null default false,
+    created    timestamptz not null default current_timestamp
+);
@@ -0,0 +1,16 @@
+create table login_throttles
+(
+    key          text primary key,
+    failures     bigint      not null default 0,
+    locked_until timestamptz not null default current_timestamp,
+    last_failure timestamptz not null default current_timestamp
+);
+
+create table lockout_events
+(
+    id           bigserial primary key,
+    key          text        not null,
+    failures     bigint      not null,
+    locked_until timestamptz not null,
+    created      timestamptz not null default current_timestamp
+);
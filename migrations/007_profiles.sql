@@ -0,0 +1,4 @@
+alter table users add column bio text not null default '';
+alter table users add column avatar_image_name text not null default '';
+alter table users add column links text[] not null default '{}';
+alter table users add column location text not null default '';
@@ -0,0 +1,25 @@
+-- Every user gets a pen name of their own name, as registration now gives,
+-- and existing books are published under it.
+
+create table pen_names
+(
+    id      bigserial primary key,
+    user_id bigint      not null references users,
+    name    text        not null,
+    bio     text        not null default '',
+    linked  boolean     not null default false,
+    active  boolean     not null default true,
+    created timestamptz not null default current_timestamp
+);
+
+insert into pen_names (user_id, name)
+select id, name from users order by id;
+
+alter table books add column pen_name_id bigint references pen_names;
+
+update books b
+set pen_name_id = p.id
+from pen_names p
+where p.user_id = b.author_id;
+
+alter table books alter column pen_name_id set not null;
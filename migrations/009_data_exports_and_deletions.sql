@@ -0,0 +1,17 @@
+create table data_exports
+(
+    id        bigserial primary key,
+    user_id   bigint      not null references users,
+    status    text        not null default 'pending' check (status in ('pending', 'ready', 'failed')),
+    file_name text        not null default '',
+    created   timestamptz not null default current_timestamp,
+    finished  timestamptz
+);
+
+create table account_deletions
+(
+    user_id       bigint primary key references users,
+    books_action  text        not null check (books_action in ('delete', 'reassign')),
+    execute_after timestamptz not null,
+    created       timestamptz not null default current_timestamp
+);
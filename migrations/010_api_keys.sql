@@ -0,0 +1,12 @@
+create table api_keys
+(
+    id         bigserial primary key,
+    user_id    bigint      not null references users,
+    name       text        not null,
+    key_hash   text        not null unique,
+    key_prefix text        not null,
+    scopes     text[]      not null,
+    last_used  timestamptz,
+    revoked    boolean     not null default false,
+    created    timestamptz not null default current_timestamp
+);
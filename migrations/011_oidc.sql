@@ -0,0 +1,19 @@
+create table user_identities
+(
+    id      bigserial primary key,
+    user_id bigint      not null references users,
+    issuer  text        not null,
+    subject text        not null,
+    email   text        not null default '',
+    created timestamptz not null default current_timestamp,
+    unique (issuer, subject)
+);
+
+create table oidc_logins
+(
+    state_hash    text primary key,
+    nonce         text        not null,
+    code_verifier text        not null,
+    user_id       bigint references users,
+    expire        timestamptz not null
+);
@@ -0,0 +1,9 @@
+-- Existing books and chapters stay published.
+
+alter table books add column status text not null default 'published' check (status in ('draft', 'scheduled', 'published'));
+alter table books add column publish_at timestamptz;
+alter table chapters add column status text not null default 'published' check (status in ('draft', 'scheduled', 'published'));
+alter table chapters add column publish_at timestamptz;
+
+create index on books (publish_at) where status = 'scheduled';
+create index on chapters (publish_at) where status = 'scheduled';
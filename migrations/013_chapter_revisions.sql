@@ -0,0 +1,18 @@
+-- Existing chapters start their history with what they hold now.
+
+create table chapter_revisions
+(
+    id         bigserial primary key,
+    chapter_id bigint      not null references chapters,
+    name       text        not null,
+    content    text        not null,
+    author_id  bigint      not null references users,
+    created    timestamptz not null default current_timestamp
+);
+
+create index on chapter_revisions (chapter_id, id);
+
+insert into chapter_revisions (chapter_id, name, content, author_id)
+select c.id, c.name, c.content, b.author_id
+from chapters c join books b on b.id = c.book_id
+order by c.id;
@@ -0,0 +1,2 @@
+alter table books add column version bigint not null default 1;
+alter table chapters add column version bigint not null default 1;
@@ -0,0 +1,13 @@
+-- Chapter numbers were not unique before. Number the chapters of every book
+-- from one in their old order, ties broken by id, so that the constraint
+-- can be added.
+
+update chapters c
+set number = n.number
+from (
+    select id, row_number() over (partition by book_id order by number, id) as number from chapters
+) n
+where c.id = n.id and c.number <> n.number;
+
+-- deferred to the end of each statement so that shifting numbers by one is possible
+alter table chapters add constraint chapters_book_number unique (book_id, number) deferrable initially immediate;
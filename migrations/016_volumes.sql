@@ -0,0 +1,12 @@
+create table volumes
+(
+    id          bigserial primary key,
+    book_id     bigint      not null references books,
+    title       text        not null,
+    description text        not null default '',
+    number      bigint      not null,
+    created     timestamptz not null default current_timestamp,
+    constraint volumes_book_number unique (book_id, number) deferrable initially immediate
+);
+
+alter table chapters add column volume_id bigint references volumes on delete set null;
@@ -0,0 +1,2 @@
+alter table chapters add column format text not null default 'plain' check (format in ('plain', 'markdown', 'html'));
+alter table chapter_revisions add column format text not null default 'plain';
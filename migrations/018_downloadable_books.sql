@@ -0,0 +1 @@
+alter table books add column downloadable boolean not null default false;
@@ -0,0 +1,13 @@
+create table book_pdf_exports
+(
+    id        bigserial primary key,
+    book_id   bigint      not null references books,
+    user_id   bigint      not null references users,
+    page_size text        not null check (page_size in ('a5', 'a4', '6x9')),
+    status    text        not null default 'pending' check (status in ('pending', 'ready', 'failed')),
+    file_name text        not null default '',
+    attempts  int         not null default 0,
+    created   timestamptz not null default current_timestamp,
+    started   timestamptz,
+    finished  timestamptz
+);
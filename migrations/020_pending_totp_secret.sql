@@ -0,0 +1,9 @@
+-- A new secret waits here until its first code is confirmed, so enrolling
+-- never replaces or turns off the active one.
+
+alter table users add column totp_pending_secret text not null default '';
+
+update users
+set totp_pending_secret = totp_secret,
+    totp_secret         = ''
+where totp_enabled = false and totp_secret <> '';
@@ -0,0 +1,5 @@
+-- Data exports are claimed by the jobs loop like PDF exports, so an export
+-- whose server stopped while building it is started again.
+
+alter table data_exports add column attempts int not null default 0;
+alter table data_exports add column started timestamptz;
@@ -3,16 +3,153 @@ create table users
     id       bigserial primary key,
     name     text      not null,
     login    text      not null unique,
+    email    text      unique,
+    email_verified boolean not null default false,
     password text      not null unique,
+    totp_secret    text    not null default '',
+    totp_pending_secret text not null default '',
+    totp_enabled   boolean not null default false,
+    totp_last_step bigint  not null default 0,
+    bio      text      not null default '',
+    avatar_image_name text not null default '',
+    links    text[]    not null default '{}',
+    location text      not null default '',
+    role     text      not null default 'user' check (role in ('user', 'moderator', 'admin')),
     active   boolean   not null default true,
     created  timestamp not null default current_timestamp
 );
 
 create table users_tokens
 (
+    id                 bigserial primary key,
+    user_id            bigint      not null references users,
+    token_hash         text        not null unique,
+    token_prefix       text        not null,
+    expire             timestamptz not null,
+    refresh_token_hash text        not null unique,
+    refresh_expire     timestamptz not null,
+    revoked            boolean     not null default false,
+    user_agent         text        not null default '',
+    ip                 text        not null default '',
+    last_seen          timestamptz not null default current_timestamp,
+    created            timestamptz not null default current_timestamp
+);
+
+create table api_keys
+(
+    id         bigserial primary key,
+    user_id    bigint      not null references users,
+    name       text        not null,
+    key_hash   text        not null unique,
+    key_prefix text        not null,
+    scopes     text[]      not null,
+    last_used  timestamptz,
+    revoked    boolean     not null default false,
+    created    timestamptz not null default current_timestamp
+);
+
+create table user_identities
+(
+    id      bigserial primary key,
+    user_id bigint      not null references users,
+    issuer  text        not null,
+    subject text        not null,
+    email   text        not null default '',
+    created timestamptz not null default current_timestamp,
+    unique (issuer, subject)
+);
+
+create table oidc_logins
+(
+    state_hash    text primary key,
+    nonce         text        not null,
+    code_verifier text        not null,
+    user_id       bigint references users,
+    expire        timestamptz not null
+);
+
+create table recovery_codes
+(
+    id        bigserial primary key,
+    user_id   bigint      not null references users,
+    code_hash text        not null,
+    used      boolean     not null default false,
+    created   timestamptz not null default current_timestamp
+);
+
+create table mfa_challenges
+(
+    id         bigserial primary key,
+    user_id    bigint      not null references users,
+    token_hash text        not null unique,
+    expire     timestamptz not null,
+    attempts   bigint      not null default 0,
+    used       boolean     not null default false,
+    created    timestamptz not null default current_timestamp
+);
+
+create table login_throttles
+(
+    key          text primary key,
+    failures     bigint      not null default 0,
+    locked_until timestamptz not null default current_timestamp,
+    last_failure timestamptz not null default current_timestamp
+);
+
+create table lockout_events
+(
+    id           bigserial primary key,
+    key          text        not null,
+    failures     bigint      not null,
+    locked_until timestamptz not null,
+    created      timestamptz not null default current_timestamp
+);
+
+create table password_resets
+(
+    id         bigserial primary key,
+    user_id    bigint      not null references users,
+    token_hash text        not null unique,
+    expire     timestamptz not null,
+    used       boolean     not null default false,
+    created    timestamptz not null default current_timestamp
+);
+
+create table email_verifications
+(
+    id         bigserial primary key,
+    user_id    bigint      not null references users,
+    token_hash text        not null unique,
+    expire     timestamptz not null,
+    used       boolean     not null default false,
+    created    timestamptz not null default current_timestamp
+);
+
+create table invite_codes
+(
+    id         bigserial primary key,
+    code       text        not null unique,
+    max_uses   bigint      not null default 1,
+    uses       bigint      not null default 0,
+    created_by bigint      not null references users,
+    created    timestamptz not null default current_timestamp
+);
+
+create table invite_redemptions
+(
+    invite_id bigint      not null references invite_codes,
+    user_id   bigint      not null unique references users,
+    created   timestamptz not null default current_timestamp
+);
+
+create table pen_names
+(
+    id      bigserial primary key,
     user_id bigint      not null references users,
-    token   text        not null unique,
-    expire  timestamptz not null default current_timestamp + interval '1 day',
+    name    text        not null,
+    bio     text        not null default '',
+    linked  boolean     not null default false,
+    active  boolean     not null default true,
     created timestamptz not null default current_timestamp
 );
 
@@ -21,25 +158,66 @@ create table books
     id          bigserial primary key,
     title       text      not null,
     author_id   bigint    not null references users,
+    pen_name_id bigint    not null references pen_names,
     genre_id       bigint      not null references genres,
     description text not null default 'description',
     cover_image_name text      not null,
     access_read boolean   not null default true,
+    downloadable boolean  not null default false,
     active      boolean   not null default true,
+    hidden      boolean   not null default false,
+    status      text      not null default 'published' check (status in ('draft', 'scheduled', 'published')),
+    publish_at  timestamptz,
+    version     bigint    not null default 1,
     created     timestamptz not null default current_timestamp
 );
 
+create table volumes
+(
+    id          bigserial primary key,
+    book_id     bigint      not null references books,
+    title       text        not null,
+    description text        not null default '',
+    number      bigint      not null,
+    created     timestamptz not null default current_timestamp,
+    constraint volumes_book_number unique (book_id, number) deferrable initially immediate
+);
+
 create table chapters
 (
     id      bigserial primary key,
     book_id bigint    not null references books,
+    volume_id bigint  references volumes on delete set null,
     number  bigint    not null,
     name    text      not null,
     content text      not null,
+    format  text      not null default 'plain' check (format in ('plain', 'markdown', 'html')),
     active  boolean   not null default true,
-    created timestamptz not null default current_timestamp
+    hidden  boolean   not null default false,
+    status  text      not null default 'published' check (status in ('draft', 'scheduled', 'published')),
+    publish_at timestamptz,
+    version bigint    not null default 1,
+    created timestamptz not null default current_timestamp,
+    -- deferred to the end of each statement so that shifting numbers by one is possible
+    constraint chapters_book_number unique (book_id, number) deferrable initially immediate
 );
 
+create table chapter_revisions
+(
+    id         bigserial primary key,
+    chapter_id bigint      not null references chapters,
+    name       text        not null,
+    content    text        not null,
+    format     text        not null default 'plain',
+    author_id  bigint      not null references users,
+    created    timestamptz not null default current_timestamp
+);
+
+create index on chapter_revisions (chapter_id, id);
+
+create index on books (publish_at) where status = 'scheduled';
+create index on chapters (publish_at) where status = 'scheduled';
+
 create table genres
 (
     id     bigserial primary key,
@@ -56,3 +234,37 @@ create table ratings
 );
     alter table ratings add unique (book_id, user_id);
 
+
+create table data_exports
+(
+    id        bigserial primary key,
+    user_id   bigint      not null references users,
+    status    text        not null default 'pending' check (status in ('pending', 'ready', 'failed')),
+    file_name text        not null default '',
+    attempts  int         not null default 0,
+    created   timestamptz not null default current_timestamp,
+    started   timestamptz,
+    finished  timestamptz
+);
+
+create table book_pdf_exports
+(
+    id        bigserial primary key,
+    book_id   bigint      not null references books,
+    user_id   bigint      not null references users,
+    page_size text        not null check (page_size in ('a5', 'a4', '6x9')),
+    status    text        not null default 'pending' check (status in ('pending', 'ready', 'failed')),
+    file_name text        not null default '',
+    attempts  int         not null default 0,
+    created   timestamptz not null default current_timestamp,
+    started   timestamptz,
+    finished  timestamptz
+);
+
+create table account_deletions
+(
+    user_id       bigint primary key references users,
+    books_action  text        not null check (books_action in ('delete', 'reassign')),
+    execute_after timestamptz not null,
+    created       timestamptz not null default current_timestamp
+);